@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/version"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// exportWmtsTiles copies cached tiles to a static WMTS REST tree with its capabilities, to publish without the server
+
+const (
+	APP                        = "exportWmtsTiles"
+	defaultWmtsConfig          = "config.yaml"
+	defaultMaxClientTimeOutSec = 30
+	defaultBufferSize          = 50
+	defaultLogName             = "stderr"
+)
+
+func main() {
+	l, err := golog.NewLogger(
+		"simple",
+		config.GetLogWriterFromEnvOrPanic(defaultLogName),
+		config.GetLogLevelFromEnvOrPanic(golog.InfoLevel),
+		fmt.Sprintf("%s:", APP),
+	)
+	if err != nil {
+		log.Fatalf("💥💥 error golog.NewLogger error: %v'\n", err)
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, build:%s, from: %s", APP, version.VERSION, version.Build, version.REPOSITORY)
+	configFileName := flag.String("config", config.GetLayersConfigPath(defaultWmtsConfig), "config file name (default is env LAYERS_CONFIG_PATH or config.yaml)")
+	layers := flag.String("layers", "", "comma separated names of the layers to export (default is all the layers)")
+	dest := flag.String("dest", "", "folder receiving the tiles, WMTSCapabilities.xml and the TileJSON files, outside the cache folder")
+	baseURL := flag.String("baseUrl", "", "url where the dest folder is published, like https://cdn.example.com/tiles")
+	bboxStr := flag.String("bbox", "", "exported area as xMin,yMin,xMax,yMax (default is the wmts_bbox of each layer)")
+	crs := flag.String("crs", "", "spatial reference of the bbox, like 2056 or wgs84 (default is the grid of each layer)")
+	minZoom := flag.Int("minZoom", -1, "min zoom level (default is the grid min zoom)")
+	maxZoom := flag.Int("maxZoom", -1, "max zoom level (default is the grid max zoom)")
+	tileJSON := flag.Bool("tilejson", false, "write a {layer}.json TileJSON for the WebMercatorQuad layers")
+	emptyTiles := flag.Bool("emptyTiles", false, "write a transparent tile in place of every tile missing in the cache")
+	cacheFolder := flag.String("cacheFolder", "", "folder of the tiles cache, overrides env CACHE_FOLDER")
+	flag.Parse()
+
+	if *dest == "" || *baseURL == "" {
+		l.Fatal("💥💥 the -dest folder and the -baseUrl where it is published are required")
+	}
+	var settingsFlags wmts.SettingsFlags
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "cacheFolder" {
+			settingsFlags.CacheFolder = cacheFolder
+		}
+	})
+
+	cfg, err := wmts.ConfigFromYAML(*configFileName)
+	if err != nil {
+		l.Fatal("error loading %s layer config: %v", *configFileName, err)
+	}
+	settings, err := cfg.ResolveSettings(settingsFlags, wmts.Settings{
+		BufferSize:       defaultBufferSize,
+		ClientTimeoutSec: defaultMaxClientTimeOutSec,
+		NumWorkers:       seed.DefaultNumWorkers,
+	})
+	if err != nil {
+		l.Fatal("💥💥 invalid settings: %v", err)
+	}
+	state, err := wmts.NewLayersState(cfg, settings, l)
+	if err != nil {
+		l.Fatal("💥💥 %v", err)
+	}
+
+	opts := wmts.ExportOptions{
+		Dest:       *dest,
+		BaseURL:    *baseURL,
+		MinZoom:    *minZoom,
+		MaxZoom:    *maxZoom,
+		TileJSON:   *tileJSON,
+		EmptyTiles: *emptyTiles,
+	}
+	if *layers != "" {
+		opts.Layers = strings.Split(*layers, ",")
+	}
+	if *bboxStr != "" {
+		bbox, err := wmts.ParseBBox(*bboxStr)
+		if err == nil {
+			opts.BBox, err = wmts.NewBBoxFromArray(bbox)
+		}
+		if err != nil {
+			l.Fatal("💥💥 %v", err)
+		}
+	}
+	if *crs != "" {
+		if opts.CRS, err = coords.ParseCRS(*crs); err != nil {
+			l.Fatal("💥💥 %v", err)
+		}
+	}
+
+	// Ctrl-C stops the export, the tiles already copied stay in dest
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	exported, err := wmts.Export(ctx, state, opts)
+	for _, layer := range exported {
+		for _, r := range layer.Ranges {
+			l.Debug("layer %s: %s", layer.Name, r)
+		}
+		if opts.TileJSON && !layer.TileJSON {
+			l.Warn("layer %s: no TileJSON written, TileJSON needs tiles of the %s grid", layer.Name, wmts.WebMercatorMatrixSet)
+		}
+		fmt.Printf("layer %s: %d zoom levels, %d tiles copied, %d empty tiles written\n", layer.Name, len(layer.Ranges), layer.Tiles, layer.Placeholders)
+	}
+	if err != nil {
+		l.Fatal("💥💥 export failed: %v", err)
+	}
+	fmt.Printf("✅ %d layers exported in %s, capabilities: %s/WMTSCapabilities.xml\n", len(exported), *dest, strings.TrimSuffix(*baseURL, "/"))
+}
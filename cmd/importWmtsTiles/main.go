@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tileimport"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/version"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// importWmtsTiles fills the cache of a layer with the tiles of a MapProxy or TileCache cache, an XYZ folder or an MBTiles file
+
+const (
+	APP                        = "importWmtsTiles"
+	defaultWmtsConfig          = "config.yaml"
+	defaultMaxClientTimeOutSec = 30
+	defaultBufferSize          = 50
+	defaultLogName             = "stderr"
+)
+
+func main() {
+	l, err := golog.NewLogger(
+		"simple",
+		config.GetLogWriterFromEnvOrPanic(defaultLogName),
+		config.GetLogLevelFromEnvOrPanic(golog.InfoLevel),
+		fmt.Sprintf("%s:", APP),
+	)
+	if err != nil {
+		log.Fatalf("💥💥 error golog.NewLogger error: %v'\n", err)
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, build:%s, from: %s", APP, version.VERSION, version.Build, version.REPOSITORY)
+	configFileName := flag.String("config", config.GetLayersConfigPath(defaultWmtsConfig), "config file name (default is env LAYERS_CONFIG_PATH or config.yaml)")
+	layerName := flag.String("layer", "", "name of the layer receiving the tiles")
+	source := flag.String("source", "", "folder of the tiles or MBTiles file to import")
+	layout := flag.String("layout", "", fmt.Sprintf("layout of the source, one of %v (default is mbtiles for a .mbtiles file)", tileimport.Layouts))
+	origin := flag.String("origin", "", "row 0 at the top (ul) or at the bottom (ll) of the source (default is ul for xyz, ll for the other layouts)")
+	zoomOffset := flag.Int("zoomOffset", 0, "added to the zoom levels of the source to get the ones of the layer grid")
+	minZoom := flag.Int("minZoom", -1, "min zoom level of the grid to import (default is all)")
+	maxZoom := flag.Int("maxZoom", -1, "max zoom level of the grid to import (default is all)")
+	overwrite := flag.Bool("overwrite", false, "replace the cached tiles different from the imported ones")
+	dryRun := flag.Bool("dryRun", false, "check the tiles and report the conflicts without writing anything")
+	cacheFolder := flag.String("cacheFolder", "", "folder of the tiles cache, overrides env CACHE_FOLDER")
+	flag.Parse()
+
+	if *source == "" {
+		l.Fatal("💥💥 the -source folder or MBTiles file is required")
+	}
+	var settingsFlags wmts.SettingsFlags
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "cacheFolder" {
+			settingsFlags.CacheFolder = cacheFolder
+		}
+	})
+
+	cfg, err := wmts.ConfigFromYAML(*configFileName)
+	if err != nil {
+		l.Fatal("error loading %s layer config: %v", *configFileName, err)
+	}
+	settings, err := cfg.ResolveSettings(settingsFlags, wmts.Settings{
+		BufferSize:       defaultBufferSize,
+		ClientTimeoutSec: defaultMaxClientTimeOutSec,
+		NumWorkers:       seed.DefaultNumWorkers,
+	})
+	if err != nil {
+		l.Fatal("💥💥 invalid settings: %v", err)
+	}
+	layerConfig, exists := cfg.Layers[*layerName]
+	if !exists {
+		l.Fatal("💥💥 layer %q not found in %s", *layerName, *configFileName)
+	}
+	grid, err := wmts.NewGridForMatrixSet(layerConfig.WMTSMatrixSet, layerConfig.WMSBackendURL, layerConfig.WMSBackendPrefix, l)
+	if err != nil {
+		l.Fatal("💥💥 %v", err)
+	}
+
+	opts := tileimport.Options{
+		Source:     *source,
+		Layout:     *layout,
+		Origin:     *origin,
+		ZoomOffset: *zoomOffset,
+		MinZoom:    *minZoom,
+		MaxZoom:    *maxZoom,
+		Overwrite:  *overwrite,
+		DryRun:     *dryRun,
+	}
+	// Ctrl-C stops the import, the tiles already written stay in the cache
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := tileimport.Import(ctx, grid, layerConfig, wmts.NewFileStore(settings.CacheFolder), opts, l)
+	if result != nil {
+		for _, issue := range result.Samples {
+			fmt.Println(issue)
+		}
+		kinds := make([]string, 0, len(result.Issues))
+		for kind := range result.Issues {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Printf("%s: %d tiles\n", kind, result.Issues[kind])
+		}
+		verb := "imported"
+		if *dryRun {
+			verb = "to import (dry run)"
+		}
+		fmt.Printf("layer %s: %d tiles read, %d %s (%d converted to png), %d already cached\n",
+			*layerName, result.Read, result.Imported, verb, result.Converted, result.Identical)
+	}
+	if err != nil {
+		l.Fatal("💥💥 import failed: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/version"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// invalidateWmtsTiles expires the cached tiles of a layer touching a changed area, in every zoom level
+
+const (
+	APP                        = "invalidateWmtsTiles"
+	defaultWmtsConfig          = "config.yaml"
+	defaultMaxClientTimeOutSec = 30
+	defaultMaxIdleConn         = 100
+	defaultMaxIdleConnPerHost  = 100
+	defaultIdleConnTimeoutSec  = 90
+	defaultBufferSize          = 50
+	defaultLogName             = "stderr"
+)
+
+func main() {
+	l, err := golog.NewLogger(
+		"simple",
+		config.GetLogWriterFromEnvOrPanic(defaultLogName),
+		config.GetLogLevelFromEnvOrPanic(golog.InfoLevel),
+		fmt.Sprintf("%s:", APP),
+	)
+	if err != nil {
+		log.Fatalf("💥💥 error golog.NewLogger error: %v'\n", err)
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, build:%s, from: %s", APP, version.VERSION, version.Build, version.REPOSITORY)
+	configFileName := flag.String("config", config.GetLayersConfigPath(defaultWmtsConfig), "config file name (default is env LAYERS_CONFIG_PATH or config.yaml)")
+	layerName := flag.String("layer", "", "name of the layer to invalidate")
+	bboxStr := flag.String("bbox", "", "changed area as xMin,yMin,xMax,yMax in the grid spatial reference")
+	geojsonFile := flag.String("geojson", "", "file containing the changed area as a GeoJSON geometry, Feature or FeatureCollection")
+	minZoom := flag.Int("minZoom", -1, "min zoom level (default is the grid min zoom)")
+	maxZoom := flag.Int("maxZoom", -1, "max zoom level (default is the grid max zoom)")
+	mode := flag.String("mode", string(seed.InvalidateDelete), "delete the tiles or mark them as stale (delete|stale)")
+	reseed := flag.Bool("reseed", false, "fetch again the tiles of the area once invalidated")
+	cacheFolder := flag.String("cacheFolder", "", "folder of the tiles cache, overrides env CACHE_FOLDER")
+	flag.Parse()
+
+	var settingsFlags wmts.SettingsFlags
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "cacheFolder" {
+			settingsFlags.CacheFolder = cacheFolder
+		}
+	})
+
+	cfg, err := wmts.ConfigFromYAML(*configFileName)
+	if err != nil {
+		l.Fatal("error loading %s layer config: %v", *configFileName, err)
+	}
+	settings, err := cfg.ResolveSettings(settingsFlags, wmts.Settings{
+		BufferSize:       defaultBufferSize,
+		ClientTimeoutSec: defaultMaxClientTimeOutSec,
+		NumWorkers:       seed.DefaultNumWorkers,
+	})
+	if err != nil {
+		l.Fatal("💥💥 invalid settings: %v", err)
+	}
+	layerConfig, exists := cfg.Layers[*layerName]
+	if !exists {
+		l.Fatal("💥💥 layer %q not found in %s", *layerName, *configFileName)
+	}
+	grid, err := wmts.NewGridForMatrixSet(layerConfig.WMTSMatrixSet, layerConfig.WMSBackendURL, layerConfig.WMSBackendPrefix, l)
+	if err != nil {
+		l.Fatal("💥💥 %v", err)
+	}
+
+	var bbox []float64
+	var geometry []byte
+	if *bboxStr != "" {
+		if bbox, err = wmts.ParseBBox(*bboxStr); err != nil {
+			l.Fatal("💥💥 %v", err)
+		}
+	}
+	if *geojsonFile != "" {
+		if geometry, err = os.ReadFile(*geojsonFile); err != nil {
+			l.Fatal("💥💥 cannot read GeoJSON file: %v", err)
+		}
+	}
+	area, err := seed.NewArea(bbox, geometry)
+	if err != nil {
+		l.Fatal("💥💥 %v", err)
+	}
+	if *minZoom < 0 {
+		*minZoom = grid.MinZoom()
+	}
+	if *maxZoom < 0 {
+		*maxZoom = grid.MaxZoom()
+	}
+
+	// Ctrl-C stops the invalidation or the reseed, the tiles already processed stay so
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := seed.Invalidate(ctx, grid, layerConfig, wmts.NewFileStore(settings.CacheFolder), area, *minZoom, *maxZoom, seed.InvalidateMode(*mode))
+	if err != nil {
+		l.Fatal("💥💥 invalidation failed: %v", err)
+	}
+	for z := *minZoom; z <= *maxZoom; z++ {
+		fmt.Printf("zoom %2d: %d tiles invalidated\n", z, result.PerZoom[z])
+	}
+	fmt.Printf("layer %s: %d tiles in area, %d cached tiles invalidated (%s)\n", result.Layer, result.TilesInArea, result.TilesInvalidated, result.Mode)
+
+	if *reseed {
+		backend.Default.Configure(cfg.Backends)
+		client := tools.CreateHTTPClient(settings.ClientTimeoutSec, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
+		state, err := wmts.NewLayersState(cfg, settings, l)
+		if err != nil {
+			l.Fatal("💥💥 %v", err)
+		}
+		opts := seed.Options{
+			Grid:       grid,
+			Layer:      layerConfig,
+			BasePath:   settings.CacheFolder,
+			Client:     client,
+			Buffer:     settings.BufferSize,
+			NumWorkers: settings.NumWorkers,
+			Logger:     l,
+			Throttle:   seed.NewThrottle(backend.Default.SeedLimits, l),
+			Builder:    state.Builder(*layerName),
+		}
+		progress := &seed.Progress{}
+		for z := *minZoom; z <= *maxZoom; z++ {
+			if err := seed.ProcessZoomLevel(ctx, z, area.BBox, opts, progress); err != nil {
+				if ctx.Err() != nil {
+					l.Warn("reseed interrupted at zoom %d: %d tiles saved, %d failed", z, progress.TilesDone.Load(), progress.TilesFailed.Load())
+					os.Exit(1)
+				}
+				l.Fatal("💥💥 reseed of zoom %d failed: %v", z, err)
+			}
+		}
+		fmt.Printf("reseed: %d tiles saved, %d failed\n", progress.TilesDone.Load(), progress.TilesFailed.Load())
+	}
+}
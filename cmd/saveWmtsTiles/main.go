@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/metrics"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/version"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
 	"github.com/schollz/progressbar/v3"
@@ -19,26 +27,19 @@ import (
 
 const (
 	APP                        = "saveWmtsTiles"
-	defaultWmtsConfig          = "wmtsConfig.yaml"
+	defaultWmtsConfig          = "config.yaml"
 	defaultLayer               = "fonds_geo_osm_bdcad_couleur"
 	defaultZoomLevel           = 3
 	defaultMaxClientTimeOutSec = 30
 	defaultMaxIdleConn         = 100
 	defaultMaxIdleConnPerHost  = 100
 	defaultIdleConnTimeoutSec  = 90
-	defaultNumWorkers          = 4 // Default number of workers
-	defaultMetaTileSize        = 4 // Number of tiles per side in a meta-tile (e.g., 2 for a 2x2 meta-tile)
+	defaultNumWorkers          = seed.DefaultNumWorkers
+	defaultMetaTileSize        = seed.DefaultMetaTileSize
 	defaultBufferSize          = 50
 	defaultLogName             = "stderr"
 )
 
-// metaTileTask defines a task to process a meta-tile.
-type metaTileTask struct {
-	zoomLevel int
-	startCol  int
-	startRow  int
-}
-
 func main() {
 	l, err := golog.NewLogger(
 		"simple",
@@ -50,42 +51,93 @@ func main() {
 		log.Fatalf("💥💥 error golog.NewLogger error: %v'\n", err)
 	}
 	l.Info("🚀🚀 Starting App:'%s', ver:%s, build:%s, from: %s", APP, version.VERSION, version.Build, version.REPOSITORY)
+	shutdownTracing, err := tracing.Init(context.Background(), config.GetTracesExporterFromEnvOrPanic(), APP, version.VERSION)
+	if err != nil {
+		l.Fatal("💥💥 error initializing tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// get the YAML config file name received from the config parameter
-	configFileName := flag.String("config", defaultWmtsConfig, "config file name")
+	configFileName := flag.String("config", config.GetLayersConfigPath(defaultWmtsConfig), "config file name (default is env LAYERS_CONFIG_PATH or config.yaml)")
 	verbose := flag.Bool("verbose", false, "verbose output")
 	layerName := flag.String("layer", defaultLayer, "config file name")
 	zoomLevel := flag.Int("zoom", defaultZoomLevel, "zoom level")
-	numWorkers := flag.Int("workers", defaultNumWorkers, "number of worker goroutines")
+	numWorkers := flag.Int("workers", defaultNumWorkers, "number of worker goroutines, overrides env NUM_WORKERS")
 	ptrMetaTileSize := flag.Int("metatile", defaultMetaTileSize, "number of tiles size per request(e.g. 2 for a 2x2 meta-tile) default is 4 ")
-	metaTileSize := *ptrMetaTileSize
-	bufferFromEnv := config.GetBufferSizeFromEnvOrPanic(defaultBufferSize)
 	// command line override
-	ptrBuffer := flag.Int("buffer", bufferFromEnv, "buffer in pixel around  tiles (default is 50)")
-	buffer := *ptrBuffer
+	ptrBuffer := flag.Int("buffer", defaultBufferSize, "buffer in pixel around  tiles (default is 50), overrides env BUFFER_SIZE")
+	cacheFolder := flag.String("cacheFolder", "", "folder of the tiles cache, overrides env CACHE_FOLDER")
 
 	// New parameters
-	clientTimeOut := flag.Int("ClientTimeOut", defaultMaxClientTimeOutSec, "client timeout in seconds")
+	clientTimeOut := flag.Int("ClientTimeOut", defaultMaxClientTimeOutSec, "client timeout in seconds, overrides env CLIENT_TIMEOUT_SEC")
 	minZoom := flag.Int("minZoom", defaultZoomLevel, "min zoom level")
 	maxZoom := flag.Int("maxZoom", defaultZoomLevel+1, "max zoom level")
+	metricsPushUrl := flag.String("metricsPushUrl", "", "Prometheus Pushgateway url to push the seeding metrics to")
+	metricsTextfile := flag.String("metricsTextfile", "", "file to write the seeding metrics to, for the node_exporter textfile collector")
+	maxRps := flag.Float64("maxRps", 0, "maximum meta-tile requests per second to the WMS backend, overrides the backends seed config")
+	maxKBps := flag.Int("maxKBps", 0, "maximum download bandwidth in KB/s from the WMS backend, overrides the backends seed config")
+	progressFile := flag.String("progressFile", "", "JSON file to record the progress of the run in, also written when interrupted")
+	window := flag.String("window", "", "daily time window like 19:00-07:00 to seed in, paused outside, overrides the backends seed config")
 
 	flag.Parse()
+	metaTileSize := *ptrMetaTileSize
 
-	// Capture explicitly set flags
+	// Capture explicitly set flags, only those override the env and YAML settings
 	flagsSet := make(map[string]bool)
 	flag.Visit(func(f *flag.Flag) {
 		flagsSet[f.Name] = true
 	})
+	var settingsFlags wmts.SettingsFlags
+	if flagsSet["buffer"] {
+		settingsFlags.BufferSize = ptrBuffer
+	}
+	if flagsSet["cacheFolder"] {
+		settingsFlags.CacheFolder = cacheFolder
+	}
+	if flagsSet["ClientTimeOut"] {
+		settingsFlags.ClientTimeoutSec = clientTimeOut
+	}
+	if flagsSet["workers"] {
+		settingsFlags.NumWorkers = numWorkers
+	}
 
 	l.Info("ℹ️ Reading config file: %s", *configFileName)
 	config, err := wmts.ConfigFromYAML(*configFileName)
 	if err != nil {
 		l.Fatal("error loading %s layer config: %v", *configFileName, err)
 	}
-	basePath := config.Caches.Local.Folder
+	settings, err := config.ResolveSettings(settingsFlags, wmts.Settings{
+		BufferSize:       defaultBufferSize,
+		ClientTimeoutSec: defaultMaxClientTimeOutSec,
+		NumWorkers:       defaultNumWorkers,
+	})
+	if err != nil {
+		l.Fatal("💥💥 invalid settings: %v", err)
+	}
+	l.Info("ℹ️ Settings: %s", settings)
+	backend.Default.Configure(config.Backends)
+	if _, err := seed.ParseTimeWindow(*window); err != nil {
+		l.Fatal("💥💥 invalid window parameter: %v", err)
+	}
+	throttle := seed.NewThrottle(func(host string) backend.SeedLimits {
+		limits := backend.Default.SeedLimits(host)
+		if flagsSet["maxRps"] {
+			limits.MaxRPS = *maxRps
+		}
+		if flagsSet["maxKBps"] {
+			limits.MaxKBps = *maxKBps
+		}
+		if flagsSet["window"] {
+			limits.Window = *window
+		}
+		return limits
+	}, l)
+	basePath := settings.CacheFolder
+	buffer := settings.BufferSize
 	layers := config.Layers
 	// Check if there are layers loaded
 	if len(layers) == 0 {
-		l.Fatal("💥💥 no layers loaded from %s", configFileName)
+		l.Fatal("💥💥 no layers loaded from %s", *configFileName)
 	}
 	l.Info("ℹ️ Found %d layers in config file: %s", len(layers), *configFileName)
 	isLayerNameInConfig := false
@@ -104,15 +156,19 @@ func main() {
 	l.Info("ℹ️ Using layer: %s", *layerName)
 
 	layerConfig := layers[*layerName]
-	wmsBackEndUrl := layerConfig.WMSBackendURL
-	wmsStartParams := layerConfig.WMSBackendPrefix
-	wmtsBBox := layerConfig.WMTSBBox
-	xMin, yMin, xMax, yMax := wmtsBBox[0], wmtsBBox[1], wmtsBBox[2], wmtsBBox[3]
+	bbox, err := wmts.NewBBoxFromArray(layerConfig.WMTSBBox)
+	if err != nil {
+		l.Fatal("💥💥 invalid wmts_bbox for layer %s: %v", *layerName, err)
+	}
 
-	// Create a new grid
-	myGrid := wmts.CreateNewLausanneGridFromEnvOrFail(wmsBackEndUrl, wmsStartParams, l)
+	// Create the grids and the tile builders of the layers, a composite layer uses the other ones
+	state, err := wmts.NewLayersState(config, settings, l)
+	if err != nil {
+		l.Fatal("💥💥 %v", err)
+	}
+	myGrid := state.Grids[*layerName]
 
-	client := tools.CreateHTTPClient(*clientTimeOut, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
+	client := tools.CreateHTTPClient(settings.ClientTimeoutSec, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
 
 	var zoomsToProcess []int
 
@@ -140,95 +196,136 @@ func main() {
 		zoomsToProcess = append(zoomsToProcess, *zoomLevel)
 	}
 
+	// the counters are shared by all the zoom levels so the exported metrics describe the whole run
+	progress := &seed.Progress{}
+	start := time.Now()
+	exportMetrics := func() {
+		if *metricsPushUrl == "" && *metricsTextfile == "" {
+			return
+		}
+		err := metrics.ExportSeed(metrics.SeedStats{
+			Layer:       *layerName,
+			TilesDone:   progress.TilesDone.Load(),
+			TilesFailed: progress.TilesFailed.Load(),
+			TilesEmpty:  progress.TilesEmpty.Load(),
+			Elapsed:     time.Since(start),
+		}, *metricsPushUrl, *metricsTextfile)
+		if err != nil {
+			l.Warn("metrics export failed: %v", err)
+		}
+	}
+	record := seedRecord{Layer: *layerName, Zooms: zoomsToProcess, CompletedZooms: []int{}, StartedAt: start}
+	writeRecord := func() {
+		if *progressFile == "" {
+			return
+		}
+		record.TilesTotal = progress.TilesTotal.Load()
+		record.TilesDone = progress.TilesDone.Load()
+		record.TilesFailed = progress.TilesFailed.Load()
+		record.TilesEmpty = progress.TilesEmpty.Load()
+		record.FinishedAt = time.Now()
+		if err := record.write(*progressFile); err != nil {
+			l.Warn("cannot record the progress: %v", err)
+		}
+	}
+
+	// Ctrl-C aborts the running requests and stops gracefully, a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
 	for _, z := range zoomsToProcess {
 		l.Info("=======================================================================")
 		l.Info("🚀 Processing Zoom Level: %d", z)
 		l.Info("=======================================================================")
-		processZoomLevel(z, *layerName, myGrid, xMin, yMin, xMax, yMax, metaTileSize, buffer, layerConfig, basePath, client, *numWorkers, *verbose, l)
+		err := processZoomLevel(ctx, z, *layerName, *bbox, progress, seed.Options{
+			Grid:         myGrid,
+			Layer:        layerConfig,
+			BasePath:     basePath,
+			Client:       client,
+			Buffer:       buffer,
+			NumWorkers:   settings.NumWorkers,
+			MetaTileSize: metaTileSize,
+			Verbose:      *verbose,
+			Logger:       l,
+			Throttle:     throttle,
+			Builder:      state.Builder(*layerName),
+		})
+		exportMetrics()
+		if ctx.Err() != nil {
+			record.Interrupted = true
+			record.InterruptedZoom = &z
+			writeRecord()
+			l.Warn("⏹️ interrupted during zoom %d: %d/%d tiles done, %d failed, completed zooms: %v",
+				z, progress.TilesDone.Load(), progress.TilesTotal.Load(), progress.TilesFailed.Load(), record.CompletedZooms)
+			shutdownTracing(context.Background())
+			os.Exit(130)
+		}
+		if err != nil {
+			writeRecord()
+			l.Fatal("💥💥 processing zoom %d failed: %v", z, err)
+		}
+		record.CompletedZooms = append(record.CompletedZooms, z)
 	}
+	writeRecord()
 
 	l.Info("🏁 All requested operations completed.")
 }
 
 func processZoomLevel(
+	ctx context.Context,
 	zoomLevel int,
 	layerName string,
-	myGrid *wmts.Grid,
-	xMin, yMin, xMax, yMax float64,
-	metaTileSize int,
-	buffer int,
-	layerConfig wmts.LayerConfig,
-	basePath string,
-	client *http.Client,
-	numWorkers int,
-	verbose bool,
-	l golog.MyLogger,
-) {
-	// Get tile boundaries
-	minCol, maxRow, err := myGrid.GetTile(xMin, yMin, zoomLevel)
-	if err != nil {
-		l.Fatal("💥💥 GetTile(%f, %f, %d) got error: %v", xMin, yMin, zoomLevel, err)
-	}
-	maxCol, minRow, err := myGrid.GetTile(xMax, yMax, zoomLevel)
+	bbox wmts.BBox,
+	progress *seed.Progress,
+	opts seed.Options,
+) error {
+	l := opts.Logger
+	tiles, err := opts.Grid.TileRange(bbox, zoomLevel)
 	if err != nil {
-		l.Fatal("💥💥 GetTile(%f, %f, %d) got error: %v", xMax, yMax, zoomLevel, err)
+		return err
 	}
-	l.Info("ℹ️ minCol: %d, minRow: %d", minCol, minRow)
-	l.Info("ℹ️ maxCol: %d, maxRow: %d", maxCol, maxRow)
-
-	// Calculate total tiles
-	totalTiles := (maxCol - minCol + 1) * (maxRow - minRow + 1)
+	totalTiles := tiles.Count()
 
 	// Initialize progress bar
 	bar := progressbar.Default(int64(totalTiles), fmt.Sprintf("Processing tiles for layer %s, zoom %d", layerName, zoomLevel))
-
-	// Create a channel for tasks. The channel now holds metaTileTask.
-	tasks := make(chan metaTileTask, (totalTiles)/(metaTileSize*metaTileSize)+1)
-	var wg sync.WaitGroup
-
-	// Channel to track completed tasks
-	done := make(chan struct{}, totalTiles)
-
-	// Start a worker pool. Each worker now processes a meta-tile.
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			for task := range tasks {
-				err := myGrid.SaveTilesFromMetaTile(task.zoomLevel, task.startCol, task.startRow, metaTileSize, metaTileSize, buffer, layerConfig, basePath, client)
-				if err != nil {
-					l.Error("💥 Worker %d: SaveTilesFromMetaTile for zoom:%d, meta-tile at (row:%d, col:%d) failed: %v", workerID, task.zoomLevel, task.startRow, task.startCol, err)
-				} else {
-					if verbose {
-						l.Info("ℹ️ Worker %d: zoom:%d, meta-tile at (row:%d, col:%d) saved", workerID, task.zoomLevel, task.startRow, task.startCol)
-					}
-					// Signal completion for each tile in the meta-tile
-					for j := 0; j < metaTileSize*metaTileSize; j++ {
-						done <- struct{}{}
-					}
-				}
-			}
-		}(i)
+	progress.OnTiles = func(numTiles int) {
+		bar.Add(numTiles) // Increment progress bar
 	}
-	// Start a goroutine to update the progress bar
-	go func() {
-		for range done {
-			bar.Add(1) // Increment progress bar
-		}
-	}()
-
-	// Enqueue meta-tile tasks
-	for row := minRow; row <= maxRow; row += metaTileSize {
-		for col := minCol; col <= maxCol; col += metaTileSize {
-			tasks <- metaTileTask{zoomLevel: zoomLevel, startCol: col, startRow: row}
-		}
+	progress.TilesTotal.Add(int64(totalTiles))
+	failedBefore := progress.TilesFailed.Load()
+	if err := seed.ProcessZoomLevel(ctx, zoomLevel, bbox, opts, progress); err != nil {
+		bar.Exit()
+		fmt.Println()
+		return err
 	}
-
-	// Close the tasks channel and wait for workers to finish
-	close(tasks)
-	wg.Wait()
-	// Close done channel and wait for progress bar to finish
-	close(done)
 	bar.Finish()
+	if failed := progress.TilesFailed.Load() - failedBefore; failed > 0 {
+		l.Warn("Zoom %d processed with %d failed tiles, last error: %s", zoomLevel, failed, progress.LastError())
+		return nil
+	}
 	l.Info("ℹ️ Zoom %d processed successfully", zoomLevel)
+	return nil
+}
+
+// seedRecord is the progress of a run written to the -progressFile
+type seedRecord struct {
+	Layer           string    `json:"layer"`
+	Zooms           []int     `json:"zooms"`
+	CompletedZooms  []int     `json:"completed_zooms"`
+	Interrupted     bool      `json:"interrupted"`
+	InterruptedZoom *int      `json:"interrupted_zoom,omitempty"`
+	TilesTotal      int64     `json:"tiles_total"`
+	TilesDone       int64     `json:"tiles_done"`
+	TilesFailed     int64     `json:"tiles_failed"`
+	TilesEmpty      int64     `json:"tiles_empty"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+}
+
+func (r seedRecord) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+const (
+	maxAdminRequestBodySize = 1 << 20  // GeoJSON geometries can be large
+	maxAdminTileSize        = 16 << 20 // a png tile of a synchronisation
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSON sends v as JSON with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any, l golog.MyLogger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		l.Error("Error encoding JSON response: %v", err)
+	}
+}
+
+// seedErrorStatus returns the http status of an error of the seed.Manager, a bad request unless too many jobs run
+func seedErrorStatus(err error) int {
+	if errors.Is(err, seed.ErrTooManyJobs) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadRequest
+}
+
+// startSeedJobHandler starts a seeding job described by the JSON body, see seed.Request
+func startSeedJobHandler(manager *seed.Manager, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "startSeedJobHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		var req seed.Request
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBodySize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid seed request: " + err.Error()}, l)
+			return
+		}
+		job, err := manager.Start(req)
+		if err != nil {
+			writeJSON(w, seedErrorStatus(err), errorResponse{Error: err.Error()}, l)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job.Status(), l)
+	}
+}
+
+// listSeedJobsHandler returns the status of all the seeding jobs
+func listSeedJobsHandler(manager *seed.Manager, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "listSeedJobsHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		writeJSON(w, http.StatusOK, manager.List(), l)
+	}
+}
+
+// getSeedJobHandler returns the status of the seeding job given in the path
+func getSeedJobHandler(manager *seed.Manager, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "getSeedJobHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		job, exists := manager.Get(r.PathValue("id"))
+		if !exists {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "job not found"}, l)
+			return
+		}
+		writeJSON(w, http.StatusOK, job.Status(), l)
+	}
+}
+
+// cancelSeedJobHandler cancels the seeding job given in the path
+func cancelSeedJobHandler(manager *seed.Manager, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "cancelSeedJobHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		job, exists := manager.Cancel(r.PathValue("id"))
+		if !exists {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "job not found"}, l)
+			return
+		}
+		l.Info("seed job %s cancellation requested from IP: [%s]", job.ID(), r.RemoteAddr)
+		writeJSON(w, http.StatusAccepted, job.Status(), l)
+	}
+}
+
+// invalidateTilesHandler deletes or marks as stale the cached tiles of an area, see seed.InvalidateRequest
+func invalidateTilesHandler(manager *seed.Manager, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "invalidateTilesHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		var req seed.InvalidateRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestBodySize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid invalidate request: " + err.Error()}, l)
+			return
+		}
+		result, err := manager.Invalidate(r.Context(), req)
+		if err != nil {
+			writeJSON(w, seedErrorStatus(err), errorResponse{Error: err.Error()}, l)
+			return
+		}
+		writeJSON(w, http.StatusOK, result, l)
+	}
+}
+
+// adminTileParams returns the layer, the cache and the position of the tile in the path of an admin tile request,
+// the error is sent and ok is false when the tile is invalid
+func adminTileParams(w http.ResponseWriter, r *http.Request, registry *wmts.LayerRegistry, l golog.MyLogger) (lc wmts.LayerConfig, grid *wmts.Grid, store *wmts.FileStore, zoom, row, col int, ok bool) {
+	layer, zoom, col, row, err := parseTileParams(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()}, l)
+		return lc, nil, nil, 0, 0, 0, false
+	}
+	state := registry.Current()
+	lc, grid, exists := state.Layer(layer)
+	if !exists {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid layer %q", layer)}, l)
+		return lc, nil, nil, 0, 0, 0, false
+	}
+	if err := grid.CheckTile(zoom, col, row); err != nil {
+		writeJSON(w, gridErrorStatus(err), errorResponse{Error: err.Error()}, l)
+		return lc, nil, nil, 0, 0, 0, false
+	}
+	return lc, grid, wmts.NewFileStore(state.BasePath()), zoom, row, col, true
+}
+
+// getCachedTileHandler answers the tile given in the path only when it is cached, it is never fetched from the
+// backend. A HEAD gives the size and the ETag of the tile, the way syncWmtsTiles compares it, see seed.HTTPDest.
+func getCachedTileHandler(registry *wmts.LayerRegistry, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "getCachedTileHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		lc, _, store, zoom, row, col, ok := adminTileParams(w, r, registry, l)
+		if !ok {
+			return
+		}
+		path := store.TilePath(lc, zoom, row, col)
+		info, err := os.Stat(path)
+		var data []byte
+		if err == nil {
+			data, err = os.ReadFile(path)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "tile not cached"}, l)
+			return
+		}
+		if err != nil {
+			l.Error("cannot read the tile %s: %v", path, err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "cannot read the tile"}, l)
+			return
+		}
+		w.Header().Set("ETag", wmts.TileETag(data))
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, filepath.Base(path), info.ModTime(), bytes.NewReader(data))
+	}
+}
+
+// putTileHandler caches the png tile in the body at the position given in the path, replacing the cached one.
+// The Last-Modified header gives its modification time, so a stale tile stays stale, it is now when missing.
+func putTileHandler(registry *wmts.LayerRegistry, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "putTileHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		lc, grid, store, zoom, row, col, ok := adminTileParams(w, r, registry, l)
+		if !ok {
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminTileSize))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "cannot read the tile: " + err.Error()}, l)
+			return
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "the tile is not a png: " + err.Error()}, l)
+			return
+		}
+		if cfg.Width != int(grid.GetTileWidth()) || cfg.Height != int(grid.GetTileHeight()) {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("the tile is %dx%d, the tiles of the grid are %gx%g", cfg.Width, cfg.Height, grid.GetTileWidth(), grid.GetTileHeight())}, l)
+			return
+		}
+		modTime := time.Now()
+		if lastModified := r.Header.Get("Last-Modified"); lastModified != "" {
+			if modTime, err = http.ParseTime(lastModified); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid Last-Modified %q", lastModified)}, l)
+				return
+			}
+		}
+		if err := store.WriteModTime(lc, zoom, row, col, data, modTime); err != nil {
+			l.Error("cannot cache the tile sent: %v", err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "cannot cache the tile"}, l)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
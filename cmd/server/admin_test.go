@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/gohttp"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// TestSyncToAdminTiles synchronises a cache folder to the cache of a server through its admin API
+func TestSyncToAdminTiles(t *testing.T) {
+	const token = "0123456789abcdef"
+	// the backend must not be asked for the tiles missing in the replica
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected backend request %s", r.URL)
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	defer backendServer.Close()
+	registry := newTestRegistry(t, backendServer.URL)
+	l := newTestLogger(t)
+	adminAuth := gohttp.BearerAuthMiddleware(token, l)
+	mux := http.NewServeMux()
+	mux.Handle("GET "+seed.AdminTilesPath+"{layer}/{zoom}/{row}/{col}", adminAuth(getCachedTileHandler(registry, l)))
+	mux.Handle("PUT "+seed.AdminTilesPath+"{layer}/{zoom}/{row}/{col}", adminAuth(putTileHandler(registry, l)))
+	replica := httptest.NewServer(mux)
+	defer replica.Close()
+
+	tilePNG := func(gray uint8) []byte {
+		img := image.NewGray(image.Rect(0, 0, 256, 256))
+		for i := range img.Pix {
+			img.Pix[i] = gray + uint8(i%7)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	state := registry.Current()
+	lc, grid, _ := state.Layer("plan_ville")
+	source, dest := wmts.NewFileStore(t.TempDir()), wmts.NewFileStore(state.BasePath())
+	// zoom 0: 2 tiles in row 3 and 1 in row 4, the replica has a different tile and an identical one
+	for _, tile := range []struct {
+		store    *wmts.FileStore
+		row, col int
+		data     []byte
+	}{
+		{source, 3, 2, tilePNG(10)},
+		{source, 3, 3, tilePNG(20)},
+		{source, 4, 2, tilePNG(30)},
+		{dest, 3, 2, tilePNG(40)},
+		{dest, 3, 3, tilePNG(20)},
+	} {
+		if err := tile.store.Write(lc, 0, tile.row, tile.col, tile.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cachedAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(source.TilePath(lc, 0, 4, 2), cachedAt, cachedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := seed.SyncOptions{
+		Grid:       grid,
+		Layer:      lc,
+		Source:     source,
+		Dest:       seed.NewHTTPDest(replica.URL+"/", token, replica.Client()),
+		BBox:       wmts.LausanneGridBBox,
+		MinZoom:    0,
+		MaxZoom:    1,
+		NumWorkers: 2,
+		Logger:     l,
+	}
+	result := &seed.SyncResult{}
+	if err := seed.Sync(context.Background(), opts, result); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.Compared.Load() != 3 || result.Missing.Load() != 1 || result.Changed.Load() != 1 || result.Unchanged.Load() != 1 {
+		t.Errorf("Sync() compared %d, missing %d, changed %d, unchanged %d, want 3, 1, 1, 1",
+			result.Compared.Load(), result.Missing.Load(), result.Changed.Load(), result.Unchanged.Load())
+	}
+	for _, tile := range [][2]int{{3, 2}, {4, 2}} {
+		data, err := os.ReadFile(dest.TilePath(lc, 0, tile[0], tile[1]))
+		want, _ := os.ReadFile(source.TilePath(lc, 0, tile[0], tile[1]))
+		if err != nil || !bytes.Equal(data, want) {
+			t.Errorf("tile row:%d, col:%d of the replica differs from the source: %v", tile[0], tile[1], err)
+		}
+	}
+	if info, err := dest.Stat(lc, 0, 4, 2); err != nil || !info.ModTime().Equal(cachedAt) {
+		t.Errorf("copied tile modified at %v, %v, want %v", info, err, cachedAt)
+	}
+
+	tileURL := replica.URL + seed.AdminTilesPath + "plan_ville/0/3/2"
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		token      string
+		body       []byte
+		wantStatus int
+	}{
+		{"without token", http.MethodHead, tileURL, "", nil, http.StatusUnauthorized},
+		{"cached tile", http.MethodGet, tileURL, token, nil, http.StatusOK},
+		{"tile not cached", http.MethodHead, replica.URL + seed.AdminTilesPath + "plan_ville/0/10/10", token, nil, http.StatusNotFound},
+		{"unknown layer", http.MethodHead, replica.URL + seed.AdminTilesPath + "other/0/3/2", token, nil, http.StatusBadRequest},
+		{"outside the tile matrix", http.MethodPut, replica.URL + seed.AdminTilesPath + "plan_ville/0/3/900", token, tilePNG(10), http.StatusNotFound},
+		{"not a png", http.MethodPut, tileURL, token, []byte("tile"), http.StatusBadRequest},
+		{"png of another size", http.MethodPut, tileURL, token, func() []byte {
+			var buf bytes.Buffer
+			png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 100)))
+			return buf.Bytes()
+		}(), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := replica.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.url, resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
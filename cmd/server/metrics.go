@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/metrics"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// statusRecorder keeps the status and size of a response for the metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// tileMetricsMiddleware records the count, latency and size of the tile responses by layer, zoom and status.
+// Unknown layers and invalid zooms share a single label value to keep the number of series bounded.
+func tileMetricsMiddleware(registry *wmts.LayerRegistry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		layer := r.PathValue("layer")
+		zoom := r.PathValue("zoom")
+		_, grid, exists := registry.Current().Layer(layer)
+		if !exists {
+			layer, zoom = "unknown", "invalid"
+		} else if z, err := strconv.Atoi(zoom); err != nil || z < grid.MinZoom() || z > grid.MaxZoom() {
+			zoom = "invalid"
+		} else {
+			// "01" or "+1" are valid zooms, the label is normalized to not create a series for each spelling
+			zoom = strconv.Itoa(z)
+		}
+		status := strconv.Itoa(rec.status)
+		metrics.TileRequests.WithLabelValues(layer, zoom, status).Inc()
+		metrics.TileRequestDuration.WithLabelValues(layer, zoom, status).Observe(time.Since(start).Seconds())
+		metrics.TileBytesServed.WithLabelValues(layer).Add(float64(rec.bytes))
+	})
+}
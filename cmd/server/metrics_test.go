@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/metrics"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testLayersYAML is the config of the layer plan_ville and of plan_ville_live expiring after 3000 seconds,
+// %[1]s is the url of their WMS backend
+const testLayersYAML = `layers:
+    plan_ville:
+        wms_backend_url: %[1]s
+        wms_layers: plan_ville
+        layer_name: plan_ville
+        wmts_bbox: [2532500, 1149000, 2545625, 1161000]
+        wmts_url_style: default
+        wmts_matrix_set: swissgrid_05
+        wmts_dimension_name: DATE
+        wmts_dimension_year: "2025"
+        image_extension: png
+    plan_ville_live:
+        wms_backend_url: %[1]s
+        wms_layers: plan_ville
+        layer_name: plan_ville_live
+        wmts_bbox: [2532500, 1149000, 2545625, 1161000]
+        wmts_url_style: default
+        wmts_matrix_set: swissgrid_05
+        wmts_dimension_name: DATE
+        wmts_dimension_year: "2025"
+        image_extension: png
+        max_age_sec: 3000
+`
+
+func newTestLogger(t *testing.T) golog.MyLogger {
+	t.Helper()
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	return l
+}
+
+// newTestRegistry returns the registry of the layers of testLayersYAML requested from backendURL, cached in a temporary folder
+func newTestRegistry(t *testing.T, backendURL string) *wmts.LayerRegistry {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(fmt.Sprintf(testLayersYAML, backendURL)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cacheFolder := t.TempDir()
+	registry, err := wmts.NewLayerRegistry(configPath, wmts.SettingsFlags{CacheFolder: &cacheFolder}, wmts.Settings{
+		BufferSize:       defaultBufferSize,
+		ClientTimeoutSec: defaultMaxClientTimeOutSec,
+		NumWorkers:       defaultNumWorkers,
+	}, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("NewLayerRegistry: %v", err)
+	}
+	return registry
+}
+
+func TestTileMetricsMiddleware(t *testing.T) {
+	registry := newTestRegistry(t, "https://example.org/wms")
+	tile := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("row") == "404" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("tile"))
+	})
+	mux := http.NewServeMux()
+	mux.Handle("GET /tiles/1.0.0/{layer}/default/{year}/{matrixSet}/{zoom}/{row}/{col}", tileMetricsMiddleware(registry, tile))
+	tests := []struct {
+		name   string
+		path   string
+		layer  string
+		zoom   string
+		status string
+	}{
+		{"tile", "plan_ville/default/2025/swissgrid_05/3/1/2", "plan_ville", "3", "200"},
+		{"zoom spelled with a 0", "plan_ville/default/2025/swissgrid_05/04/1/2", "plan_ville", "4", "200"},
+		{"missing tile", "plan_ville/default/2025/swissgrid_05/5/404/2", "plan_ville", "5", "404"},
+		{"zoom above the grid", "plan_ville/default/2025/swissgrid_05/99/1/2", "plan_ville", "invalid", "200"},
+		{"zoom not a number", "plan_ville/default/2025/swissgrid_05/z/1/2", "plan_ville", "invalid", "200"},
+		{"unknown layer", "other/default/2025/swissgrid_05/3/1/2", "unknown", "invalid", "200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := metrics.TileRequests.WithLabelValues(tt.layer, tt.zoom, tt.status)
+			before, bytesBefore := testutil.ToFloat64(requests), testutil.ToFloat64(metrics.TileBytesServed.WithLabelValues(tt.layer))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tiles/1.0.0/"+tt.path, nil))
+			if got := testutil.ToFloat64(requests) - before; got != 1 {
+				t.Errorf("tile_requests_total{layer=%q, zoom=%q, status=%q} increased by %g, want 1", tt.layer, tt.zoom, tt.status, got)
+			}
+			if got := testutil.ToFloat64(metrics.TileBytesServed.WithLabelValues(tt.layer)) - bytesBefore; got != float64(rec.Body.Len()) {
+				t.Errorf("tile_bytes_served_total{layer=%q} increased by %g, want %d", tt.layer, got, rec.Body.Len())
+			}
+		})
+	}
+}
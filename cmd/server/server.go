@@ -1,23 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/gohttp"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/metrics"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/version"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -31,16 +45,21 @@ const (
 	defaultMaxIdleConnPerHost  = 100
 	defaultIdleConnTimeoutSec  = 90
 	defaultBufferSize          = 50
+	defaultNumWorkers          = 4
+	defaultTileMaxAgeSec       = 3600 // client cache duration of tiles of layers without max_age_sec
 	formatTraceRequest         = "[%s] %s '%s', IP: [%s],%s\n"
 	defaultLogName             = "stderr"
 )
 
 type TileInfoResponse struct {
-	Zoom   int       `json:"zoom,omitempty"`
-	Col    int       `json:"col,omitempty"`
-	Row    int       `json:"row,omitempty"`
-	WmsUrl string    `json:"wms_url,omitempty"`
-	BBox   []float64 `json:"bbox,omitempty"`
+	Zoom    int       `json:"zoom,omitempty"`
+	Col     int       `json:"col,omitempty"`
+	Row     int       `json:"row,omitempty"`
+	WmsUrl  string    `json:"wms_url,omitempty"`
+	TileUrl string    `json:"tile_url,omitempty"` // url of the upstream tile for the wmts and xyz layers
+	X       float64   `json:"x,omitempty"`        // the coordinates looked up, in the spatial reference of the grid
+	Y       float64   `json:"y,omitempty"`
+	BBox    []float64 `json:"bbox,omitempty"`
 }
 
 // content holds our static web server content.
@@ -97,6 +116,18 @@ func parseTileParams(r *http.Request) (layer string, zoom, col, row int, err err
 	return layer, zoom, col, row, nil
 }
 
+// gridErrorStatus returns the HTTP status of an error of the grid methods: an unknown zoom level is a bad request
+// while a tile or coordinates outside the tile matrix have no tile
+func gridErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, wmts.ErrZoomOutOfRange):
+		return http.StatusBadRequest
+	case errors.Is(err, wmts.ErrTileOutOfMatrix), errors.Is(err, wmts.ErrOutsideGridExtent):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
 func GetMyDefaultHandler(s *gohttp.Server, webRootDir string, content embed.FS) http.HandlerFunc {
 	handlerName := "GetMyDefaultHandler"
 	logger := s.GetLog()
@@ -123,24 +154,23 @@ func GetMyDefaultHandler(s *gohttp.Server, webRootDir string, content embed.FS)
 	}
 }
 
-func GetLayersInfoHandler(layers map[string]wmts.LayerConfig, l golog.MyLogger) http.HandlerFunc {
+func GetLayersInfoHandler(registry *wmts.LayerRegistry, l golog.MyLogger) http.HandlerFunc {
 	handlerName := "GetLayersInfoHandler"
 	l.Debug("Initial call to %s", handlerName)
 	return func(w http.ResponseWriter, r *http.Request) {
 		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
 		// Encode the response as JSON and send it.
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(layers); err != nil {
+		if err := json.NewEncoder(w).Encode(registry.Current().Layers); err != nil {
 			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-func getTileInfoByXYHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfig, l golog.MyLogger) http.HandlerFunc {
+func getTileInfoByXYHandler(registry *wmts.LayerRegistry, l golog.MyLogger) http.HandlerFunc {
 	handlerName := "getTileInfoByXYHandler"
-	buffer := config.GetBufferSizeFromEnvOrPanic(defaultBufferSize)
-	l.Debug("Initial call to %s, buffer size: %d", handlerName, buffer)
+	l.Debug("Initial call to %s", handlerName)
 	return func(w http.ResponseWriter, r *http.Request) {
 		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
 		layer, zoom, x, y, err := parseTileInfoByXYParams(r)
@@ -150,8 +180,10 @@ func getTileInfoByXYHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfi
 		}
 		l.Info("getTileInfoByXYHandler: layer:%s, zoom:%d, x:%f, y:%f", layer, zoom, x, y)
 
-		// Look up layer config
-		layerConfig, exists := layers[layer]
+		// Look up layer config in the current configuration, it may be reloaded at any time
+		state := registry.Current()
+		buffer := state.Settings.BufferSize
+		layerConfig, chGrid, exists := state.Layer(layer)
 		if !exists {
 			l.Error("invalid layer request: %s", layer)
 			// Maybe try using structured logging if logger supports it:
@@ -160,37 +192,49 @@ func getTileInfoByXYHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfi
 			return
 		}
 
-		// 4. Perform calculations, handling potential errors from the lausanne wmts grid package.
-		col, row, err := chGrid.GetTile(x, y, zoom)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest) // Forward the error message
-			return
+		// the coordinates may be given in another spatial reference than the one of the grid, like GPS lon/lat
+		if crs := r.URL.Query().Get("crs"); crs != "" {
+			from, err := coords.ParseCRS(crs)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			transform, err := coords.NewTransform(from, chGrid.SpatialREF)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			x, y = transform(x, y)
 		}
 
-		bbox, err := chGrid.GetTileBBox(zoom, col, row)
+		// 4. Perform calculations, handling potential errors from the lausanne wmts grid package.
+		info, err := chGrid.GetTileInfoByXY(x, y, zoom)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), gridErrorStatus(err)) // Forward the error message
 			return
 		}
-		layers := layerConfig.WMSLayers
-
-		params := chGrid.GetWMSParams(*bbox, layers, int(chGrid.GetTileWidth()), int(chGrid.GetTileHeight()), buffer, "png") // Use GetTileWidth
-
-		// 5. Build the WMS URL.
-		wmsURL := fmt.Sprintf("%s?%s%s", chGrid.WmsBackendUrl, chGrid.WmsStartParams, tools.BuildQueryString(params))
-
-		bboxArray := bbox.ToArray()
-
-		// 6. Create the response.
+		col, row, bbox := info.Col, info.Row, &info.BBox
+		// 5. Create the response with the WMS URL, or the upstream tile URL
 		tileInfo := TileInfoResponse{
-			Zoom:   zoom,
-			Col:    col,
-			Row:    row,
-			WmsUrl: wmsURL,
-			BBox:   bboxArray,
+			Zoom: zoom,
+			Col:  col,
+			Row:  row,
+			X:    x,
+			Y:    y,
+			BBox: bbox.ToArray(),
+		}
+		switch builder := state.Builder(layer).(type) {
+		case nil:
+			params := chGrid.GetWMSParams(*bbox, layerConfig.WMSLayers, int(chGrid.GetTileWidth()), int(chGrid.GetTileHeight()), buffer, "png") // Use GetTileWidth
+			tileInfo.WmsUrl = fmt.Sprintf("%s?%s%s", chGrid.WmsBackendUrl, chGrid.WmsStartParams, tools.BuildQueryString(params))
+		case *wmts.TileSource:
+			if !builder.Resampled() {
+				// a resampled tile is built from several upstream tiles
+				tileInfo.TileUrl = builder.TileURL(zoom, col, row)
+			}
 		}
 
-		// 7. Encode the response as JSON and send it.
+		// 6. Encode the response as JSON and send it.
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(tileInfo); err != nil {
 			http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
@@ -199,11 +243,14 @@ func getTileInfoByXYHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfi
 	}
 }
 
-func getTileImageHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfig, basePath string, l golog.MyLogger) http.HandlerFunc {
+// getTileImageHandler serves the tiles from the cache, fetching the missing ones from the WMS backend or the upstream tile source.
+// The expired tiles are refreshed in background until ctx is done. The client timeout is the one of the current settings.
+func getTileImageHandler(ctx context.Context, registry *wmts.LayerRegistry, l golog.MyLogger) http.HandlerFunc {
 	handlerName := "getTileImageHandler"
-	buffer := config.GetBufferSizeFromEnvOrPanic(defaultBufferSize)
-	l.Debug("Initial call to %s, buffer size: %d", handlerName, buffer)
-	client := tools.CreateHTTPClient(defaultMaxClientTimeOutSec, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
+	clientTimeOut := registry.Current().Settings.ClientTimeoutSec
+	l.Debug("Initial call to %s, client timeout: %d", handlerName, clientTimeOut)
+	client := tools.CreateHTTPClient(clientTimeOut, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
+	refresher := newTileRefresher(ctx, l)
 	return func(w http.ResponseWriter, r *http.Request) {
 		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
 		layer, zoom, col, row, err := parseTileParams(r)
@@ -212,8 +259,19 @@ func getTileImageHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfig,
 			return
 		}
 		l.Info("getTileImageHandler: layer:%s, zoom:%d, col:%d, row:%d", layer, zoom, col, row)
-		// Look up layer config
-		layerConfig, exists := layers[layer]
+		ctx, span := tracing.Start(r.Context(), handlerName, trace.WithAttributes(
+			attribute.String("layer", layer),
+			attribute.Int("zoom", zoom),
+			attribute.Int("col", col),
+			attribute.Int("row", row),
+		))
+		defer span.End()
+		// Look up layer config in the current configuration, it may be reloaded at any time
+		state := registry.Current()
+		client := tools.ClientWithTimeout(client, state.Settings.ClientTimeoutSec)
+		basePath := state.BasePath()
+		buffer := state.Settings.BufferSize
+		layerConfig, chGrid, exists := state.Layer(layer)
 		if !exists {
 			l.Error("invalid layer request: %s", layer)
 			http.Error(w, "Invalid layer", http.StatusBadRequest)
@@ -221,47 +279,94 @@ func getTileImageHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfig,
 		}
 
 		// 4. check if tile exists
-		if chGrid.IsValidTile(zoom, col, row) == false {
-			errMsg := fmt.Sprintf("invalid tile request for zoom:%d, col:%d, row:%d", zoom, col, row)
-			l.Error(errMsg)
-			http.Error(w, errMsg, http.StatusBadRequest)
-			return
-		}
-
-		bbox, err := chGrid.GetTileBBox(zoom, col, row)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if err := chGrid.CheckTile(zoom, col, row); err != nil {
+			l.Warn("invalid tile request: %v", err)
+			http.Error(w, err.Error(), gridErrorStatus(err))
 			return
 		}
-		layers := layerConfig.WMSLayers
-		params := chGrid.GetWMSParams(*bbox, layers, int(chGrid.GetTileWidth()), int(chGrid.GetTileHeight()), buffer, "png") // Use GetTileWidth
-
-		// 5. Build the WMS URL.
-		wmsURL := fmt.Sprintf("%s?%s%s", chGrid.WmsBackendUrl, chGrid.WmsStartParams, tools.BuildQueryString(params))
 
 		imgPath := wmts.GetWmtsImgPath(basePath, layerConfig.WMTSURLPrefix, layerConfig.Name, layerConfig.WMTSURLStyle, layerConfig.WMTSDimensionYear, layerConfig.WMTSMatrixSet, "png", zoom, row, col)
-		// check if tile is in cache
-		_, err = os.Stat(imgPath)
-		if err != nil {
-			l.Debug("file %s is not in cache, downloading: %s", imgPath, wmsURL)
-			err = tools.GetPngFromUrl(client, wmsURL, imgPath, buffer, 2, l)
+		// check if tile is in cache, an expired tile is served as is while a fresh one is fetched in background
+		_, statSpan := tracing.Start(ctx, "store.stat")
+		info, err := os.Stat(imgPath)
+		cached := err == nil
+		statSpan.SetAttributes(attribute.Bool("cached", cached))
+		statSpan.End()
+
+		// 5. Build the request to the WMS backend, or to the upstream tile service
+		var sourceURL string
+		var fetch tileFetcher
+		if builder := state.Builder(layer); builder != nil {
+			sourceURL = builder.TileURL(zoom, col, row)
+			fetch = func(ctx context.Context, retry backend.RetryPolicy) error {
+				_, err := builder.SaveTile(ctx, client, zoom, col, row, imgPath, retry)
+				return err
+			}
+		} else {
+			bbox, err := chGrid.GetTileBBox(zoom, col, row)
 			if err != nil {
-				errMsg := fmt.Sprintf("error in GetPngFromUrl tile  zoom:%d, col:%d, row:%d", zoom, col, row)
+				http.Error(w, err.Error(), gridErrorStatus(err))
+				return
+			}
+			params := chGrid.GetWMSParams(*bbox, layerConfig.WMSLayers, int(chGrid.GetTileWidth()), int(chGrid.GetTileHeight()), buffer, "png") // Use GetTileWidth
+			sourceURL = fmt.Sprintf("%s?%s%s", chGrid.WmsBackendUrl, chGrid.WmsStartParams, tools.BuildQueryString(params))
+			fetch = func(ctx context.Context, retry backend.RetryPolicy) error {
+				return tools.GetPngFromUrl(ctx, client, sourceURL, imgPath, buffer, layerConfig.TileFilter(col, row, int(chGrid.GetTileWidth())), retry, l)
+			}
+		}
+		if !cached {
+			l.Debug("file %s is not in cache, downloading: %s", imgPath, sourceURL)
+			// a tile request is not retried, sleeping in the handler piles up requests on a slow backend
+			err = fetch(ctx, backend.NoRetry)
+			if errors.Is(err, backend.ErrUnavailable) {
+				// fail fast with an empty tile the client must not keep, instead of queuing on a backend in trouble
+				l.Warn("serving placeholder for tile zoom:%d, col:%d, row:%d: %v", zoom, col, row, err)
+				metrics.ObserveCache(layerConfig.Name, metrics.CachePlaceholder)
+				servePlaceholderTile(w, int(chGrid.GetTileWidth()), int(chGrid.GetTileHeight()), l)
+				return
+			}
+			metrics.ObserveCache(layerConfig.Name, metrics.CacheMiss)
+			if errors.Is(err, tools.ErrInvalidResponse) {
+				// the backend answered with an exception report or an error page, nothing was cached
+				l.Error("invalid backend response for tile zoom:%d, col:%d, row:%d: %v", zoom, col, row, err)
+				http.Error(w, fmt.Sprintf("backend error for tile zoom:%d, col:%d, row:%d: %v", zoom, col, row, err), http.StatusBadGateway)
+				return
+			}
+			if err != nil {
+				errMsg := fmt.Sprintf("error fetching tile zoom:%d, col:%d, row:%d", zoom, col, row)
+				l.Error(errMsg)
+				http.Error(w, errMsg, http.StatusInternalServerError)
+				return
+			}
+			if info, err = os.Stat(imgPath); err != nil {
+				errMsg := fmt.Sprintf("error doing os.Stat(imgPath:%s)", imgPath)
 				l.Error(errMsg)
 				http.Error(w, errMsg, http.StatusInternalServerError)
 				return
 			}
 		}
-		// Open the image file
+		now := time.Now()
+		expired := layerConfig.IsTileExpired(info.ModTime(), now)
+		if expired {
+			metrics.ObserveCache(layerConfig.Name, metrics.CacheStale)
+			span.SetAttributes(attribute.Bool("tile.expired", true))
+			refresher.refresh(ctx, sourceURL, imgPath, fetch)
+		} else if cached {
+			metrics.ObserveCache(layerConfig.Name, metrics.CacheHit)
+		}
+		// Read the image file, tiles are small, and hash it for the ETag
 		l.Debug("reading local tile %s", imgPath)
-		file, err := os.Open(imgPath)
+		_, readSpan := tracing.Start(ctx, "store.read")
+		data, err := os.ReadFile(imgPath)
+		tracing.EndSpan(readSpan, err)
 		if err != nil {
-			errMsg := fmt.Sprintf("error doing os.Open(imgPath:%s)", imgPath)
+			errMsg := fmt.Sprintf("error doing os.ReadFile(imgPath:%s)", imgPath)
 			l.Error(errMsg)
 			http.Error(w, errMsg, http.StatusInternalServerError)
 			return
 		}
-		defer file.Close()
+		w.Header().Set("ETag", wmts.TileETag(data))
+		w.Header().Set("Cache-Control", tileCacheControl(layerConfig, info.ModTime(), now, expired))
 		// Using http.ServeContent to efficiently serve the file content.
 		// This function handles a number of important HTTP features automatically:
 		// - Caching: It supports `If-Modified-Since` and `If-None-Match` headers,
@@ -272,12 +377,62 @@ func getTileImageHandler(chGrid *wmts.Grid, layers map[string]wmts.LayerConfig,
 		// - Content Headers: It sets the correct `Content-Type` and `Content-Length` headers
 		//   for the response.
 		//
-		// We pass a `time.Now()` as the `modtime` because the file is dynamically generated
-		// and we want to prevent clients from caching it for too long, as its content
-		// might change in the future.
-		http.ServeContent(w, r, filepath.Base(imgPath), time.Now(), file)
+		// The tile modification time is sent as Last-Modified, except for the tiles marked as stale
+		// whose mtime is the Unix epoch, ServeContent omits it in this case.
+		http.ServeContent(w, r, filepath.Base(imgPath), info.ModTime(), bytes.NewReader(data))
+
+	}
+}
+
+// servePlaceholderTile sends a transparent tile with headers preventing the clients to cache it
+func servePlaceholderTile(w http.ResponseWriter, width, height int, l golog.MyLogger) {
+	data, err := imgTools.GeneratePng(0, 0, 0, 0, width, height)
+	if err != nil {
+		l.Error("error generating placeholder tile: %v", err)
+		http.Error(w, "WMS backend unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Tile-Placeholder", "true")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
 
+// tileCacheControl tells clients how long they may keep the tile: until it expires for layers with a max_age_sec,
+// defaultTileMaxAgeSec otherwise. An expired tile being refreshed must be revalidated on next use.
+func tileCacheControl(lc wmts.LayerConfig, modTime, now time.Time, expired bool) string {
+	if expired {
+		return "public, max-age=0, must-revalidate"
 	}
+	maxAge := defaultTileMaxAgeSec
+	if lc.MaxAgeSec > 0 {
+		maxAge = lc.MaxAgeSec - int(now.Sub(modTime).Seconds())
+	}
+	return fmt.Sprintf("public, max-age=%d", max(maxAge, 0))
+}
+
+// parseSettingsFlags parses the command line, only the flags explicitly given override the env and YAML settings
+func parseSettingsFlags() wmts.SettingsFlags {
+	buffer := flag.Int("buffer", defaultBufferSize, "buffer in pixel around tiles, overrides env BUFFER_SIZE")
+	cacheFolder := flag.String("cacheFolder", "", "folder of the tiles cache, overrides env CACHE_FOLDER")
+	clientTimeOut := flag.Int("ClientTimeOut", defaultMaxClientTimeOutSec, "WMS client timeout in seconds, overrides env CLIENT_TIMEOUT_SEC")
+	numWorkers := flag.Int("workers", defaultNumWorkers, "number of worker goroutines, overrides env NUM_WORKERS")
+	flag.Parse()
+	var flags wmts.SettingsFlags
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "buffer":
+			flags.BufferSize = buffer
+		case "cacheFolder":
+			flags.CacheFolder = cacheFolder
+		case "ClientTimeOut":
+			flags.ClientTimeoutSec = clientTimeOut
+		case "workers":
+			flags.NumWorkers = numWorkers
+		}
+	})
+	return flags
 }
 
 func main() {
@@ -293,28 +448,35 @@ func main() {
 	l.Info("🚀🚀 Starting App:'%s', ver:%s, build:%s, from: %s", version.APP, version.VERSION, version.Build, version.REPOSITORY)
 
 	configPath := config.GetLayersConfigPathFromEnvOrPanic()
-	myConfig, err := wmts.ConfigFromYAML(configPath)
+	registry, err := wmts.NewLayerRegistry(configPath, parseSettingsFlags(), wmts.Settings{
+		BufferSize:       defaultBufferSize,
+		ClientTimeoutSec: defaultMaxClientTimeOutSec,
+		NumWorkers:       defaultNumWorkers,
+	}, l)
 	if err != nil {
-		l.Fatal("error loading %s layer config: %v", configPath, err)
-	}
-	basePath := myConfig.Caches.Local.Folder
-	layers := myConfig.Layers
-	// Check if there are layers loaded
-	if len(layers) == 0 {
-		l.Fatal("no layers loaded from %s", configPath)
+		l.Fatal("%v", err)
 	}
+	l.Info("ℹ️ Settings: %s", registry.Current().Settings)
 	// Print loaded layers for info
-	firstLayer := ""
-	for name, layer := range layers {
-		firstLayer = name
+	for _, layer := range registry.Current().Layers {
 		wmts.PrintLayerInfo(layer)
 	}
+	// the layers config is reloaded on SIGHUP and each time the file changes
+	if err := registry.WatchFile(context.Background()); err != nil {
+		l.Warn("config hot reload on file change is disabled: %v", err)
+	}
+	hangupChan := make(chan os.Signal, 1)
+	signal.Notify(hangupChan, syscall.SIGHUP)
+	go func() {
+		for range hangupChan {
+			registry.ReloadAndLog("SIGHUP received")
+		}
+	}()
 
-	wmsBackEndUrl := layers[firstLayer].WMSBackendURL
-	wmsStartParams := layers[firstLayer].WMSBackendPrefix
-
-	// Create a new grid
-	myGrid := wmts.CreateNewLausanneGridFromEnvOrFail(wmsBackEndUrl, wmsStartParams, l)
+	shutdownTracing, err := tracing.Init(context.Background(), config.GetTracesExporterFromEnvOrPanic(), version.APP, version.VERSION)
+	if err != nil {
+		l.Fatal("💥💥 error initializing tracing: %v", err)
+	}
 
 	myVersionReader := gohttp.NewSimpleVersionReader(version.APP, version.VERSION, version.REPOSITORY, version.Build)
 	server := gohttp.CreateNewServerFromEnvOrFail(
@@ -322,16 +484,47 @@ func main() {
 		defaultServerIp,
 		myVersionReader,
 		l)
+	server.AddShutdownHook(shutdownTracing)
+	// the server is not ready when all the WMS backends are failing, tiles in cache could still be served by another instance
+	server.AddReadinessCheck("wms_backends", func() (bool, any) {
+		return backend.Default.Ready(), backend.Default.Health()
+	})
 	mux := server.GetRouter()
 
-	mux.Handle("GET /layersInfo", gohttp.CorsMiddleware(GetLayersInfoHandler(layers, l)))
+	mux.Handle("GET /layersInfo", gohttp.CorsMiddleware(GetLayersInfoHandler(registry, l)))
 
 	// route to retrieve information about a tile surrounding the given coordinates
-	mux.Handle("GET /getTileByXY/{layer}/{zoom}/{x}/{y}", gohttp.CorsMiddleware(getTileInfoByXYHandler(myGrid, layers, l)))
+	mux.Handle("GET /getTileByXY/{layer}/{zoom}/{x}/{y}", gohttp.CorsMiddleware(getTileInfoByXYHandler(registry, l)))
+
+	// route to convert coordinates between LV95, LV03, WGS84 and WebMercator
+	mux.Handle("GET /transform", gohttp.CorsMiddleware(transformHandler(l)))
+
+	// route to render an image of a bbox of a layer from its tiles, for reports
+	mux.Handle("GET /staticmap", gohttp.CorsMiddleware(tracing.InstrumentHandler(staticMapHandler(registry, l), "GET staticmap")))
+
+	// WMS facade serving GetCapabilities and GetMap from the cached tiles, for desktop GIS clients
+	mux.Handle("GET /wms", gohttp.CorsMiddleware(tracing.InstrumentHandler(wmsHandler(registry, l), "GET wms")))
 
 	wmtsUrlTemplate := fmt.Sprintf("/%s/{layer}/%s/{year}/{matrixSet}/{zoom}/{row}/{col}", defaultWmtsUrlPrefix, defaultWmtsUrlStyle)
 	l.Debug("tiles url template: %s", wmtsUrlTemplate)
-	mux.Handle(fmt.Sprintf("GET %s", wmtsUrlTemplate), gohttp.CorsMiddleware(getTileImageHandler(myGrid, layers, basePath, l)))
+	mux.Handle(fmt.Sprintf("GET %s", wmtsUrlTemplate), gohttp.CorsMiddleware(tracing.InstrumentHandler(tileMetricsMiddleware(registry, getTileImageHandler(server.Context(), registry, l)), "GET tile")))
+
+	// admin API to seed the cache, only available when the ADMIN_TOKEN env variable is defined
+	if adminToken, enabled := config.GetAdminTokenFromEnv(); enabled {
+		seedClient := tools.CreateHTTPClient(registry.Current().Settings.ClientTimeoutSec, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
+		seedManager := seed.NewManager(server.Context(), registry, seedClient,
+			config.GetAdminMaxSeedJobsFromEnvOrPanic(seed.DefaultMaxJobs), config.GetAdminJobRetentionFromEnvOrPanic(seed.DefaultJobRetention), l)
+		adminAuth := gohttp.BearerAuthMiddleware(adminToken, l)
+		mux.Handle("POST /admin/seed/jobs", adminAuth(startSeedJobHandler(seedManager, l)))
+		mux.Handle("GET /admin/seed/jobs", adminAuth(listSeedJobsHandler(seedManager, l)))
+		mux.Handle("GET /admin/seed/jobs/{id}", adminAuth(getSeedJobHandler(seedManager, l)))
+		mux.Handle("DELETE /admin/seed/jobs/{id}", adminAuth(cancelSeedJobHandler(seedManager, l)))
+		mux.Handle("POST /admin/invalidate", adminAuth(invalidateTilesHandler(seedManager, l)))
+		mux.Handle("GET "+seed.AdminTilesPath+"{layer}/{zoom}/{row}/{col}", adminAuth(getCachedTileHandler(registry, l)))
+		mux.Handle("PUT "+seed.AdminTilesPath+"{layer}/{zoom}/{row}/{col}", adminAuth(putTileHandler(registry, l)))
+	} else {
+		l.Info("ℹ️ admin API is disabled, define env ADMIN_TOKEN to enable it")
+	}
 
 	mux.HandleFunc("GET /", GetMyDefaultHandler(server, defaultWebRootDir, content))
 	server.StartServer()
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+func TestTileCacheControl(t *testing.T) {
+	now := time.Now()
+	live := wmts.LayerConfig{LayerDefaultValues: wmts.LayerDefaultValues{MaxAgeSec: 3000}}
+	tests := []struct {
+		name    string
+		lc      wmts.LayerConfig
+		modTime time.Time
+		expired bool
+		want    string
+	}{
+		{"layer without max age", wmts.LayerConfig{}, now.Add(-48 * time.Hour), false, "public, max-age=" + strconv.Itoa(defaultTileMaxAgeSec)},
+		{"fresh tile", live, now.Add(-1000 * time.Second), false, "public, max-age=2000"},
+		{"tile expiring now", live, now.Add(-3000 * time.Second), false, "public, max-age=0"},
+		{"expired tile", live, now.Add(-4000 * time.Second), true, "public, max-age=0, must-revalidate"},
+		{"stale tile", wmts.LayerConfig{}, wmts.StaleModTime, true, "public, max-age=0, must-revalidate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tileCacheControl(tt.lc, tt.modTime, now, tt.expired); got != tt.want {
+				t.Errorf("tileCacheControl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTileImageHandler(t *testing.T) {
+	// the WMS backend answers once release is closed, counting the requests
+	var requests atomic.Int32
+	release := make(chan struct{})
+	wms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		width, _ := strconv.Atoi(r.URL.Query().Get("WIDTH"))
+		height, _ := strconv.Atoi(r.URL.Query().Get("HEIGHT"))
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewGray(image.Rect(0, 0, width, height)))
+	}))
+	defer wms.Close()
+	defer close(release)
+	registry := newTestRegistry(t, wms.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mux := http.NewServeMux()
+	mux.Handle("GET /tiles/1.0.0/{layer}/default/{year}/{matrixSet}/{zoom}/{row}/{col}", getTileImageHandler(ctx, registry, newTestLogger(t)))
+
+	state := registry.Current()
+	store := wmts.NewFileStore(state.BasePath())
+	var cached bytes.Buffer
+	if err := png.Encode(&cached, image.NewGray(image.Rect(0, 0, 256, 256))); err != nil {
+		t.Fatal(err)
+	}
+	planVille, _, _ := state.Layer("plan_ville")
+	live, _, _ := state.Layer("plan_ville_live")
+	for _, lc := range []wmts.LayerConfig{planVille, live} {
+		for _, row := range []int{3, 4} {
+			if err := store.Write(lc, 0, row, 2, cached.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// row 3 of plan_ville_live was cached 1000 seconds ago, row 4 of plan_ville is marked as stale
+	cachedAt := time.Now().Add(-1000 * time.Second)
+	if err := os.Chtimes(store.TilePath(live, 0, 3, 2), cachedAt, cachedAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.MarkStale(planVille, 0, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	get := func(layer string, row int, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/tiles/1.0.0/"+layer+"/default/2025/swissgrid_05/0/"+strconv.Itoa(row)+"/2", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	etag := wmts.TileETag(cached.Bytes())
+
+	t.Run("cached tile", func(t *testing.T) {
+		rec := get("plan_ville", 3, nil)
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), cached.Bytes()) {
+			t.Fatalf("status %d with %d bytes, want 200 with the %d bytes cached", rec.Code, rec.Body.Len(), cached.Len())
+		}
+		if got := rec.Header().Get("ETag"); got != etag {
+			t.Errorf("ETag = %s, want %s", got, etag)
+		}
+		if got, want := rec.Header().Get("Cache-Control"), "public, max-age="+strconv.Itoa(defaultTileMaxAgeSec); got != want {
+			t.Errorf("Cache-Control = %q, want %q", got, want)
+		}
+	})
+	t.Run("If-None-Match of the cached tile", func(t *testing.T) {
+		rec := get("plan_ville", 3, http.Header{"If-None-Match": {etag}})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("status %d with %d bytes, want 304 without body", rec.Code, rec.Body.Len())
+		}
+		if rec := get("plan_ville", 3, http.Header{"If-None-Match": {`"other"`}}); rec.Code != http.StatusOK {
+			t.Errorf("status %d for another ETag, want 200", rec.Code)
+		}
+	})
+	t.Run("fresh tile of a layer with a max age", func(t *testing.T) {
+		rec := get("plan_ville_live", 3, nil)
+		// the tile expires in 2000 seconds, a second may have passed
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=2000" && got != "public, max-age=1999" {
+			t.Errorf("Cache-Control = %q, want public, max-age=2000", got)
+		}
+	})
+	t.Run("stale tile refreshed once", func(t *testing.T) {
+		// the refresh waits for the backend, the next requests of the tile are served from the cache meanwhile
+		for range 5 {
+			rec := get("plan_ville", 4, nil)
+			if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), cached.Bytes()) {
+				t.Fatalf("status %d with %d bytes, want the stale tile", rec.Code, rec.Body.Len())
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "public, max-age=0, must-revalidate" {
+				t.Errorf("Cache-Control = %q, want public, max-age=0, must-revalidate", got)
+			}
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for requests.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		release <- struct{}{}
+		for time.Now().Before(deadline) {
+			if info, err := store.Stat(planVille, 0, 4, 2); err == nil && !wmts.IsStaleModTime(info.ModTime()) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if info, err := store.Stat(planVille, 0, 4, 2); err != nil || wmts.IsStaleModTime(info.ModTime()) {
+			t.Fatalf("the stale tile was not refreshed: %v", err)
+		}
+		if got := requests.Load(); got != 1 {
+			t.Errorf("%d backend requests for 5 requests of the stale tile, want 1", got)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+const (
+	maxStaticMapMarkers  = 100
+	staticMapJpegQuality = 90
+)
+
+// parseStaticMapParams reads the query of a /staticmap request, the bbox and the markers given in another
+// spatial reference with crs are converted to the one of the layer grid
+func parseStaticMapParams(r *http.Request, grid *wmts.Grid) (m wmts.StaticMap, format string, err error) {
+	query := r.URL.Query()
+	b, err := parseCoordinates(query.Get("bbox"), 4)
+	if err != nil {
+		return m, "", fmt.Errorf("bbox: %w", err)
+	}
+	if m.Width, err = strconv.Atoi(query.Get("width")); err != nil {
+		return m, "", fmt.Errorf("invalid width %q", query.Get("width"))
+	}
+	if m.Height, err = strconv.Atoi(query.Get("height")); err != nil {
+		return m, "", fmt.Errorf("invalid height %q", query.Get("height"))
+	}
+	format = strings.ToLower(query.Get("format"))
+	switch format {
+	case "", "png", "image/png":
+		format = "png"
+	case "jpeg", "jpg", "image/jpeg":
+		format = "jpeg"
+	default:
+		return m, "", fmt.Errorf("unsupported format %q, use png or jpeg", format)
+	}
+	if m.ScaleBar, err = strconv.ParseBool(query.Get("scalebar")); query.Get("scalebar") != "" && err != nil {
+		return m, "", fmt.Errorf("invalid scalebar %q, use true or false", query.Get("scalebar"))
+	}
+	transform := coords.Transform(func(x, y float64) (float64, float64) { return x, y })
+	if crs := query.Get("crs"); crs != "" {
+		from, err := coords.ParseCRS(crs)
+		if err != nil {
+			return m, "", err
+		}
+		if transform, err = coords.NewTransform(from, grid.SpatialREF); err != nil {
+			return m, "", err
+		}
+	}
+	if b[0] >= b[2] || b[1] >= b[3] {
+		return m, "", fmt.Errorf("bbox: xmin must be less than xmax and ymin less than ymax")
+	}
+	xMin, yMin, xMax, yMax := transform.BBox(b[0], b[1], b[2], b[3])
+	m.BBox = wmts.BBox{XMin: xMin, YMin: yMin, XMax: xMax, YMax: yMax}
+	markers := query["marker"]
+	if len(markers) > maxStaticMapMarkers {
+		return m, "", fmt.Errorf("at most %d markers can be drawn", maxStaticMapMarkers)
+	}
+	for _, marker := range markers {
+		// x,y or x,y,#rrggbb
+		c := imgTools.DefaultMarkerColor
+		if i := strings.Index(marker, ",#"); i >= 0 {
+			if c, err = imgTools.ParseHexColor(marker[i+1:]); err != nil {
+				return m, "", fmt.Errorf("marker: %w", err)
+			}
+			marker = marker[:i]
+		}
+		xy, err := parseCoordinates(marker, 2)
+		if err != nil {
+			return m, "", fmt.Errorf("marker: %w", err)
+		}
+		x, y := transform(xy[0], xy[1])
+		m.Markers = append(m.Markers, wmts.Marker{X: x, Y: y, Color: c})
+	}
+	return m, format, nil
+}
+
+// staticMapHandler answers /staticmap?layer=&bbox=xmin,ymin,xmax,ymax&width=&height= with a png or jpeg image
+// of the bbox drawn from the cached tiles of the layer, see wmts.RenderStaticMap
+func staticMapHandler(registry *wmts.LayerRegistry, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "staticMapHandler"
+	clientTimeOut := registry.Current().Settings.ClientTimeoutSec
+	l.Debug("Initial call to %s, client timeout: %d", handlerName, clientTimeOut)
+	client := tools.CreateHTTPClient(clientTimeOut, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		layer := r.URL.Query().Get("layer")
+		state := registry.Current()
+		_, grid, exists := state.Layer(layer)
+		if !exists {
+			http.Error(w, fmt.Sprintf("invalid layer %q", layer), http.StatusBadRequest)
+			return
+		}
+		m, format, err := parseStaticMapParams(r, grid)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		img, err := wmts.RenderStaticMap(r.Context(), tools.ClientWithTimeout(client, state.Settings.ClientTimeoutSec), state, layer, m)
+		switch {
+		case errors.Is(err, wmts.ErrOutsideGridExtent):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, wmts.ErrStaticMapTooLarge):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, backend.ErrUnavailable), errors.Is(err, tools.ErrInvalidResponse):
+			l.Error("static map of layer %s: %v", layer, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		case err != nil && r.Context().Err() != nil:
+			// the client is gone
+			return
+		case err != nil:
+			l.Error("static map of layer %s: %v", layer, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if format == "jpeg" {
+			// jpeg has no transparency, the parts without tiles are white
+			opaque := image.NewRGBA(img.Bounds())
+			draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+			draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+			err = jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: staticMapJpegQuality})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			l.Error("error encoding the static map: %v", err)
+			http.Error(w, "error encoding the static map", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/"+format)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStaticMapHandlerRejectsInvalidParams(t *testing.T) {
+	var requests atomic.Int32
+	wms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	defer wms.Close()
+	handler := staticMapHandler(newTestRegistry(t, wms.URL), newTestLogger(t))
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{"unknown layer", "layer=other&bbox=2537000,1152000,2538000,1153000&width=100&height=100", http.StatusBadRequest},
+		{"missing bbox", "layer=plan_ville&width=100&height=100", http.StatusBadRequest},
+		{"bbox of 3 numbers", "layer=plan_ville&bbox=2537000,1152000,2538000&width=100&height=100", http.StatusBadRequest},
+		{"bbox not a number", "layer=plan_ville&bbox=2537000,1152000,east,1153000&width=100&height=100", http.StatusBadRequest},
+		{"inverted bbox", "layer=plan_ville&bbox=2538000,1152000,2537000,1153000&width=100&height=100", http.StatusBadRequest},
+		{"empty bbox", "layer=plan_ville&bbox=2537000,1152000,2537000,1152000&width=100&height=100", http.StatusBadRequest},
+		{"width not a number", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=wide&height=100", http.StatusBadRequest},
+		{"missing height", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=100", http.StatusBadRequest},
+		{"zero width", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=0&height=100", http.StatusBadRequest},
+		{"negative height", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=100&height=-1", http.StatusBadRequest},
+		{"too large", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=5000&height=100", http.StatusBadRequest},
+		{"unsupported format", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=100&height=100&format=gif", http.StatusBadRequest},
+		{"invalid scalebar", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=100&height=100&scalebar=maybe", http.StatusBadRequest},
+		{"invalid marker", "layer=plan_ville&bbox=2537000,1152000,2538000,1153000&width=100&height=100&marker=2537500", http.StatusBadRequest},
+		{"outside the grid", "layer=plan_ville&bbox=0,0,1000,1000&width=100&height=100", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/staticmap?"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+	if requests.Load() != 0 {
+		t.Errorf("%d backend requests for invalid static maps", requests.Load())
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tileFetcher downloads a tile to its cache file with the given retry policy
+type tileFetcher func(ctx context.Context, retry backend.RetryPolicy) error
+
+// tileRefresher fetches again in background the expired tiles, at most one refresh runs for a given tile
+type tileRefresher struct {
+	ctx      context.Context // the refreshes are cancelled when it is done
+	l        golog.MyLogger
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+func newTileRefresher(ctx context.Context, l golog.MyLogger) *tileRefresher {
+	return &tileRefresher{
+		ctx:      ctx,
+		l:        l,
+		inFlight: make(map[string]bool),
+	}
+}
+
+// refresh starts fetch, the download of sourceURL to imgPath, unless a refresh of imgPath is already running.
+// The new tile replaces the old one atomically so it can still be served in the meantime.
+// The download is part of the trace in ctx but is not cancelled with it, only with the context of the refresher.
+func (t *tileRefresher) refresh(ctx context.Context, sourceURL, imgPath string, fetch tileFetcher) {
+	ctx = trace.ContextWithSpanContext(t.ctx, trace.SpanContextFromContext(ctx))
+	t.mu.Lock()
+	if t.inFlight[imgPath] {
+		t.mu.Unlock()
+		return
+	}
+	t.inFlight[imgPath] = true
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.inFlight, imgPath)
+			t.mu.Unlock()
+		}()
+		t.l.Debug("refreshing expired tile %s from: %s", imgPath, sourceURL)
+		// nobody waits for the refresh, it uses the retry policy of the backend
+		if err := fetch(ctx, backend.Default.RetryPolicyForURL(sourceURL)); err != nil {
+			// the expired tile stays in cache and will be refreshed on a next request
+			t.l.Error("💥 background refresh of tile %s failed: %v", imgPath, err)
+		}
+	}()
+}
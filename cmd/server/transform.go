@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// maxTransformPoints limits the number of point parameters of a /transform request
+const maxTransformPoints = 1000
+
+// TransformResponse gives the coordinates of a /transform request in the target spatial reference
+type TransformResponse struct {
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Points [][2]float64 `json:"points,omitempty"`
+	BBox   []float64    `json:"bbox,omitempty"` // extent of the transformed bbox
+}
+
+// parseCoordinates parses a comma separated list of n numbers
+func parseCoordinates(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d comma separated numbers, got %q", n, s)
+	}
+	values := make([]float64, n)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in %q", part, s)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// transformHandler converts the point (x,y, repeatable) and bbox (xmin,ymin,xmax,ymax) query parameters
+// from the spatial reference from to the spatial reference to, like /transform?from=wgs84&to=EPSG:2056&point=6.63,46.52
+func transformHandler(l golog.MyLogger) http.HandlerFunc {
+	handlerName := "transformHandler"
+	l.Debug("Initial call to %s", handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		query := r.URL.Query()
+		from, err := coords.ParseCRS(query.Get("from"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "from: " + err.Error()}, l)
+			return
+		}
+		to, err := coords.ParseCRS(query.Get("to"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "to: " + err.Error()}, l)
+			return
+		}
+		points, bbox := query["point"], query.Get("bbox")
+		if len(points) == 0 && bbox == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "a point or a bbox parameter is required"}, l)
+			return
+		}
+		if len(points) > maxTransformPoints {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("at most %d points can be transformed at once", maxTransformPoints)}, l)
+			return
+		}
+		transform, err := coords.NewTransform(from, to)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()}, l)
+			return
+		}
+		response := TransformResponse{From: fmt.Sprintf("EPSG:%d", from), To: fmt.Sprintf("EPSG:%d", to)}
+		for _, point := range points {
+			xy, err := parseCoordinates(point, 2)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "point: " + err.Error()}, l)
+				return
+			}
+			x, y := transform(xy[0], xy[1])
+			response.Points = append(response.Points, [2]float64{x, y})
+		}
+		if bbox != "" {
+			b, err := parseCoordinates(bbox, 4)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "bbox: " + err.Error()}, l)
+				return
+			}
+			if b[0] > b[2] || b[1] > b[3] {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: "bbox: xmin must be less than xmax and ymin less than ymax"}, l)
+				return
+			}
+			xMin, yMin, xMax, yMax := transform.BBox(b[0], b[1], b[2], b[3])
+			response.BBox = []float64{xMin, yMin, xMax, yMax}
+		}
+		writeJSON(w, http.StatusOK, response, l)
+	}
+}
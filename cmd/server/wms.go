@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+const (
+	// maxWMSMapSize is the largest width or height of a GetMap, a map is drawn in memory like a static map
+	maxWMSMapSize = wmts.MaxStaticMapSize
+	// maxWMSMapsDrawn is the number of GetMap drawn at the same time, each one holds a canvas of up to 64 MB
+	maxWMSMapsDrawn = 4
+	// maxWMSErrorBodySize is the maximum number of bytes of a backend answer read when it is not an image
+	maxWMSErrorBodySize = 64 * 1024
+)
+
+// wmsError is a request error answered with a WMS exception report
+type wmsError struct {
+	status  int
+	code    string // WMS exception code, like InvalidFormat, may be empty
+	message string
+}
+
+func (e *wmsError) Error() string {
+	return e.message
+}
+
+// wmsServiceExceptionReport is the WMS 1.3.0 exception report
+type wmsServiceExceptionReport struct {
+	XMLName    xml.Name              `xml:"ServiceExceptionReport"`
+	Version    string                `xml:"version,attr"`
+	Xmlns      string                `xml:"xmlns,attr"`
+	Exceptions []wmsServiceException `xml:"ServiceException"`
+}
+
+type wmsServiceException struct {
+	Code    string `xml:"code,attr,omitempty"`
+	Message string `xml:",chardata"`
+}
+
+// writeWMSException answers err as an exception report with its status
+func writeWMSException(w http.ResponseWriter, err *wmsError, l golog.MyLogger) {
+	report := wmsServiceExceptionReport{
+		Version:    "1.3.0",
+		Xmlns:      "http://www.opengis.net/ogc",
+		Exceptions: []wmsServiceException{{Code: err.code, Message: err.message}},
+	}
+	data, encodeErr := xml.MarshalIndent(report, "", "  ")
+	if encodeErr != nil {
+		l.Error("Error encoding WMS exception: %v", encodeErr)
+		http.Error(w, err.message, err.status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.ogc.se_xml")
+	w.WriteHeader(err.status)
+	w.Write(append([]byte(xml.Header), data...))
+}
+
+// wmsParams returns the query parameters with upper case names, the names of the WMS parameters are case-insensitive
+func wmsParams(query url.Values) map[string]string {
+	params := make(map[string]string, len(query))
+	for name, values := range query {
+		if len(values) > 0 {
+			params[strings.ToUpper(name)] = values[0]
+		}
+	}
+	return params
+}
+
+// invalidCRSCode returns the exception code of an unsupported spatial reference, it was named SRS before WMS 1.3.0
+func invalidCRSCode(params map[string]string) string {
+	if strings.HasPrefix(params["VERSION"], "1.1") {
+		return "InvalidSRS"
+	}
+	return "InvalidCRS"
+}
+
+// parseWMSCRS returns the EPSG code of the CRS (or SRS) of a GetMap and whether its bbox is latitude first,
+// which is the case of EPSG:4326 in WMS 1.3.0. Any EPSG code is accepted, the ones not used by a grid are
+// requested from the backend of the layers.
+func parseWMSCRS(params map[string]string) (code int, latitudeFirst bool, err error) {
+	crs, version := params["CRS"], params["VERSION"]
+	if crs == "" {
+		crs = params["SRS"]
+	}
+	if strings.EqualFold(crs, "CRS:84") {
+		return coords.WGS84, false, nil
+	}
+	name, ok := strings.CutPrefix(strings.ToUpper(crs), "EPSG:")
+	if code, err = strconv.Atoi(name); !ok || err != nil {
+		return 0, false, fmt.Errorf("invalid CRS %q, expected EPSG:<code>", crs)
+	}
+	return code, code == coords.WGS84 && !strings.HasPrefix(version, "1.1"), nil
+}
+
+// parseGetMapParams reads the parameters of a GetMap, the error is a *wmsError
+func parseGetMapParams(params map[string]string) (req wmts.WMSMapRequest, format string, background color.Color, err error) {
+	for _, name := range []string{"LAYERS", "BBOX", "WIDTH", "HEIGHT", "FORMAT"} {
+		if params[name] == "" {
+			return req, "", nil, &wmsError{status: http.StatusBadRequest, code: "MissingParameterValue", message: "missing parameter " + name}
+		}
+	}
+	req.Layers = strings.Split(params["LAYERS"], ",")
+	code, latitudeFirst, err := parseWMSCRS(params)
+	if err != nil {
+		return req, "", nil, &wmsError{status: http.StatusBadRequest, code: invalidCRSCode(params), message: err.Error()}
+	}
+	req.CRS = code
+	b, err := parseCoordinates(params["BBOX"], 4)
+	if err != nil {
+		return req, "", nil, &wmsError{status: http.StatusBadRequest, message: "BBOX: " + err.Error()}
+	}
+	if latitudeFirst {
+		b[0], b[1], b[2], b[3] = b[1], b[0], b[3], b[2]
+	}
+	if b[0] >= b[2] || b[1] >= b[3] {
+		return req, "", nil, &wmsError{status: http.StatusBadRequest, message: "BBOX: the minimum values must be less than the maximum ones"}
+	}
+	req.BBox = wmts.BBox{XMin: b[0], YMin: b[1], XMax: b[2], YMax: b[3]}
+	if req.Width, err = strconv.Atoi(params["WIDTH"]); err != nil || req.Width < 1 || req.Width > maxWMSMapSize {
+		return req, "", nil, &wmsError{status: http.StatusBadRequest, message: fmt.Sprintf("WIDTH must be between 1 and %d", maxWMSMapSize)}
+	}
+	if req.Height, err = strconv.Atoi(params["HEIGHT"]); err != nil || req.Height < 1 || req.Height > maxWMSMapSize {
+		return req, "", nil, &wmsError{status: http.StatusBadRequest, message: fmt.Sprintf("HEIGHT must be between 1 and %d", maxWMSMapSize)}
+	}
+	switch format = strings.ToLower(params["FORMAT"]); {
+	case strings.HasPrefix(format, "image/png"):
+		format = "image/png"
+	case format == "image/jpeg", format == "image/jpg":
+		format = "image/jpeg"
+	default:
+		return req, "", nil, &wmsError{status: http.StatusBadRequest, code: "InvalidFormat", message: fmt.Sprintf("unsupported FORMAT %q, use image/png or image/jpeg", params["FORMAT"])}
+	}
+	// the maps are opaque by default in WMS, on a white background or BGCOLOR
+	if format == "image/png" && strings.EqualFold(params["TRANSPARENT"], "true") {
+		return req, format, nil, nil
+	}
+	background = color.White
+	if bg := params["BGCOLOR"]; bg != "" {
+		rgb, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(bg), "0x"), 16, 32)
+		if err != nil || rgb > 0xffffff {
+			return req, "", nil, &wmsError{status: http.StatusBadRequest, message: fmt.Sprintf("invalid BGCOLOR %q, expected 0xRRGGBB", bg)}
+		}
+		background = color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}
+	}
+	return req, format, background, nil
+}
+
+// wmsServiceURL returns the url of the /wms endpoint as seen by the client, behind a proxy setting X-Forwarded-Proto too
+func wmsServiceURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
+
+// wmsHandler answers the WMS GetCapabilities and GetMap requests for the configured layers, the maps are drawn from
+// the cached tiles when possible, see wmts.GetMap
+func wmsHandler(registry *wmts.LayerRegistry, l golog.MyLogger) http.HandlerFunc {
+	handlerName := "wmsHandler"
+	clientTimeOut := registry.Current().Settings.ClientTimeoutSec
+	l.Debug("Initial call to %s, client timeout: %d", handlerName, clientTimeOut)
+	client := tools.CreateHTTPClient(clientTimeOut, defaultMaxIdleConn, defaultMaxIdleConnPerHost, defaultIdleConnTimeoutSec)
+	drawing := make(chan struct{}, maxWMSMapsDrawn)
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.Debug(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr, "")
+		params := wmsParams(r.URL.Query())
+		if service := params["SERVICE"]; service != "" && !strings.EqualFold(service, "WMS") {
+			writeWMSException(w, &wmsError{status: http.StatusBadRequest, message: fmt.Sprintf("unsupported SERVICE %q, only WMS is served", service)}, l)
+			return
+		}
+		state := registry.Current()
+		switch strings.ToLower(params["REQUEST"]) {
+		case "getcapabilities":
+			data, err := wmts.WMSCapabilities(state, wmsServiceURL(r))
+			if err != nil {
+				l.Error("error encoding the WMS capabilities: %v", err)
+				writeWMSException(w, &wmsError{status: http.StatusInternalServerError, message: "error encoding the capabilities"}, l)
+				return
+			}
+			w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+			w.Write(data)
+		case "getmap":
+			serveWMSMap(w, r, tools.ClientWithTimeout(client, state.Settings.ClientTimeoutSec), state, params, drawing, l)
+		default:
+			writeWMSException(w, &wmsError{status: http.StatusBadRequest, code: "OperationNotSupported", message: fmt.Sprintf("unsupported REQUEST %q, use GetCapabilities or GetMap", params["REQUEST"])}, l)
+		}
+	}
+}
+
+// serveWMSMap answers a GetMap, at most cap(drawing) maps are drawn at the same time and the others wait.
+// The map of a single layer requested from its backend is sent as is, see wmts.BackendMapURL.
+func serveWMSMap(w http.ResponseWriter, r *http.Request, client *http.Client, state *wmts.LayersState, params map[string]string, drawing chan struct{}, l golog.MyLogger) {
+	req, format, background, err := parseGetMapParams(params)
+	if err != nil {
+		writeWMSException(w, err.(*wmsError), l)
+		return
+	}
+	if backendURL, ok := wmts.BackendMapURL(state, req, format, background); ok {
+		proxyWMSMap(w, r, client, backendURL, format, l)
+		return
+	}
+	select {
+	case drawing <- struct{}{}:
+		defer func() { <-drawing }()
+	case <-r.Context().Done():
+		// the client is gone
+		return
+	}
+	img, err := wmts.GetMap(r.Context(), client, state, req)
+	switch {
+	case errors.Is(err, wmts.ErrLayerNotDefined):
+		writeWMSException(w, &wmsError{status: http.StatusBadRequest, code: "LayerNotDefined", message: err.Error()}, l)
+		return
+	case errors.Is(err, wmts.ErrInvalidCRS):
+		writeWMSException(w, &wmsError{status: http.StatusBadRequest, code: invalidCRSCode(params), message: err.Error()}, l)
+		return
+	case errors.Is(err, wmts.ErrStaticMapTooLarge):
+		writeWMSException(w, &wmsError{status: http.StatusBadRequest, message: err.Error()}, l)
+		return
+	case errors.Is(err, backend.ErrUnavailable), errors.Is(err, tools.ErrInvalidResponse):
+		l.Error("WMS GetMap %v: %v", req.Layers, err)
+		writeWMSException(w, &wmsError{status: http.StatusBadGateway, message: err.Error()}, l)
+		return
+	case err != nil && r.Context().Err() != nil:
+		// the client is gone
+		return
+	case err != nil:
+		l.Error("WMS GetMap %v: %v", req.Layers, err)
+		writeWMSException(w, &wmsError{status: http.StatusInternalServerError, message: err.Error()}, l)
+		return
+	}
+	if background != nil {
+		flattenOn(img, background)
+	}
+	var buf bytes.Buffer
+	if format == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: staticMapJpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		l.Error("error encoding the WMS map: %v", err)
+		writeWMSException(w, &wmsError{status: http.StatusInternalServerError, message: "error encoding the map"}, l)
+		return
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// flattenOn draws img over the opaque background in place, img has premultiplied colors so a pixel of alpha a
+// receives the background with a weight of 1 - a
+func flattenOn(img *image.RGBA, background color.Color) {
+	br, bg, bb, _ := background.RGBA()
+	bgPix := [3]uint32{br >> 8, bg >> 8, bb >> 8}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			transparency := 0xff - uint32(row[i+3])
+			for c := 0; c < 3; c++ {
+				row[i+c] += uint8((bgPix[c]*transparency + 0x7f) / 0xff)
+			}
+			row[i+3] = 0xff
+		}
+	}
+}
+
+// proxyWMSMap sends the answer of the backend GetMap at backendURL to the client without decoding it, the errors
+// of the backend are answered with a 502
+func proxyWMSMap(w http.ResponseWriter, r *http.Request, client *http.Client, backendURL, format string, l golog.MyLogger) {
+	badGateway := func(err error) {
+		if r.Context().Err() != nil {
+			// the client is gone
+			return
+		}
+		l.Error("WMS GetMap from backend %s: %v", backendURL, err)
+		writeWMSException(w, &wmsError{status: http.StatusBadGateway, message: err.Error()}, l)
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, backendURL, nil)
+	if err != nil {
+		badGateway(fmt.Errorf("invalid request url: %w", err))
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		badGateway(fmt.Errorf("request failed: %w", err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		badGateway(backend.NewStatusError(resp))
+		return
+	}
+	// a WMS answers its errors with an exception report and a 200
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(strings.ToLower(contentType), format) {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxWMSErrorBodySize))
+		if exception := tools.ParseServiceException(data); exception != nil {
+			badGateway(exception)
+		} else {
+			badGateway(fmt.Errorf("%w: content type %q instead of %s", tools.ErrInvalidResponse, contentType, format))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", format)
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, resp.Body); err != nil && r.Context().Err() == nil {
+		l.Warn("WMS GetMap from backend %s interrupted: %v", backendURL, err)
+	}
+}
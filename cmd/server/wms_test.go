@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+func TestParseWMSCRS(t *testing.T) {
+	tests := []struct {
+		name              string
+		params            map[string]string
+		wantCode          int
+		wantLatitudeFirst bool
+		wantErr           bool
+	}{
+		{"LV95 in 1.3.0", map[string]string{"VERSION": "1.3.0", "CRS": "EPSG:2056"}, coords.LV95, false, false},
+		{"EPSG:4326 in 1.3.0 is latitude first", map[string]string{"VERSION": "1.3.0", "CRS": "EPSG:4326"}, coords.WGS84, true, false},
+		{"EPSG:4326 without version is latitude first", map[string]string{"CRS": "epsg:4326"}, coords.WGS84, true, false},
+		{"EPSG:4326 in 1.1.1 is longitude first", map[string]string{"VERSION": "1.1.1", "SRS": "EPSG:4326"}, coords.WGS84, false, false},
+		{"CRS:84 is longitude first", map[string]string{"VERSION": "1.3.0", "CRS": "CRS:84"}, coords.WGS84, false, false},
+		{"SRS of 1.1.1", map[string]string{"VERSION": "1.1.1", "SRS": "EPSG:3857"}, coords.WebMercator, false, false},
+		{"missing", map[string]string{"VERSION": "1.3.0"}, 0, false, true},
+		{"not an EPSG code", map[string]string{"CRS": "EPSG:lv95"}, 0, false, true},
+		{"other authority", map[string]string{"CRS": "ESRI:102100"}, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, latitudeFirst, err := parseWMSCRS(tt.params)
+			if (err != nil) != tt.wantErr || code != tt.wantCode || latitudeFirst != tt.wantLatitudeFirst {
+				t.Errorf("parseWMSCRS(%v) = %d, %v, %v, want %d, %v, error %v", tt.params, code, latitudeFirst, err, tt.wantCode, tt.wantLatitudeFirst, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseGetMapParams(t *testing.T) {
+	getMap := func(changes map[string]string) map[string]string {
+		params := map[string]string{
+			"VERSION": "1.3.0",
+			"LAYERS":  "ortho,labels",
+			"CRS":     "EPSG:2056",
+			"BBOX":    "2535000,1150000,2545000,1157000",
+			"WIDTH":   "800",
+			"HEIGHT":  "560",
+			"FORMAT":  "image/png",
+		}
+		for name, value := range changes {
+			if value == "" {
+				delete(params, name)
+			} else {
+				params[name] = value
+			}
+		}
+		return params
+	}
+	lv95BBox := wmts.BBox{XMin: 2535000, YMin: 1150000, XMax: 2545000, YMax: 1157000}
+	tests := []struct {
+		name           string
+		params         map[string]string
+		wantBBox       wmts.BBox
+		wantFormat     string
+		wantBackground color.Color
+		wantErrCode    string // exception code, "-" when no error is expected
+	}{
+		{"defaults to an opaque white map", getMap(nil), lv95BBox, "image/png", color.White, "-"},
+		{"transparent png", getMap(map[string]string{"TRANSPARENT": "TRUE"}), lv95BBox, "image/png", nil, "-"},
+		{"png with options", getMap(map[string]string{"FORMAT": "image/png; mode=8bit", "TRANSPARENT": "true"}), lv95BBox, "image/png", nil, "-"},
+		{"jpeg is always opaque", getMap(map[string]string{"FORMAT": "image/jpeg", "TRANSPARENT": "true"}), lv95BBox, "image/jpeg", color.White, "-"},
+		{"jpg", getMap(map[string]string{"FORMAT": "IMAGE/JPG"}), lv95BBox, "image/jpeg", color.White, "-"},
+		{"BGCOLOR", getMap(map[string]string{"BGCOLOR": "0xFF8000"}), lv95BBox, "image/png", color.RGBA{R: 0xff, G: 0x80, A: 0xff}, "-"},
+		{
+			"EPSG:4326 bbox of 1.3.0 is swapped",
+			getMap(map[string]string{"CRS": "EPSG:4326", "BBOX": "46.5,6.6,46.6,6.7"}),
+			wmts.BBox{XMin: 6.6, YMin: 46.5, XMax: 6.7, YMax: 46.6}, "image/png", color.White, "-",
+		},
+		{
+			"EPSG:4326 bbox of 1.1.1 is not swapped",
+			getMap(map[string]string{"VERSION": "1.1.1", "CRS": "", "SRS": "EPSG:4326", "BBOX": "6.6,46.5,6.7,46.6"}),
+			wmts.BBox{XMin: 6.6, YMin: 46.5, XMax: 6.7, YMax: 46.6}, "image/png", color.White, "-",
+		},
+		{
+			"CRS:84 bbox is not swapped",
+			getMap(map[string]string{"CRS": "CRS:84", "BBOX": "6.6,46.5,6.7,46.6"}),
+			wmts.BBox{XMin: 6.6, YMin: 46.5, XMax: 6.7, YMax: 46.6}, "image/png", color.White, "-",
+		},
+		{"missing LAYERS", getMap(map[string]string{"LAYERS": ""}), wmts.BBox{}, "", nil, "MissingParameterValue"},
+		{"missing FORMAT", getMap(map[string]string{"FORMAT": ""}), wmts.BBox{}, "", nil, "MissingParameterValue"},
+		{"unsupported format", getMap(map[string]string{"FORMAT": "image/gif"}), wmts.BBox{}, "", nil, "InvalidFormat"},
+		{"invalid CRS", getMap(map[string]string{"CRS": "lv95"}), wmts.BBox{}, "", nil, "InvalidCRS"},
+		{"invalid SRS of 1.1.1", getMap(map[string]string{"VERSION": "1.1.1", "CRS": "", "SRS": "lv95"}), wmts.BBox{}, "", nil, "InvalidSRS"},
+		{"invalid BGCOLOR", getMap(map[string]string{"BGCOLOR": "red"}), wmts.BBox{}, "", nil, ""},
+		{"BGCOLOR out of range", getMap(map[string]string{"BGCOLOR": "0x1000000"}), wmts.BBox{}, "", nil, ""},
+		{"inverted bbox", getMap(map[string]string{"BBOX": "2545000,1150000,2535000,1157000"}), wmts.BBox{}, "", nil, ""},
+		{"bbox of 3 values", getMap(map[string]string{"BBOX": "2535000,1150000,2545000"}), wmts.BBox{}, "", nil, ""},
+		{"zero width", getMap(map[string]string{"WIDTH": "0"}), wmts.BBox{}, "", nil, ""},
+		{"too high", getMap(map[string]string{"HEIGHT": strconv.Itoa(maxWMSMapSize + 1)}), wmts.BBox{}, "", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, format, background, err := parseGetMapParams(tt.params)
+			if tt.wantErrCode != "-" {
+				var wmsErr *wmsError
+				if !errors.As(err, &wmsErr) || wmsErr.status != http.StatusBadRequest || wmsErr.code != tt.wantErrCode {
+					t.Fatalf("parseGetMapParams() error = %v, want a 400 with code %q", err, tt.wantErrCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGetMapParams() unexpected error: %v", err)
+			}
+			if req.BBox != tt.wantBBox || format != tt.wantFormat || background != tt.wantBackground {
+				t.Errorf("parseGetMapParams() = bbox %v, %s, background %v, want %v, %s, %v", req.BBox, format, background, tt.wantBBox, tt.wantFormat, tt.wantBackground)
+			}
+			if len(req.Layers) != 2 || req.Width != 800 || req.Height != 560 {
+				t.Errorf("parseGetMapParams() = layers %v, size %dx%d, want 2 layers of 800x560", req.Layers, req.Width, req.Height)
+			}
+		})
+	}
+}
+
+func TestFlattenOn(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(1, 0, color.RGBA{R: 0x80, A: 0x80}) // red at half opacity, premultiplied
+	img.SetRGBA(2, 0, color.RGBA{G: 0xff, A: 0xff})
+	flattenOn(img, color.RGBA{B: 0xff, A: 0xff})
+	want := []color.RGBA{{B: 0xff, A: 0xff}, {R: 0x80, B: 0x7f, A: 0xff}, {G: 0xff, A: 0xff}}
+	for x, c := range want {
+		if got := img.RGBAAt(x, 0); got != c {
+			t.Errorf("pixel %d = %v, want %v", x, got, c)
+		}
+	}
+}
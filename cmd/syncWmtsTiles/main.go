@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/seed"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/version"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// syncWmtsTiles copies the missing or changed tiles of a cache to another one, like from the seeding machine to the replicas
+
+const (
+	APP                        = "syncWmtsTiles"
+	defaultWmtsConfig          = "config.yaml"
+	defaultMaxClientTimeOutSec = 30
+	defaultBufferSize          = 50
+	defaultLogName             = "stderr"
+	defaultCheckpoint          = "syncWmtsTiles.checkpoint"
+)
+
+func main() {
+	l, err := golog.NewLogger(
+		"simple",
+		config.GetLogWriterFromEnvOrPanic(defaultLogName),
+		config.GetLogLevelFromEnvOrPanic(golog.InfoLevel),
+		fmt.Sprintf("%s:", APP),
+	)
+	if err != nil {
+		log.Fatalf("💥💥 error golog.NewLogger error: %v'\n", err)
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, build:%s, from: %s", APP, version.VERSION, version.Build, version.REPOSITORY)
+	configFileName := flag.String("config", config.GetLayersConfigPath(defaultWmtsConfig), "config file name (default is env LAYERS_CONFIG_PATH or config.yaml)")
+	layers := flag.String("layers", "", "comma separated names of the layers to synchronise (default is all the layers)")
+	from := flag.String("from", "", "folder of the source cache (default is the cache of the config, env CACHE_FOLDER)")
+	to := flag.String("to", "", "folder of the destination cache, like the mounted cache of a replica, or url of a server with the admin API enabled (env ADMIN_TOKEN)")
+	bboxStr := flag.String("bbox", "", "area as xMin,yMin,xMax,yMax in the grid spatial reference (default is the wmts_bbox of each layer)")
+	minZoom := flag.Int("minZoom", -1, "min zoom level (default is the grid min zoom)")
+	maxZoom := flag.Int("maxZoom", -1, "max zoom level (default is the grid max zoom)")
+	numWorkers := flag.Int("workers", 0, "number of rows of tiles compared at the same time (default is the num_workers setting)")
+	sizeOnly := flag.Bool("sizeOnly", false, "take the tiles of the same size as unchanged, without hashing them")
+	dryRun := flag.Bool("dryRun", false, "count the tiles to copy without copying them")
+	checkpointPath := flag.String("checkpoint", defaultCheckpoint, "file recording the rows done, an interrupted run resumes from it, deleted once complete")
+	flag.Parse()
+
+	if *to == "" {
+		l.Fatal("💥💥 the -to folder or server url of the destination cache is required")
+	}
+	var settingsFlags wmts.SettingsFlags
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "from" {
+			settingsFlags.CacheFolder = from
+		}
+	})
+	cfg, err := wmts.ConfigFromYAML(*configFileName)
+	if err != nil {
+		l.Fatal("error loading %s layer config: %v", *configFileName, err)
+	}
+	settings, err := cfg.ResolveSettings(settingsFlags, wmts.Settings{
+		BufferSize:       defaultBufferSize,
+		ClientTimeoutSec: defaultMaxClientTimeOutSec,
+		NumWorkers:       seed.DefaultNumWorkers,
+	})
+	if err != nil {
+		l.Fatal("💥💥 invalid settings: %v", err)
+	}
+	if *numWorkers <= 0 {
+		*numWorkers = settings.NumWorkers
+	}
+	var bbox []float64
+	if *bboxStr != "" {
+		if bbox, err = wmts.ParseBBox(*bboxStr); err != nil {
+			l.Fatal("💥💥 %v", err)
+		}
+	}
+	names := strings.Split(*layers, ",")
+	if *layers == "" {
+		names = names[:0]
+		for name := range cfg.Layers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	checkpoint, err := seed.OpenCheckpoint(*checkpointPath, settings.CacheFolder, *to)
+	if err != nil {
+		l.Fatal("💥💥 %v", err)
+	}
+	if n := checkpoint.Len(); n > 0 {
+		l.Info("ℹ️ resuming from %s, %d rows already synchronised", *checkpointPath, n)
+	}
+	// Ctrl-C stops the synchronisation, the rows done are kept in the checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	source := wmts.NewFileStore(settings.CacheFolder)
+	var dest seed.SyncDest = seed.NewFolderDest(wmts.NewFileStore(*to))
+	if strings.HasPrefix(*to, "http://") || strings.HasPrefix(*to, "https://") {
+		token, enabled := config.GetAdminTokenFromEnv()
+		if !enabled {
+			l.Fatal("💥💥 the ADMIN_TOKEN env variable of the server %s is required", *to)
+		}
+		dest = seed.NewHTTPDest(*to, token, &http.Client{Timeout: time.Duration(settings.ClientTimeoutSec) * time.Second})
+	}
+	for _, name := range names {
+		layerConfig, exists := cfg.Layers[name]
+		if !exists {
+			l.Fatal("💥💥 layer %q not found in %s", name, *configFileName)
+		}
+		grid, err := wmts.NewGridForMatrixSet(layerConfig.WMTSMatrixSet, layerConfig.WMSBackendURL, layerConfig.WMSBackendPrefix, l)
+		if err != nil {
+			l.Fatal("💥💥 %v", err)
+		}
+		area := grid.GetBBox()
+		if layerBBox, err := wmts.NewBBoxFromArray(layerConfig.WMTSBBox); err == nil {
+			area = *layerBBox
+		}
+		if bbox != nil {
+			b, err := wmts.NewBBoxFromArray(bbox)
+			if err != nil {
+				l.Fatal("💥💥 %v", err)
+			}
+			area = *b
+		}
+		opts := seed.SyncOptions{
+			Grid:       grid,
+			Layer:      layerConfig,
+			Source:     source,
+			Dest:       dest,
+			BBox:       area,
+			MinZoom:    *minZoom,
+			MaxZoom:    *maxZoom,
+			NumWorkers: *numWorkers,
+			SizeOnly:   *sizeOnly,
+			DryRun:     *dryRun,
+			Checkpoint: checkpoint,
+			Logger:     l,
+		}
+		if opts.MinZoom < 0 {
+			opts.MinZoom = grid.MinZoom()
+		}
+		if opts.MaxZoom < 0 {
+			opts.MaxZoom = grid.MaxZoom()
+		}
+		result := &seed.SyncResult{}
+		err = seed.Sync(ctx, opts, result)
+		verb := "copied"
+		if *dryRun {
+			verb = "to copy (dry run)"
+		}
+		fmt.Printf("layer %s: %d tiles compared, %d missing and %d changed %s (%d bytes), %d unchanged, %d rows done before\n",
+			name, result.Compared.Load(), result.Missing.Load(), result.Changed.Load(), verb, result.Bytes.Load(),
+			result.Unchanged.Load(), result.RowsSkipped.Load())
+		if err != nil {
+			checkpoint.Close()
+			if ctx.Err() != nil {
+				l.Warn("synchronisation interrupted, run the same command again to resume from %s", *checkpointPath)
+				os.Exit(1)
+			}
+			l.Fatal("💥💥 synchronisation of layer %s failed: %v, run the same command again to resume from %s", name, err, *checkpointPath)
+		}
+	}
+	if err := checkpoint.Remove(); err != nil {
+		l.Warn("cannot delete the checkpoint %s: %v", *checkpointPath, err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// validateConfig checks a goWmtsTool YAML configuration and prints every problem found with its line number
+
+const (
+	APP               = "validateConfig"
+	defaultWmtsConfig = "config.yaml"
+)
+
+func main() {
+	configFileName := flag.String("config", config.GetLayersConfigPath(defaultWmtsConfig), "config file name (default is env LAYERS_CONFIG_PATH or config.yaml)")
+	flag.Parse()
+
+	errs, err := wmts.ValidateConfigFile(*configFileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "💥💥 %s: %v\n", APP, err)
+		os.Exit(2)
+	}
+	if len(errs) == 0 {
+		fmt.Printf("✅ %s is a valid configuration\n", *configFileName)
+		return
+	}
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: %s: %s\n", *configFileName, e.Line, e.Column, e.Path, e.Message)
+	}
+	fmt.Fprintf(os.Stderr, "💥💥 %s contains %d errors\n", *configFileName, len(errs))
+	os.Exit(1)
+}
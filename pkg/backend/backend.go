@@ -0,0 +1,374 @@
+// Package backend protects the WMS backends with a limit of concurrent requests and a circuit breaker per host.
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxConcurrent    = 8
+	DefaultFailureThreshold = 5
+	DefaultOpenTimeoutSec   = 30
+	DefaultQueueTimeoutSec  = 5
+)
+
+var (
+	// ErrUnavailable is the parent of the errors returned without contacting the backend
+	ErrUnavailable = errors.New("WMS backend unavailable")
+	// ErrCircuitOpen is returned while the circuit of a backend is open after repeated failures
+	ErrCircuitOpen = fmt.Errorf("%w: circuit open after repeated failures", ErrUnavailable)
+	// ErrBusy is returned when no request slot was free for the backend during the queue timeout
+	ErrBusy = fmt.Errorf("%w: too many concurrent requests", ErrUnavailable)
+)
+
+// Config holds the protection settings of a backend, zero values are replaced by the defaults
+type Config struct {
+	MaxConcurrent    int `yaml:"max_concurrent"`    // maximum number of simultaneous requests
+	FailureThreshold int `yaml:"failure_threshold"` // consecutive failures opening the circuit
+	OpenTimeoutSec   int `yaml:"open_timeout_sec"`  // time the circuit stays open before a trial request
+	QueueTimeoutSec  int `yaml:"queue_timeout_sec"` // maximum wait for a free request slot
+	// Seed limits the load the seeder puts on the backend, it is not used by the tile server
+	Seed SeedLimits `yaml:"seed"`
+	// Retry tells how the failed requests to the backend are retried
+	Retry RetryPolicy `yaml:"retry"`
+}
+
+// guardSettings are the settings used by a Guard, a change of the other ones keeps the circuit state
+type guardSettings struct {
+	maxConcurrent, failureThreshold, openTimeoutSec, queueTimeoutSec int
+}
+
+func (c Config) guardSettings() guardSettings {
+	c = c.withDefaults()
+	return guardSettings{c.MaxConcurrent, c.FailureThreshold, c.OpenTimeoutSec, c.QueueTimeoutSec}
+}
+
+// SeedLimits holds the politeness settings of the seeder for a backend, zero values mean no limit
+type SeedLimits struct {
+	MaxRPS        float64 `yaml:"max_rps"`         // maximum meta-tile requests per second
+	MaxKBps       int     `yaml:"max_kbps"`        // maximum download bandwidth in kilobytes per second
+	Window        string  `yaml:"window"`          // daily time window like 19:00-07:00 (local time), the seeding pauses outside it
+	SlowLatencyMs int     `yaml:"slow_latency_ms"` // responses slower than this make the seeder back off, like a 5xx
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = DefaultFailureThreshold
+	}
+	if c.OpenTimeoutSec <= 0 {
+		c.OpenTimeoutSec = DefaultOpenTimeoutSec
+	}
+	if c.QueueTimeoutSec <= 0 {
+		c.QueueTimeoutSec = DefaultQueueTimeoutSec
+	}
+	return c
+}
+
+// CircuitState is the state of the circuit breaker of a backend
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // requests go through
+	CircuitOpen     CircuitState = "open"      // requests fail fast
+	CircuitHalfOpen CircuitState = "half-open" // a single trial request decides to close or open again
+)
+
+// Health is a snapshot of the state of a backend, suitable for a JSON response
+type Health struct {
+	Host                string       `json:"host"`
+	State               CircuitState `json:"state"`
+	InFlight            int          `json:"in_flight"`
+	MaxConcurrent       int          `json:"max_concurrent"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+	OpenUntil           *time.Time   `json:"open_until,omitempty"`
+}
+
+// Guard limits the concurrent requests to a backend and fails fast when it is down
+type Guard struct {
+	host  string
+	cfg   Config
+	slots chan struct{}
+
+	mu                  sync.Mutex
+	state               CircuitState
+	consecutiveFailures int
+	openUntil           time.Time
+	trialRunning        bool
+	lastError           string
+}
+
+func newGuard(host string, cfg Config) *Guard {
+	cfg = cfg.withDefaults()
+	return &Guard{
+		host:  host,
+		cfg:   cfg,
+		slots: make(chan struct{}, cfg.MaxConcurrent),
+		state: CircuitClosed,
+	}
+}
+
+// allow checks the circuit, in half-open state only one trial request is let through
+func (g *Guard) allow(now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch g.state {
+	case CircuitOpen:
+		if now.Before(g.openUntil) {
+			return ErrCircuitOpen
+		}
+		g.state = CircuitHalfOpen
+		g.trialRunning = true
+		return nil
+	case CircuitHalfOpen:
+		if g.trialRunning {
+			return ErrCircuitOpen
+		}
+		g.trialRunning = true
+	}
+	return nil
+}
+
+// record updates the circuit with the outcome of a request
+func (g *Guard) record(err error, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.state == CircuitHalfOpen {
+		g.trialRunning = false
+	}
+	if err == nil {
+		g.consecutiveFailures = 0
+		g.state = CircuitClosed
+		return
+	}
+	g.lastError = err.Error()
+	g.consecutiveFailures++
+	if g.state == CircuitHalfOpen || g.consecutiveFailures >= g.cfg.FailureThreshold {
+		g.state = CircuitOpen
+		g.openUntil = now.Add(time.Duration(g.cfg.OpenTimeoutSec) * time.Second)
+	}
+}
+
+// Acquire waits until the circuit allows a request and a request slot is free.
+// done must be called once with the outcome of the request, it records it in the circuit and frees the slot.
+// Errors wrapping ErrUnavailable are returned when the backend must not be contacted.
+func (g *Guard) Acquire(ctx context.Context) (done func(err error), err error) {
+	if err := g.allow(time.Now()); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(time.Duration(g.cfg.QueueTimeoutSec) * time.Second)
+	defer timer.Stop()
+	select {
+	case g.slots <- struct{}{}:
+	case <-timer.C:
+		g.abortTrial()
+		return nil, ErrBusy
+	case <-ctx.Done():
+		g.abortTrial()
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				// a request cancelled by the caller says nothing about the backend health
+				g.abortTrial()
+			} else {
+				g.record(err, time.Now())
+			}
+			<-g.slots
+		})
+	}, nil
+}
+
+// Do runs fn when the circuit allows it and a request slot is free, its error is recorded by the circuit
+func (g *Guard) Do(ctx context.Context, fn func() error) error {
+	done, err := g.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err)
+	return err
+}
+
+// abortTrial releases the half-open trial when the request did not reach the backend
+func (g *Guard) abortTrial() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.state == CircuitHalfOpen {
+		g.trialRunning = false
+	}
+}
+
+// Health returns the current state of the backend
+func (g *Guard) Health() Health {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	h := Health{
+		Host:                g.host,
+		State:               g.state,
+		InFlight:            len(g.slots),
+		MaxConcurrent:       g.cfg.MaxConcurrent,
+		ConsecutiveFailures: g.consecutiveFailures,
+		LastError:           g.lastError,
+	}
+	if g.state == CircuitOpen {
+		openUntil := g.openUntil
+		h.OpenUntil = &openUntil
+	}
+	return h
+}
+
+// Registry holds the Guard of each backend host
+type Registry struct {
+	mu      sync.Mutex
+	configs map[string]Config
+	guards  map[string]*Guard
+}
+
+// NewRegistry returns an empty Registry, the guards are created on first use
+func NewRegistry() *Registry {
+	return &Registry{
+		configs: make(map[string]Config),
+		guards:  make(map[string]*Guard),
+	}
+}
+
+// Default is the Registry used by the instrumented http clients, see Transport
+var Default = NewRegistry()
+
+// Configure sets the protection settings by backend host, the hosts not listed use the defaults.
+// The guards whose settings changed are replaced, the requests in progress finish with the old ones.
+func (r *Registry) Configure(configs map[string]Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	newConfigs := make(map[string]Config, len(configs))
+	for host, cfg := range configs {
+		newConfigs[host] = cfg.withDefaults()
+	}
+	for host, guard := range r.guards {
+		if guard.cfg.guardSettings() != newConfigs[host].guardSettings() {
+			delete(r.guards, host)
+		}
+	}
+	r.configs = newConfigs
+}
+
+// SeedLimits returns the seeder politeness settings of the backend host
+func (r *Registry) SeedLimits(host string) SeedLimits {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.configs[host].Seed
+}
+
+// RetryPolicy returns the retry policy of the backend host, with the defaults applied
+func (r *Registry) RetryPolicy(host string) RetryPolicy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.configs[host].Retry.WithDefaults()
+}
+
+// RetryPolicyForURL returns the retry policy of the backend host of rawURL
+func (r *Registry) RetryPolicyForURL(rawURL string) RetryPolicy {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return RetryPolicy{}.WithDefaults()
+	}
+	return r.RetryPolicy(u.Host)
+}
+
+// Guard returns the Guard of the backend host
+func (r *Registry) Guard(host string) *Guard {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	guard, ok := r.guards[host]
+	if !ok {
+		guard = newGuard(host, r.configs[host])
+		r.guards[host] = guard
+	}
+	return guard
+}
+
+// Health returns the state of all the backends used so far, sorted by host
+func (r *Registry) Health() []Health {
+	r.mu.Lock()
+	guards := make([]*Guard, 0, len(r.guards))
+	for _, guard := range r.guards {
+		guards = append(guards, guard)
+	}
+	r.mu.Unlock()
+	list := make([]Health, 0, len(guards))
+	for _, guard := range guards {
+		list = append(list, guard.Health())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	return list
+}
+
+// Ready returns false when every backend used so far has its circuit open, a server can still answer from its cache otherwise
+func (r *Registry) Ready() bool {
+	health := r.Health()
+	for _, h := range health {
+		if h.State != CircuitOpen {
+			return true
+		}
+	}
+	return len(health) == 0
+}
+
+// guardedTransport is a http.RoundTripper protecting each backend host with the Guard of a Registry
+type guardedTransport struct {
+	registry *Registry
+	next     http.RoundTripper
+}
+
+// Transport wraps next so each request goes through the Guard of its host in the Default registry.
+// A 5xx response or a network error counts as a failure of the backend.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return &guardedTransport{registry: Default, next: next}
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.registry.Guard(req.URL.Host).Acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	// the request slot is kept until the body is read,
+	// a 5xx response is given to the caller as is, it only counts as a failure for the circuit
+	body := &releaseOnClose{ReadCloser: resp.Body, done: done}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		body.err = fmt.Errorf("backend returned status %d", resp.StatusCode)
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// releaseOnClose releases the request slot with err when the body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	done func(error)
+	err  error
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.done(b.err)
+	return err
+}
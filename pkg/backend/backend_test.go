@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var errBackend = errors.New("boom")
+
+func TestGuardCircuit(t *testing.T) {
+	g := newGuard("wms.example.com", Config{FailureThreshold: 2, OpenTimeoutSec: 60})
+	ctx := context.Background()
+	fail := func() error { return errBackend }
+	succeed := func() error { return nil }
+
+	if err := g.Do(ctx, fail); !errors.Is(err, errBackend) {
+		t.Fatalf("first failure: got %v", err)
+	}
+	if state := g.Health().State; state != CircuitClosed {
+		t.Fatalf("after 1 failure state = %s, want %s", state, CircuitClosed)
+	}
+	g.Do(ctx, fail)
+	if state := g.Health().State; state != CircuitOpen {
+		t.Fatalf("after 2 failures state = %s, want %s", state, CircuitOpen)
+	}
+	called := false
+	if err := g.Do(ctx, func() error { called = true; return nil }); !errors.Is(err, ErrCircuitOpen) || called {
+		t.Fatalf("open circuit: got %v, called %v, want ErrCircuitOpen without call", err, called)
+	}
+
+	// once the open timeout is elapsed a single trial request is let through
+	g.mu.Lock()
+	g.openUntil = time.Now().Add(-time.Second)
+	g.mu.Unlock()
+	if err := g.allow(time.Now()); err != nil {
+		t.Fatalf("trial request refused: %v", err)
+	}
+	if err := g.allow(time.Now()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second request during trial: got %v, want ErrCircuitOpen", err)
+	}
+	g.record(errBackend, time.Now())
+	if state := g.Health().State; state != CircuitOpen {
+		t.Fatalf("after failed trial state = %s, want %s", state, CircuitOpen)
+	}
+
+	g.mu.Lock()
+	g.openUntil = time.Now().Add(-time.Second)
+	g.mu.Unlock()
+	if err := g.Do(ctx, succeed); err != nil {
+		t.Fatalf("successful trial: got %v", err)
+	}
+	if h := g.Health(); h.State != CircuitClosed || h.ConsecutiveFailures != 0 {
+		t.Fatalf("after successful trial got %+v, want closed circuit without failures", h)
+	}
+}
+
+func TestGuardConcurrencyLimit(t *testing.T) {
+	g := newGuard("wms.example.com", Config{MaxConcurrent: 1, QueueTimeoutSec: 1})
+	done, err := g.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("first Acquire: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := g.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire with no free slot: got %v, want context.DeadlineExceeded", err)
+	}
+	done(nil)
+	done2, err := g.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+	done2(nil)
+	if h := g.Health(); h.InFlight != 0 {
+		t.Fatalf("in flight = %d, want 0", h.InFlight)
+	}
+}
+
+func TestRegistryReady(t *testing.T) {
+	r := NewRegistry()
+	if !r.Ready() {
+		t.Fatal("an unused registry must be ready")
+	}
+	r.Configure(map[string]Config{"a": {FailureThreshold: 1}, "b": {FailureThreshold: 1}})
+	r.Guard("b").Do(context.Background(), func() error { return nil })
+	r.Guard("a").Do(context.Background(), func() error { return errBackend })
+	if !r.Ready() {
+		t.Fatal("registry with one backend open of two must be ready")
+	}
+	r.Guard("b").Do(context.Background(), func() error { return errBackend })
+	if r.Ready() {
+		t.Fatal("registry with all backends open must not be ready")
+	}
+}
+
+func TestGuardedTransportReleasesOnClose(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+				w.Write([]byte("body"))
+			}))
+			defer server.Close()
+			r := NewRegistry()
+			transport := &guardedTransport{registry: r, next: http.DefaultTransport}
+			req := httptest.NewRequest(http.MethodGet, server.URL, nil)
+			req.RequestURI = ""
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip: %v", err)
+			}
+			g := r.Guard(req.URL.Host)
+			// the request slot is kept while the body is read, whatever the status
+			if h := g.Health(); h.InFlight != 1 {
+				t.Fatalf("in flight before Close = %d, want 1", h.InFlight)
+			}
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+			wantFailures := 0
+			if status >= http.StatusInternalServerError {
+				wantFailures = 1
+			}
+			if h := g.Health(); h.InFlight != 0 || h.ConsecutiveFailures != wantFailures {
+				t.Fatalf("after Close got %+v, want no request in flight and %d failures", h, wantFailures)
+			}
+		})
+	}
+}
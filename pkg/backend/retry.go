@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelayMs = 500
+	DefaultMaxDelayMs  = 10000
+)
+
+// DefaultRetryStatuses are the HTTP statuses retried when the policy does not list them
+var DefaultRetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// Jitter modes, see https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+const (
+	JitterFull  = "full"  // random delay between 0 and the exponential delay
+	JitterEqual = "equal" // half the exponential delay plus a random part of the other half
+	JitterNone  = "none"
+)
+
+// RetryPolicy tells how the requests to a backend are retried, zero values are replaced by the defaults
+type RetryPolicy struct {
+	MaxAttempts        int    `yaml:"max_attempts"`         // attempts including the first one, 1 to never retry
+	BaseDelayMs        int    `yaml:"base_delay_ms"`        // delay before the first retry, doubled at each attempt
+	MaxDelayMs         int    `yaml:"max_delay_ms"`         // maximum delay between two attempts, a longer Retry-After gives up
+	Jitter             string `yaml:"jitter"`               // full (default), equal or none
+	RetryStatuses      []int  `yaml:"retry_statuses"`       // HTTP statuses to retry, default 429, 502, 503 and 504
+	RetryNetworkErrors *bool  `yaml:"retry_network_errors"` // retry the connection errors and timeouts, default true
+}
+
+// NoRetry is a policy making a single attempt
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// WithDefaults returns the policy with the zero values replaced by the defaults
+func (p RetryPolicy) WithDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.BaseDelayMs <= 0 {
+		p.BaseDelayMs = DefaultBaseDelayMs
+	}
+	if p.MaxDelayMs <= 0 {
+		p.MaxDelayMs = DefaultMaxDelayMs
+	}
+	if p.Jitter == "" {
+		p.Jitter = JitterFull
+	}
+	if p.RetryStatuses == nil {
+		p.RetryStatuses = DefaultRetryStatuses
+	}
+	if p.RetryNetworkErrors == nil {
+		retry := true
+		p.RetryNetworkErrors = &retry
+	}
+	return p
+}
+
+// StatusError is returned for a response of a backend with an unexpected HTTP status
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration // from the Retry-After header, 0 when absent
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
+// NewStatusError returns the StatusError of resp, reading its Retry-After header given in seconds or as an HTTP date
+func NewStatusError(resp *http.Response) *StatusError {
+	e := &StatusError{Code: resp.StatusCode}
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			e.RetryAfter = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(value); err == nil {
+			e.RetryAfter = max(time.Until(date), 0)
+		}
+	}
+	return e
+}
+
+// Retryable tells if a request failing with err may succeed when retried
+func (p RetryPolicy) Retryable(err error) bool {
+	p = p.WithDefaults()
+	var statusErr *StatusError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, ErrUnavailable), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// the backend must not be contacted, or the caller gave up
+		return false
+	case errors.As(err, &statusErr):
+		return slices.Contains(p.RetryStatuses, statusErr.Code)
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return *p.RetryNetworkErrors
+	}
+	return false
+}
+
+// Delay returns the wait before the given retry (1 for the first one)
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	p = p.WithDefaults()
+	maxDelay := time.Duration(p.MaxDelayMs) * time.Millisecond
+	delay := time.Duration(p.BaseDelayMs) * time.Millisecond << min(retry-1, 30)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	switch p.Jitter {
+	case JitterFull:
+		delay = rand.N(delay + 1)
+	case JitterEqual:
+		delay = delay/2 + rand.N(delay/2+1)
+	}
+	return delay
+}
+
+// Do calls fn until it succeeds, fails with an error that is not Retryable or the attempts are exhausted.
+// The wait between two attempts follows Delay, or the Retry-After of a StatusError when it is longer;
+// a Retry-After longer than the maximum delay gives up. onRetry, when not nil, is called before each wait.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error, onRetry func(retry int, err error, delay time.Duration)) error {
+	p = p.WithDefaults()
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= p.MaxAttempts || !p.Retryable(err) {
+			return err
+		}
+		delay := p.Delay(attempt)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			if statusErr.RetryAfter > time.Duration(p.MaxDelayMs)*time.Millisecond {
+				return fmt.Errorf("%w, giving up as Retry-After is %s", err, statusErr.RetryAfter)
+			}
+			delay = statusErr.RetryAfter
+		}
+		if onRetry != nil {
+			onRetry(attempt, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("retry cancelled: %w, last error: %v", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
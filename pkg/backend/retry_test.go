@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	fast := RetryPolicy{MaxAttempts: 3, BaseDelayMs: 1, MaxDelayMs: 5}
+	noNetwork := false
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		errs      []error // error of each attempt, nil for a success
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", fast, []error{nil}, 1, false},
+		{"502 then success", fast, []error{&StatusError{Code: http.StatusBadGateway}, nil}, 2, false},
+		{"404 not retried", fast, []error{&StatusError{Code: http.StatusNotFound}}, 1, true},
+		{"attempts exhausted", fast, []error{&StatusError{Code: 503}, &StatusError{Code: 503}, &StatusError{Code: 503}}, 3, true},
+		{"circuit open not retried", fast, []error{fmt.Errorf("request failed: %w", ErrCircuitOpen)}, 1, true},
+		{"Retry-After too long", fast, []error{&StatusError{Code: 429, RetryAfter: time.Minute}}, 1, true},
+		{"network error", fast, []error{&timeoutError{}, nil}, 2, false},
+		{"network error not retried", RetryPolicy{MaxAttempts: 3, RetryNetworkErrors: &noNetwork}, []error{&timeoutError{}}, 1, true},
+		{"no retry", NoRetry, []error{&StatusError{Code: 502}}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := tt.policy.Do(context.Background(), func() error {
+				calls++
+				return tt.errs[calls-1]
+			}, nil)
+			if calls != tt.wantCalls || (err != nil) != tt.wantErr {
+				t.Fatalf("got %d calls and error %v, want %d calls and error %v", calls, err, tt.wantCalls, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelayMs: 100, MaxDelayMs: 1000, Jitter: JitterNone}
+	for retry, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 40: time.Second} {
+		if got := p.Delay(retry); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", retry, got, want)
+		}
+	}
+	p.Jitter = JitterFull
+	for i := 0; i < 100; i++ {
+		if got := p.Delay(3); got < 0 || got > 400*time.Millisecond {
+			t.Fatalf("full jitter Delay(3) = %s, want between 0 and 400ms", got)
+		}
+	}
+}
+
+func TestNewStatusErrorRetryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": []string{"7"}}}
+	if e := NewStatusError(resp); e.RetryAfter != 7*time.Second {
+		t.Errorf("RetryAfter = %s, want 7s", e.RetryAfter)
+	}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if e := NewStatusError(resp); e.RetryAfter < 59*time.Minute {
+		t.Errorf("RetryAfter = %s, want about 1h", e.RetryAfter)
+	}
+	var statusErr *StatusError
+	if !errors.As(fmt.Errorf("wrapped: %w", NewStatusError(resp)), &statusErr) {
+		t.Error("a wrapped StatusError must be found by errors.As")
+	}
+}
+
+// timeoutError is a net.Error
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetAdminTokenFromEnv returns the bearer token protecting the admin API based on the content of the env variable
+// ADMIN_TOKEN : when not defined or empty the admin API is disabled and the second value is false.
+// The function panics if the token is shorter than 16 characters.
+func GetAdminTokenFromEnv() (string, bool) {
+	val, exist := os.LookupEnv("ADMIN_TOKEN")
+	if !exist || val == "" {
+		return "", false
+	}
+	if len(val) < 16 {
+		panic("💥💥 ERROR: ENV ADMIN_TOKEN should contain at least 16 characters.")
+	}
+	return val, true
+}
+
+// GetAdminMaxSeedJobsFromEnvOrPanic returns the number of seeding jobs the admin API runs at the same time
+// based on the content of the env variable ADMIN_MAX_SEED_JOBS (defaultMax when not defined).
+// The function panics if the value is not a positive integer.
+func GetAdminMaxSeedJobsFromEnvOrPanic(defaultMax int) int {
+	val, exist := os.LookupEnv("ADMIN_MAX_SEED_JOBS")
+	if !exist || val == "" {
+		return defaultMax
+	}
+	maxJobs, err := strconv.Atoi(val)
+	if err != nil || maxJobs < 1 {
+		panic(fmt.Errorf("💥💥 ERROR: ENV ADMIN_MAX_SEED_JOBS should contain a positive integer, got %q", val))
+	}
+	return maxJobs
+}
+
+// GetAdminJobRetentionFromEnvOrPanic returns how long the finished seeding jobs stay listed by the admin API
+// based on the content of the env variable ADMIN_JOB_RETENTION, a duration like 12h (defaultRetention when not defined).
+// The function panics if the value is not a positive duration.
+func GetAdminJobRetentionFromEnvOrPanic(defaultRetention time.Duration) time.Duration {
+	val, exist := os.LookupEnv("ADMIN_JOB_RETENTION")
+	if !exist || val == "" {
+		return defaultRetention
+	}
+	retention, err := time.ParseDuration(val)
+	if err != nil || retention <= 0 {
+		panic(fmt.Errorf("💥💥 ERROR: ENV ADMIN_JOB_RETENTION should contain a positive duration like 12h, got %q", val))
+	}
+	return retention
+}
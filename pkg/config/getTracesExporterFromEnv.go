@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	TracesExporterNone    = "none"    // traces are not recorded
+	TracesExporterOTLP    = "otlp"    // sent to OTEL_EXPORTER_OTLP_ENDPOINT (default http://localhost:4318)
+	TracesExporterConsole = "console" // printed on stdout, for local debugging
+)
+
+// GetTracesExporterFromEnvOrPanic returns the exporter of the OpenTelemetry traces based on the content of the env variable
+// OTEL_TRACES_EXPORTER : none (default), otlp or console. The function panics on any other value.
+func GetTracesExporterFromEnvOrPanic() string {
+	val, exist := os.LookupEnv("OTEL_TRACES_EXPORTER")
+	if !exist || strings.TrimSpace(val) == "" {
+		return TracesExporterNone
+	}
+	val = strings.ToLower(strings.TrimSpace(val))
+	switch val {
+	case TracesExporterNone, TracesExporterOTLP, TracesExporterConsole:
+		return val
+	}
+	panic(fmt.Sprintf("💥💥 ERROR: ENV OTEL_TRACES_EXPORTER should be one of %s, %s or %s, got %q",
+		TracesExporterNone, TracesExporterOTLP, TracesExporterConsole, val))
+}
@@ -36,6 +36,15 @@ func GetLayersConfigPathFromEnvOrPanic() string {
 	return fmt.Sprintf("%s", val)
 }
 
+// GetLayersConfigPath returns the default of the -config flag of the commands : the content of the env variable
+// LAYERS_CONFIG_PATH when it is set, else defaultPath. The flag given on the command line wins over both.
+func GetLayersConfigPath(defaultPath string) string {
+	if val, exist := os.LookupEnv("LAYERS_CONFIG_PATH"); exist && val != "" {
+		return val
+	}
+	return defaultPath
+}
+
 // GetBufferSizeFromEnvOrPanic returns the buffer to use in WMS queries
 func GetBufferSizeFromEnvOrPanic(defaultBuffer int) int {
 	buffer := defaultBuffer
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envReference matches $${...} (escaped), ${VAR} and ${VAR:-default}
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// InterpolateEnv replaces the environment variables references found in data :
+//
+//	${VAR}          : value of env VAR, an error is returned if VAR is not defined
+//	${VAR:-default} : value of env VAR, or default if VAR is not defined or empty
+//	$${             : a literal ${
+//
+// The replacement is done on the raw text, so line numbers of the YAML document are preserved
+// as long as the values do not contain new lines. The YAML comments are left as they are,
+// so a commented out setting may reference a variable that is not defined.
+func InterpolateEnv(data []byte) ([]byte, error) {
+	var missing []string
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		end := commentStart(line)
+		line, comment := line[:end], line[end:]
+		lines[i] = envReference.ReplaceAllFunc(line, func(ref []byte) []byte {
+			if string(ref) == "$${" {
+				return []byte("${")
+			}
+			match := envReference.FindSubmatch(ref)
+			name := string(match[1])
+			val, exist := os.LookupEnv(name)
+			hasDefault := len(match[2]) > 0
+			switch {
+			case hasDefault && val == "":
+				return match[3]
+			case !exist:
+				missing = append(missing, fmt.Sprintf("%s (line %d)", name, i+1))
+				return ref
+			}
+			return []byte(val)
+		})
+		lines[i] = append(lines[i], comment...)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined environment variables without default value: %s", strings.Join(missing, ", "))
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// commentStart returns the index of the YAML comment of line, a # at the start or after a blank
+// outside of a quoted string, or len(line) if line has no comment
+func commentStart(line []byte) int {
+	var quote byte
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return i
+		}
+	}
+	return len(line)
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("WMTS_TEST_FOLDER", "/data/tiles")
+	t.Setenv("WMTS_TEST_EMPTY", "")
+	tests := []struct {
+		name        string
+		input       string
+		expected    string
+		errContains string
+	}{
+		{"No reference", "folder: /tmp", "folder: /tmp", ""},
+		{"Defined variable", "folder: ${WMTS_TEST_FOLDER}", "folder: /data/tiles", ""},
+		{"Defined variable with default", "folder: ${WMTS_TEST_FOLDER:-/tmp}/cache", "folder: /data/tiles/cache", ""},
+		{"Undefined variable with default", "buffer: ${WMTS_TEST_UNDEFINED:-50}", "buffer: 50", ""},
+		{"Empty variable with default", "buffer: ${WMTS_TEST_EMPTY:-50}", "buffer: 50", ""},
+		{"Empty default", "prefix: '${WMTS_TEST_UNDEFINED:-}'", "prefix: ''", ""},
+		{"Escaped reference", "url: $${WMTS_TEST_FOLDER}", "url: ${WMTS_TEST_FOLDER}", ""},
+		{"Several references", "a: ${WMTS_TEST_FOLDER}\nb: ${WMTS_TEST_UNDEFINED:-x}", "a: /data/tiles\nb: x", ""},
+		{"Undefined variable", "a: 1\nfolder: ${WMTS_TEST_UNDEFINED}", "", "WMTS_TEST_UNDEFINED (line 2)"},
+		{"Commented out reference", "# folder: ${WMTS_TEST_UNDEFINED}\na: 1", "# folder: ${WMTS_TEST_UNDEFINED}\na: 1", ""},
+		{"Reference in a comment", "folder: ${WMTS_TEST_FOLDER} # was ${WMTS_TEST_UNDEFINED}", "folder: /data/tiles # was ${WMTS_TEST_UNDEFINED}", ""},
+		{"Hash in a value", "url: 'http://x/ #${WMTS_TEST_FOLDER}'\nb: a#${WMTS_TEST_FOLDER}", "url: 'http://x/ #/data/tiles'\nb: a#/data/tiles", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := InterpolateEnv([]byte(tt.input))
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("Expected error containing %q, got %v", tt.errContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Expected %q, but got %q", tt.expected, string(result))
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Source tells where the value of a setting comes from
+type Source string
+
+// The sources of a setting, in decreasing order of precedence :
+// a command line flag wins over an env variable, which wins over the YAML config, which wins over the default.
+const (
+	SourceFlag    Source = "flag"
+	SourceEnv     Source = "env"
+	SourceYAML    Source = "yaml"
+	SourceDefault Source = "default"
+)
+
+// ResolveIntSetting returns the value of an integer setting following the precedence flag > env > YAML > default.
+// flagValue and yamlValue must be nil when the flag was not given or the YAML key is absent.
+// An error is returned if the env variable does not contain a valid integer or if the value is outside [minValue, maxValue].
+func ResolveIntSetting(envName string, flagValue, yamlValue *int, defaultValue, minValue, maxValue int) (int, Source, error) {
+	value, source := defaultValue, SourceDefault
+	if yamlValue != nil {
+		value, source = *yamlValue, SourceYAML
+	}
+	if val, exist := os.LookupEnv(envName); exist && val != "" {
+		envValue, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, SourceEnv, fmt.Errorf("ENV %s should contain a valid integer. %v", envName, err)
+		}
+		value, source = envValue, SourceEnv
+	}
+	if flagValue != nil {
+		value, source = *flagValue, SourceFlag
+	}
+	if value < minValue || value > maxValue {
+		return 0, source, fmt.Errorf("%s (from %s) should contain an integer between %d and %d inclusive, got %d", envName, source, minValue, maxValue, value)
+	}
+	return value, source, nil
+}
+
+// ResolveStringSetting returns the value of a string setting following the precedence flag > env > YAML > default.
+// flagValue must be nil when the flag was not given, an empty yamlValue means the YAML key is absent.
+func ResolveStringSetting(envName string, flagValue *string, yamlValue, defaultValue string) (string, Source) {
+	value, source := defaultValue, SourceDefault
+	if yamlValue != "" {
+		value, source = yamlValue, SourceYAML
+	}
+	if val, exist := os.LookupEnv(envName); exist && val != "" {
+		value, source = val, SourceEnv
+	}
+	if flagValue != nil {
+		value, source = *flagValue, SourceFlag
+	}
+	return value, source
+}
@@ -0,0 +1,43 @@
+package config
+
+import "testing"
+
+func TestResolveIntSetting(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	tests := []struct {
+		name           string
+		envValue       string
+		flagValue      *int
+		yamlValue      *int
+		expected       int
+		expectedSource Source
+		expectErr      bool
+	}{
+		{"Default", "", nil, nil, 50, SourceDefault, false},
+		{"YAML over default", "", nil, intPtr(20), 20, SourceYAML, false},
+		{"Env over YAML", "30", nil, intPtr(20), 30, SourceEnv, false},
+		{"Flag over env", "30", intPtr(0), intPtr(20), 0, SourceFlag, false},
+		{"Invalid env", "abc", nil, nil, 0, SourceEnv, true},
+		{"Out of range", "", nil, intPtr(300), 0, SourceYAML, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.envValue != "" {
+				t.Setenv("WMTS_TEST_SETTING", tt.envValue)
+			}
+			result, source, err := ResolveIntSetting("WMTS_TEST_SETTING", tt.flagValue, tt.yamlValue, 50, 0, 256)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %d", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected || source != tt.expectedSource {
+				t.Errorf("Expected %d from %s, but got %d from %s", tt.expected, tt.expectedSource, result, source)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Package coords converts coordinates between the Swiss LV95 and LV03, WGS84 and WebMercator spatial references
+// with the approximate formulas of swisstopo, precise to about 1 meter in Switzerland.
+package coords
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EPSG codes of the supported spatial references
+const (
+	LV95        = 2056  // Swiss CH1903+ / LV95, east and north in meters
+	LV03        = 21781 // Swiss CH1903 / LV03, y (east) and x (north) in meters
+	WGS84       = 4326  // longitude and latitude in degrees, in this order
+	WebMercator = 3857  // Pseudo-Mercator of the web maps, in meters
+)
+
+// EarthRadius is the sphere radius of the Pseudo-Mercator projection
+const EarthRadius = 6378137.0
+
+// MaxMercatorLatitude is the latitude of the edges of the square Pseudo-Mercator world
+const MaxMercatorLatitude = 85.05112877980659
+
+// Transform converts the coordinates x, y of a spatial reference to another one
+type Transform func(x, y float64) (float64, float64)
+
+// toWGS84 and fromWGS84 hold the conversions of each spatial reference, WGS84 is the pivot between them
+var (
+	toWGS84 = map[int]Transform{
+		LV95:        lv95ToWGS84,
+		LV03:        func(y, x float64) (float64, float64) { return lv95ToWGS84(lv03ToLV95(y, x)) },
+		WGS84:       identity,
+		WebMercator: webMercatorToWGS84,
+	}
+	fromWGS84 = map[int]Transform{
+		LV95:        wgs84ToLV95,
+		LV03:        func(lon, lat float64) (float64, float64) { return lv95ToLV03(wgs84ToLV95(lon, lat)) },
+		WGS84:       identity,
+		WebMercator: wgs84ToWebMercator,
+	}
+)
+
+// direct holds the conversions not going through WGS84
+var direct = map[[2]int]Transform{
+	{LV03, LV95}: lv03ToLV95,
+	{LV95, LV03}: lv95ToLV03,
+}
+
+// crsNames are the names accepted by ParseCRS besides the EPSG codes
+var crsNames = map[string]int{
+	"lv95":        LV95,
+	"lv03":        LV03,
+	"wgs84":       WGS84,
+	"webmercator": WebMercator,
+}
+
+// Supported returns the sorted EPSG codes of the supported spatial references
+func Supported() []int {
+	codes := make([]int, 0, len(toWGS84))
+	for code := range toWGS84 {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// ParseCRS returns the EPSG code of a spatial reference given as "EPSG:2056", "2056" or a name like "wgs84"
+func ParseCRS(s string) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if code, ok := crsNames[name]; ok {
+		return code, nil
+	}
+	code, err := strconv.Atoi(strings.TrimPrefix(name, "epsg:"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid crs %q, expected EPSG:<code> or one of lv95, lv03, wgs84, webmercator", s)
+	}
+	if _, ok := toWGS84[code]; !ok {
+		return 0, fmt.Errorf("unsupported crs EPSG:%d, supported values are %v", code, Supported())
+	}
+	return code, nil
+}
+
+// NewTransform returns the conversion of the coordinates from the EPSG code from to the EPSG code to
+func NewTransform(from, to int) (Transform, error) {
+	if from == to {
+		return identity, nil
+	}
+	if t, ok := direct[[2]int{from, to}]; ok {
+		return t, nil
+	}
+	toPivot, ok := toWGS84[from]
+	if !ok {
+		return nil, fmt.Errorf("no transformation from EPSG:%d, supported spatial references are %v", from, Supported())
+	}
+	fromPivot, ok := fromWGS84[to]
+	if !ok {
+		return nil, fmt.Errorf("no transformation to EPSG:%d, supported spatial references are %v", to, Supported())
+	}
+	return func(x, y float64) (float64, float64) {
+		return fromPivot(toPivot(x, y))
+	}, nil
+}
+
+// bboxEdgeSteps is the number of points sampled along each edge of a transformed bbox
+const bboxEdgeSteps = 16
+
+// BBox returns the extent of the bbox xMin, yMin, xMax, yMax once transformed. The edges are sampled,
+// since they are not straight lines anymore in the other spatial reference.
+func (t Transform) BBox(xMin, yMin, xMax, yMax float64) (float64, float64, float64, float64) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	add := func(x, y float64) {
+		tx, ty := t(x, y)
+		minX, minY = min(minX, tx), min(minY, ty)
+		maxX, maxY = max(maxX, tx), max(maxY, ty)
+	}
+	for i := 0; i <= bboxEdgeSteps; i++ {
+		f := float64(i) / bboxEdgeSteps
+		x := xMin + f*(xMax-xMin)
+		y := yMin + f*(yMax-yMin)
+		add(x, yMin)
+		add(x, yMax)
+		add(xMin, y)
+		add(xMax, y)
+	}
+	return minX, minY, maxX, maxY
+}
+
+func identity(x, y float64) (float64, float64) {
+	return x, y
+}
+
+// lv95ToWGS84 uses the approximate formulas of swisstopo
+func lv95ToWGS84(east, north float64) (lon, lat float64) {
+	y := (east - 2600000) / 1000000
+	x := (north - 1200000) / 1000000
+	lambda := 2.6779094 + 4.728982*y + 0.791484*y*x + 0.1306*y*x*x - 0.0436*y*y*y
+	phi := 16.9023892 + 3.238272*x - 0.270978*y*y - 0.002528*x*x - 0.0447*y*y*x - 0.0140*x*x*x
+	// the results are in units of 10000"
+	return lambda * 100 / 36, phi * 100 / 36
+}
+
+// wgs84ToLV95 uses the approximate formulas of swisstopo
+func wgs84ToLV95(lon, lat float64) (east, north float64) {
+	phi := (lat*3600 - 169028.66) / 10000
+	lambda := (lon*3600 - 26782.5) / 10000
+	east = 2600072.37 + 211455.93*lambda - 10938.51*lambda*phi - 0.36*lambda*phi*phi - 44.54*lambda*lambda*lambda
+	north = 1200147.07 + 308807.95*phi + 3745.25*lambda*lambda + 76.63*phi*phi - 194.56*lambda*lambda*phi + 119.79*phi*phi*phi
+	return east, north
+}
+
+// lv03ToLV95 adds the false easting and northing of LV95, the approximate formulas of both frames only differ by them
+func lv03ToLV95(y, x float64) (east, north float64) {
+	return y + 2000000, x + 1000000
+}
+
+func lv95ToLV03(east, north float64) (y, x float64) {
+	return east - 2000000, north - 1000000
+}
+
+func webMercatorToWGS84(x, y float64) (lon, lat float64) {
+	lon = x / EarthRadius * 180 / math.Pi
+	lat = (2*math.Atan(math.Exp(y/EarthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lon, lat
+}
+
+// wgs84ToWebMercator clamps the latitude to the square world of the projection
+func wgs84ToWebMercator(lon, lat float64) (x, y float64) {
+	lat = min(max(lat, -MaxMercatorLatitude), MaxMercatorLatitude)
+	x = EarthRadius * lon * math.Pi / 180
+	y = EarthRadius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+	return x, y
+}
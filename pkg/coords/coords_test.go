@@ -0,0 +1,83 @@
+package coords
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewTransform(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int
+		x, y     float64
+		wantX    float64
+		wantY    float64
+		tol      float64
+	}{
+		// reference point of the swisstopo approximate formulas documentation
+		{"WGS84 to LV95", WGS84, LV95, 8.730497222, 46.044130556, 2699999.76, 1099999.97, 0.5},
+		{"LV95 to WGS84", LV95, WGS84, 2700000, 1100000, 8.730497, 46.044131, 1e-5},
+		{"WGS84 to LV03", WGS84, LV03, 8.730497222, 46.044130556, 699999.76, 99999.97, 0.5},
+		{"LV03 to LV95", LV03, LV95, 538000, 152600, 2538000, 1152600, 1e-6},
+		{"WGS84 to WebMercator", WGS84, WebMercator, 180, 0, math.Pi * EarthRadius, 0, 1e-6},
+		{"WebMercator to WGS84", WebMercator, WGS84, 0, math.Pi * EarthRadius, 0, MaxMercatorLatitude, 1e-9},
+		{"same reference", LV95, LV95, 2538000, 1152600, 2538000, 1152600, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := NewTransform(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x, y := transform(tt.x, tt.y)
+			if math.Abs(x-tt.wantX) > tt.tol || math.Abs(y-tt.wantY) > tt.tol {
+				t.Errorf("got %f, %f, want %f, %f", x, y, tt.wantX, tt.wantY)
+			}
+		})
+	}
+	// LV95 to WebMercator and back, the approximate formulas are precise to about 1 meter
+	toMercator, _ := NewTransform(LV95, WebMercator)
+	toLV95, _ := NewTransform(WebMercator, LV95)
+	if x, y := toLV95(toMercator(2538000, 1152600)); math.Abs(x-2538000) > 1 || math.Abs(y-1152600) > 1 {
+		t.Errorf("round trip of 2538000, 1152600 gives %f, %f", x, y)
+	}
+	if _, err := NewTransform(LV95, 31370); err == nil {
+		t.Error("expected an error for an unsupported spatial reference")
+	}
+}
+
+func TestParseCRS(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		wantErr bool
+	}{
+		{"EPSG:2056", LV95, false},
+		{"epsg:4326", WGS84, false},
+		{"21781", LV03, false},
+		{"WebMercator", WebMercator, false},
+		{" wgs84 ", WGS84, false},
+		{"EPSG:31370", 0, true},
+		{"lambert", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseCRS(tt.s)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseCRS(%q) = %d, %v, want %d, error %v", tt.s, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransformBBox(t *testing.T) {
+	toLV95, _ := NewTransform(WGS84, LV95)
+	xMin, yMin, xMax, yMax := toLV95.BBox(6.5, 46.4, 6.8, 46.6)
+	// the corners and the middle of the edges are inside the transformed bbox
+	for _, p := range [][2]float64{{6.5, 46.4}, {6.8, 46.6}, {6.65, 46.6}, {6.5, 46.5}} {
+		x, y := toLV95(p[0], p[1])
+		if x < xMin || x > xMax || y < yMin || y > yMax {
+			t.Errorf("point %v at %f, %f is outside the bbox %f, %f, %f, %f", p, x, y, xMin, yMin, xMax, yMax)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package gohttp
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// BearerAuthMiddleware only lets through the requests having an "Authorization: Bearer <token>" header with the given token.
+func BearerAuthMiddleware(token string, l golog.MyLogger) func(http.Handler) http.Handler {
+	if token == "" {
+		panic("💥💥 panic in BearerAuthMiddleware : token cannot be empty")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(received), []byte(token)) != 1 {
+				l.Warn("unauthorized request %s '%s', IP: [%s]", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
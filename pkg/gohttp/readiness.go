@@ -0,0 +1,76 @@
+package gohttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// ReadinessCheck tells if a dependency allows the server to answer requests, details are added to the /readiness response
+type ReadinessCheck func() (ready bool, details any)
+
+type readinessChecks struct {
+	mu     sync.RWMutex
+	checks map[string]ReadinessCheck
+}
+
+// readinessResponse is the body of /readiness when checks are registered
+type readinessResponse struct {
+	Ready  bool                      `json:"ready"`
+	Checks map[string]readinessCheck `json:"checks"`
+}
+
+type readinessCheck struct {
+	Ready   bool `json:"ready"`
+	Details any  `json:"details,omitempty"`
+}
+
+// AddReadinessCheck registers a check run at each /readiness request,
+// the server is reported not ready (503) as soon as one check fails
+func (s *Server) AddReadinessCheck(name string, check ReadinessCheck) {
+	s.readiness.mu.Lock()
+	defer s.readiness.mu.Unlock()
+	if s.readiness.checks == nil {
+		s.readiness.checks = make(map[string]ReadinessCheck)
+	}
+	s.readiness.checks[name] = check
+}
+
+// getReadinessWithChecksHandler answers like GetReadinessHandler when no check is registered,
+// otherwise with the JSON result of every check
+func (s *Server) getReadinessWithChecksHandler(l golog.MyLogger) http.HandlerFunc {
+	handlerName := "GetReadinessWithChecksHandler"
+	l.Debug(initCallMsg, handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		TraceRequest(handlerName, r, l)
+		s.readiness.mu.RLock()
+		names := make([]string, 0, len(s.readiness.checks))
+		for name := range s.readiness.checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		resp := readinessResponse{Ready: true, Checks: make(map[string]readinessCheck, len(names))}
+		for _, name := range names {
+			ready, details := s.readiness.checks[name]()
+			resp.Checks[name] = readinessCheck{Ready: ready, Details: details}
+			resp.Ready = resp.Ready && ready
+		}
+		s.readiness.mu.RUnlock()
+		if len(names) == 0 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		status := http.StatusOK
+		if !resp.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set(HeaderContentType, MIMEAppJSONCharsetUTF8)
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			l.Error("readiness JSON encoding failed. Error: %v", err)
+		}
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/xid"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -25,6 +27,10 @@ type Server struct {
 	startTime     time.Time
 	VersionReader VersionReader
 	httpServer    http.Server
+	shutdownHooks []func(context.Context) error
+	readiness     readinessChecks
+	ctx           context.Context // cancelled once the server is stopped
+	cancel        context.CancelFunc
 }
 
 // NewGoHttpServer is a constructor that initializes the server mux (routes) and all fields of the  Server type
@@ -37,7 +43,10 @@ func NewGoHttpServer(listenAddress string, Ver VersionReader, logger golog.MyLog
 		defaultHttpLogger = log.New(os.Stderr, "NewGoHttpServer::defaultHttpLogger", log.Ldate|log.Ltime|log.Lshortfile)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	myServer := Server{
+		ctx:           ctx,
+		cancel:        cancel,
 		listenAddress: listenAddress,
 		logger:        logger,
 		router:        myServerMux,
@@ -50,6 +59,8 @@ func NewGoHttpServer(listenAddress string, Ver VersionReader, logger golog.MyLog
 			ReadTimeout:  defaultReadTimeout,  // max time to read request from the client
 			WriteTimeout: defaultWriteTimeout, // max time to write response to the client
 			IdleTimeout:  defaultIdleTimeout,  // max time for connections using TCP Keep-Alive
+			// the requests still running when the shutdown timeout is over are cancelled with it
+			BaseContext: func(net.Listener) context.Context { return ctx },
 		},
 	}
 	myServer.routes()
@@ -83,8 +94,9 @@ func (s *Server) routes() {
 			return
 		}
 	})
-	s.router.Handle("GET /readiness", GetReadinessHandler(s.logger))
+	s.router.Handle("GET /readiness", s.getReadinessWithChecksHandler(s.logger))
 	s.router.Handle("GET /health", GetHealthHandler(s.logger))
+	s.router.Handle("GET /metrics", promhttp.Handler())
 }
 
 // AddRoute   adds a handler for this web server
@@ -92,6 +104,16 @@ func (s *Server) AddRoute(pathPattern string, handler http.Handler) {
 	s.router.Handle(pathPattern, handler)
 }
 
+// AddShutdownHook registers a function called once the server is stopped, before exiting, like flushing the traces
+func (s *Server) AddShutdownHook(hook func(context.Context) error) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+// Context returns a context cancelled once the server is stopped, for the work done in background
+func (s *Server) Context() context.Context {
+	return s.ctx
+}
+
 // GetRouter returns the ServeMux of this web server
 func (s *Server) GetRouter() *http.ServeMux {
 	return s.router
@@ -121,7 +143,7 @@ func (s *Server) StartServer() {
 	s.logger.Debug("Server listening on : %s PID:[%d]", s.httpServer.Addr, os.Getpid())
 
 	// Graceful Shutdown on SIGINT (interrupt)
-	waitForShutdownToExit(&s.httpServer, secondsShutDownTimeout)
+	waitForShutdownToExit(&s.httpServer, secondsShutDownTimeout, s.cancel, s.shutdownHooks)
 
 }
 
@@ -174,7 +196,8 @@ func WaitForHttpServer(listenAddress string, waitDuration time.Duration, numRetr
 }
 
 // waitForShutdownToExit will wait for interrupt signal SIGINT or SIGTERM and gracefully shutdown the server after secondsToWait seconds.
-func waitForShutdownToExit(srv *http.Server, secondsToWait time.Duration) {
+// Once the server is stopped, cancel is called to stop the remaining requests and the background work, then the hooks are called.
+func waitForShutdownToExit(srv *http.Server, secondsToWait time.Duration, cancel context.CancelFunc, hooks []func(context.Context) error) {
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
@@ -184,14 +207,20 @@ func waitForShutdownToExit(srv *http.Server, secondsToWait time.Duration) {
 	srv.ErrorLog.Printf("INFO: 'SIGINT %d interrupt signal received, about to shut down server after max %v seconds...'\n", sig, secondsToWait.Seconds())
 
 	// create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), secondsToWait)
-	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), secondsToWait)
+	defer cancelTimeout()
 	// gracefully shuts down the server without interrupting any active connections
 	// as long as the actives connections last less than shutDownTimeout
 	// https://pkg.go.dev/net/http#Server.Shutdown
 	if err := srv.Shutdown(ctx); err != nil {
 		srv.ErrorLog.Printf("💥💥 ERROR: 'Problem doing Shutdown %v'\n", err)
 	}
+	cancel()
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			srv.ErrorLog.Printf("💥💥 ERROR: 'Problem doing shutdown hook %v'\n", err)
+		}
+	}
 	<-ctx.Done()
 	srv.ErrorLog.Println("INFO: 'Server gracefully stopped, will exit'")
 	os.Exit(0)
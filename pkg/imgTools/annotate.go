@@ -0,0 +1,104 @@
+package imgTools
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+const (
+	markerRadius   = 6 // pixels of the colored disc of a marker
+	markerBorder   = 2 // pixels of the white ring around it
+	scaleBarMargin = 10
+	scaleBarHeight = 4
+)
+
+// DefaultMarkerColor is the color of the markers without one, a red
+var DefaultMarkerColor = color.NRGBA{R: 0xe0, G: 0x1b, B: 0x24, A: 0xff}
+
+// Crop returns a copy of the part r of src, the parts of r outside src are transparent
+func Crop(src image.Image, r image.Rectangle) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, r.Min, draw.Src)
+	return dst
+}
+
+// DrawMarker draws a disc of color c ringed with white centered on the pixel x, y of dst.
+// The edges are anti-aliased, a marker partly outside dst is clipped.
+func DrawMarker(dst draw.Image, x, y int, c color.NRGBA) {
+	outer := float64(markerRadius + markerBorder)
+	for py := y - int(outer) - 1; py <= y+int(outer)+1; py++ {
+		for px := x - int(outer) - 1; px <= x+int(outer)+1; px++ {
+			if !(image.Point{X: px, Y: py}).In(dst.Bounds()) {
+				continue
+			}
+			d := math.Hypot(float64(px-x), float64(py-y))
+			// coverage of the pixel by the marker and by its colored disc, with a one pixel wide transition
+			cover := math.Min(math.Max(outer+0.5-d, 0), 1)
+			if cover == 0 {
+				continue
+			}
+			inner := math.Min(math.Max(markerRadius+0.5-d, 0), 1)
+			fill := color.NRGBA{
+				R: toByte((float64(c.R)*inner + 255*(1-inner)) / 255),
+				G: toByte((float64(c.G)*inner + 255*(1-inner)) / 255),
+				B: toByte((float64(c.B)*inner + 255*(1-inner)) / 255),
+				A: toByte(cover * (float64(c.A)*inner + 255*(1-inner)) / 255),
+			}
+			draw.Draw(dst, image.Rect(px, py, px+1, py+1), image.NewUniform(fill), image.Point{}, draw.Over)
+		}
+	}
+}
+
+// DrawScaleBar draws at the bottom left of dst a bar of a round length of at most a quarter of its width,
+// with its length as label. metersPerPixel is the ground size of a pixel of dst.
+func DrawScaleBar(dst draw.Image, metersPerPixel float64) error {
+	if metersPerPixel <= 0 || math.IsInf(metersPerPixel, 0) || math.IsNaN(metersPerPixel) {
+		return fmt.Errorf("invalid ground size of a pixel: %f", metersPerPixel)
+	}
+	bounds := dst.Bounds()
+	meters := roundLength(float64(bounds.Dx()) / 4 * metersPerPixel)
+	length := int(math.Round(meters / metersPerPixel))
+	if length < 1 {
+		return nil
+	}
+	label, err := textImage(formatLength(meters), "")
+	if err != nil {
+		return err
+	}
+	labelSize := label.Bounds().Size()
+	width := max(length, labelSize.X)
+	// white box holding the label above the bar
+	box := image.Rect(0, 0, width+8, labelSize.Y+scaleBarHeight+10).
+		Add(image.Pt(bounds.Min.X+scaleBarMargin-4, bounds.Max.Y-scaleBarMargin-labelSize.Y-scaleBarHeight-6))
+	draw.Draw(dst, box, image.NewUniform(color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xc0}), image.Point{}, draw.Over)
+	at := box.Min.Add(image.Pt(4, 2))
+	draw.Draw(dst, image.Rectangle{Min: at, Max: at.Add(labelSize)}, label, label.Bounds().Min, draw.Over)
+	bar := image.Rect(0, 0, length, scaleBarHeight).Add(image.Pt(at.X, at.Y+labelSize.Y+2))
+	draw.Draw(dst, bar, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	return nil
+}
+
+// roundLength returns the largest length of 1, 2 or 5 times a power of ten not longer than meters
+func roundLength(meters float64) float64 {
+	if meters <= 0 {
+		return 0
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(meters)))
+	for _, f := range []float64{5, 2, 1} {
+		if f*pow <= meters {
+			return f * pow
+		}
+	}
+	return pow
+}
+
+// formatLength returns the length in m, or in km from 1000 m
+func formatLength(meters float64) string {
+	if meters >= 1000 {
+		return fmt.Sprintf("%g km", meters/1000)
+	}
+	return fmt.Sprintf("%g m", meters)
+}
@@ -0,0 +1,92 @@
+package imgTools
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Blend modes of a layer drawn over another, see https://www.w3.org/TR/compositing-1/#blending
+const (
+	BlendNormal   = "normal"
+	BlendMultiply = "multiply"
+	BlendScreen   = "screen"
+	BlendOverlay  = "overlay"
+	BlendDarken   = "darken"
+	BlendLighten  = "lighten"
+)
+
+// BlendModes lists the supported blend modes
+var BlendModes = []string{BlendNormal, BlendMultiply, BlendScreen, BlendOverlay, BlendDarken, BlendLighten}
+
+// blendFuncs are the separable blend functions B(cb, cs) of the blend modes, on not premultiplied values in [0, 1]
+var blendFuncs = map[string]func(cb, cs float64) float64{
+	BlendMultiply: func(cb, cs float64) float64 { return cb * cs },
+	BlendScreen:   screen,
+	BlendOverlay: func(cb, cs float64) float64 {
+		// hard light with the layers swapped
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return screen(2*cb-1, cs)
+	},
+	BlendDarken:  math.Min,
+	BlendLighten: math.Max,
+}
+
+func screen(cb, cs float64) float64 {
+	return cb + cs - cb*cs
+}
+
+// Composite draws src over dst, aligned on their top-left corners, with the given opacity (0 to 1) and blend mode.
+// The normal mode uses draw.DrawMask, the other ones are computed per pixel.
+func Composite(dst *image.RGBA, src image.Image, opacity float64, mode string) error {
+	if opacity <= 0 {
+		return nil
+	}
+	opacity = min(opacity, 1)
+	r := dst.Bounds().Intersect(src.Bounds().Sub(src.Bounds().Min).Add(dst.Bounds().Min))
+	if mode == "" || mode == BlendNormal {
+		var mask image.Image
+		if opacity < 1 {
+			mask = image.NewUniform(color.Alpha{A: uint8(math.Round(opacity * 255))})
+		}
+		draw.DrawMask(dst, r, src, src.Bounds().Min, mask, image.Point{}, draw.Over)
+		return nil
+	}
+	blend, ok := blendFuncs[mode]
+	if !ok {
+		return fmt.Errorf("unknown blend mode %q, supported values are %v", mode, BlendModes)
+	}
+	offset := src.Bounds().Min.Sub(dst.Bounds().Min)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sr, sg, sb, sa := src.At(x+offset.X, y+offset.Y).RGBA()
+			if sa == 0 {
+				continue
+			}
+			d := dst.RGBAAt(x, y)
+			as := float64(sa) / 0xffff * opacity
+			ab := float64(d.A) / 0xff
+			// co = cs·(1 − αb) + cb·(1 − αs) + αs·αb·B(Cb, Cs) with premultiplied cs, cb
+			channel := func(s uint32, b uint8) uint8 {
+				cs := float64(s) / 0xffff * opacity
+				cb := float64(b) / 0xff
+				mixed := 0.0
+				if ab > 0 {
+					mixed = as * ab * blend(cb/ab, cs/as)
+				}
+				return uint8(math.Round(min(cs*(1-ab)+cb*(1-as)+mixed, 1) * 0xff))
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: channel(sr, d.R),
+				G: channel(sg, d.G),
+				B: channel(sb, d.B),
+				A: uint8(math.Round((as + ab*(1-as)) * 0xff)),
+			})
+		}
+	}
+	return nil
+}
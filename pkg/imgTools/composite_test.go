@@ -0,0 +1,42 @@
+package imgTools
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestComposite(t *testing.T) {
+	backdrop := color.RGBA{R: 255, G: 128, B: 0, A: 255}
+	source := color.RGBA{R: 128, G: 128, B: 255, A: 255}
+	tests := []struct {
+		mode    string
+		opacity float64
+		want    color.RGBA
+	}{
+		{BlendNormal, 1, source},
+		{BlendNormal, 0, backdrop},
+		{BlendMultiply, 1, color.RGBA{R: 128, G: 64, B: 0, A: 255}},
+		{BlendScreen, 1, color.RGBA{R: 255, G: 192, B: 255, A: 255}},
+		{BlendDarken, 1, color.RGBA{R: 128, G: 128, B: 0, A: 255}},
+		{BlendLighten, 1, color.RGBA{R: 255, G: 128, B: 255, A: 255}},
+		{BlendMultiply, 0.5, color.RGBA{R: 192, G: 96, B: 0, A: 255}},
+	}
+	for _, tt := range tests {
+		dst := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		for i := 0; i < 4; i++ {
+			dst.SetRGBA(i%2, i/2, backdrop)
+			src.SetRGBA(i%2, i/2, source)
+		}
+		if err := Composite(dst, src, tt.opacity, tt.mode); err != nil {
+			t.Fatalf("Composite(%s): %v", tt.mode, err)
+		}
+		if got := dst.RGBAAt(1, 1); got != tt.want {
+			t.Errorf("Composite(%s, %g) = %v, want %v", tt.mode, tt.opacity, got, tt.want)
+		}
+	}
+	if err := Composite(image.NewRGBA(image.Rect(0, 0, 1, 1)), image.NewRGBA(image.Rect(0, 0, 1, 1)), 1, "dodge"); err == nil {
+		t.Error("Composite with an unknown blend mode must fail")
+	}
+}
@@ -0,0 +1,273 @@
+package imgTools
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Filter types
+const (
+	FilterGrayscale  = "grayscale"
+	FilterBrightness = "brightness"
+	FilterContrast   = "contrast"
+	FilterGamma      = "gamma"
+	FilterTint       = "tint"
+	FilterWatermark  = "watermark"
+)
+
+// FilterTypes lists the supported filter types
+var FilterTypes = []string{FilterGrayscale, FilterBrightness, FilterContrast, FilterGamma, FilterTint, FilterWatermark}
+
+const (
+	DefaultWatermarkSpacing = 256
+	DefaultWatermarkOpacity = 0.5
+)
+
+// Filter is one step of the post-processing of the tiles of a layer, applied after the crop and before the encoding
+type Filter struct {
+	Type    string   `yaml:"type"`    // grayscale, brightness, contrast, gamma, tint or watermark
+	Value   float64  `yaml:"value"`   // brightness and contrast from -1 to 1, gamma > 0
+	Color   string   `yaml:"color"`   // #rrggbb of the tint or of the watermark text
+	Text    string   `yaml:"text"`    // text of the watermark
+	Image   string   `yaml:"image"`   // or PNG file of the watermark
+	Spacing int      `yaml:"spacing"` // pixels between two watermarks, default 256
+	Opacity *float64 `yaml:"opacity"` // of the tint (default 1) or of the watermark (default 0.5)
+}
+
+// Validate checks the parameters of the filter, and that the watermark image can be read
+func (f Filter) Validate() error {
+	if f.Opacity != nil && (*f.Opacity < 0 || *f.Opacity > 1) {
+		return fmt.Errorf("%s opacity %g must be between 0 and 1", f.Type, *f.Opacity)
+	}
+	switch f.Type {
+	case FilterGrayscale:
+	case FilterBrightness, FilterContrast:
+		if f.Value < -1 || f.Value > 1 {
+			return fmt.Errorf("%s value %g must be between -1 and 1", f.Type, f.Value)
+		}
+	case FilterGamma:
+		if f.Value <= 0 {
+			return fmt.Errorf("gamma value %g must be greater than 0", f.Value)
+		}
+	case FilterTint:
+		if _, err := ParseHexColor(f.Color); err != nil {
+			return err
+		}
+	case FilterWatermark:
+		if (f.Text == "") == (f.Image == "") {
+			return fmt.Errorf("a watermark needs either a text or an image")
+		}
+		if f.Color != "" {
+			if _, err := ParseHexColor(f.Color); err != nil {
+				return err
+			}
+		}
+		if f.Image != "" {
+			if _, err := loadWatermark(f.Image); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown filter type %q, supported values are %v", f.Type, FilterTypes)
+	}
+	return nil
+}
+
+// ParseHexColor parses a #rrggbb color
+func ParseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// ApplyFilters returns img processed by the filters in order. origin is the position of img in the pixels of the
+// whole zoom level, so the watermarks are spaced regularly across the tiles. img is returned as is without filters.
+func ApplyFilters(img image.Image, filters []Filter, origin image.Point) (image.Image, error) {
+	if len(filters) == 0 {
+		return img, nil
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	for _, f := range filters {
+		if err := f.Validate(); err != nil {
+			return nil, err
+		}
+		if f.Type == FilterWatermark {
+			if err := watermark(dst, f, origin); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		adjust := f.pixelFunc()
+		for i := 0; i < len(dst.Pix); i += 4 {
+			if dst.Pix[i+3] == 0 {
+				continue
+			}
+			r, g, bl := adjust(float64(dst.Pix[i])/0xff, float64(dst.Pix[i+1])/0xff, float64(dst.Pix[i+2])/0xff)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = toByte(r), toByte(g), toByte(bl)
+		}
+	}
+	return dst, nil
+}
+
+func toByte(v float64) uint8 {
+	return uint8(math.Round(min(max(v, 0), 1) * 0xff))
+}
+
+// luminance returns the Rec. 709 luma of a color
+func luminance(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// pixelFunc returns the per pixel function of a color filter, on not premultiplied values in [0, 1]
+func (f Filter) pixelFunc() func(r, g, b float64) (float64, float64, float64) {
+	switch f.Type {
+	case FilterGrayscale:
+		return func(r, g, b float64) (float64, float64, float64) {
+			y := luminance(r, g, b)
+			return y, y, y
+		}
+	case FilterBrightness:
+		return func(r, g, b float64) (float64, float64, float64) {
+			return r + f.Value, g + f.Value, b + f.Value
+		}
+	case FilterContrast:
+		// -1 gives a uniform grey, 1 a maximal contrast
+		factor := math.Tan((f.Value + 1) * math.Pi / 4)
+		c := func(v float64) float64 { return (v-0.5)*factor + 0.5 }
+		return func(r, g, b float64) (float64, float64, float64) {
+			return c(r), c(g), c(b)
+		}
+	case FilterGamma:
+		c := func(v float64) float64 { return math.Pow(v, 1/f.Value) }
+		return func(r, g, b float64) (float64, float64, float64) {
+			return c(r), c(g), c(b)
+		}
+	case FilterTint:
+		// the luminance of the pixel is kept with the hue of the tint, mixed by its opacity
+		tint, _ := ParseHexColor(f.Color)
+		strength := 1.0
+		if f.Opacity != nil {
+			strength = *f.Opacity
+		}
+		tr, tg, tb := float64(tint.R)/0xff, float64(tint.G)/0xff, float64(tint.B)/0xff
+		return func(r, g, b float64) (float64, float64, float64) {
+			y := luminance(r, g, b)
+			mix := func(v, t float64) float64 { return v*(1-strength) + y*t*strength }
+			return mix(r, tr), mix(g, tg), mix(b, tb)
+		}
+	}
+	return func(r, g, b float64) (float64, float64, float64) { return r, g, b }
+}
+
+// watermark draws the text or the image of f centered every f.Spacing pixels of the zoom level
+func watermark(dst *image.NRGBA, f Filter, origin image.Point) error {
+	var mark image.Image
+	var err error
+	if f.Image != "" {
+		mark, err = loadWatermark(f.Image)
+	} else {
+		mark, err = textImage(f.Text, f.Color)
+	}
+	if err != nil {
+		return err
+	}
+	spacing := f.Spacing
+	if spacing <= 0 {
+		spacing = DefaultWatermarkSpacing
+	}
+	opacity := DefaultWatermarkOpacity
+	if f.Opacity != nil {
+		opacity = *f.Opacity
+	}
+	mask := image.NewUniform(color.Alpha{A: toByte(opacity)})
+	size := mark.Bounds().Size()
+	// the marks centered at (i+0.5)*spacing which overlap dst, in the coordinates of the zoom level
+	view := dst.Bounds().Add(origin)
+	first := func(v, markSize int) int { return floorDiv(v-markSize-spacing/2, spacing) }
+	for j := first(view.Min.Y, size.Y); j*spacing+spacing/2-size.Y/2 < view.Max.Y; j++ {
+		for i := first(view.Min.X, size.X); i*spacing+spacing/2-size.X/2 < view.Max.X; i++ {
+			at := image.Pt(i*spacing+spacing/2-size.X/2, j*spacing+spacing/2-size.Y/2).Sub(origin)
+			draw.DrawMask(dst, image.Rectangle{Min: at, Max: at.Add(size)}, mark, mark.Bounds().Min, mask, image.Point{}, draw.Over)
+		}
+	}
+	return nil
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// textImages caches the rendered watermark texts by text and color
+var textImages sync.Map
+
+// textImage renders text with the basic font of x/image, in black by default
+func textImage(text, hexColor string) (image.Image, error) {
+	key := hexColor + " " + text
+	if cached, ok := textImages.Load(key); ok {
+		return cached.(image.Image), nil
+	}
+	c := color.NRGBA{A: 0xff}
+	if hexColor != "" {
+		var err error
+		if c, err = ParseHexColor(hexColor); err != nil {
+			return nil, err
+		}
+	}
+	face := basicfont.Face7x13
+	img := image.NewNRGBA(image.Rect(0, 0, font.MeasureString(face, text).Ceil(), face.Metrics().Height.Ceil()))
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(0, face.Metrics().Ascent.Ceil())}
+	d.DrawString(text)
+	textImages.Store(key, image.Image(img))
+	return img, nil
+}
+
+type cachedWatermark struct {
+	modTime time.Time
+	img     image.Image
+}
+
+// watermarks caches the decoded watermark images by path, they are read again when the file changes
+var watermarks sync.Map
+
+func loadWatermark(path string) (image.Image, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read watermark image: %w", err)
+	}
+	if cached, ok := watermarks.Load(path); ok && cached.(cachedWatermark).modTime.Equal(info.ModTime()) {
+		return cached.(cachedWatermark).img, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read watermark image: %w", err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode watermark image %s: %w", path, err)
+	}
+	watermarks.Store(path, cachedWatermark{modTime: info.ModTime(), img: img})
+	return img, nil
+}
@@ -0,0 +1,122 @@
+package imgTools
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func opacity(v float64) *float64 {
+	return &v
+}
+
+func TestApplyFiltersPixel(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		in     color.NRGBA
+		want   color.NRGBA
+	}{
+		{"grayscale red", Filter{Type: FilterGrayscale}, color.NRGBA{R: 255, A: 255}, color.NRGBA{R: 54, G: 54, B: 54, A: 255}},
+		{"grayscale keeps alpha", Filter{Type: FilterGrayscale}, color.NRGBA{G: 255, A: 128}, color.NRGBA{R: 182, G: 182, B: 182, A: 128}},
+		{"brightness up", Filter{Type: FilterBrightness, Value: 0.2}, color.NRGBA{R: 100, G: 200, B: 250, A: 255}, color.NRGBA{R: 151, G: 251, B: 255, A: 255}},
+		{"brightness down", Filter{Type: FilterBrightness, Value: -1}, color.NRGBA{R: 100, G: 200, B: 250, A: 255}, color.NRGBA{A: 255}},
+		{"contrast -1 is uniform grey", Filter{Type: FilterContrast, Value: -1}, color.NRGBA{R: 10, G: 128, B: 250, A: 255}, color.NRGBA{R: 128, G: 128, B: 128, A: 255}},
+		{"contrast 1 is maximal", Filter{Type: FilterContrast, Value: 1}, color.NRGBA{R: 120, G: 136, B: 250, A: 255}, color.NRGBA{R: 0, G: 255, B: 255, A: 255}},
+		{"contrast 0 is neutral", Filter{Type: FilterContrast}, color.NRGBA{R: 10, G: 128, B: 250, A: 255}, color.NRGBA{R: 10, G: 128, B: 250, A: 255}},
+		{"gamma 2 brightens", Filter{Type: FilterGamma, Value: 2}, color.NRGBA{R: 64, G: 0, B: 255, A: 255}, color.NRGBA{R: 128, G: 0, B: 255, A: 255}},
+		{"gamma 1 is neutral", Filter{Type: FilterGamma, Value: 1}, color.NRGBA{R: 64, G: 1, B: 254, A: 255}, color.NRGBA{R: 64, G: 1, B: 254, A: 255}},
+		{"tint white", Filter{Type: FilterTint, Color: "#ff8000"}, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBA{R: 255, G: 128, B: 0, A: 255}},
+		{"tint half", Filter{Type: FilterTint, Color: "#ff0000", Opacity: opacity(0.5)}, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBA{R: 255, G: 128, B: 128, A: 255}},
+		{"transparent pixel unchanged", Filter{Type: FilterBrightness, Value: 1}, color.NRGBA{R: 10}, color.NRGBA{R: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+			src.SetNRGBA(0, 0, tt.in)
+			img, err := ApplyFilters(src, []Filter{tt.filter}, image.Point{})
+			if err != nil {
+				t.Fatalf("ApplyFilters: %v", err)
+			}
+			if got := img.(*image.NRGBA).NRGBAAt(0, 0); got != tt.want {
+				t.Errorf("ApplyFilters(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		filter  Filter
+		wantErr bool
+	}{
+		{Filter{Type: FilterContrast, Value: -1}, false},
+		{Filter{Type: FilterContrast, Value: 1.1}, true},
+		{Filter{Type: FilterGamma}, true},
+		{Filter{Type: FilterTint, Color: "#336699", Opacity: opacity(0)}, false},
+		{Filter{Type: FilterTint, Color: "#336699", Opacity: opacity(1.5)}, true},
+		{Filter{Type: FilterTint, Color: "336"}, true},
+		{Filter{Type: FilterWatermark, Text: "©", Opacity: opacity(1)}, false},
+		{Filter{Type: FilterWatermark, Text: "©", Opacity: opacity(-0.1)}, true},
+		{Filter{Type: FilterWatermark}, true},
+		{Filter{Type: "blur"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v Validate() = %v, want error %v", tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFloorDiv(t *testing.T) {
+	tests := []struct{ a, b, want int }{
+		{7, 2, 3},
+		{8, 2, 4},
+		{0, 5, 0},
+		{-1, 256, -1},
+		{-7, 2, -4},
+		{-8, 2, -4},
+	}
+	for _, tt := range tests {
+		if got := floorDiv(tt.a, tt.b); got != tt.want {
+			t.Errorf("floorDiv(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// the watermarks of adjacent tiles must join like the ones of a single image covering them
+func TestWatermarkAcrossTiles(t *testing.T) {
+	filters := []Filter{{Type: FilterWatermark, Text: "Lausanne", Color: "#ff0000", Spacing: 100, Opacity: opacity(1)}}
+	blank := func(w, h int) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for i := range img.Pix {
+			img.Pix[i] = 255
+		}
+		return img
+	}
+	whole, err := ApplyFilters(blank(512, 512), filters, image.Pt(256, 512))
+	if err != nil {
+		t.Fatalf("ApplyFilters: %v", err)
+	}
+	marked := 0
+	for _, origin := range []image.Point{{0, 0}, {256, 0}, {0, 256}, {256, 256}} {
+		tile, err := ApplyFilters(blank(256, 256), filters, origin.Add(image.Pt(256, 512)))
+		if err != nil {
+			t.Fatalf("ApplyFilters: %v", err)
+		}
+		for y := 0; y < 256; y++ {
+			for x := 0; x < 256; x++ {
+				got, want := tile.At(x, y), whole.At(origin.X+x, origin.Y+y)
+				if got != want {
+					t.Fatalf("tile at %v pixel (%d, %d) = %v, want %v", origin, x, y, got, want)
+				}
+				if got.(color.NRGBA).G != 255 {
+					marked++
+				}
+			}
+		}
+	}
+	if marked == 0 {
+		t.Error("no watermark drawn")
+	}
+}
@@ -2,6 +2,7 @@ package imgTools
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -9,6 +10,9 @@ import (
 	"image/png"
 
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GeneratePng creates a PNG image with the specified color and dimensions.
@@ -111,7 +115,10 @@ func SplitImage(img image.Image, tileWidth, tileHeight int) ([]image.Image, erro
 	return tiles, nil
 }
 
-func CropImage(bufferedImage image.Image, buffer int, l golog.MyLogger) image.Image {
+// CropImage removes the buffer pixels around the image, it is traced as a child span of ctx
+func CropImage(ctx context.Context, bufferedImage image.Image, buffer int, l golog.MyLogger) image.Image {
+	_, span := tracing.Start(ctx, "imgTools.CropImage", trace.WithAttributes(attribute.Int("buffer", buffer)))
+	defer span.End()
 	l.Debug("CropImage bufferedImg wxh = %v , buffer : %d", bufferedImage.Bounds(), buffer)
 	originalWidth := bufferedImage.Bounds().Dx() - (buffer * 2)
 	originalHeight := bufferedImage.Bounds().Dy() - (buffer * 2)
@@ -0,0 +1,76 @@
+package imgTools
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Resampling methods
+const (
+	ResampleNearest  = "nearest"
+	ResampleBilinear = "bilinear"
+)
+
+// ResampleMethods lists the supported resampling methods
+var ResampleMethods = []string{ResampleNearest, ResampleBilinear}
+
+// Resample returns a width x height image whose pixel (x, y) takes the color of src at the position returned by at,
+// in src pixel coordinates where (0.5, 0.5) is the center of the top-left pixel. Positions outside src are transparent.
+// The same function serves a change of resolution or a reprojection, only at differs.
+func Resample(src image.Image, width, height int, at func(x, y int) (sx, sy float64), method string) (*image.NRGBA, error) {
+	var sample func(src image.Image, sx, sy float64) color.NRGBA
+	switch method {
+	case "", ResampleNearest:
+		sample = sampleNearest
+	case ResampleBilinear:
+		sample = sampleBilinear
+	default:
+		return nil, fmt.Errorf("unknown resampling method %q, supported values are %v", method, ResampleMethods)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := at(x, y)
+			dst.SetNRGBA(x, y, sample(src, sx, sy))
+		}
+	}
+	return dst, nil
+}
+
+func sampleNearest(src image.Image, sx, sy float64) color.NRGBA {
+	b := src.Bounds()
+	px, py := b.Min.X+int(math.Floor(sx)), b.Min.Y+int(math.Floor(sy))
+	if !(image.Point{X: px, Y: py}).In(b) {
+		return color.NRGBA{}
+	}
+	return color.NRGBAModel.Convert(src.At(px, py)).(color.NRGBA)
+}
+
+// sampleBilinear interpolates the 4 pixels around the position, the pixels outside src are clamped to its border
+func sampleBilinear(src image.Image, sx, sy float64) color.NRGBA {
+	b := src.Bounds()
+	fx, fy := sx-0.5, sy-0.5
+	if fx < -0.5 || fy < -0.5 || fx > float64(b.Dx())-0.5 || fy > float64(b.Dy())-0.5 {
+		return color.NRGBA{}
+	}
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	pixel := func(x, y int) [4]float64 {
+		x = min(max(x, 0), b.Dx()-1)
+		y = min(max(y, 0), b.Dy()-1)
+		// premultiplied values, so transparent pixels do not darken their neighbours
+		r, g, bl, a := src.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return [4]float64{float64(r), float64(g), float64(bl), float64(a)}
+	}
+	p00, p10, p01, p11 := pixel(x0, y0), pixel(x0+1, y0), pixel(x0, y0+1), pixel(x0+1, y0+1)
+	var v [4]float64
+	for i := range v {
+		top := p00[i]*(1-tx) + p10[i]*tx
+		bottom := p01[i]*(1-tx) + p11[i]*tx
+		v[i] = top*(1-ty) + bottom*ty
+	}
+	c := color.RGBA64{R: uint16(v[0]), G: uint16(v[1]), B: uint16(v[2]), A: uint16(v[3])}
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
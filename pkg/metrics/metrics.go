@@ -0,0 +1,110 @@
+// Package metrics defines the Prometheus metrics of the tile server and the seeder.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "wmts"
+
+// CacheResult is the value of the result label of TileCache
+type CacheResult string
+
+const (
+	CacheHit         CacheResult = "hit"
+	CacheMiss        CacheResult = "miss"
+	CacheStale       CacheResult = "stale"       // served from cache while being refreshed
+	CachePlaceholder CacheResult = "placeholder" // not in cache and the backend is unavailable
+)
+
+var (
+	// TileRequests counts the tile requests by layer, zoom and HTTP status
+	TileRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tile_requests_total",
+		Help:      "Number of tile requests by layer, zoom and HTTP status.",
+	}, []string{"layer", "zoom", "status"})
+
+	// TileRequestDuration measures the time to serve a tile by layer, zoom and HTTP status
+	TileRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tile_request_duration_seconds",
+		Help:      "Time to serve a tile by layer, zoom and HTTP status.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"layer", "zoom", "status"})
+
+	// TileBytesServed counts the bytes of the tile responses by layer
+	TileBytesServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tile_bytes_served_total",
+		Help:      "Bytes sent in the tile responses by layer.",
+	}, []string{"layer"})
+
+	// TileCache counts the cache lookups by layer and result (hit, miss, stale, placeholder)
+	TileCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tile_cache_total",
+		Help:      "Tile cache lookups by layer and result (hit, miss, stale, placeholder).",
+	}, []string{"layer", "result"})
+
+	// BackendRequestDuration measures the WMS backend requests by HTTP status, "error" when no response was received
+	BackendRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Duration of the WMS backend requests by HTTP status code.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"code"})
+
+	// BackendErrors counts the failed WMS backend requests by HTTP status, "error" when no response was received
+	BackendErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_errors_total",
+		Help:      "Failed WMS backend requests by HTTP status code, error when no response was received.",
+	}, []string{"code"})
+
+	// BackendInFlight is the number of WMS backend requests in progress
+	BackendInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backend_in_flight_requests",
+		Help:      "Number of WMS backend requests in progress.",
+	})
+)
+
+// ObserveCache counts a cache lookup of the layer
+func ObserveCache(layer string, result CacheResult) {
+	TileCache.WithLabelValues(layer, string(result)).Inc()
+}
+
+// backendTransport is a http.RoundTripper recording the WMS backend metrics
+type backendTransport struct {
+	next http.RoundTripper
+}
+
+// InstrumentBackend wraps the transport of a WMS backend client to record the latency, errors and in-flight requests
+func InstrumentBackend(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &backendTransport{next: next}
+}
+
+func (t *backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	BackendInFlight.Inc()
+	defer BackendInFlight.Dec()
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	BackendRequestDuration.WithLabelValues(code).Observe(time.Since(start).Seconds())
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		BackendErrors.WithLabelValues(code).Inc()
+	}
+	return resp, err
+}
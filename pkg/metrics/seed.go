@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// SeedJobName is the Pushgateway job name used by ExportSeed
+const SeedJobName = "saveWmtsTiles"
+
+// SeedStats is a summary of a seeding run
+type SeedStats struct {
+	Layer       string
+	TilesDone   int64
+	TilesFailed int64
+	TilesEmpty  int64 // tiles detected as empty, see wmts.LayerConfig.IsEmptyTile
+	Elapsed     time.Duration
+}
+
+// seedRegistry returns a registry with the gauges describing stats, batch jobs expose their last state only.
+// labels are added to every gauge, the Pushgateway refuses the labels of the grouping key so they are nil for a push.
+func seedRegistry(stats SeedStats, labels prometheus.Labels) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	gauge := func(name, help string, value float64) {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "seed",
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		})
+		g.Set(value)
+		reg.MustRegister(g)
+	}
+	tilesPerSec, emptyRatio := 0.0, 0.0
+	if secs := stats.Elapsed.Seconds(); secs > 0 {
+		tilesPerSec = float64(stats.TilesDone+stats.TilesFailed) / secs
+	}
+	if stats.TilesDone > 0 {
+		emptyRatio = float64(stats.TilesEmpty) / float64(stats.TilesDone)
+	}
+	gauge("tiles_done", "Number of tiles saved by the seeding.", float64(stats.TilesDone))
+	gauge("tiles_failed", "Number of tiles the seeding failed to save.", float64(stats.TilesFailed))
+	gauge("tiles_empty", "Number of saved tiles detected as empty.", float64(stats.TilesEmpty))
+	gauge("tiles_per_second", "Tiles processed per second.", tilesPerSec)
+	gauge("empty_tile_ratio", "Ratio of empty tiles among the saved ones.", emptyRatio)
+	gauge("duration_seconds", "Duration of the seeding.", stats.Elapsed.Seconds())
+	gauge("last_update_timestamp_seconds", "Time of the last update of these metrics.", float64(time.Now().Unix()))
+	return reg
+}
+
+// ExportSeed pushes the seeding stats to a Prometheus Pushgateway when pushURL is given,
+// and writes them for the node_exporter textfile collector when textfile is given.
+// The pushed metrics get the layer label from the grouping key, the ones of the textfile carry it themselves.
+func ExportSeed(stats SeedStats, pushURL, textfile string) error {
+	if pushURL != "" {
+		reg := seedRegistry(stats, nil)
+		if err := push.New(pushURL, SeedJobName).Grouping("layer", stats.Layer).Gatherer(reg).Push(); err != nil {
+			return fmt.Errorf("cannot push metrics to %s: %w", pushURL, err)
+		}
+	}
+	if textfile != "" {
+		reg := seedRegistry(stats, prometheus.Labels{"layer": stats.Layer})
+		if err := prometheus.WriteToTextfile(textfile, reg); err != nil {
+			return fmt.Errorf("cannot write metrics to %s: %w", textfile, err)
+		}
+	}
+	return nil
+}
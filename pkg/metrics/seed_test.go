@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportSeed(t *testing.T) {
+	stats := SeedStats{Layer: "ortho", TilesDone: 10, TilesFailed: 2, TilesEmpty: 5, Elapsed: 4 * time.Second}
+	var method, path string
+	var body []byte
+	// the Pushgateway answers a push with a 200
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer pushgateway.Close()
+	textfile := filepath.Join(t.TempDir(), "seed.prom")
+
+	if err := ExportSeed(stats, pushgateway.URL, textfile); err != nil {
+		t.Fatalf("ExportSeed: %v", err)
+	}
+	if want := "/metrics/job/" + SeedJobName + "/layer/ortho"; method != http.MethodPut || path != want {
+		t.Errorf("pushed with %s %s, want PUT %s", method, path, want)
+	}
+	for _, name := range []string{"wmts_seed_tiles_done", "wmts_seed_tiles_per_second", "wmts_seed_empty_tile_ratio"} {
+		if !bytes.Contains(body, []byte(name)) {
+			t.Errorf("pushed metrics without %s", name)
+		}
+	}
+	data, err := os.ReadFile(textfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`wmts_seed_tiles_done{layer="ortho"} 10`,
+		`wmts_seed_tiles_per_second{layer="ortho"} 3`,
+		`wmts_seed_empty_tile_ratio{layer="ortho"} 0.5`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("textfile without %s:\n%s", line, data)
+		}
+	}
+}
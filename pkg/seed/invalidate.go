@@ -0,0 +1,189 @@
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// InvalidateMode tells what to do with the cached tiles of a changed area
+type InvalidateMode string
+
+const (
+	// InvalidateDelete removes the tiles from the cache, they will be fetched again on the next request
+	InvalidateDelete InvalidateMode = "delete"
+	// InvalidateStale keeps the tiles but marks them as stale, see wmts.StaleModTime
+	InvalidateStale InvalidateMode = "stale"
+)
+
+// MaxInvalidateTiles limits the tiles of the area of Manager.Invalidate, which answers the admin request once done.
+// The larger areas are invalidated with the invalidateWmtsTiles command.
+const MaxInvalidateTiles = 200_000
+
+// ErrTooManyTiles is returned by Manager.Invalidate for an area of more than MaxInvalidateTiles tiles
+var ErrTooManyTiles = errors.New("too many tiles to invalidate")
+
+// InvalidateRequest describes the area whose tiles must be expired, given by a bbox or a GeoJSON geometry
+type InvalidateRequest struct {
+	Layer    string          `json:"layer"`
+	BBox     []float64       `json:"bbox,omitempty"`     // XMin, YMin, XMax, YMax in the grid spatial reference
+	Geometry json.RawMessage `json:"geometry,omitempty"` // GeoJSON geometry, Feature or FeatureCollection
+	MinZoom  *int            `json:"min_zoom,omitempty"` // default is the grid min zoom
+	MaxZoom  *int            `json:"max_zoom,omitempty"` // default is the grid max zoom
+	Mode     InvalidateMode  `json:"mode,omitempty"`     // delete (default) or stale
+	Reseed   bool            `json:"reseed,omitempty"`   // start a seed job on the bbox of the area once the tiles are invalidated, the whole bbox of a geometry is seeded
+}
+
+// InvalidateResult gives the number of tiles affected by an invalidation
+type InvalidateResult struct {
+	Layer            string         `json:"layer"`
+	Mode             InvalidateMode `json:"mode"`
+	BBox             []float64      `json:"bbox"`
+	TilesInArea      int64          `json:"tiles_in_area"`
+	TilesInvalidated int64          `json:"tiles_invalidated"` // tiles of the area that were in the cache
+	PerZoom          map[int]int64  `json:"per_zoom"`
+	ReseedJob        *JobStatus     `json:"reseed_job,omitempty"`
+}
+
+// Area is the part of a grid touched by a change
+type Area struct {
+	BBox     wmts.BBox
+	Geometry *wmts.Geometry // nil when the area is the whole bbox
+}
+
+// NewArea builds the Area from a bbox array or a GeoJSON geometry, one of them must be given
+func NewArea(bbox []float64, geometry json.RawMessage) (Area, error) {
+	switch {
+	case len(geometry) > 0 && len(bbox) > 0:
+		return Area{}, fmt.Errorf("give either a bbox or a geometry, not both")
+	case len(geometry) > 0:
+		g, err := wmts.ParseGeoJSON(geometry)
+		if err != nil {
+			return Area{}, err
+		}
+		return Area{BBox: g.BBox(), Geometry: g}, nil
+	case len(bbox) > 0:
+		b, err := wmts.NewBBoxFromArray(bbox)
+		if err != nil {
+			return Area{}, fmt.Errorf("invalid bbox: %w", err)
+		}
+		return Area{BBox: *b}, nil
+	}
+	return Area{}, fmt.Errorf("a bbox or a geometry is required")
+}
+
+// Invalidate deletes or marks as stale every cached tile of the layer touching the area, for each zoom in [minZoom, maxZoom].
+// When the area has a geometry only the tiles whose bbox intersects it are invalidated.
+func Invalidate(ctx context.Context, grid *wmts.Grid, lc wmts.LayerConfig, store *wmts.FileStore, area Area, minZoom, maxZoom int, mode InvalidateMode) (InvalidateResult, error) {
+	result := InvalidateResult{
+		Layer:   lc.Name,
+		Mode:    mode,
+		BBox:    area.BBox.ToArray(),
+		PerZoom: make(map[int]int64),
+	}
+	invalidate := store.Delete
+	switch mode {
+	case InvalidateDelete, "":
+		result.Mode = InvalidateDelete
+	case InvalidateStale:
+		invalidate = store.MarkStale
+	default:
+		return result, fmt.Errorf("unknown invalidation mode %q, use %s or %s", mode, InvalidateDelete, InvalidateStale)
+	}
+	if err := checkZoomRange(grid, minZoom, maxZoom); err != nil {
+		return result, err
+	}
+	gridBBox := grid.GetBBox()
+	if !gridBBox.Intersects(area.BBox) {
+		return result, fmt.Errorf("%w: area [%s]", wmts.ErrOutsideGridExtent, area.BBox.String())
+	}
+	for z := minZoom; z <= maxZoom; z++ {
+		tiles, err := grid.TileRange(area.BBox, z)
+		if err != nil {
+			return result, err
+		}
+		for row := tiles.MinRow; row <= tiles.MaxRow; row++ {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			for col := tiles.MinCol; col <= tiles.MaxCol; col++ {
+				if area.Geometry != nil {
+					tileBBox, err := grid.GetTileBBox(z, col, row)
+					if err != nil || !area.Geometry.IntersectsBBox(*tileBBox) {
+						continue
+					}
+				}
+				result.TilesInArea++
+				done, err := invalidate(lc, z, row, col)
+				if err != nil {
+					return result, err
+				}
+				if done {
+					result.TilesInvalidated++
+					result.PerZoom[z]++
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// checkZoomRange returns an error when [minZoom, maxZoom] is not a range of zoom levels of grid
+func checkZoomRange(grid *wmts.Grid, minZoom, maxZoom int) error {
+	if minZoom < grid.MinZoom() || maxZoom > grid.MaxZoom() || minZoom > maxZoom {
+		return fmt.Errorf("%w: invalid zoom range [%d, %d], grid supports [%d, %d]", wmts.ErrZoomOutOfRange, minZoom, maxZoom, grid.MinZoom(), grid.MaxZoom())
+	}
+	return nil
+}
+
+// Invalidate expires the tiles described by the request using the current configuration,
+// and starts a seed job on the bbox of the area if req.Reseed is true. It is refused with ErrTooManyTiles
+// when the bbox of the area has more than MaxInvalidateTiles tiles in the zoom levels.
+func (m *Manager) Invalidate(ctx context.Context, req InvalidateRequest) (InvalidateResult, error) {
+	state := m.registry.Current()
+	layer, grid, exists := state.Layer(req.Layer)
+	if !exists {
+		return InvalidateResult{}, fmt.Errorf("unknown layer %q", req.Layer)
+	}
+	area, err := NewArea(req.BBox, req.Geometry)
+	if err != nil {
+		return InvalidateResult{}, err
+	}
+	minZoom, maxZoom := grid.MinZoom(), grid.MaxZoom()
+	if req.MinZoom != nil {
+		minZoom = *req.MinZoom
+	}
+	if req.MaxZoom != nil {
+		maxZoom = *req.MaxZoom
+	}
+	if err := checkZoomRange(grid, minZoom, maxZoom); err != nil {
+		return InvalidateResult{}, err
+	}
+	var total int
+	for z := minZoom; z <= maxZoom; z++ {
+		tiles, err := grid.TileRange(area.BBox, z)
+		if err != nil {
+			return InvalidateResult{}, err
+		}
+		if total += tiles.Count(); total > MaxInvalidateTiles {
+			return InvalidateResult{}, fmt.Errorf("%w: the area has more than %d tiles in the zoom levels [%d, %d], use the invalidateWmtsTiles command", ErrTooManyTiles, MaxInvalidateTiles, minZoom, maxZoom)
+		}
+	}
+	result, err := Invalidate(ctx, grid, layer, wmts.NewFileStore(state.BasePath()), area, minZoom, maxZoom, req.Mode)
+	if err != nil {
+		return result, err
+	}
+	m.l.Info("🧹 layer %s, area [%s], zoom [%d, %d]: %d tiles invalidated (%s)", req.Layer, area.BBox.String(), minZoom, maxZoom, result.TilesInvalidated, result.Mode)
+	if req.Reseed {
+		job, err := m.Start(Request{Layer: req.Layer, MinZoom: minZoom, MaxZoom: maxZoom, BBox: area.BBox.ToArray()})
+		if err != nil {
+			return result, fmt.Errorf("tiles invalidated but reseed failed: %w", err)
+		}
+		status := job.Status()
+		result.ReseedJob = &status
+	}
+	return result, nil
+}
@@ -0,0 +1,153 @@
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+func TestInvalidate(t *testing.T) {
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	grid := wmts.NewLausanneGrid("", "", l)
+	lc := wmts.LayerConfig{
+		LayerDefaultValues: wmts.LayerDefaultValues{
+			WMTSURLPrefix:     "tiles/1.0.0",
+			WMTSURLStyle:      "default",
+			WMTSDimensionYear: "2025",
+			WMTSMatrixSet:     wmts.LausanneMatrixSet,
+		},
+		Name: "ortho",
+	}
+	center := func(row, col int) [2]float64 {
+		b, err := grid.GetTileBBox(0, col, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return [2]float64{(b.XMin + b.XMax) / 2, (b.YMin + b.YMax) / 2}
+	}
+	// the geometries touch the tiles of zoom 0 through their centers
+	geometry := func(geoJSONType string, points ...[2]float64) json.RawMessage {
+		coordinates, _ := json.Marshal(points)
+		return json.RawMessage(fmt.Sprintf(`{"type":"Feature","geometry":{"type":%q,"coordinates":%s}}`, geoJSONType, coordinates))
+	}
+	c32, c33, c43 := center(3, 2), center(3, 3), center(4, 3)
+	tests := []struct {
+		name            string
+		bbox            []float64
+		geometry        json.RawMessage
+		mode            InvalidateMode
+		wantInArea      int64
+		wantInvalidated int64
+		wantRemaining   int // tiles still in the cache after the invalidation
+	}{
+		{"bbox in a tile", []float64{c32[0] - 1, c32[1] - 1, c32[0] + 1, c32[1] + 1}, nil, InvalidateDelete, 1, 1, 2},
+		{"line across 2 tiles", nil, geometry("LineString", c32, c33), InvalidateDelete, 2, 2, 1},
+		{"points at the corners of the bbox of 4 tiles", nil, geometry("MultiPoint", c32, c43), InvalidateDelete, 2, 1, 2},
+		{"stale line across 2 tiles", nil, geometry("LineString", c32, c33), InvalidateStale, 2, 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := wmts.NewFileStore(t.TempDir())
+			// the cached tiles of zoom 0, (4, 3) is not cached
+			cached := [][2]int{{3, 2}, {3, 3}, {4, 2}}
+			for _, tile := range cached {
+				if err := store.Write(lc, 0, tile[0], tile[1], []byte("tile")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			area, err := NewArea(tt.bbox, tt.geometry)
+			if err != nil {
+				t.Fatalf("NewArea: %v", err)
+			}
+			result, err := Invalidate(context.Background(), grid, lc, store, area, 0, 0, tt.mode)
+			if err != nil {
+				t.Fatalf("Invalidate: %v", err)
+			}
+			if result.TilesInArea != tt.wantInArea || result.TilesInvalidated != tt.wantInvalidated || result.PerZoom[0] != tt.wantInvalidated {
+				t.Errorf("Invalidate() = %d tiles in area, %d invalidated (%v), want %d, %d", result.TilesInArea, result.TilesInvalidated, result.PerZoom, tt.wantInArea, tt.wantInvalidated)
+			}
+			remaining, stale := 0, 0
+			for _, tile := range cached {
+				info, err := store.Stat(lc, 0, tile[0], tile[1])
+				if err != nil {
+					continue
+				}
+				remaining++
+				if wmts.IsStaleModTime(info.ModTime()) {
+					stale++
+				}
+			}
+			wantStale := 0
+			if tt.mode == InvalidateStale {
+				wantStale = int(tt.wantInvalidated)
+			}
+			if remaining != tt.wantRemaining || stale != wantStale {
+				t.Errorf("%d tiles left in the cache, %d of them stale, want %d and %d", remaining, stale, tt.wantRemaining, wantStale)
+			}
+		})
+	}
+
+	store := wmts.NewFileStore(t.TempDir())
+	area := Area{BBox: wmts.LausanneGridBBox}
+	if _, err := Invalidate(context.Background(), grid, lc, store, area, 0, 0, "expire"); err == nil {
+		t.Error("Invalidate() accepted an unknown mode")
+	}
+	if _, err := Invalidate(context.Background(), grid, lc, store, area, 2, 1, InvalidateDelete); !errors.Is(err, wmts.ErrZoomOutOfRange) {
+		t.Errorf("Invalidate() with an inverted zoom range = %v, want ErrZoomOutOfRange", err)
+	}
+	outside := Area{BBox: wmts.BBox{XMin: 0, YMin: 0, XMax: 1, YMax: 1}}
+	if _, err := Invalidate(context.Background(), grid, lc, store, outside, 0, 0, InvalidateDelete); !errors.Is(err, wmts.ErrOutsideGridExtent) {
+		t.Errorf("Invalidate() outside the grid = %v, want ErrOutsideGridExtent", err)
+	}
+}
+
+func TestManagerInvalidateTooManyTiles(t *testing.T) {
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := fmt.Sprintf(`caches:
+    local:
+        cache_type: filesystem
+        folder: %s
+layers:
+    plan_ville:
+        wms_backend_url: https://example.org/wms
+        wms_layers: plan_ville
+        wmts_bbox: [2532500, 1149000, 2545625, 1161000]
+        wmts_url_style: default
+        wmts_matrix_set: swissgrid_05
+        image_extension: png
+`, t.TempDir())
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := wmts.NewLayerRegistry(configPath, wmts.SettingsFlags{}, wmts.Settings{BufferSize: 50, ClientTimeoutSec: 10, NumWorkers: 1}, l)
+	if err != nil {
+		t.Fatalf("NewLayerRegistry: %v", err)
+	}
+	m := NewManager(context.Background(), registry, nil, 1, time.Hour, l)
+	bbox := []float64{2532500, 1149000, 2545625, 1161000}
+	_, grid, _ := registry.Current().Layer("plan_ville")
+	if _, err := m.Invalidate(context.Background(), InvalidateRequest{Layer: "plan_ville", BBox: bbox}); !errors.Is(err, ErrTooManyTiles) {
+		t.Errorf("Invalidate() of zoom levels [%d, %d] = %v, want ErrTooManyTiles", grid.MinZoom(), grid.MaxZoom(), err)
+	}
+	minZoom, maxZoom := 0, 2
+	result, err := m.Invalidate(context.Background(), InvalidateRequest{Layer: "plan_ville", BBox: bbox, MinZoom: &minZoom, MaxZoom: &maxZoom})
+	if err != nil || result.TilesInArea == 0 || result.TilesInvalidated != 0 {
+		t.Errorf("Invalidate() of zoom levels [0, 2] = %+v, %v, want tiles in the area and none in the empty cache", result, err)
+	}
+}
@@ -0,0 +1,312 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+	"github.com/rs/xid"
+)
+
+// JobState is the state of a seeding job
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Default limits of the Manager jobs
+const (
+	DefaultMaxJobs      = 2              // seeding jobs running at the same time
+	DefaultJobRetention = 24 * time.Hour // time the finished jobs stay listed
+)
+
+// ErrTooManyJobs is returned by Manager.Start when the maximum number of jobs are already running
+var ErrTooManyJobs = errors.New("too many seed jobs running")
+
+// Request describes the tiles to seed
+type Request struct {
+	Layer        string    `json:"layer"`
+	MinZoom      int       `json:"min_zoom"`
+	MaxZoom      int       `json:"max_zoom"`
+	BBox         []float64 `json:"bbox,omitempty"` // XMin, YMin, XMax, YMax, the layer wmts_bbox is used when empty
+	MetaTileSize int       `json:"meta_tile_size,omitempty"`
+}
+
+// JobStatus is a snapshot of a seeding job, suitable for a JSON response
+type JobStatus struct {
+	ID          string     `json:"id"`
+	Request     Request    `json:"request"`
+	State       JobState   `json:"state"`
+	CurrentZoom int        `json:"current_zoom"`
+	TilesTotal  int64      `json:"tiles_total"`
+	TilesDone   int64      `json:"tiles_done"`
+	TilesFailed int64      `json:"tiles_failed"`
+	TilesEmpty  int64      `json:"tiles_empty"`
+	Paused      bool       `json:"paused"` // waiting for the seeding time window of the backend
+	Errors      int64      `json:"errors"`
+	LastError   string     `json:"last_error,omitempty"`
+	TilesPerSec float64    `json:"tiles_per_sec"`
+	ETASeconds  float64    `json:"eta_seconds"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+}
+
+// Job is a seeding running in background
+type Job struct {
+	id        string
+	request   Request
+	progress  Progress
+	cancel    context.CancelFunc
+	startedAt time.Time
+	mu        sync.Mutex
+	state     JobState
+	endedAt   time.Time
+	err       error
+}
+
+// ID returns the identifier of the job
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Status returns a snapshot of the job progress
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := JobStatus{
+		ID:          j.id,
+		Request:     j.request,
+		State:       j.state,
+		CurrentZoom: int(j.progress.CurrentZoom.Load()),
+		TilesTotal:  j.progress.TilesTotal.Load(),
+		TilesDone:   j.progress.TilesDone.Load(),
+		TilesFailed: j.progress.TilesFailed.Load(),
+		TilesEmpty:  j.progress.TilesEmpty.Load(),
+		Paused:      j.progress.Paused.Load() > 0,
+		Errors:      j.progress.Errors.Load(),
+		LastError:   j.progress.LastError(),
+		StartedAt:   j.startedAt,
+	}
+	end := time.Now()
+	if j.state != JobRunning {
+		end = j.endedAt
+		status.EndedAt = &j.endedAt
+		if j.err != nil {
+			status.LastError = j.err.Error()
+		}
+	}
+	processed := status.TilesDone + status.TilesFailed
+	if elapsed := end.Sub(j.startedAt).Seconds(); elapsed > 0 && processed > 0 {
+		status.TilesPerSec = float64(processed) / elapsed
+		if j.state == JobRunning {
+			status.ETASeconds = float64(status.TilesTotal-processed) / status.TilesPerSec
+		}
+	}
+	return status
+}
+
+// Cancel asks the job to stop, the requests of the meta-tiles being fetched are aborted
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// endedBefore returns true when the job is finished since before t
+func (j *Job) endedBefore(t time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state != JobRunning && j.endedAt.Before(t)
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.endedAt = time.Now()
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.state = JobCancelled
+	case err != nil:
+		j.state = JobFailed
+		j.err = err
+	default:
+		j.state = JobCompleted
+	}
+}
+
+// Manager starts seeding jobs on the layers of a LayerRegistry and keeps track of them
+type Manager struct {
+	ctx       context.Context // parent of the jobs contexts
+	registry  *wmts.LayerRegistry
+	client    *http.Client
+	throttle  *Throttle
+	maxJobs   int           // jobs running at the same time, Start refuses more
+	retention time.Duration // the finished jobs are forgotten after this duration
+	l         golog.MyLogger
+	mu        sync.RWMutex
+	jobs      map[string]*Job
+}
+
+// NewManager returns a Manager using the given registry for the layers and client for the WMS requests,
+// with the client timeout of the settings current when a job starts.
+// At most maxJobs jobs run at the same time and the finished ones are kept for retention, see DefaultMaxJobs.
+// The jobs respect the seed limits of the backends configured in backend.Default and are all cancelled when ctx is done.
+func NewManager(ctx context.Context, registry *wmts.LayerRegistry, client *http.Client, maxJobs int, retention time.Duration, l golog.MyLogger) *Manager {
+	if maxJobs <= 0 {
+		maxJobs = DefaultMaxJobs
+	}
+	if retention <= 0 {
+		retention = DefaultJobRetention
+	}
+	return &Manager{
+		ctx:       ctx,
+		registry:  registry,
+		client:    client,
+		throttle:  NewThrottle(backend.Default.SeedLimits, l),
+		maxJobs:   maxJobs,
+		retention: retention,
+		l:         l,
+		jobs:      make(map[string]*Job),
+	}
+}
+
+// prune forgets the jobs finished for longer than the retention, m.mu must be locked
+func (m *Manager) prune() {
+	limit := time.Now().Add(-m.retention)
+	for id, job := range m.jobs {
+		if job.endedBefore(limit) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// add records the job, unless the maximum number of jobs are already running
+func (m *Manager) add(job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+	running := 0
+	for _, other := range m.jobs {
+		if other.Status().State == JobRunning {
+			running++
+		}
+	}
+	if running >= m.maxJobs {
+		return fmt.Errorf("%w: %d jobs, wait for one to finish or cancel it", ErrTooManyJobs, running)
+	}
+	m.jobs[job.id] = job
+	return nil
+}
+
+// Start validates the request and starts a job seeding it in background
+func (m *Manager) Start(req Request) (*Job, error) {
+	state := m.registry.Current()
+	layer, grid, exists := state.Layer(req.Layer)
+	if !exists {
+		return nil, fmt.Errorf("unknown layer %q", req.Layer)
+	}
+	if req.MinZoom < grid.MinZoom() || req.MaxZoom > grid.MaxZoom() || req.MinZoom > req.MaxZoom {
+		return nil, fmt.Errorf("%w: invalid zoom range [%d, %d], grid supports [%d, %d]", wmts.ErrZoomOutOfRange, req.MinZoom, req.MaxZoom, grid.MinZoom(), grid.MaxZoom())
+	}
+	if len(req.BBox) == 0 {
+		req.BBox = layer.WMTSBBox
+	}
+	bbox, err := wmts.NewBBoxFromArray(req.BBox)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bbox: %w", err)
+	}
+	gridBBox := grid.GetBBox()
+	if !gridBBox.Contains(*bbox) {
+		return nil, fmt.Errorf("%w: bbox [%s]", wmts.ErrOutsideGridExtent, bbox)
+	}
+	if req.MetaTileSize <= 0 {
+		req.MetaTileSize = DefaultMetaTileSize
+	}
+	var total int64
+	for z := req.MinZoom; z <= req.MaxZoom; z++ {
+		tiles, err := grid.TileRange(*bbox, z)
+		if err != nil {
+			return nil, err
+		}
+		total += int64(tiles.Count())
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	job := &Job{
+		id:        xid.New().String(),
+		request:   req,
+		cancel:    cancel,
+		startedAt: time.Now(),
+		state:     JobRunning,
+	}
+	job.progress.TilesTotal.Store(total)
+	opts := Options{
+		Grid:         grid,
+		Layer:        layer,
+		BasePath:     state.BasePath(),
+		Client:       tools.ClientWithTimeout(m.client, state.Settings.ClientTimeoutSec),
+		Buffer:       state.Settings.BufferSize,
+		NumWorkers:   state.Settings.NumWorkers,
+		MetaTileSize: req.MetaTileSize,
+		Logger:       m.l,
+		Throttle:     m.throttle,
+		Builder:      state.Builder(req.Layer),
+	}
+	if err := m.add(job); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	m.l.Info("🌱 seed job %s started: layer %s, zoom [%d, %d], bbox [%s], %d tiles", job.id, req.Layer, req.MinZoom, req.MaxZoom, bbox, total)
+	go func() {
+		defer cancel()
+		var err error
+		for z := req.MinZoom; z <= req.MaxZoom && err == nil; z++ {
+			err = ProcessZoomLevel(ctx, z, *bbox, opts, &job.progress)
+		}
+		job.finish(err)
+		status := job.Status()
+		m.l.Info("🌱 seed job %s %s: %d tiles done, %d failed", job.id, status.State, status.TilesDone, status.TilesFailed)
+	}()
+	return job, nil
+}
+
+// Get returns the job with the given id
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List returns the status of all the jobs, the most recent first
+func (m *Manager) List() []JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+	list := make([]JobStatus, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		list = append(list, job.Status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+	return list
+}
+
+// Cancel stops the job with the given id
+func (m *Manager) Cancel(id string) (*Job, bool) {
+	job, ok := m.Get(id)
+	if ok {
+		job.Cancel()
+	}
+	return job, ok
+}
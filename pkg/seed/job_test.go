@@ -0,0 +1,42 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+func TestManagerAdd(t *testing.T) {
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	m := NewManager(context.Background(), nil, nil, 1, time.Hour, l)
+	newJob := func(id string) *Job {
+		return &Job{id: id, cancel: func() {}, startedAt: time.Now(), state: JobRunning}
+	}
+	first := newJob("first")
+	if err := m.add(first); err != nil {
+		t.Fatalf("add() = %v", err)
+	}
+	if err := m.add(newJob("second")); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("add() with a job running = %v, want ErrTooManyJobs", err)
+	}
+	first.finish(nil)
+	if err := m.add(newJob("third")); err != nil {
+		t.Fatalf("add() with the job finished = %v", err)
+	}
+	if _, ok := m.Get("first"); !ok {
+		t.Error("the job finished recently was forgotten")
+	}
+
+	// a job finished for longer than the retention is evicted
+	first.endedAt = time.Now().Add(-2 * time.Hour)
+	if list := m.List(); len(list) != 1 || list[0].ID != "third" {
+		t.Errorf("List() = %v, want only the third job", list)
+	}
+}
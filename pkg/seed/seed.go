@@ -0,0 +1,160 @@
+// Package seed fills the tiles cache of a layer by requesting meta-tiles to the WMS backend with a pool of workers.
+package seed
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	DefaultNumWorkers   = 4
+	DefaultMetaTileSize = 4 // Number of tiles per side in a meta-tile (e.g., 2 for a 2x2 meta-tile)
+)
+
+// Options holds everything the workers need to fetch and save the meta-tiles of a layer
+type Options struct {
+	Grid         *wmts.Grid
+	Layer        wmts.LayerConfig
+	BasePath     string
+	Client       *http.Client
+	Buffer       int
+	NumWorkers   int
+	MetaTileSize int
+	Verbose      bool
+	Logger       golog.MyLogger
+	Throttle     *Throttle        // limits the load on the WMS backend, nil for no limit
+	Builder      wmts.TileBuilder // the builder of the tiles of a layer not cut from WMS meta-tiles, see wmts.LayersState.Builder
+}
+
+// Progress holds the counters of a seeding, it is safe for concurrent use
+type Progress struct {
+	TilesTotal  atomic.Int64
+	TilesDone   atomic.Int64
+	TilesFailed atomic.Int64
+	TilesEmpty  atomic.Int64 // saved tiles detected as empty, see wmts.LayerConfig.IsEmptyTile
+	Errors      atomic.Int64 // number of failed meta-tile requests
+	CurrentZoom atomic.Int64
+	Paused      atomic.Int32 // workers waiting for the seeding time window of their backend
+	lastError   atomic.Value
+	// OnTiles is called (if not nil) each time a meta-tile is processed with the number of tiles it contained
+	OnTiles func(numTiles int)
+}
+
+// LastError returns the message of the last error encountered, or an empty string
+func (p *Progress) LastError() string {
+	if v, ok := p.lastError.Load().(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (p *Progress) setLastError(err error) {
+	p.lastError.Store(err.Error())
+}
+
+// ProcessZoomLevel saves all the tiles covering the bbox at the given zoom level.
+// The work is split in meta-tiles fetched by opts.NumWorkers goroutines, a failed meta-tile
+// is counted in progress and does not stop the others. When ctx is cancelled the running WMS requests
+// are aborted, no new meta-tile is started and the context error is returned. The tiles of the
+// aborted meta-tiles are neither counted as done nor as failed.
+func ProcessZoomLevel(ctx context.Context, zoomLevel int, bbox wmts.BBox, opts Options, progress *Progress) error {
+	l := opts.Logger
+	metaTileSize := opts.MetaTileSize
+	if metaTileSize <= 0 {
+		metaTileSize = DefaultMetaTileSize
+	}
+	numWorkers := opts.NumWorkers
+	if numWorkers <= 0 {
+		numWorkers = DefaultNumWorkers
+	}
+	tiles, err := opts.Grid.TileRange(bbox, zoomLevel)
+	if err != nil {
+		return err
+	}
+	l.Info("ℹ️ tiles to save: %s", tiles)
+	progress.CurrentZoom.Store(int64(zoomLevel))
+
+	ctx, span := tracing.Start(ctx, "seed.ProcessZoomLevel", trace.WithAttributes(
+		attribute.String("layer", opts.Layer.Name),
+		attribute.Int("zoom", zoomLevel),
+	))
+	defer span.End()
+	client := opts.Client
+	if opts.Throttle != nil {
+		client = opts.Throttle.Client(client)
+	}
+
+	// Create a channel for tasks. The channel holds the meta-tiles to save.
+	tasks := make(chan wmts.MetaTile, numWorkers*2)
+	var wg sync.WaitGroup
+
+	// Start a worker pool. Each worker processes a meta-tile.
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for task := range tasks {
+				if opts.Throttle != nil {
+					if err := opts.Throttle.wait(ctx, opts.Layer.BackendURL(), progress); err != nil {
+						// ctx is cancelled, the remaining tasks are skipped
+						continue
+					}
+				}
+				var numEmpty int
+				var err error
+				if opts.Builder != nil {
+					// the tiles are built one by one, there is no meta-tile request
+					numEmpty, err = opts.Builder.SaveTiles(ctx, client, task.Zoom, task.StartCol, task.StartRow, task.NumCols, task.NumRows, opts.Layer, opts.BasePath)
+				} else {
+					numEmpty, err = opts.Grid.SaveTilesFromMetaTile(ctx, task.Zoom, task.StartCol, task.StartRow, task.NumCols, task.NumRows, opts.Buffer, opts.Layer, opts.BasePath, client)
+				}
+				if err != nil && ctx.Err() != nil {
+					// aborted, the meta-tile will be fetched again by a next seeding
+					continue
+				}
+				if err != nil {
+					l.Error("💥 Worker %d: saving tiles of zoom:%d, meta-tile at (row:%d, col:%d) failed: %v", workerID, task.Zoom, task.StartRow, task.StartCol, err)
+					progress.Errors.Add(1)
+					progress.TilesFailed.Add(int64(task.Count()))
+					progress.setLastError(err)
+				} else {
+					if opts.Verbose {
+						l.Info("ℹ️ Worker %d: zoom:%d, meta-tile at (row:%d, col:%d) saved", workerID, task.Zoom, task.StartRow, task.StartCol)
+					}
+					progress.TilesDone.Add(int64(task.Count()))
+					progress.TilesEmpty.Add(int64(numEmpty))
+				}
+				if progress.OnTiles != nil {
+					progress.OnTiles(task.Count())
+				}
+			}
+		}(i)
+	}
+
+	// Enqueue meta-tile tasks
+enqueue:
+	for task := range tiles.MetaTiles(metaTileSize) {
+		select {
+		case <-ctx.Done():
+			break enqueue
+		case tasks <- task:
+		}
+	}
+
+	// Close the tasks channel and wait for workers to finish
+	close(tasks)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.Info("ℹ️ Zoom %d processed", zoomLevel)
+	return nil
+}
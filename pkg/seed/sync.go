@@ -0,0 +1,301 @@
+package seed
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// SyncOptions selects the tiles of a layer copied from a cache to another one, see Sync
+type SyncOptions struct {
+	Grid       *wmts.Grid
+	Layer      wmts.LayerConfig
+	Source     *wmts.FileStore
+	Dest       SyncDest
+	BBox       wmts.BBox // in the grid spatial reference
+	MinZoom    int
+	MaxZoom    int
+	NumWorkers int
+	SizeOnly   bool        // the tiles of the same size are not hashed and taken as unchanged
+	DryRun     bool        // compare the tiles without copying them
+	Checkpoint *Checkpoint // the rows synchronised by a previous run are skipped, nil to compare everything
+	Logger     golog.MyLogger
+}
+
+// SyncResult holds the counters of a synchronisation, it is safe for concurrent use
+type SyncResult struct {
+	Compared    atomic.Int64 // tiles of the source compared with the destination
+	Missing     atomic.Int64 // tiles not in the destination, copied
+	Changed     atomic.Int64 // tiles of another size or content in the destination, copied
+	Unchanged   atomic.Int64
+	Bytes       atomic.Int64 // size of the copied tiles
+	RowsSkipped atomic.Int64 // rows already synchronised by a previous run
+}
+
+// syncRow is the unit of work of Sync, the tiles of a row of a zoom level
+type syncRow struct {
+	tiles wmts.TileRange
+	row   int
+}
+
+// Sync copies to opts.Dest the tiles of the layer cached in opts.Source that are missing or different in opts.Dest,
+// within the bbox and the zoom levels of opts. A tile of the same size is compared by a hash of its content, see SyncDest.
+// Only the cached tiles of the source are visited, the rows of a zoom level are shared by opts.NumWorkers goroutines.
+// The copied tiles keep their modification time, so the stale ones stay stale, see wmts.StaleModTime.
+// The rows done are recorded in opts.Checkpoint, the first error stops the synchronisation.
+func Sync(ctx context.Context, opts SyncOptions, result *SyncResult) error {
+	if opts.MinZoom < opts.Grid.MinZoom() || opts.MaxZoom > opts.Grid.MaxZoom() || opts.MinZoom > opts.MaxZoom {
+		return fmt.Errorf("%w: invalid zoom range [%d, %d], grid supports [%d, %d]", wmts.ErrZoomOutOfRange, opts.MinZoom, opts.MaxZoom, opts.Grid.MinZoom(), opts.Grid.MaxZoom())
+	}
+	numWorkers := opts.NumWorkers
+	if numWorkers <= 0 {
+		numWorkers = DefaultNumWorkers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	rows := make(chan syncRow)
+	for range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rows {
+				err := syncTileRow(ctx, opts, r, result)
+				if err == nil && opts.Checkpoint != nil && !opts.DryRun {
+					err = opts.Checkpoint.Mark(opts.Layer.Name, r.tiles, r.row)
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("zoom:%d, row:%d: %w", r.tiles.Zoom, r.row, err)
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	err := enqueueSyncRows(ctx, opts, rows, result)
+	close(rows)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// enqueueSyncRows sends the rows of the source having tiles in the bbox, zoom level after zoom level
+func enqueueSyncRows(ctx context.Context, opts SyncOptions, rows chan<- syncRow, result *SyncResult) error {
+	for zoom := opts.MinZoom; zoom <= opts.MaxZoom; zoom++ {
+		tiles, err := opts.Grid.TileRange(opts.BBox, zoom)
+		if err != nil {
+			return err
+		}
+		if tiles.IsEmpty() {
+			continue
+		}
+		cached, err := opts.Source.Rows(opts.Layer, zoom, tiles.MinRow, tiles.MaxRow)
+		if err != nil {
+			return err
+		}
+		opts.Logger.Debug("zoom %d: %d rows of %s in the source", zoom, len(cached), tiles)
+		for _, row := range cached {
+			if opts.Checkpoint != nil && opts.Checkpoint.Done(opts.Layer.Name, tiles, row) {
+				result.RowsSkipped.Add(1)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case rows <- syncRow{tiles: tiles, row: row}:
+			}
+		}
+	}
+	return nil
+}
+
+// syncTileRow copies the tiles of the row that are missing or different in the destination
+func syncTileRow(ctx context.Context, opts SyncOptions, r syncRow, result *SyncResult) error {
+	lc, zoom, row := opts.Layer, r.tiles.Zoom, r.row
+	cols, err := opts.Source.Cols(lc, zoom, row, r.tiles.MinCol, r.tiles.MaxCol)
+	if err != nil {
+		return err
+	}
+	for _, col := range cols {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		srcPath := opts.Source.TilePath(lc, zoom, row, col)
+		srcInfo, err := os.Stat(srcPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted since the listing of the row
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		result.Compared.Add(1)
+		// the source is only read to be hashed or copied
+		var data []byte
+		readSource := func() (err error) {
+			if data == nil {
+				data, err = os.ReadFile(srcPath)
+			}
+			return err
+		}
+		destTile, err := opts.Dest.Stat(ctx, lc, zoom, row, col)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			result.Missing.Add(1)
+		case err != nil:
+			return err
+		case destTile.Size != srcInfo.Size():
+			result.Changed.Add(1)
+		case opts.SizeOnly:
+			result.Unchanged.Add(1)
+			continue
+		default:
+			if err := readSource(); err != nil {
+				return err
+			}
+			same, err := opts.Dest.Same(ctx, lc, zoom, row, col, destTile, data)
+			if err != nil {
+				return err
+			}
+			if same {
+				result.Unchanged.Add(1)
+				continue
+			}
+			result.Changed.Add(1)
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := readSource(); err != nil {
+			return err
+		}
+		if err := opts.Dest.Write(ctx, lc, zoom, row, col, data, srcInfo.ModTime()); err != nil {
+			return err
+		}
+		result.Bytes.Add(int64(len(data)))
+	}
+	return nil
+}
+
+// Checkpoint records the rows synchronised in a file, one line per row, so that an interrupted Sync
+// resumes where it stopped. A row is identified with the columns compared, changing the bbox compares it again.
+// The first line of the file gives the source folder and the destination, a checkpoint is only used between them.
+type Checkpoint struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	done map[string]bool
+}
+
+// OpenCheckpoint reads the rows recorded in the file at path for the synchronisation of the folder source to
+// dest, a folder or the url of a server, it is created when missing. A checkpoint written for other caches is refused.
+func OpenCheckpoint(path, source, dest string) (*Checkpoint, error) {
+	header, err := checkpointHeader(source, dest)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the checkpoint: %w", err)
+	}
+	c := &Checkpoint{path: path, file: file, done: make(map[string]bool)}
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() && scanner.Text() != header {
+		file.Close()
+		return nil, fmt.Errorf("the checkpoint %s is not the one of %s, delete it or give another file", path, header)
+	}
+	for scanner.Scan() {
+		c.done[scanner.Text()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot read the checkpoint: %w", err)
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		_, err = file.WriteString(header + "\n")
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot write the checkpoint: %w", err)
+	}
+	return c, nil
+}
+
+// checkpointHeader returns the first line of the checkpoint of the synchronisation of source to dest,
+// dest being a folder or the url of a server
+func checkpointHeader(source, dest string) (string, error) {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(dest, "://") {
+		if dest, err = filepath.Abs(dest); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("# sync %s to %s", absSource, dest), nil
+}
+
+func checkpointKey(layer string, tiles wmts.TileRange, row int) string {
+	return fmt.Sprintf("%s %d %d %d-%d", layer, tiles.Zoom, row, tiles.MinCol, tiles.MaxCol)
+}
+
+// Len returns the number of rows recorded
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// Done returns true when the columns of tiles of the row were synchronised
+func (c *Checkpoint) Done(layer string, tiles wmts.TileRange, row int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[checkpointKey(layer, tiles, row)]
+}
+
+// Mark records the columns of tiles of the row as synchronised
+func (c *Checkpoint) Mark(layer string, tiles wmts.TileRange, row int) error {
+	key := checkpointKey(layer, tiles, row)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.WriteString(key + "\n"); err != nil {
+		return fmt.Errorf("cannot write the checkpoint: %w", err)
+	}
+	c.done[key] = true
+	return nil
+}
+
+// Close closes the file, keeping the rows for the next run
+func (c *Checkpoint) Close() error {
+	return c.file.Close()
+}
+
+// Remove closes and deletes the file, once the synchronisation completed
+func (c *Checkpoint) Remove() error {
+	c.file.Close()
+	return os.Remove(c.path)
+}
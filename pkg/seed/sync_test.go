@@ -0,0 +1,110 @@
+package seed
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+func TestSync(t *testing.T) {
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	lc := wmts.LayerConfig{
+		LayerDefaultValues: wmts.LayerDefaultValues{
+			WMTSURLPrefix:     "tiles/1.0.0",
+			WMTSURLStyle:      "default",
+			WMTSDimensionYear: "2025",
+			WMTSMatrixSet:     wmts.LausanneMatrixSet,
+		},
+		Name: "ortho",
+	}
+	source, dest := wmts.NewFileStore(t.TempDir()), wmts.NewFileStore(t.TempDir())
+	// zoom 0: 2 tiles in row 3 and 1 in row 4, the destination has a different tile and an identical one
+	for _, tile := range []struct {
+		store    *wmts.FileStore
+		row, col int
+		data     string
+	}{
+		{source, 3, 2, "tile a"},
+		{source, 3, 3, "tile b"},
+		{source, 4, 2, "tile c"},
+		{dest, 3, 2, "tile x"},
+		{dest, 3, 3, "tile b"},
+	} {
+		if err := tile.store.Write(lc, 0, tile.row, tile.col, []byte(tile.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the source tiles were cached a day ago
+	cachedAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(source.TilePath(lc, 0, 4, 2), cachedAt, cachedAt); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := OpenCheckpoint(filepath.Join(t.TempDir(), "checkpoint"), source.BasePath, dest.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid := wmts.NewLausanneGrid("", "", l)
+	opts := SyncOptions{
+		Grid:       grid,
+		Layer:      lc,
+		Source:     source,
+		Dest:       NewFolderDest(dest),
+		BBox:       wmts.LausanneGridBBox,
+		MinZoom:    0,
+		MaxZoom:    1,
+		NumWorkers: 2,
+		Checkpoint: checkpoint,
+		Logger:     l,
+	}
+	result := &SyncResult{}
+	if err := Sync(context.Background(), opts, result); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.Compared.Load() != 3 || result.Missing.Load() != 1 || result.Changed.Load() != 1 || result.Unchanged.Load() != 1 {
+		t.Errorf("Sync() compared %d, missing %d, changed %d, unchanged %d, want 3, 1, 1, 1",
+			result.Compared.Load(), result.Missing.Load(), result.Changed.Load(), result.Unchanged.Load())
+	}
+	for _, tile := range [][2]int{{3, 2}, {4, 2}} {
+		data, err := os.ReadFile(dest.TilePath(lc, 0, tile[0], tile[1]))
+		want, _ := os.ReadFile(source.TilePath(lc, 0, tile[0], tile[1]))
+		if err != nil || string(data) != string(want) {
+			t.Errorf("tile row:%d, col:%d = %q, %v, want %q", tile[0], tile[1], data, err, want)
+		}
+	}
+	if info, err := os.Stat(dest.TilePath(lc, 0, 4, 2)); err != nil {
+		t.Error(err)
+	} else if !info.ModTime().Equal(cachedAt) {
+		t.Errorf("copied tile modified at %v, want %v", info.ModTime(), cachedAt)
+	}
+
+	// a second run with the same checkpoint skips the 2 rows done
+	checkpoint.Close()
+	if _, err := OpenCheckpoint(checkpoint.path, source.BasePath, t.TempDir()); err == nil {
+		t.Error("OpenCheckpoint() accepted the checkpoint of another destination")
+	}
+	if opts.Checkpoint, err = OpenCheckpoint(checkpoint.path, source.BasePath, dest.BasePath); err != nil {
+		t.Fatal(err)
+	}
+	if n := opts.Checkpoint.Len(); n != 2 {
+		t.Errorf("reopened checkpoint has %d rows, want 2", n)
+	}
+	result = &SyncResult{}
+	if err := Sync(context.Background(), opts, result); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.RowsSkipped.Load() != 2 || result.Compared.Load() != 0 {
+		t.Errorf("resumed Sync() skipped %d rows and compared %d tiles, want 2 and 0", result.RowsSkipped.Load(), result.Compared.Load())
+	}
+	if err := opts.Checkpoint.Remove(); err != nil {
+		t.Error(err)
+	}
+}
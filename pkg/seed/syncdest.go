@@ -0,0 +1,147 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// AdminTilesPath is the path of the tiles of the admin API of a server, followed by {layer}/{zoom}/{row}/{col}.
+// A GET or a HEAD answers a cached tile without fetching it from the backend, a PUT caches the png in the body.
+const AdminTilesPath = "/admin/tiles/"
+
+// SyncTile describes a tile cached in a SyncDest
+type SyncTile struct {
+	Size int64
+	ETag string // wmts.TileETag of the content, empty when the destination does not give it
+}
+
+// SyncDest is the cache the tiles of a Sync are copied to
+type SyncDest interface {
+	// Stat returns the tile cached in the destination, the error satisfies errors.Is(err, fs.ErrNotExist) if not cached
+	Stat(ctx context.Context, lc wmts.LayerConfig, zoom, row, col int) (SyncTile, error)
+	// Same returns true when the tile returned by Stat has the content data
+	Same(ctx context.Context, lc wmts.LayerConfig, zoom, row, col int, tile SyncTile, data []byte) (bool, error)
+	// Write caches data as the tile, with the modification time modTime
+	Write(ctx context.Context, lc wmts.LayerConfig, zoom, row, col int, data []byte, modTime time.Time) error
+}
+
+// FolderDest is a SyncDest writing in a cache folder, like the mounted cache of a replica
+type FolderDest struct {
+	Store *wmts.FileStore
+}
+
+// NewFolderDest returns the SyncDest of the cache folder of store
+func NewFolderDest(store *wmts.FileStore) *FolderDest {
+	return &FolderDest{Store: store}
+}
+
+// Stat returns the size of the cached tile
+func (d *FolderDest) Stat(_ context.Context, lc wmts.LayerConfig, zoom, row, col int) (SyncTile, error) {
+	info, err := d.Store.Stat(lc, zoom, row, col)
+	if err != nil {
+		return SyncTile{}, err
+	}
+	return SyncTile{Size: info.Size()}, nil
+}
+
+// Same reads the cached tile and compares its sha256 hash with the one of data
+func (d *FolderDest) Same(_ context.Context, lc wmts.LayerConfig, zoom, row, col int, _ SyncTile, data []byte) (bool, error) {
+	other, err := os.ReadFile(d.Store.TilePath(lc, zoom, row, col))
+	if err != nil {
+		return false, err
+	}
+	return sha256.Sum256(data) == sha256.Sum256(other), nil
+}
+
+// Write writes the tile atomically, see wmts.FileStore.WriteModTime
+func (d *FolderDest) Write(_ context.Context, lc wmts.LayerConfig, zoom, row, col int, data []byte, modTime time.Time) error {
+	return d.Store.WriteModTime(lc, zoom, row, col, data, modTime)
+}
+
+// HTTPDest is a SyncDest sending the tiles to the admin API of a server, see AdminTilesPath.
+// A tile is compared with the size and the ETag answered to a HEAD, so the tiles are not downloaded.
+type HTTPDest struct {
+	BaseURL string // url of the server, like https://replica.example.org:8000
+	Token   string // the ADMIN_TOKEN of the server
+	Client  *http.Client
+}
+
+// NewHTTPDest returns the SyncDest of the server at baseURL, whose admin API is enabled with token
+func NewHTTPDest(baseURL, token string, client *http.Client) *HTTPDest {
+	return &HTTPDest{BaseURL: strings.TrimSuffix(baseURL, "/"), Token: token, Client: client}
+}
+
+// tileURL returns the url of the tile in the admin API
+func (d *HTTPDest) tileURL(lc wmts.LayerConfig, zoom, row, col int) string {
+	return fmt.Sprintf("%s%s%s/%d/%d/%d", d.BaseURL, AdminTilesPath, lc.Name, zoom, row, col)
+}
+
+// do sends the request with the admin token, an answer other than a 2xx is an error
+func (d *HTTPDest) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+d.Token)
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && req.Method == http.MethodHead {
+		return nil, fs.ErrNotExist
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// Stat returns the size and the ETag answered to a HEAD of the tile
+func (d *HTTPDest) Stat(ctx context.Context, lc wmts.LayerConfig, zoom, row, col int) (SyncTile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, d.tileURL(lc, zoom, row, col), nil)
+	if err != nil {
+		return SyncTile{}, err
+	}
+	resp, err := d.do(req)
+	if err != nil {
+		return SyncTile{}, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return SyncTile{}, fmt.Errorf("HEAD %s: no Content-Length", req.URL)
+	}
+	return SyncTile{Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// Same compares the ETag of the tile with the one of data
+func (d *HTTPDest) Same(_ context.Context, _ wmts.LayerConfig, _, _, _ int, tile SyncTile, data []byte) (bool, error) {
+	if tile.ETag == "" {
+		return false, errors.New("the destination did not give the ETag of the tile")
+	}
+	return tile.ETag == wmts.TileETag(data), nil
+}
+
+// Write sends the tile in a PUT, modTime is given as its Last-Modified header
+func (d *HTTPDest) Write(ctx context.Context, lc wmts.LayerConfig, zoom, row, col int, data []byte, modTime time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, d.tileURL(lc, zoom, row, col), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	resp, err := d.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
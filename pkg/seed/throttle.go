@@ -0,0 +1,277 @@
+package seed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"golang.org/x/time/rate"
+)
+
+const (
+	minBackoff   = 500 * time.Millisecond // first delay added between requests when a backend struggles
+	maxBackoff   = time.Minute
+	bandwidthMTU = 32 * 1024 // bytes read at once from a response body limited in bandwidth
+)
+
+// TimeWindow is a daily period in local time, it ends the next day when End is before Start
+type TimeWindow struct {
+	Start time.Duration // offset from midnight
+	End   time.Duration
+}
+
+// ParseTimeWindow parses a window like 19:00-07:00, an empty string means always open
+func ParseTimeWindow(s string) (*TimeWindow, error) {
+	if s == "" {
+		return nil, nil
+	}
+	from, to, found := strings.Cut(s, "-")
+	if !found {
+		return nil, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %w", s, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time window %q: %w", s, err)
+	}
+	return &TimeWindow{Start: start, End: end}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	hours, minutes, found := strings.Cut(strings.TrimSpace(s), ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !found || errH != nil || errM != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Contains returns true when t is inside the window, a window with the same start and end is always open
+func (w TimeWindow) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+	switch {
+	case w.Start == w.End:
+		return true
+	case w.Start < w.End:
+		return offset >= w.Start && offset < w.End
+	default:
+		return offset >= w.Start || offset < w.End
+	}
+}
+
+// NextOpen returns t when it is inside the window, otherwise the next start of the window
+func (w TimeWindow) NextOpen(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	year, month, day := t.Date()
+	next := time.Date(year, month, day, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	if !next.After(t) {
+		next = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	}
+	return next
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	year, month, day := t.Date()
+	return t.Sub(time.Date(year, month, day, 0, 0, 0, 0, t.Location()))
+}
+
+// Throttle keeps the seeding polite with each WMS backend host: it limits the requests per second
+// and the bandwidth, pauses outside the time window and adds a growing delay between the requests
+// while the backend answers slowly or with 5xx errors. The limits are read from the backend settings.
+type Throttle struct {
+	limits func(host string) backend.SeedLimits
+	l      golog.MyLogger
+	mu     sync.Mutex
+	hosts  map[string]*hostThrottle
+}
+
+type hostThrottle struct {
+	limits    backend.SeedLimits
+	window    *TimeWindow
+	requests  *rate.Limiter // nil when the requests per second are not limited
+	bandwidth *rate.Limiter // nil when the bandwidth is not limited, in bytes
+	paused    atomic.Int32  // workers of all the jobs waiting for the window of this host
+	mu        sync.Mutex
+	delay     time.Duration // adaptive delay before each request
+}
+
+// NewThrottle returns a Throttle using the limits of the backend hosts returned by limits,
+// they are read again for every request so a config reload is taken into account
+func NewThrottle(limits func(host string) backend.SeedLimits, l golog.MyLogger) *Throttle {
+	return &Throttle{limits: limits, l: l, hosts: make(map[string]*hostThrottle)}
+}
+
+// host returns the state of the host, it is reset when its limits changed
+func (t *Throttle) host(host string) *hostThrottle {
+	limits := t.limits(host)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[host]
+	if ok && h.limits == limits {
+		return h
+	}
+	h = &hostThrottle{limits: limits}
+	window, err := ParseTimeWindow(limits.Window)
+	if err != nil {
+		// the config schema rejects invalid windows, this only protects from a programming error
+		t.l.Error("💥 backend %s: %v, seeding not restricted in time", host, err)
+	}
+	h.window = window
+	if limits.MaxRPS > 0 {
+		h.requests = rate.NewLimiter(rate.Limit(limits.MaxRPS), 1)
+	}
+	if limits.MaxKBps > 0 {
+		bytesPerSec := limits.MaxKBps * 1024
+		h.bandwidth = rate.NewLimiter(rate.Limit(bytesPerSec), max(bytesPerSec, bandwidthMTU))
+	}
+	t.hosts[host] = h
+	return h
+}
+
+// wait blocks until a request to the host of rawURL is allowed, progress.Paused counts the workers waiting for the window.
+// The pause is tracked by host, so the jobs seeding other backends are not reported as paused.
+func (t *Throttle) wait(ctx context.Context, rawURL string, progress *Progress) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	host := hostOf(rawURL)
+	h := t.host(host)
+	if h.window != nil {
+		if now := time.Now(); !h.window.Contains(now) {
+			resume := h.window.NextOpen(now)
+			// every worker of the host waits, only the first one logs it and the last one the resume
+			progress.Paused.Add(1)
+			if h.paused.Add(1) == 1 {
+				t.l.Warn("⏸️ seeding of backend %s paused until %s (window %s)", host, resume.Format(time.DateTime), h.limits.Window)
+			}
+			err := sleep(ctx, time.Until(resume))
+			if h.paused.Add(-1) == 0 && err == nil {
+				t.l.Info("▶️ seeding of backend %s resumed", host)
+			}
+			progress.Paused.Add(-1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	h.mu.Lock()
+	delay := h.delay
+	h.mu.Unlock()
+	if err := sleep(ctx, delay); err != nil {
+		return err
+	}
+	if h.requests != nil {
+		return h.requests.Wait(ctx)
+	}
+	return nil
+}
+
+// observe adapts the delay of the host to the outcome of a request
+func (t *Throttle) observe(host string, h *hostThrottle, latency time.Duration, failed bool) {
+	slow := h.limits.SlowLatencyMs > 0 && latency > time.Duration(h.limits.SlowLatencyMs)*time.Millisecond
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if failed || slow {
+		h.delay = min(max(2*h.delay, minBackoff), maxBackoff)
+		t.l.Warn("backend %s struggling (latency %s, failed %v), seeding delay raised to %s", host, latency.Round(time.Millisecond), failed, h.delay)
+		return
+	}
+	if h.delay > 0 {
+		// recover slowly, a few good responses are needed to get back to full speed
+		h.delay = h.delay * 3 / 4
+		if h.delay < minBackoff/10 {
+			h.delay = 0
+		}
+	}
+}
+
+// Client returns a copy of client whose responses are observed for the adaptive delay and limited in bandwidth
+func (t *Throttle) Client(client *http.Client) *http.Client {
+	throttled := *client
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	throttled.Transport = &throttleTransport{throttle: t, next: next}
+	return &throttled
+}
+
+type throttleTransport struct {
+	throttle *Throttle
+	next     http.RoundTripper
+}
+
+func (tt *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := tt.throttle.host(req.URL.Host)
+	start := time.Now()
+	resp, err := tt.next.RoundTrip(req)
+	if err != nil {
+		if req.Context().Err() == nil {
+			tt.throttle.observe(req.URL.Host, h, time.Since(start), true)
+		}
+		return nil, err
+	}
+	tt.throttle.observe(req.URL.Host, h, time.Since(start), resp.StatusCode >= http.StatusInternalServerError)
+	if h.bandwidth != nil {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, ctx: req.Context(), limiter: h.bandwidth}
+	}
+	return resp, nil
+}
+
+// limitedBody reads a response body no faster than its limiter allows
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > b.limiter.Burst() {
+		p = p[:b.limiter.Burst()]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.WaitN(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+func TestTimeWindow(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2025, time.March, 10, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window   string
+		at       time.Time
+		contains bool
+		nextOpen time.Time
+	}{
+		{"08:00-17:00", day(12, 0), true, day(12, 0)},
+		{"08:00-17:00", day(17, 0), false, day(8, 0).AddDate(0, 0, 1)},
+		{"08:00-17:00", day(7, 59), false, day(8, 0)},
+		{"19:00-07:00", day(23, 30), true, day(23, 30)},
+		{"19:00-07:00", day(6, 59), true, day(6, 59)},
+		{"19:00-07:00", day(12, 0), false, day(19, 0)},
+		{"00:00-00:00", day(12, 0), true, day(12, 0)},
+	}
+	for _, tt := range tests {
+		w, err := ParseTimeWindow(tt.window)
+		if err != nil {
+			t.Fatalf("ParseTimeWindow(%q): %v", tt.window, err)
+		}
+		if got := w.Contains(tt.at); got != tt.contains {
+			t.Errorf("%s Contains(%s) = %v, want %v", tt.window, tt.at.Format(time.TimeOnly), got, tt.contains)
+		}
+		if got := w.NextOpen(tt.at); !got.Equal(tt.nextOpen) {
+			t.Errorf("%s NextOpen(%s) = %s, want %s", tt.window, tt.at.Format(time.TimeOnly), got, tt.nextOpen)
+		}
+	}
+	for _, invalid := range []string{"19:00", "25:00-07:00", "19:00-07:60", "a-b"} {
+		if _, err := ParseTimeWindow(invalid); err == nil {
+			t.Errorf("ParseTimeWindow(%q) must fail", invalid)
+		}
+	}
+}
+
+func TestThrottlePausedByHost(t *testing.T) {
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	now := time.Now()
+	closed := now.Add(time.Hour).Format("15:04") + "-" + now.Add(2*time.Hour).Format("15:04")
+	throttle := NewThrottle(func(host string) backend.SeedLimits {
+		if host == "night.example.org" {
+			return backend.SeedLimits{Window: closed}
+		}
+		return backend.SeedLimits{}
+	}, l)
+	ctx, cancel := context.WithCancel(context.Background())
+	var night, day Progress
+	done := make(chan error)
+	go func() { done <- throttle.wait(ctx, "https://night.example.org/wms", &night) }()
+	for night.Paused.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := throttle.wait(ctx, "https://day.example.org/wms", &day); err != nil || day.Paused.Load() != 0 {
+		t.Errorf("wait() on another host = %v, paused %d, want no pause", err, day.Paused.Load())
+	}
+	if paused := throttle.host("day.example.org").paused.Load(); paused != 0 {
+		t.Errorf("day.example.org has %d workers paused, want 0", paused)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("wait() outside the window = %v, want context.Canceled", err)
+	}
+	if night.Paused.Load() != 0 || throttle.host("night.example.org").paused.Load() != 0 {
+		t.Error("the workers are still counted as paused after the wait")
+	}
+}
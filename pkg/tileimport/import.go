@@ -0,0 +1,186 @@
+// Package tileimport fills the tiles cache of a layer with tiles rendered by other tools, like the MapProxy
+// and TileCache caches, XYZ folders or MBTiles files, so the historical years need not be rendered again.
+package tileimport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // the decoders of the formats accepted and converted to png
+	_ "image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+// maxReportedIssues limits the issues kept in a Result, they are all counted
+const maxReportedIssues = 100
+
+// Options describes the tiles to import into the cache of a layer
+type Options struct {
+	Source     string // folder of the tiles or MBTiles file
+	Layout     string // one of Layouts
+	Origin     string // OriginUpperLeft or OriginLowerLeft, the default of the layout when empty
+	ZoomOffset int    // added to the zoom levels of the source to get the ones of the grid
+	MinZoom    int    // first imported zoom level of the grid, -1 for all
+	MaxZoom    int    // last imported zoom level of the grid, -1 for all
+	Overwrite  bool   // replace the cached tiles different from the imported ones, they are kept by default
+	DryRun     bool   // check and count the tiles without writing them
+}
+
+// The kinds of Issue
+const (
+	IssueOutside  = "outside"  // the tile is not in the grid of the layer
+	IssueInvalid  = "invalid"  // the tile is not an image or not of the size of the grid tiles
+	IssueConflict = "conflict" // the cache already has a different tile at this place
+)
+
+// Issue is a source tile not imported as is
+type Issue struct {
+	Kind    string
+	Source  string // file or MBTiles row of the tile
+	Zoom    int    // zoom, col and row in the grid of the layer
+	Col     int
+	Row     int
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s %s -> zoom:%d, col:%d, row:%d: %s", i.Kind, i.Source, i.Zoom, i.Col, i.Row, i.Message)
+}
+
+// Result counts the tiles of an import
+type Result struct {
+	Read      int            // tiles read from the source in the selected zoom levels
+	Imported  int            // tiles written into the cache, or that would be in a dry run
+	Converted int            // imported tiles converted to png
+	Identical int            // tiles already in the cache with the same content
+	Issues    map[string]int // tiles not imported, or overwriting a cached one, by kind of Issue
+	Samples   []Issue        // the first maxReportedIssues issues
+}
+
+func (r *Result) addIssue(issue Issue) {
+	r.Issues[issue.Kind]++
+	if len(r.Samples) < maxReportedIssues {
+		r.Samples = append(r.Samples, issue)
+	}
+}
+
+// Import copies the tiles of opts.Source into the cache store of the layer lc of grid. The source must use the
+// same tile matrix set as the grid, its zoom levels shifted by opts.ZoomOffset. Each tile must have the size of
+// the grid tiles, the jpeg and gif ones are converted to png. A cached tile is only replaced with opts.Overwrite,
+// either way the different ones are reported as conflicts.
+func Import(ctx context.Context, grid *wmts.Grid, lc wmts.LayerConfig, store *wmts.FileStore, opts Options, l golog.MyLogger) (*Result, error) {
+	reader, err := newReader(opts.Source, opts.Layout)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	origin := opts.Origin
+	if origin == "" {
+		origin = defaultOrigin(opts.Layout)
+	}
+	if origin != OriginUpperLeft && origin != OriginLowerLeft {
+		return nil, fmt.Errorf("unknown origin %q, use %s or %s", origin, OriginUpperLeft, OriginLowerLeft)
+	}
+	result := &Result{Issues: make(map[string]int)}
+	tileSize := int(grid.TileSize)
+	err = reader.Walk(ctx, func(t sourceTile) error {
+		zoom := t.Zoom + opts.ZoomOffset
+		if (opts.MinZoom >= 0 && zoom < opts.MinZoom) || (opts.MaxZoom >= 0 && zoom > opts.MaxZoom) {
+			return nil
+		}
+		result.Read++
+		col, row := t.X, t.Y
+		if origin == OriginLowerLeft {
+			row = grid.GetMaxNumRows(zoom) - 1 - t.Y
+		}
+		issue := Issue{Source: t.Name, Zoom: zoom, Col: col, Row: row}
+		if err := grid.CheckTile(zoom, col, row); err != nil {
+			issue.Kind, issue.Message = IssueOutside, err.Error()
+			result.addIssue(issue)
+			return nil
+		}
+		data, converted, err := pngTile(t.Data, tileSize)
+		if err != nil {
+			issue.Kind, issue.Message = IssueInvalid, err.Error()
+			result.addIssue(issue)
+			return nil
+		}
+		cached, err := os.ReadFile(store.TilePath(lc, zoom, row, col))
+		switch {
+		case err == nil && bytes.Equal(cached, data):
+			result.Identical++
+			return nil
+		case err == nil:
+			issue.Kind, issue.Message = IssueConflict, "a different tile is cached, kept"
+			if opts.Overwrite {
+				issue.Message = "a different tile is cached, overwritten"
+			}
+			result.addIssue(issue)
+			if !opts.Overwrite {
+				return nil
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return err
+		}
+		if !opts.DryRun {
+			if err := store.Write(lc, zoom, row, col, data); err != nil {
+				return err
+			}
+		}
+		result.Imported++
+		if converted {
+			result.Converted++
+		}
+		if result.Imported%10_000 == 0 {
+			l.Info("ℹ️ %d tiles imported, last one zoom:%d, col:%d, row:%d", result.Imported, zoom, col, row)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// newReader opens the source in the layout
+func newReader(source, layout string) (tileReader, error) {
+	if layout == "" && strings.HasSuffix(strings.ToLower(source), ".mbtiles") {
+		layout = LayoutMBTiles
+	}
+	switch layout {
+	case LayoutMBTiles:
+		return newMBTilesReader(source)
+	case LayoutTC, LayoutMP, LayoutXYZ:
+		return newDirReader(source, layout)
+	default:
+		return nil, fmt.Errorf("unknown layout %q, use one of %v", layout, Layouts)
+	}
+}
+
+// pngTile checks the size of the encoded tile and returns it as png, converted reports a re-encoded tile
+func pngTile(data []byte, tileSize int) (encoded []byte, converted bool, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("not an image: %w", err)
+	}
+	if cfg.Width != tileSize || cfg.Height != tileSize {
+		return nil, false, fmt.Errorf("%s tile of %dx%d pixels, the grid tiles have %dx%d", format, cfg.Width, cfg.Height, tileSize, tileSize)
+	}
+	if format == wmts.DefaultImageFormat {
+		return data, false, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s tile: %w", format, err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
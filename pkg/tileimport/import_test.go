@@ -0,0 +1,163 @@
+package tileimport
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/wmts"
+)
+
+func encodeTile(t *testing.T, size int, c color.Color, format string) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b, a := c.RGBA()
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestLayer(t *testing.T) (*wmts.Grid, wmts.LayerConfig, *wmts.FileStore, golog.MyLogger) {
+	t.Helper()
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	lc := wmts.LayerConfig{
+		LayerDefaultValues: wmts.LayerDefaultValues{
+			WMTSURLPrefix:     "tiles/1.0.0",
+			WMTSURLStyle:      "default",
+			WMTSDimensionYear: "2010",
+			WMTSMatrixSet:     wmts.LausanneMatrixSet,
+		},
+		Name: "historical",
+	}
+	return wmts.NewLausanneGrid("", "", l), lc, wmts.NewFileStore(t.TempDir()), l
+}
+
+func TestParsePaths(t *testing.T) {
+	if zoom, x, y, ok := parseTCPath([]string{"03", "000", "001", "234", "000", "000", "249"}); !ok || zoom != 3 || x != 1234 || y != 249 {
+		t.Errorf("parseTCPath = %d, %d, %d, %v", zoom, x, y, ok)
+	}
+	if zoom, x, y, ok := parseMPPath([]string{"05", "0000", "1874", "0001", "0003"}); !ok || zoom != 5 || x != 1874 || y != 10003 {
+		t.Errorf("parseMPPath = %d, %d, %d, %v", zoom, x, y, ok)
+	}
+	if _, _, _, ok := parseXYZPath([]string{"2", "1", "tile"}); ok {
+		t.Error("parseXYZPath accepted a name that is not a number")
+	}
+}
+
+// the zoom level 0 of the Lausanne grid has 38 columns and 25 rows, the row 24 is the bottom one
+func TestImportTCFolder(t *testing.T) {
+	grid, lc, store, l := newTestLayer(t)
+	src := t.TempDir()
+	red := encodeTile(t, 256, color.RGBA{R: 255, A: 255}, "png")
+	writeFile(t, filepath.Join(src, "00/000/000/002/000/000/000.png"), red)                                      // bottom row
+	writeFile(t, filepath.Join(src, "00/000/000/003/000/000/001.jpeg"), encodeTile(t, 256, color.White, "jpeg")) // converted
+	writeFile(t, filepath.Join(src, "00/000/000/004/000/000/002.png"), encodeTile(t, 512, color.White, "png"))   // invalid
+	writeFile(t, filepath.Join(src, "00/000/000/040/000/000/000.png"), red)                                      // outside
+	writeFile(t, filepath.Join(src, "00/000/000/005/000/000/000.png"), red)                                      // conflict
+	writeFile(t, filepath.Join(src, "00/000/000/005/000/000/000.png.lck"), nil)                                  // ignored
+	if err := store.Write(lc, 0, 24, 5, []byte("cached")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Import(context.Background(), grid, lc, store, Options{Source: src, Layout: LayoutTC, MinZoom: -1, MaxZoom: -1}, l)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.Read != 5 || result.Imported != 2 || result.Converted != 1 {
+		t.Errorf("Import() read %d, imported %d, converted %d, want 5, 2, 1", result.Read, result.Imported, result.Converted)
+	}
+	for kind, want := range map[string]int{IssueInvalid: 1, IssueOutside: 1, IssueConflict: 1} {
+		if result.Issues[kind] != want {
+			t.Errorf("Import() has %d %s issues, want %d", result.Issues[kind], kind, want)
+		}
+	}
+	if data, err := os.ReadFile(store.TilePath(lc, 0, 24, 2)); err != nil || !bytes.Equal(data, red) {
+		t.Errorf("tile 0/24/2 not imported: %v", err)
+	}
+	if data, _ := os.ReadFile(store.TilePath(lc, 0, 24, 5)); string(data) != "cached" {
+		t.Error("the conflicting cached tile was overwritten")
+	}
+
+	// the second run finds the same tiles in the cache
+	result, err = Import(context.Background(), grid, lc, store, Options{Source: src, Layout: LayoutTC, MinZoom: -1, MaxZoom: -1, Overwrite: true}, l)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.Identical != 2 || result.Imported != 1 || result.Issues[IssueConflict] != 1 {
+		t.Errorf("second Import() identical %d, imported %d, conflicts %d, want 2, 1, 1", result.Identical, result.Imported, result.Issues[IssueConflict])
+	}
+}
+
+func TestImportMBTiles(t *testing.T) {
+	grid, lc, store, l := newTestLayer(t)
+	dir := t.TempDir()
+	created := filepath.Join(dir, "historical.mbtiles")
+	db, err := sql.Open("sqlite", created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"CREATE TABLE metadata (name text, value text)",
+		"CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+		"INSERT INTO metadata VALUES ('format', 'png')",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tile := encodeTile(t, 256, color.RGBA{B: 255, A: 255}, "png")
+	if _, err := db.Exec("INSERT INTO tiles VALUES (1, 10, 0, ?), (2, 1, 1, ?)", tile, tile); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	// the characters starting the query or the fragment of an url are read as part of the file name
+	path := filepath.Join(dir, "historical 2021?#1.mbtiles")
+	if err := os.Rename(created, path); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Import(context.Background(), grid, lc, store, Options{Source: path, MinZoom: 1, MaxZoom: 1}, l)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.Read != 1 || result.Imported != 1 {
+		t.Errorf("Import() read %d, imported %d, want 1, 1", result.Read, result.Imported)
+	}
+	// the zoom level 1 has 63 rows, the MBTiles row 0 is the bottom one
+	if _, err := os.Stat(store.TilePath(lc, 1, 62, 10)); err != nil {
+		t.Errorf("tile 1/62/10 not imported: %v", err)
+	}
+}
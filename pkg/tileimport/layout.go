@@ -0,0 +1,161 @@
+package tileimport
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The tile layouts that can be imported
+const (
+	LayoutTC      = "tc"      // TileCache and MapProxy tc: zz/xxx/xxx/xxx/yyy/yyy/yyy.png, rows from the bottom
+	LayoutMP      = "mp"      // MapProxy mp: zz/xxxx/xxxx/yyyy/yyyy.png, rows from the bottom
+	LayoutXYZ     = "xyz"     // z/x/y.png, rows from the top
+	LayoutMBTiles = "mbtiles" // SQLite file of the MBTiles specification, rows from the bottom
+)
+
+// Layouts lists the supported layouts
+var Layouts = []string{LayoutTC, LayoutMP, LayoutXYZ, LayoutMBTiles}
+
+// The origins of the rows of a layout
+const (
+	OriginUpperLeft = "ul" // the row 0 is at the top, like WMTS
+	OriginLowerLeft = "ll" // the row 0 is at the bottom, like TMS
+)
+
+// sourceTile is a tile read from a source, x and y are in the convention of the layout
+type sourceTile struct {
+	Zoom, X, Y int
+	Name       string // file or row of the tile, for the reports
+	Data       []byte
+}
+
+// tileReader reads all the tiles of a source
+type tileReader interface {
+	// Walk calls fn with every tile of the source, it stops at the first error returned by fn
+	Walk(ctx context.Context, fn func(sourceTile) error) error
+	Close() error
+}
+
+// defaultOrigin returns the origin of the rows of the layout when it is not given
+func defaultOrigin(layout string) string {
+	if layout == LayoutXYZ {
+		return OriginUpperLeft
+	}
+	return OriginLowerLeft
+}
+
+// imageExtensions are the files read from a folder, the other ones like the MapProxy locks are ignored
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true}
+
+// dirReader reads the tiles of a folder in the tc, mp or xyz layout
+type dirReader struct {
+	root  string
+	parse func(parts []string) (zoom, x, y int, ok bool)
+}
+
+func newDirReader(root, layout string) (*dirReader, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a folder, needed by the %s layout", root, layout)
+	}
+	r := &dirReader{root: root}
+	switch layout {
+	case LayoutTC:
+		r.parse = parseTCPath
+	case LayoutMP:
+		r.parse = parseMPPath
+	case LayoutXYZ:
+		r.parse = parseXYZPath
+	default:
+		return nil, fmt.Errorf("unknown folder layout %q, use %s, %s or %s", layout, LayoutTC, LayoutMP, LayoutXYZ)
+	}
+	return r, nil
+}
+
+func (r *dirReader) Walk(ctx context.Context, fn func(sourceTile) error) error {
+	return filepath.WalkDir(r.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if d.IsDir() || !imageExtensions[ext] {
+			return nil
+		}
+		rel, err := filepath.Rel(r.root, strings.TrimSuffix(path, filepath.Ext(path)))
+		if err != nil {
+			return err
+		}
+		zoom, x, y, ok := r.parse(strings.Split(filepath.ToSlash(rel), "/"))
+		if !ok {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return fn(sourceTile{Zoom: zoom, X: x, Y: y, Name: path, Data: data})
+	})
+}
+
+func (r *dirReader) Close() error {
+	return nil
+}
+
+// parseTCPath reads zz/xxx/xxx/xxx/yyy/yyy/yyy, the coordinates are split in groups of 3 digits
+func parseTCPath(parts []string) (zoom, x, y int, ok bool) {
+	if len(parts) != 7 {
+		return 0, 0, 0, false
+	}
+	values, ok := atois(parts)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return values[0], values[1]*1_000_000 + values[2]*1_000 + values[3], values[4]*1_000_000 + values[5]*1_000 + values[6], true
+}
+
+// parseMPPath reads zz/xxxx/xxxx/yyyy/yyyy, the coordinates are split in groups of 4 digits
+func parseMPPath(parts []string) (zoom, x, y int, ok bool) {
+	if len(parts) != 5 {
+		return 0, 0, 0, false
+	}
+	values, ok := atois(parts)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return values[0], values[1]*10_000 + values[2], values[3]*10_000 + values[4], true
+}
+
+// parseXYZPath reads z/x/y
+func parseXYZPath(parts []string) (zoom, x, y int, ok bool) {
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	values, ok := atois(parts)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return values[0], values[1], values[2], true
+}
+
+func atois(parts []string) ([]int, bool) {
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
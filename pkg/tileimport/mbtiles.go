@@ -0,0 +1,80 @@
+package tileimport
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite" // pure Go SQLite driver, registered as "sqlite"
+)
+
+// mbtilesReader reads the tiles of an MBTiles file
+type mbtilesReader struct {
+	db *sql.DB
+}
+
+func newMBTilesReader(path string) (*mbtilesReader, error) {
+	// sql.Open would create a missing file
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	// the url escapes the characters of the path which would start the query, like ? and #
+	dsn := (&url.URL{Scheme: "file", Path: absPath, RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	r := &mbtilesReader{db: db}
+	format, err := r.metadata("format")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s is not an MBTiles file: %w", path, err)
+	}
+	// the vector tiles cannot be drawn in a raster cache
+	if format = strings.ToLower(format); format != "" && format != "png" && format != "jpg" && format != "jpeg" {
+		db.Close()
+		return nil, fmt.Errorf("unsupported MBTiles format %q, only png and jpg tiles can be imported", format)
+	}
+	return r, nil
+}
+
+// metadata returns the value of the metadata name, empty when absent
+func (r *mbtilesReader) metadata(name string) (string, error) {
+	var value string
+	err := r.db.QueryRow("SELECT value FROM metadata WHERE name = ?", name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (r *mbtilesReader) Walk(ctx context.Context, fn func(sourceTile) error) error {
+	rows, err := r.db.QueryContext(ctx, "SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles ORDER BY zoom_level, tile_row, tile_column")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t sourceTile
+		if err := rows.Scan(&t.Zoom, &t.X, &t.Y, &t.Data); err != nil {
+			return err
+		}
+		t.Name = fmt.Sprintf("tiles(%d,%d,%d)", t.Zoom, t.X, t.Y)
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *mbtilesReader) Close() error {
+	return r.db.Close()
+}
@@ -1,127 +1,196 @@
 package tools
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/metrics"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// CreateHTTPClient creates a configured HTTP client with timeouts
+// CreateHTTPClient creates a configured HTTP client with timeouts, instrumented with the backend metrics
 func CreateHTTPClient(maxTimeout, maxIdleConn, maxIdleConnPerHost, idleConnTimeout int) *http.Client {
 	return &http.Client{
 		Timeout: time.Duration(maxTimeout) * time.Second,
-		Transport: &http.Transport{
+		// each backend host is protected by a concurrency limit and a circuit breaker,
+		// the requests latency, errors and in-flight count are exported as Prometheus metrics,
+		// and each request is traced with the W3C trace context sent to the backend
+		Transport: backend.Transport(metrics.InstrumentBackend(tracing.InstrumentTransport(&http.Transport{
 			MaxIdleConns:        maxIdleConn,
 			MaxIdleConnsPerHost: maxIdleConnPerHost,
 			IdleConnTimeout:     time.Duration(idleConnTimeout) * time.Second,
-		},
+		}))),
 	}
 }
 
+// ClientWithTimeout returns client when its timeout is timeoutSec seconds, otherwise a copy of it with this timeout
+// sharing its transport and connections, so a reloaded timeout applies without a restart
+func ClientWithTimeout(client *http.Client, timeoutSec int) *http.Client {
+	timeout := time.Duration(timeoutSec) * time.Second
+	if client == nil || client.Timeout == timeout {
+		return client
+	}
+	c := *client
+	c.Timeout = timeout
+	return &c
+}
+
 // ensureDir creates the output directory if it doesn't exist
 func ensureDir(dir string) error {
 	return os.MkdirAll(dir, 0755)
 }
 
-// GetPngFromUrl downloads a single tile with retry logic and saves it to a file in path parameter
-func GetPngFromUrl(client *http.Client, url, path string, buffer, maxRetries int, l golog.MyLogger) error {
-	var lastErr error
+// GetPngFromUrl downloads a single tile, retrying the transient failures as told by retry, and saves it to a file in path parameter.
+// When process is not nil it is applied to the cropped image before the encoding, see LayerConfig.TileFilter in wmts.
+// The download, decoding, crop and write are traced as children of the span in ctx.
+func GetPngFromUrl(ctx context.Context, client *http.Client, url, path string, buffer int, process func(image.Image) (image.Image, error), retry backend.RetryPolicy, l golog.MyLogger) (err error) {
+	ctx, span := tracing.Start(ctx, "tools.GetPngFromUrl", trace.WithAttributes(
+		attribute.String("wms.url", url),
+		attribute.String("tile.path", path),
+		attribute.Int("buffer", buffer),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
 	l.Debug("GetPngFromUrl buffer: %d , url: %s", buffer, url)
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		if attempt > 0 {
-			// Exponential backoff: wait 2^attempt seconds
-			time.Sleep(time.Duration(1<<attempt) * time.Second)
-		}
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		l.Error("💥💥 error creating dir %v", err)
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	width, height := WMSImageSize(url)
+	var bufferedImage image.Image
+	var raw []byte
+	err = retry.Do(ctx, func() error {
+		var err error
+		bufferedImage, raw, err = fetchWMSImage(ctx, client, url, width, height)
+		return err
+	}, func(attempt int, err error, delay time.Duration) {
+		l.Warn("retrying tile %s in %s after: %v", path, delay.Round(time.Millisecond), err)
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt), attribute.String("last_error", err.Error())))
+	})
+	if errors.Is(err, ErrInvalidResponse) {
+		// never cache an error page or exception report
+		l.Error("💥💥 refusing to cache tile %s: %v", path, err)
+		return err
+	}
+	if err != nil {
+		l.Error("💥💥 error fetching tile %s: %v", path, err)
+		return err
+	}
 
-		// Create the directory structure
-		if err := ensureDir(filepath.Dir(path)); err != nil {
-			lastErr = fmt.Errorf("failed to create directory: %v", err)
-			l.Error("💥💥 error creating dir %v", err)
-			continue
+	_, writeSpan := tracing.Start(ctx, "store.write")
+	if buffer == 0 && process == nil && bytes.HasPrefix(raw, pngSignature) {
+		// the image is already a tile, it is stored as received
+		err = WriteFileAtomic(path, raw)
+	} else {
+		l.Debug("about to  imgTools.CropImage buffer:%d", buffer)
+		img := imgTools.CropImage(ctx, bufferedImage, buffer, l)
+		if process != nil {
+			img, err = process(img)
 		}
-
-		// Make HTTP request
-		resp, err := client.Get(url)
-		if err != nil {
-			lastErr = fmt.Errorf("request failed: %v", err)
-			l.Error("💥💥 error doing request  %v", err)
-			continue
+		if err == nil {
+			err = writePngFile(img, path)
 		}
+	}
+	tracing.EndSpan(writeSpan, err)
+	return err
+}
 
-		// Check status code
-		if resp.StatusCode != http.StatusOK {
-			err := resp.Body.Close()
-			if err != nil {
-				return fmt.Errorf("error occured doing Body.Close : %x", err)
-			}
-			lastErr = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-			l.Error("💥💥 error unexpected status code %d doing request", resp.StatusCode)
-			continue
-		}
+// fetchWMSImage makes a WMS GetMap request and returns its image, see ReadWMSImage.
+// A response with another status than 200 gives a *backend.StatusError.
+func fetchWMSImage(ctx context.Context, client *http.Client, url string, width, height int) (image.Image, []byte, error) {
+	// the trace context is propagated to the backend by the client transport
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid request url: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, backend.NewStatusError(resp)
+	}
+	_, decodeSpan := tracing.Start(ctx, "png.decode")
+	img, raw, err := ReadWMSImage(resp, width, height)
+	tracing.EndSpan(decodeSpan, err)
+	return img, raw, err
+}
 
-		if buffer == 0 {
+// FetchImage makes a WMS GetMap or a tile request, retrying the transient failures as told by retry,
+// and returns its image checked to be of width x height pixels, see ReadWMSImage.
+func FetchImage(ctx context.Context, client *http.Client, url string, width, height int, retry backend.RetryPolicy, l golog.MyLogger) (img image.Image, err error) {
+	err = retry.Do(ctx, func() error {
+		var err error
+		img, _, err = fetchWMSImage(ctx, client, url, width, height)
+		return err
+	}, func(attempt int, err error, delay time.Duration) {
+		l.Warn("retrying request %s in %s after: %v", url, delay.Round(time.Millisecond), err)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt), attribute.String("last_error", err.Error())))
+	})
+	return img, err
+}
 
-			// Create output file
-			file, err := os.Create(path)
-			if err != nil {
-				resp.Body.Close()
-				if err != nil {
-					return fmt.Errorf("error occured doing Body.Close : %x", err)
-				}
-				lastErr = fmt.Errorf("failed to create file: %v", err)
-				l.Error("💥💥 error creating file %s, err: %v", path, err)
-				continue
-			}
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
 
-			// Copy response body to file
-			_, err = io.Copy(file, resp.Body)
-			if err != nil {
-				lastErr = fmt.Errorf("error occured doing io.Copy : %x", err)
-				l.Error("💥💥 error doing io.Copy  %v", err)
-				os.Remove(path) // Clean up partial file
-				continue
-			}
-			err = file.Close()
-			if err != nil {
-				l.Error("💥💥 error doing file.Close  %v", err)
-				return fmt.Errorf("error occured doing file.Close : %x", err)
-			}
-			resp.Body.Close()
-			if err != nil {
-				l.Error("💥💥 error doing Body.Close  %v", err)
-				return fmt.Errorf("error occured doing Body.Close : %x", err)
-			}
-			return nil
-		} else {
-			l.Debug("buffer is not null(= %d) so we need to crop the image before saving it", buffer)
-			// Decode the image from the response body.
-			bufferedImage, _, err := image.Decode(resp.Body)
-			if err != nil {
-				l.Error("💥💥 error doing image.Decode(resp.Body)  %v", err)
-				return fmt.Errorf("failed to decode meta-tile image: %w", err)
-			}
-			l.Debug("about to  imgTools.CropImage buffer:%d", buffer)
-			img := imgTools.CropImage(bufferedImage, buffer, l)
+// writePngFile encodes img in a temporary file renamed to path once complete, so readers never see a partial tile
+func writePngFile(img image.Image, path string) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return fmt.Errorf("failed to encode tile image: %w", err)
+	}
+	return WriteFileAtomic(path, encoded.Bytes())
+}
+
+// WriteFileAtomic writes data in a temporary file renamed to path once complete, so readers never see a partial tile
+func WriteFileAtomic(path string, data []byte) error {
+	return WriteFileAtomicModTime(path, data, time.Time{})
+}
 
-			outFile, err := os.Create(path)
-			if err != nil {
-				return fmt.Errorf("failed to create tile image file: %w", err)
-			}
-			if err := png.Encode(outFile, img); err != nil {
-				return fmt.Errorf("failed to encode tile image: %w", err)
-			}
-			outFile.Close()
-			return nil
+// WriteFileAtomicModTime is WriteFileAtomic giving the modification time modTime to the file, unless it is zero.
+// The time is set before the rename, so a reader never sees the file with another time.
+func WriteFileAtomicModTime(path string, data []byte, modTime time.Time) error {
+	outFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create tile image file: %w", err)
+	}
+	// os.CreateTemp makes a file only readable by its owner, the tiles stay readable like with os.WriteFile
+	if err := outFile.Chmod(0644); err != nil {
+		outFile.Close()
+		os.Remove(outFile.Name())
+		return fmt.Errorf("failed to chmod tile image file: %w", err)
+	}
+	if _, err := outFile.Write(data); err != nil {
+		outFile.Close()
+		os.Remove(outFile.Name())
+		return fmt.Errorf("failed to write tile image file: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		os.Remove(outFile.Name())
+		return fmt.Errorf("failed to close tile image file: %w", err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(outFile.Name(), time.Now(), modTime); err != nil {
+			os.Remove(outFile.Name())
+			return fmt.Errorf("failed to set the time of tile image file: %w", err)
 		}
 	}
-
-	return fmt.Errorf("# failed  after %d retries: %v", maxRetries, lastErr)
+	if err := os.Rename(outFile.Name(), path); err != nil {
+		os.Remove(outFile.Name())
+		return fmt.Errorf("failed to rename tile image file: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,20 @@
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientWithTimeout(t *testing.T) {
+	client := CreateHTTPClient(10, 10, 2, 30)
+	if got := ClientWithTimeout(client, 10); got != client {
+		t.Error("ClientWithTimeout() with the same timeout returned another client")
+	}
+	got := ClientWithTimeout(client, 20)
+	if got == client || got.Timeout != 20*time.Second || got.Transport != client.Transport {
+		t.Errorf("ClientWithTimeout(20) = timeout %v, want 20s sharing the transport", got.Timeout)
+	}
+	if client.Timeout != 10*time.Second {
+		t.Errorf("ClientWithTimeout() changed the timeout of the client to %v", client.Timeout)
+	}
+}
@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // WMS backends may answer in JPEG
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxErrorBodySize is the maximum number of bytes of a non image response read to build the error
+const maxErrorBodySize = 64 * 1024
+
+// ErrInvalidResponse is the parent of the errors returned when a WMS response cannot be cached as a tile
+var ErrInvalidResponse = errors.New("invalid WMS response")
+
+// ServiceException is one exception of an OGC exception report
+type ServiceException struct {
+	Code    string // exception code, like InvalidFormat or LayerNotDefined, may be empty
+	Locator string // name of the parameter or element in error, may be empty
+	Message string
+}
+
+// ServiceExceptionError is returned when a WMS backend answers with an OGC exception report instead of an image
+type ServiceExceptionError struct {
+	Exceptions []ServiceException
+}
+
+func (e *ServiceExceptionError) Error() string {
+	parts := make([]string, 0, len(e.Exceptions))
+	for _, ex := range e.Exceptions {
+		msg := ex.Message
+		if ex.Locator != "" {
+			msg = fmt.Sprintf("%s (locator: %s)", msg, ex.Locator)
+		}
+		if ex.Code != "" {
+			msg = fmt.Sprintf("[%s] %s", ex.Code, msg)
+		}
+		parts = append(parts, msg)
+	}
+	return fmt.Sprintf("%v: service exception: %s", ErrInvalidResponse, strings.Join(parts, "; "))
+}
+
+// Unwrap allows errors.Is(err, ErrInvalidResponse)
+func (e *ServiceExceptionError) Unwrap() error {
+	return ErrInvalidResponse
+}
+
+// Code returns the code of the first exception, or an empty string
+func (e *ServiceExceptionError) Code() string {
+	if len(e.Exceptions) == 0 {
+		return ""
+	}
+	return e.Exceptions[0].Code
+}
+
+// exceptionReport matches the WMS 1.1.1 / 1.3.0 ServiceExceptionReport and the OWS ExceptionReport
+type exceptionReport struct {
+	XMLName           xml.Name
+	ServiceExceptions []struct {
+		Code    string `xml:"code,attr"`
+		Locator string `xml:"locator,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"ServiceException"`
+	Exceptions []struct {
+		Code    string   `xml:"exceptionCode,attr"`
+		Locator string   `xml:"locator,attr"`
+		Texts   []string `xml:"ExceptionText"`
+	} `xml:"Exception"`
+}
+
+// ParseServiceException parses an OGC exception report, it returns nil when data is not one
+func ParseServiceException(data []byte) *ServiceExceptionError {
+	var report exceptionReport
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return nil
+	}
+	if report.XMLName.Local != "ServiceExceptionReport" && report.XMLName.Local != "ExceptionReport" {
+		return nil
+	}
+	e := &ServiceExceptionError{}
+	for _, ex := range report.ServiceExceptions {
+		e.Exceptions = append(e.Exceptions, ServiceException{Code: ex.Code, Locator: ex.Locator, Message: strings.TrimSpace(ex.Text)})
+	}
+	for _, ex := range report.Exceptions {
+		e.Exceptions = append(e.Exceptions, ServiceException{Code: ex.Code, Locator: ex.Locator, Message: strings.TrimSpace(strings.Join(ex.Texts, " "))})
+	}
+	if len(e.Exceptions) == 0 {
+		e.Exceptions = []ServiceException{{Message: "empty exception report"}}
+	}
+	return e
+}
+
+// ReadWMSImage checks a 200 response of a WMS GetMap and decodes its image.
+// An OGC exception report gives a *ServiceExceptionError, any other content type than an image,
+// an undecodable image or an image not of width x height pixels (when > 0) gives an error wrapping ErrInvalidResponse.
+// The raw body is returned with the image so it can be stored as is.
+func ReadWMSImage(resp *http.Response, width, height int) (image.Image, []byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		if strings.Contains(mediaType, "xml") || bytes.HasPrefix(bytes.TrimSpace(body), []byte("<?xml")) {
+			if e := ParseServiceException(body); e != nil {
+				return nil, nil, e
+			}
+		}
+		return nil, nil, fmt.Errorf("%w: content type %q instead of an image: %s", ErrInvalidResponse, mediaType, bodySnippet(body))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read WMS image: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: cannot decode %s image: %v", ErrInvalidResponse, mediaType, err)
+	}
+	size := img.Bounds().Size()
+	if (width > 0 && size.X != width) || (height > 0 && size.Y != height) {
+		return nil, nil, fmt.Errorf("%w: image of %dx%d pixels instead of %dx%d", ErrInvalidResponse, size.X, size.Y, width, height)
+	}
+	return img, body, nil
+}
+
+// WMSImageSize returns the WIDTH and HEIGHT parameters of a WMS GetMap url, 0 when missing
+func WMSImageSize(rawURL string) (width, height int) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, 0
+	}
+	for key, values := range u.Query() {
+		switch strings.ToUpper(key) {
+		case "WIDTH":
+			width, _ = strconv.Atoi(values[0])
+		case "HEIGHT":
+			height, _ = strconv.Atoi(values[0])
+		}
+	}
+	return width, height
+}
+
+// bodySnippet returns the beginning of a body on one line for the error messages
+func bodySnippet(body []byte) string {
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if len(s) > 200 {
+		s = s[:200] + "…"
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func response(contentType string, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+func TestReadWMSImage(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	wms111 := `<?xml version="1.0" encoding="UTF-8"?>
+<ServiceExceptionReport version="1.1.1">
+  <ServiceException code="LayerNotDefined" locator="LAYERS">msWMSLoadGetMapParams(): Invalid layer(s) given.</ServiceException>
+</ServiceExceptionReport>`
+	ows := `<ows:ExceptionReport xmlns:ows="http://www.opengis.net/ows/1.1" version="2.0.0">
+  <ows:Exception exceptionCode="InvalidParameterValue" locator="CRS"><ows:ExceptionText>CRS not supported</ows:ExceptionText></ows:Exception>
+</ows:ExceptionReport>`
+
+	tests := []struct {
+		name        string
+		resp        *http.Response
+		wantCode    string // expected exception code, empty when no ServiceExceptionError is expected
+		wantInvalid bool
+	}{
+		{"valid png", response("image/png", encoded.Bytes()), "", false},
+		{"wms exception", response("application/vnd.ogc.se_xml; charset=UTF-8", []byte(wms111)), "LayerNotDefined", true},
+		{"ows exception", response("text/xml", []byte(ows)), "InvalidParameterValue", true},
+		{"html error page", response("text/html", []byte("<html><body>Internal error</body></html>")), "", true},
+		{"undecodable image", response("image/png", []byte("not a png")), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, raw, err := ReadWMSImage(tt.resp, 4, 2)
+			if errors.Is(err, ErrInvalidResponse) != tt.wantInvalid {
+				t.Fatalf("got error %v, want invalid response %v", err, tt.wantInvalid)
+			}
+			var se *ServiceExceptionError
+			if errors.As(err, &se) != (tt.wantCode != "") {
+				t.Fatalf("got error %v, want exception code %q", err, tt.wantCode)
+			}
+			if se != nil && se.Code() != tt.wantCode {
+				t.Errorf("exception code = %q, want %q", se.Code(), tt.wantCode)
+			}
+			if err == nil && (img == nil || !bytes.Equal(raw, encoded.Bytes())) {
+				t.Error("valid response must return the image and its raw bytes")
+			}
+		})
+	}
+
+	_, _, err := ReadWMSImage(response("image/png", encoded.Bytes()), 256, 256)
+	if !errors.Is(err, ErrInvalidResponse) || !strings.Contains(err.Error(), "4x2") {
+		t.Errorf("wrong image size: got %v, want invalid response mentioning 4x2", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Package tracing configures OpenTelemetry and gives access to the tracer used by the tile server and the seeder.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/lao-tseu-is-alive/go-wmts-tool"
+
+// Init installs the global tracer provider for the given exporter (see config.GetTracesExporterFromEnvOrPanic)
+// and the W3C trace context propagator. The returned function flushes the pending spans and must be called before exiting.
+// With config.TracesExporterNone the default no-op provider is kept.
+func Init(ctx context.Context, exporter, serviceName, serviceVersion string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case config.TracesExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case config.TracesExporterOTLP:
+		// the endpoint, headers and protocol options are read from the standard OTEL_EXPORTER_OTLP_* env variables
+		spanExporter, err = otlptracehttp.New(ctx)
+	case config.TracesExporterConsole:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the %s traces exporter: %w", exporter, err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName), semconv.ServiceVersion(serviceVersion)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(), // OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME take precedence
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the traces resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start creates a span named name, child of the span in ctx if any
+func Start(ctx context.Context, name string, attrs ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, attrs...)
+}
+
+// EndSpan records err (if not nil) on the span and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InstrumentTransport wraps the transport of the WMS backend client, each request gets a client span
+// and the W3C traceparent header so the backend rendering can be part of the trace.
+func InstrumentTransport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(next)
+}
+
+// InstrumentHandler wraps an incoming requests handler, the trace context given by the client is used as parent
+func InstrumentHandler(next http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(next, operation)
+}
@@ -1,6 +1,10 @@
 package wmts
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // BBox represents a bounding box.
 type BBox struct {
@@ -21,6 +25,23 @@ func NewBBoxFromArray(arr []float64) (*BBox, error) {
 	return &BBox{XMin: arr[0], YMin: arr[1], XMax: arr[2], YMax: arr[3]}, nil
 }
 
+// ParseBBox converts a "xMin,yMin,xMax,yMax" string, like the -bbox flag of the commands, to an array of 4 float64
+func ParseBBox(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox should contain 4 comma separated values, got %d", len(parts))
+	}
+	bbox := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbox value %q: %w", part, err)
+		}
+		bbox[i] = v
+	}
+	return bbox, nil
+}
+
 // NewBBox creates a new BBox from the given values.
 func NewBBox(xMin, yMin, xMax, yMax float64) (*BBox, error) {
 	if xMin > xMax || yMin > yMax {
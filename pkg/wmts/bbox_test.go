@@ -0,0 +1,29 @@
+package wmts
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []float64
+		wantErr bool
+	}{
+		{"2535000,1150000,2545000,1157000", []float64{2535000, 1150000, 2545000, 1157000}, false},
+		{" 6.6, 46.5 ,6.7,46.6", []float64{6.6, 46.5, 6.7, 46.6}, false},
+		{"2535000,1150000,2545000", nil, true},
+		{"2535000,1150000,2545000,1157000,0", nil, true},
+		{"2535000,north,2545000,1157000", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseBBox(tt.s)
+			if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+				t.Errorf("ParseBBox(%q) = %v, %v, want %v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package wmts
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+)
+
+// ErrNotWebMercator is returned for a TileJSON of a layer whose grid is not WebMercatorQuad, the only one of TileJSON
+var ErrNotWebMercator = errors.New("the grid of the layer is not " + WebMercatorMatrixSet)
+
+// defaultDimensionName is the name of the dimension holding wmts_dimension_year when wmts_dimension_name is empty
+const defaultDimensionName = "Time"
+
+// RESTTileURL returns the REST url template of the tiles of the layer under baseURL, with the WMTS
+// variables {Style}, {TileMatrixSet}, {TileMatrix}, {TileRow}, {TileCol} and the one of the year dimension.
+// It follows the layout of GetWmtsImgPath, without the year folder for a layer without wmts_dimension_year.
+func (lc LayerConfig) RESTTileURL(baseURL string) string {
+	dimension := ""
+	if lc.WMTSDimensionYear != "" {
+		dimension = "/{" + lc.dimensionName() + "}"
+	}
+	return fmt.Sprintf("%s/%s/%s/{Style}%s/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.%s",
+		strings.TrimSuffix(baseURL, "/"), lc.WMTSURLPrefix, lc.Name, dimension, DefaultImageFormat)
+}
+
+func (lc LayerConfig) dimensionName() string {
+	if lc.WMTSDimensionName == "" {
+		return defaultDimensionName
+	}
+	return lc.WMTSDimensionName
+}
+
+// WMTSCapabilities returns a WMTS 1.0.0 capabilities document of the exported layers of state, sorted by name,
+// for the REST urls under baseURL. The tile matrix limits of a layer are its ranges, so the clients do not
+// request the tiles outside them.
+func WMTSCapabilities(state *LayersState, layers []ExportedLayer, baseURL string) ([]byte, error) {
+	layers = slices.Clone(layers)
+	slices.SortFunc(layers, func(a, b ExportedLayer) int { return strings.Compare(a.Name, b.Name) })
+	contents := wmtsContents{}
+	matrixSets := make(map[string]bool)
+	for _, exported := range layers {
+		if len(exported.Ranges) == 0 {
+			// nothing of the layer in the exported area
+			continue
+		}
+		name := exported.Name
+		lc, grid, exists := state.Layer(name)
+		if !exists {
+			return nil, fmt.Errorf("%w: %q", ErrLayerNotDefined, name)
+		}
+		layer := wmtsLayer{
+			Title:             lc.Title,
+			Abstract:          lc.Abstract,
+			Identifier:        name,
+			Style:             wmtsStyle{IsDefault: true, Identifier: lc.WMTSURLStyle},
+			Format:            "image/" + DefaultImageFormat,
+			TileMatrixSetLink: wmtsTileMatrixSetLink{TileMatrixSet: lc.WMTSMatrixSet},
+			ResourceURL: wmtsResourceURL{
+				Format:       "image/" + DefaultImageFormat,
+				ResourceType: "tile",
+				Template:     lc.RESTTileURL(baseURL),
+			},
+		}
+		if layer.Title == "" {
+			layer.Title = name
+		}
+		if lc.WMTSDimensionYear != "" {
+			layer.Dimension = &wmtsDimension{Identifier: lc.dimensionName(), Default: lc.WMTSDimensionYear, Value: lc.WMTSDimensionYear}
+		}
+		if toWGS84, err := coords.NewTransform(grid.SpatialREF, coords.WGS84); err == nil {
+			e := exported.Extent
+			west, south, east, north := toWGS84.BBox(e.XMin, e.YMin, e.XMax, e.YMax)
+			layer.WGS84BoundingBox = &wmtsBoundingBox{LowerCorner: formatCorner(west, south), UpperCorner: formatCorner(east, north)}
+		}
+		for _, r := range exported.Ranges {
+			layer.TileMatrixSetLink.Limits = append(layer.TileMatrixSetLink.Limits, wmtsTileMatrixLimits{
+				TileMatrix: strconv.Itoa(r.Zoom),
+				MinTileRow: r.MinRow,
+				MaxTileRow: r.MaxRow,
+				MinTileCol: r.MinCol,
+				MaxTileCol: r.MaxCol,
+			})
+		}
+		contents.Layers = append(contents.Layers, layer)
+		if !matrixSets[lc.WMTSMatrixSet] {
+			matrixSets[lc.WMTSMatrixSet] = true
+			contents.TileMatrixSets = append(contents.TileMatrixSets, newWMTSTileMatrixSet(lc.WMTSMatrixSet, grid))
+		}
+	}
+	capabilities := wmtsCapabilities{
+		Version:    "1.0.0",
+		Xmlns:      "http://www.opengis.net/wmts/1.0",
+		XmlnsOWS:   "http://www.opengis.net/ows/1.1",
+		XmlnsXLink: "http://www.w3.org/1999/xlink",
+		ServiceIdentification: wmtsServiceIdentification{
+			Title:              "go-wmts-tool static tiles",
+			ServiceType:        "OGC WMTS",
+			ServiceTypeVersion: "1.0.0",
+		},
+		Contents:           contents,
+		ServiceMetadataURL: wmtsServiceMetadataURL{Href: strings.TrimSuffix(baseURL, "/") + "/WMTSCapabilities.xml"},
+	}
+	data, err := xml.MarshalIndent(capabilities, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// newWMTSTileMatrixSet returns the description of all the zoom levels of the grid
+func newWMTSTileMatrixSet(name string, g *Grid) wmtsTileMatrixSet {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	set := wmtsTileMatrixSet{Identifier: name, SupportedCRS: fmt.Sprintf("urn:ogc:def:crs:EPSG::%d", g.SpatialREF)}
+	for zoom := g.MinZoom(); zoom <= g.MaxZoom(); zoom++ {
+		res, ok := g.resolutions[zoom]
+		if !ok {
+			continue
+		}
+		set.TileMatrices = append(set.TileMatrices, wmtsTileMatrix{
+			Identifier:       strconv.Itoa(zoom),
+			ScaleDenominator: res.ScaleDenominator,
+			TopLeftCorner:    formatCorner(g.topLeftX, g.topLeftY),
+			TileWidth:        int(g.TileSize),
+			TileHeight:       int(g.TileSize),
+			MatrixWidth:      g.GetMaxNumCols(zoom),
+			MatrixHeight:     g.GetMaxNumRows(zoom),
+		})
+	}
+	return set
+}
+
+func formatCorner(x, y float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64) + " " + strconv.FormatFloat(y, 'f', -1, 64)
+}
+
+// tileJSON is the TileJSON 3.0.0 document of a layer
+type tileJSON struct {
+	TileJSON    string     `json:"tilejson"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Scheme      string     `json:"scheme"`
+	Tiles       []string   `json:"tiles"`
+	MinZoom     int        `json:"minzoom"`
+	MaxZoom     int        `json:"maxzoom"`
+	Bounds      [4]float64 `json:"bounds"`
+	Center      [3]float64 `json:"center"`
+}
+
+// TileJSON returns the TileJSON document of an exported layer of state, for its REST urls under baseURL.
+// TileJSON only knows the WebMercatorQuad grid, the error wraps ErrNotWebMercator for the other layers.
+func TileJSON(state *LayersState, exported ExportedLayer, baseURL string) ([]byte, error) {
+	name := exported.Name
+	lc, grid, exists := state.Layer(name)
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", ErrLayerNotDefined, name)
+	}
+	if lc.WMTSMatrixSet != WebMercatorMatrixSet {
+		return nil, fmt.Errorf("%w: layer %s uses %s", ErrNotWebMercator, name, lc.WMTSMatrixSet)
+	}
+	if len(exported.Ranges) == 0 {
+		return nil, fmt.Errorf("layer %s has no tiles", name)
+	}
+	doc := tileJSON{
+		TileJSON:    "3.0.0",
+		Name:        lc.Title,
+		Description: lc.Abstract,
+		Scheme:      "xyz",
+		Tiles: []string{strings.NewReplacer(
+			"{Style}", lc.WMTSURLStyle,
+			"{"+lc.dimensionName()+"}", lc.WMTSDimensionYear,
+			"{TileMatrixSet}", lc.WMTSMatrixSet,
+			"{TileMatrix}", "{z}",
+			"{TileRow}", "{y}",
+			"{TileCol}", "{x}",
+		).Replace(lc.RESTTileURL(baseURL))},
+		MinZoom: grid.MaxZoom(),
+	}
+	if doc.Name == "" {
+		doc.Name = name
+	}
+	for _, r := range exported.Ranges {
+		doc.MinZoom, doc.MaxZoom = min(doc.MinZoom, r.Zoom), max(doc.MaxZoom, r.Zoom)
+	}
+	toWGS84, err := coords.NewTransform(grid.SpatialREF, coords.WGS84)
+	if err != nil {
+		return nil, err
+	}
+	e := exported.Extent
+	west, south, east, north := toWGS84.BBox(e.XMin, e.YMin, e.XMax, e.YMax)
+	doc.Bounds = [4]float64{west, south, east, north}
+	doc.Center = [3]float64{(west + east) / 2, (south + north) / 2, float64(doc.MinZoom)}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// The elements of the WMTS 1.0.0 capabilities document
+type (
+	wmtsCapabilities struct {
+		XMLName               xml.Name                  `xml:"Capabilities"`
+		Version               string                    `xml:"version,attr"`
+		Xmlns                 string                    `xml:"xmlns,attr"`
+		XmlnsOWS              string                    `xml:"xmlns:ows,attr"`
+		XmlnsXLink            string                    `xml:"xmlns:xlink,attr"`
+		ServiceIdentification wmtsServiceIdentification `xml:"ows:ServiceIdentification"`
+		Contents              wmtsContents              `xml:"Contents"`
+		ServiceMetadataURL    wmtsServiceMetadataURL    `xml:"ServiceMetadataURL"`
+	}
+	wmtsServiceIdentification struct {
+		Title              string `xml:"ows:Title"`
+		ServiceType        string `xml:"ows:ServiceType"`
+		ServiceTypeVersion string `xml:"ows:ServiceTypeVersion"`
+	}
+	wmtsServiceMetadataURL struct {
+		Href string `xml:"xlink:href,attr"`
+	}
+	wmtsContents struct {
+		Layers         []wmtsLayer         `xml:"Layer"`
+		TileMatrixSets []wmtsTileMatrixSet `xml:"TileMatrixSet"`
+	}
+	wmtsLayer struct {
+		Title             string                `xml:"ows:Title"`
+		Abstract          string                `xml:"ows:Abstract,omitempty"`
+		WGS84BoundingBox  *wmtsBoundingBox      `xml:"ows:WGS84BoundingBox"`
+		Identifier        string                `xml:"ows:Identifier"`
+		Style             wmtsStyle             `xml:"Style"`
+		Format            string                `xml:"Format"`
+		Dimension         *wmtsDimension        `xml:"Dimension"`
+		TileMatrixSetLink wmtsTileMatrixSetLink `xml:"TileMatrixSetLink"`
+		ResourceURL       wmtsResourceURL       `xml:"ResourceURL"`
+	}
+	wmtsBoundingBox struct {
+		LowerCorner string `xml:"ows:LowerCorner"`
+		UpperCorner string `xml:"ows:UpperCorner"`
+	}
+	wmtsStyle struct {
+		IsDefault  bool   `xml:"isDefault,attr"`
+		Identifier string `xml:"ows:Identifier"`
+	}
+	wmtsDimension struct {
+		Identifier string `xml:"ows:Identifier"`
+		Default    string `xml:"Default"`
+		Value      string `xml:"Value"`
+	}
+	wmtsTileMatrixSetLink struct {
+		TileMatrixSet string                 `xml:"TileMatrixSet"`
+		Limits        []wmtsTileMatrixLimits `xml:"TileMatrixSetLimits>TileMatrixLimits"`
+	}
+	wmtsTileMatrixLimits struct {
+		TileMatrix string `xml:"TileMatrix"`
+		MinTileRow int    `xml:"MinTileRow"`
+		MaxTileRow int    `xml:"MaxTileRow"`
+		MinTileCol int    `xml:"MinTileCol"`
+		MaxTileCol int    `xml:"MaxTileCol"`
+	}
+	wmtsResourceURL struct {
+		Format       string `xml:"format,attr"`
+		ResourceType string `xml:"resourceType,attr"`
+		Template     string `xml:"template,attr"`
+	}
+	wmtsTileMatrixSet struct {
+		Identifier   string           `xml:"ows:Identifier"`
+		SupportedCRS string           `xml:"ows:SupportedCRS"`
+		TileMatrices []wmtsTileMatrix `xml:"TileMatrix"`
+	}
+	wmtsTileMatrix struct {
+		Identifier       string  `xml:"ows:Identifier"`
+		ScaleDenominator float64 `xml:"ScaleDenominator"`
+		TopLeftCorner    string  `xml:"TopLeftCorner"`
+		TileWidth        int     `xml:"TileWidth"`
+		TileHeight       int     `xml:"TileHeight"`
+		MatrixWidth      int     `xml:"MatrixWidth"`
+		MatrixHeight     int     `xml:"MatrixHeight"`
+	}
+)
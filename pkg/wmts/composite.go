@@ -0,0 +1,227 @@
+package wmts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CompositeSource is one of the images drawn in the tiles of a composite layer:
+// the tiles of another configured layer, or a WMS request
+type CompositeSource struct {
+	Layer            string   `yaml:"layer"`              // name of a configured layer with the same wmts_matrix_set
+	WMSBackendURL    string   `yaml:"wms_backend_url"`    // or the WMS service URL
+	WMSBackendPrefix string   `yaml:"wms_backend_prefix"` // and its parameters, like for a layer
+	WMSLayers        string   `yaml:"wms_layers"`
+	Opacity          *float64 `yaml:"opacity"` // 0 to 1, default 1
+	Blend            string   `yaml:"blend"`   // normal (default), multiply, screen, overlay, darken or lighten
+}
+
+// GetOpacity returns the opacity of the source, 1 when not given
+func (cs CompositeSource) GetOpacity() float64 {
+	if cs.Opacity == nil {
+		return 1
+	}
+	return *cs.Opacity
+}
+
+// GetBlend returns the blend mode of the source, normal when not given
+func (cs CompositeSource) GetBlend() string {
+	if cs.Blend == "" {
+		return imgTools.BlendNormal
+	}
+	return cs.Blend
+}
+
+// String returns a string representation of the CompositeSource.
+func (cs CompositeSource) String() string {
+	if cs.Layer != "" {
+		return "layer " + cs.Layer
+	}
+	return fmt.Sprintf("WMS %s of %s", cs.WMSLayers, cs.WMSBackendURL)
+}
+
+// compositePart is a CompositeSource resolved against the configuration
+type compositePart struct {
+	source CompositeSource
+	tiles  *layerTiles // the tiles of the referenced layer, nil for a WMS request
+	grid   *Grid       // grid of the WMS request
+}
+
+// layerTiles gives the tiles of a configured layer used to build the tiles of another one. They are read from the
+// cache, a missing tile is fetched and cached like for a tile request of the layer.
+type layerTiles struct {
+	layer    LayerConfig
+	grid     *Grid
+	builder  TileBuilder // nil when the tiles are cut from WMS meta-tiles
+	basePath string
+	buffer   int
+	l        golog.MyLogger
+}
+
+// image returns the tile zoom, col, row of the layer
+func (t *layerTiles) image(ctx context.Context, client *http.Client, zoom, col, row int, retry func(url string) backend.RetryPolicy) (image.Image, error) {
+	lc := t.layer
+	imgPath := GetWmtsImgPath(t.basePath, lc.WMTSURLPrefix, lc.Name, lc.WMTSURLStyle, lc.WMTSDimensionYear, lc.WMTSMatrixSet, DefaultImageFormat, zoom, row, col)
+	data, err := os.ReadFile(imgPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		if t.builder != nil {
+			data, err = t.builder.SaveTile(ctx, client, zoom, col, row, imgPath, retry(t.builder.TileURL(zoom, col, row)))
+		} else {
+			var wmsURL string
+			if wmsURL, err = t.grid.GetTileWmsUrl(zoom, col, row, t.buffer, lc.WMSLayers); err == nil {
+				if err = tools.GetPngFromUrl(ctx, client, wmsURL, imgPath, t.buffer, lc.TileFilter(col, row, int(t.grid.TileSize)), retry(wmsURL), t.l); err == nil {
+					data, err = os.ReadFile(imgPath)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode tile %s: %w", imgPath, err)
+	}
+	return img, nil
+}
+
+// Compositor builds the tiles of a composite layer by drawing its sources from bottom to top.
+// The tiles of a referenced layer are taken from its cache, or fetched and cached like for a tile request of this layer.
+type Compositor struct {
+	grid     *Grid
+	layer    LayerConfig
+	parts    []compositePart
+	basePath string
+	buffer   int
+	l        golog.MyLogger
+}
+
+// NewCompositor returns the Compositor of a layer with the composite source_type. The grids and the builders
+// of the referenced layers are taken from state, they must use the grid of the layer and cannot be composite.
+func NewCompositor(lc LayerConfig, grid *Grid, state *LayersState, l golog.MyLogger) (*Compositor, error) {
+	if lc.Source() != SourceComposite {
+		return nil, fmt.Errorf("layer %s is not a composite layer", lc.Name)
+	}
+	if len(lc.Composite) == 0 {
+		return nil, fmt.Errorf("composite layer %s has no source", lc.Name)
+	}
+	c := &Compositor{
+		grid:     grid,
+		layer:    lc,
+		basePath: state.BasePath(),
+		buffer:   state.Settings.BufferSize,
+		l:        l,
+	}
+	for _, src := range lc.Composite {
+		part := compositePart{source: src}
+		if src.Layer != "" {
+			ref, refGrid, exists := state.Layer(src.Layer)
+			switch {
+			case !exists:
+				return nil, fmt.Errorf("composite source %s: unknown layer", src)
+			case ref.Source() == SourceComposite:
+				return nil, fmt.Errorf("composite source %s: a composite layer cannot be used as a source", src)
+			case ref.Source() == SourceReprojected:
+				return nil, fmt.Errorf("composite source %s: a reprojected layer cannot be used as a source", src)
+			case ref.WMTSMatrixSet != lc.WMTSMatrixSet:
+				return nil, fmt.Errorf("composite source %s: matrix set %s differs from %s", src, ref.WMTSMatrixSet, lc.WMTSMatrixSet)
+			}
+			part.tiles = &layerTiles{
+				layer:    ref,
+				grid:     refGrid,
+				builder:  state.Builder(src.Layer),
+				basePath: c.basePath,
+				buffer:   c.buffer,
+				l:        l,
+			}
+		} else {
+			wmsGrid, err := NewGridForMatrixSet(lc.WMTSMatrixSet, src.WMSBackendURL, src.WMSBackendPrefix, l)
+			if err != nil {
+				return nil, err
+			}
+			part.grid = wmsGrid
+		}
+		c.parts = append(c.parts, part)
+	}
+	return c, nil
+}
+
+// TileURL returns an empty string, a composite tile combines several requests
+func (c *Compositor) TileURL(zoom, col, row int) string {
+	return ""
+}
+
+// SaveTile builds a tile and writes it as a png to imgPath, all the requests use the retry policy
+func (c *Compositor) SaveTile(ctx context.Context, client *http.Client, zoom, col, row int, imgPath string, retry backend.RetryPolicy) ([]byte, error) {
+	img, err := c.build(ctx, client, zoom, col, row, func(string) backend.RetryPolicy { return retry })
+	if err != nil {
+		return nil, err
+	}
+	return writeTile(img, imgPath, c.layer.TileFilter(col, row, int(c.grid.TileSize)))
+}
+
+// SaveTiles saves the tiles of a meta-tile one by one, the requests use the retry policy of their backend
+func (c *Compositor) SaveTiles(ctx context.Context, client *http.Client, zoomLevel, startCol, startRow, numCols, numRows int, lc LayerConfig, basePath string) (numEmpty int, err error) {
+	return saveEachTile(c.grid, zoomLevel, startCol, startRow, numCols, numRows, lc, basePath, func(col, row int, imgPath string) ([]byte, error) {
+		img, err := c.build(ctx, client, zoomLevel, col, row, backend.Default.RetryPolicyForURL)
+		if err != nil {
+			return nil, err
+		}
+		return writeTile(img, imgPath, c.layer.TileFilter(col, row, int(c.grid.TileSize)))
+	})
+}
+
+// build draws the images of all the sources of the tile
+func (c *Compositor) build(ctx context.Context, client *http.Client, zoom, col, row int, retry func(url string) backend.RetryPolicy) (img image.Image, err error) {
+	ctx, span := tracing.Start(ctx, "wmts.Compositor.build", trace.WithAttributes(
+		attribute.Int("zoom", zoom),
+		attribute.Int("col", col),
+		attribute.Int("row", row),
+		attribute.Int("num_sources", len(c.parts)),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+	tileSize := int(c.grid.TileSize)
+	dst := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for _, part := range c.parts {
+		src, err := c.partImage(ctx, client, part, zoom, col, row, retry)
+		if err != nil {
+			return nil, fmt.Errorf("composite source %s: %w", part.source, err)
+		}
+		if err := imgTools.Composite(dst, src, part.source.GetOpacity(), part.source.GetBlend()); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// partImage returns the image of a source for the tile
+func (c *Compositor) partImage(ctx context.Context, client *http.Client, part compositePart, zoom, col, row int, retry func(url string) backend.RetryPolicy) (image.Image, error) {
+	if part.tiles == nil {
+		wmsURL, err := part.grid.GetTileWmsUrl(zoom, col, row, c.buffer, part.source.WMSLayers)
+		if err != nil {
+			return nil, err
+		}
+		size := int(part.grid.TileSize)
+		img, err := tools.FetchImage(ctx, client, wmsURL, size+2*c.buffer, size+2*c.buffer, retry(wmsURL), c.l)
+		if err != nil {
+			return nil, err
+		}
+		return imgTools.CropImage(ctx, img, c.buffer, c.l), nil
+	}
+	return part.tiles.image(ctx, client, zoom, col, row, retry)
+}
@@ -2,8 +2,11 @@ package wmts
 
 import (
 	"fmt"
-	"gopkg.in/yaml.v3"
 	"os"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"gopkg.in/yaml.v3"
 )
 
 // CacheConfig holds the configuration for a single cache
@@ -19,39 +22,62 @@ type Caches struct {
 
 // Config holds the entire YAML structure
 type Config struct {
-	Caches             *Caches                `yaml:"caches"`
-	LayerDefaultValues *LayerDefaultValues    `yaml:"layer_default_values"`
-	Layers             map[string]LayerConfig `yaml:"layers"`
+	Caches             *Caches                   `yaml:"caches"`
+	Settings           *SettingsConfig           `yaml:"settings"`
+	Backends           map[string]backend.Config `yaml:"backends"` // protection of the WMS backends by host
+	LayerDefaultValues *LayerDefaultValues       `yaml:"layer_default_values"`
+	Layers             map[string]LayerConfig    `yaml:"layers"`
 }
 
-// ConfigFromYAML reads a YAML file and returns a map of layer configurations
-func ConfigFromYAML(filePath string) (*Config, error) {
-	// Read the YAML file
+// readConfigFile reads a YAML file and replaces the ${VAR:-default} env variables references in it
+func readConfigFile(filePath string) ([]byte, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read YAML file: %v", err)
 	}
+	return config.InterpolateEnv(data)
+}
+
+// ConfigFromYAML reads a YAML file and returns a map of layer configurations.
+// References to env variables like ${VAR:-default} are replaced before parsing, see config.InterpolateEnv.
+// The content is then validated with ValidateConfigData, in case of problems
+// the returned error is a ValidationErrors listing all of them.
+func ConfigFromYAML(filePath string) (*Config, error) {
+	// Read the YAML file
+	data, err := readConfigFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if errs := ValidateConfigData(data); len(errs) > 0 {
+		return nil, errs
+	}
 	// Unmarshal YAML into Config struct
-	var config Config
-	err = yaml.Unmarshal(data, &config)
+	var cfg Config
+	err = yaml.Unmarshal(data, &cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal YAML: %v", err)
 	}
 
-	// Apply defaults to each layer where applicable
+	myConfig := &Config{
+		Caches:             cfg.Caches,
+		Settings:           cfg.Settings,
+		Backends:           cfg.Backends,
+		LayerDefaultValues: cfg.LayerDefaultValues,
+		Layers:             cfg.applyLayerDefaults(),
+	}
+
+	return myConfig, nil
+}
+
+// applyLayerDefaults returns the layers with the layer_default_values applied where applicable
+func (cfg *Config) applyLayerDefaults() map[string]LayerConfig {
 	layers := make(map[string]LayerConfig)
-	for name, layer := range config.Layers {
+	for name, layer := range cfg.Layers {
 		// If LayerDefaultValues is not explicitly set in the layer, use the global defaults
-		if layer.WMSBackendURL == "" && config.LayerDefaultValues != nil {
-			layer.LayerDefaultValues = *config.LayerDefaultValues
+		if layer.WMSBackendURL == "" && cfg.LayerDefaultValues != nil {
+			layer.LayerDefaultValues = *cfg.LayerDefaultValues
 		}
 		layers[name] = layer
 	}
-	myConfig := &Config{
-		Caches:             config.Caches,
-		LayerDefaultValues: config.LayerDefaultValues,
-		Layers:             layers,
-	}
-
-	return myConfig, nil
+	return layers
 }
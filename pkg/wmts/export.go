@@ -0,0 +1,253 @@
+package wmts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+)
+
+// MaxExportPlaceholders limits the empty tiles written by an export, the matrices of the detailed zoom levels are huge
+const MaxExportPlaceholders = 1_000_000
+
+// ExportOptions selects the tiles of an export, see Export
+type ExportOptions struct {
+	Dest       string   // folder receiving the tile tree, the capabilities and the TileJSON files
+	BaseURL    string   // url where Dest is published, used in the capabilities and in the TileJSON
+	Layers     []string // the exported layers, all of them when empty
+	MinZoom    int      // first exported zoom level, -1 for the min zoom of the grid of each layer
+	MaxZoom    int      // last exported zoom level, -1 for the max zoom of the grid of each layer
+	BBox       *BBox    // extent of the export, the wmts_bbox of each layer when nil
+	CRS        int      // spatial reference of BBox, 0 for the one of the grid of each layer
+	TileJSON   bool     // write a {layer}.json TileJSON file for the WebMercatorQuad layers
+	EmptyTiles bool     // write a transparent tile for every tile of the extent missing in the cache
+}
+
+// ExportedLayer counts the files written for a layer by Export
+type ExportedLayer struct {
+	Name         string
+	Extent       BBox        // the exported area, in the spatial reference of the grid of the layer
+	Ranges       []TileRange // the tiles of the extent in each zoom level
+	Tiles        int         // cached tiles copied
+	Placeholders int         // empty tiles written for the missing ones
+	TileJSON     bool        // whether the TileJSON file was written
+}
+
+// Export copies the cached tiles of the layers of state selected by opts into the folder opts.Dest, with the
+// layout of GetWmtsImgPath so it can be published as is on a web server or a CDN. It writes there a
+// WMTSCapabilities.xml describing them with REST urls under opts.BaseURL and, when asked, the TileJSON files
+// and the placeholders of the missing tiles. The tiles are not fetched, seed the extent before exporting it.
+// opts.Dest must be outside the cache, and must not contain it.
+func Export(ctx context.Context, state *LayersState, opts ExportOptions) ([]ExportedLayer, error) {
+	if err := checkExportDest(opts.Dest, state.BasePath()); err != nil {
+		return nil, err
+	}
+	names := opts.Layers
+	if len(names) == 0 {
+		for name := range state.Layers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if err := os.MkdirAll(opts.Dest, os.ModePerm); err != nil {
+		return nil, err
+	}
+	source := NewFileStore(state.BasePath())
+	dest := NewFileStore(opts.Dest)
+	var exported []ExportedLayer
+	for _, name := range names {
+		lc, grid, exists := state.Layer(name)
+		if !exists {
+			return exported, fmt.Errorf("%w: %q", ErrLayerNotDefined, name)
+		}
+		layer, err := exportRanges(name, lc, grid, opts)
+		if err != nil {
+			return exported, fmt.Errorf("layer %s: %w", name, err)
+		}
+		var placeholder []byte
+		if opts.EmptyTiles {
+			total := 0
+			for _, r := range layer.Ranges {
+				total += r.Count()
+			}
+			if total > MaxExportPlaceholders {
+				return exported, fmt.Errorf("layer %s: the extent has %d tiles, more than the %d empty tiles that can be written, reduce the zoom levels or the bbox", name, total, MaxExportPlaceholders)
+			}
+			if placeholder, err = emptyTilePNG(int(grid.TileSize)); err != nil {
+				return exported, err
+			}
+		}
+		for _, r := range layer.Ranges {
+			if err := exportTiles(ctx, source, dest, lc, r, placeholder, &layer); err != nil {
+				return exported, fmt.Errorf("layer %s: %w", name, err)
+			}
+		}
+		if opts.TileJSON && lc.WMTSMatrixSet == WebMercatorMatrixSet && len(layer.Ranges) > 0 {
+			data, err := TileJSON(state, layer, opts.BaseURL)
+			if err != nil {
+				return exported, err
+			}
+			if err := os.WriteFile(filepath.Join(opts.Dest, name+".json"), data, 0644); err != nil {
+				return exported, fmt.Errorf("cannot write the TileJSON of layer %s: %w", name, err)
+			}
+			layer.TileJSON = true
+		}
+		exported = append(exported, layer)
+	}
+	data, err := WMTSCapabilities(state, exported, opts.BaseURL)
+	if err != nil {
+		return exported, err
+	}
+	if err := os.WriteFile(filepath.Join(opts.Dest, "WMTSCapabilities.xml"), data, 0644); err != nil {
+		return exported, fmt.Errorf("cannot write the capabilities: %w", err)
+	}
+	return exported, nil
+}
+
+// exportRanges returns the layer with the tile ranges of the export extent, in the zoom levels of opts
+func exportRanges(name string, lc LayerConfig, grid *Grid, opts ExportOptions) (ExportedLayer, error) {
+	layer := ExportedLayer{Name: name}
+	extent := grid.GetBBox()
+	if bbox, err := NewBBoxFromArray(lc.WMTSBBox); err == nil {
+		extent = *bbox
+	}
+	if opts.BBox != nil {
+		bbox := *opts.BBox
+		if opts.CRS != 0 && opts.CRS != grid.SpatialREF {
+			transform, err := coords.NewTransform(opts.CRS, grid.SpatialREF)
+			if err != nil {
+				return layer, err
+			}
+			bbox.XMin, bbox.YMin, bbox.XMax, bbox.YMax = transform.BBox(bbox.XMin, bbox.YMin, bbox.XMax, bbox.YMax)
+		}
+		if !extent.Intersects(bbox) {
+			return layer, nil
+		}
+		extent = BBox{
+			XMin: max(extent.XMin, bbox.XMin),
+			YMin: max(extent.YMin, bbox.YMin),
+			XMax: min(extent.XMax, bbox.XMax),
+			YMax: min(extent.YMax, bbox.YMax),
+		}
+	}
+	layer.Extent = extent
+	minZoom, maxZoom := opts.MinZoom, opts.MaxZoom
+	if minZoom < 0 {
+		minZoom = grid.MinZoom()
+	}
+	if maxZoom < 0 {
+		maxZoom = grid.MaxZoom()
+	}
+	for zoom := minZoom; zoom <= maxZoom; zoom++ {
+		r, err := grid.TileRange(extent, zoom)
+		if err != nil {
+			return layer, err
+		}
+		if !r.IsEmpty() {
+			layer.Ranges = append(layer.Ranges, r)
+		}
+	}
+	return layer, nil
+}
+
+// exportTiles copies the cached tiles of the range, the missing ones are replaced by placeholder when not nil.
+// Without placeholder only the rows in the cache are visited, a range is often far larger than what was seeded.
+func exportTiles(ctx context.Context, source, dest *FileStore, lc LayerConfig, r TileRange, placeholder []byte, layer *ExportedLayer) error {
+	rows, err := source.Rows(lc, r.Zoom, r.MinRow, r.MaxRow)
+	if err != nil {
+		return err
+	}
+	if placeholder != nil {
+		rows = nil
+		for row := r.MinRow; row <= r.MaxRow; row++ {
+			rows = append(rows, row)
+		}
+	}
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cols, err := source.Cols(lc, r.Zoom, row, r.MinCol, r.MaxCol)
+		if err != nil {
+			return err
+		}
+		cached := make(map[int]bool, len(cols))
+		for _, col := range cols {
+			if err := copyFile(source.TilePath(lc, r.Zoom, row, col), dest.TilePath(lc, r.Zoom, row, col)); err != nil {
+				return fmt.Errorf("tile zoom:%d, row:%d, col:%d: %w", r.Zoom, row, col, err)
+			}
+			cached[col] = true
+			layer.Tiles++
+		}
+		if placeholder == nil {
+			continue
+		}
+		for col := r.MinCol; col <= r.MaxCol; col++ {
+			if cached[col] {
+				continue
+			}
+			path := dest.TilePath(lc, r.Zoom, row, col)
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			if err := tools.WriteFileAtomic(path, placeholder); err != nil {
+				return fmt.Errorf("tile zoom:%d, row:%d, col:%d: %w", r.Zoom, row, col, err)
+			}
+			layer.Placeholders++
+		}
+	}
+	return nil
+}
+
+// checkExportDest refuses a dest folder which is the cache folder basePath, is inside it or contains it,
+// the export would then overwrite the cached tiles or copy its own files
+func checkExportDest(dest, basePath string) error {
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return fmt.Errorf("invalid export folder %q: %w", dest, err)
+	}
+	absBase, err := filepath.Abs(basePath)
+	if err != nil {
+		return fmt.Errorf("invalid cache folder %q: %w", basePath, err)
+	}
+	if isSubPath(absBase, absDest) || isSubPath(absDest, absBase) {
+		return fmt.Errorf("the export folder %s overlaps the cache folder %s, choose a folder outside the cache", absDest, absBase)
+	}
+	return nil
+}
+
+// isSubPath tells if the absolute path is parent or one of its descendants
+func isSubPath(parent, path string) bool {
+	rel, err := filepath.Rel(parent, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// copyFile copies the file src to dst, creating its folder. dst is written in a temporary file renamed once
+// complete, so a web server publishing dest never serves a partial tile.
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return tools.WriteFileAtomic(dst, data)
+}
+
+// emptyTilePNG returns a transparent png tile
+func emptyTilePNG(tileSize int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
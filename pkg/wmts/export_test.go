@@ -0,0 +1,114 @@
+package wmts
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	l := newTestLogger(t)
+	layer := LayerConfig{
+		LayerDefaultValues: LayerDefaultValues{
+			WMTSURLPrefix:     "tiles/1.0.0",
+			WMTSURLStyle:      "default",
+			WMTSDimensionName: "DATE",
+			WMTSDimensionYear: "2021",
+			WMTSMatrixSet:     WebMercatorMatrixSet,
+		},
+		Name: "osm",
+	}
+	cfg := &Config{Layers: map[string]LayerConfig{"osm": layer}}
+	state, err := NewLayersState(cfg, Settings{CacheFolder: t.TempDir()}, l)
+	if err != nil {
+		t.Fatalf("NewLayersState: %v", err)
+	}
+	cache := NewFileStore(state.BasePath())
+	// the 4 tiles of the zoom level 1, only 3 of them are cached
+	for _, tile := range [][2]int{{0, 0}, {0, 1}, {1, 0}} {
+		path := cache.TilePath(layer, 1, tile[0], tile[1])
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("tile"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := t.TempDir()
+	exported, err := Export(context.Background(), state, ExportOptions{
+		Dest:       dest,
+		BaseURL:    "https://cdn.example.com/",
+		MinZoom:    1,
+		MaxZoom:    1,
+		TileJSON:   true,
+		EmptyTiles: true,
+	})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(exported) != 1 || exported[0].Tiles != 3 || exported[0].Placeholders != 1 || !exported[0].TileJSON {
+		t.Fatalf("Export() = %+v, want 3 tiles, 1 placeholder and a TileJSON", exported)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "tiles/1.0.0/osm/default/2021/WebMercatorQuad/1/0/1.png")); err != nil || string(data) != "tile" {
+		t.Errorf("copied tile = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "tiles/1.0.0/osm/default/2021/WebMercatorQuad/1/1/1.png")); err != nil {
+		t.Errorf("missing placeholder: %v", err)
+	}
+
+	capabilities, err := os.ReadFile(filepath.Join(dest, "WMTSCapabilities.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := `template="https://cdn.example.com/tiles/1.0.0/osm/{Style}/{DATE}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.png"`
+	if !strings.Contains(string(capabilities), template) {
+		t.Errorf("capabilities without %s", template)
+	}
+	var tileJSON struct {
+		Tiles   []string
+		MinZoom int
+		MaxZoom int
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "osm.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &tileJSON); err != nil {
+		t.Fatal(err)
+	}
+	want := "https://cdn.example.com/tiles/1.0.0/osm/default/2021/WebMercatorQuad/{z}/{y}/{x}.png"
+	if len(tileJSON.Tiles) != 1 || tileJSON.Tiles[0] != want || tileJSON.MinZoom != 1 || tileJSON.MaxZoom != 1 {
+		t.Errorf("TileJSON = %+v, want tiles %s at zoom 1", tileJSON, want)
+	}
+}
+
+func TestExportDestOverlap(t *testing.T) {
+	cacheFolder := filepath.Join(t.TempDir(), "cache")
+	state, err := NewLayersState(&Config{}, Settings{CacheFolder: cacheFolder}, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("NewLayersState: %v", err)
+	}
+	sibling := filepath.Join(filepath.Dir(cacheFolder), "cache-export")
+	for _, tt := range []struct {
+		name    string
+		dest    string
+		wantErr bool
+	}{
+		{"cache folder", cacheFolder, true},
+		{"cache folder not cleaned", cacheFolder + "/tiles/..", true},
+		{"inside the cache", filepath.Join(cacheFolder, "export"), true},
+		{"containing the cache", filepath.Dir(cacheFolder), true},
+		{"sibling with the same prefix", sibling, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Export(context.Background(), state, ExportOptions{Dest: tt.dest, MinZoom: -1, MaxZoom: -1})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Export(%s) error = %v, want error %v", tt.dest, err, tt.wantErr)
+			}
+		})
+	}
+}
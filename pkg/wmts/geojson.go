@@ -0,0 +1,213 @@
+package wmts
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Geometry is a GeoJSON geometry reduced to what is needed to find the tiles it touches.
+// Coordinates are expected in the spatial reference of the grid (LV95 EPSG:2056 for the Lausanne grid).
+type Geometry struct {
+	Points   [][2]float64
+	Lines    [][][2]float64
+	Polygons [][][][2]float64 // each polygon is a list of rings, the first one being the exterior
+}
+
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Features    []geoJSONObject `json:"features"`
+}
+
+// ParseGeoJSON reads a GeoJSON geometry, Feature or FeatureCollection
+func ParseGeoJSON(data []byte) (*Geometry, error) {
+	var obj geoJSONObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	g := &Geometry{}
+	if err := g.add(&obj); err != nil {
+		return nil, err
+	}
+	if len(g.Points) == 0 && len(g.Lines) == 0 && len(g.Polygons) == 0 {
+		return nil, fmt.Errorf("GeoJSON does not contain any coordinates")
+	}
+	return g, nil
+}
+
+func (g *Geometry) add(obj *geoJSONObject) error {
+	var err error
+	switch obj.Type {
+	case "FeatureCollection":
+		for i := range obj.Features {
+			if err = g.add(&obj.Features[i]); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if obj.Geometry == nil {
+			return fmt.Errorf("GeoJSON Feature without geometry")
+		}
+		return g.add(obj.Geometry)
+	case "GeometryCollection":
+		for i := range obj.Geometries {
+			if err = g.add(&obj.Geometries[i]); err != nil {
+				return err
+			}
+		}
+	case "Point":
+		var p [2]float64
+		if err = json.Unmarshal(obj.Coordinates, &p); err == nil {
+			g.Points = append(g.Points, p)
+		}
+	case "MultiPoint":
+		var pts [][2]float64
+		if err = json.Unmarshal(obj.Coordinates, &pts); err == nil {
+			g.Points = append(g.Points, pts...)
+		}
+	case "LineString":
+		var line [][2]float64
+		if err = json.Unmarshal(obj.Coordinates, &line); err == nil {
+			g.Lines = append(g.Lines, line)
+		}
+	case "MultiLineString":
+		var lines [][][2]float64
+		if err = json.Unmarshal(obj.Coordinates, &lines); err == nil {
+			g.Lines = append(g.Lines, lines...)
+		}
+	case "Polygon":
+		var polygon [][][2]float64
+		if err = json.Unmarshal(obj.Coordinates, &polygon); err == nil {
+			g.Polygons = append(g.Polygons, polygon)
+		}
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err = json.Unmarshal(obj.Coordinates, &polygons); err == nil {
+			g.Polygons = append(g.Polygons, polygons...)
+		}
+	default:
+		return fmt.Errorf("unsupported GeoJSON type %q", obj.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid coordinates for GeoJSON %s: %w", obj.Type, err)
+	}
+	return nil
+}
+
+// BBox returns the bounding box of the geometry
+func (g *Geometry) BBox() BBox {
+	first := true
+	var b BBox
+	extend := func(p [2]float64) {
+		if first {
+			b = BBox{XMin: p[0], YMin: p[1], XMax: p[0], YMax: p[1]}
+			first = false
+			return
+		}
+		b.XMin = min(b.XMin, p[0])
+		b.YMin = min(b.YMin, p[1])
+		b.XMax = max(b.XMax, p[0])
+		b.YMax = max(b.YMax, p[1])
+	}
+	for _, p := range g.Points {
+		extend(p)
+	}
+	for _, line := range g.Lines {
+		for _, p := range line {
+			extend(p)
+		}
+	}
+	for _, polygon := range g.Polygons {
+		for _, ring := range polygon {
+			for _, p := range ring {
+				extend(p)
+			}
+		}
+	}
+	return b
+}
+
+// IntersectsBBox checks if the geometry touches the bounding box
+func (g *Geometry) IntersectsBBox(b BBox) bool {
+	for _, p := range g.Points {
+		if pointInBBox(p, b) {
+			return true
+		}
+	}
+	for _, line := range g.Lines {
+		if pathIntersectsBBox(line, b) {
+			return true
+		}
+	}
+	for _, polygon := range g.Polygons {
+		for _, ring := range polygon {
+			if pathIntersectsBBox(ring, b) {
+				return true
+			}
+		}
+		// the bbox may be entirely inside the polygon, without any edge crossing it
+		center := [2]float64{(b.XMin + b.XMax) / 2, (b.YMin + b.YMax) / 2}
+		if len(polygon) > 0 && pointInRing(center, polygon[0]) {
+			inHole := false
+			for _, hole := range polygon[1:] {
+				if pointInRing(center, hole) {
+					inHole = true
+					break
+				}
+			}
+			if !inHole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func pointInBBox(p [2]float64, b BBox) bool {
+	return p[0] >= b.XMin && p[0] <= b.XMax && p[1] >= b.YMin && p[1] <= b.YMax
+}
+
+// pathIntersectsBBox checks if one vertex of the path is inside b or if one segment crosses an edge of b
+func pathIntersectsBBox(path [][2]float64, b BBox) bool {
+	corners := [4][2]float64{{b.XMin, b.YMin}, {b.XMax, b.YMin}, {b.XMax, b.YMax}, {b.XMin, b.YMax}}
+	for i, p := range path {
+		if pointInBBox(p, b) {
+			return true
+		}
+		if i == 0 {
+			continue
+		}
+		for c := 0; c < 4; c++ {
+			if segmentsIntersect(path[i-1], p, corners[c], corners[(c+1)%4]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func orientation(a, b, c [2]float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+func segmentsIntersect(p1, p2, q1, q2 [2]float64) bool {
+	d1 := orientation(q1, q2, p1)
+	d2 := orientation(q1, q2, p2)
+	d3 := orientation(p1, p2, q1)
+	d4 := orientation(p1, p2, q2)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// pointInRing uses the ray casting algorithm
+func pointInRing(p [2]float64, ring [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,92 @@
+package wmts
+
+import (
+	"testing"
+)
+
+func TestParseGeoJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantBBox BBox
+		wantErr  bool
+	}{
+		{"point", `{"type":"Point","coordinates":[2538000,1152000]}`, BBox{XMin: 2538000, YMin: 1152000, XMax: 2538000, YMax: 1152000}, false},
+		{
+			"polygon with a hole",
+			`{"type":"Polygon","coordinates":[[[0,0],[30,0],[30,30],[0,30],[0,0]],[[10,10],[20,10],[20,20],[10,20],[10,10]]]}`,
+			BBox{XMax: 30, YMax: 30}, false,
+		},
+		{
+			"multi polygon",
+			`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[5,5],[6,5],[6,7],[5,5]]]]}`,
+			BBox{XMax: 6, YMax: 7}, false,
+		},
+		{
+			"feature collection",
+			`{"type":"FeatureCollection","features":[
+				{"type":"Feature","properties":{},"geometry":{"type":"LineString","coordinates":[[-2,1],[3,4]]}},
+				{"type":"Feature","geometry":{"type":"MultiPoint","coordinates":[[8,-1]]}}]}`,
+			BBox{XMin: -2, YMin: -1, XMax: 8, YMax: 4}, false,
+		},
+		{
+			"geometry collection",
+			`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"MultiLineString","coordinates":[[[3,4],[5,6]]]}]}`,
+			BBox{XMin: 1, YMin: 2, XMax: 5, YMax: 6}, false,
+		},
+		{"invalid json", `{"type":"Point"`, BBox{}, true},
+		{"unsupported type", `{"type":"Circle","coordinates":[0,0]}`, BBox{}, true},
+		{"feature without geometry", `{"type":"Feature","properties":{}}`, BBox{}, true},
+		{"invalid coordinates", `{"type":"Polygon","coordinates":[[0,0],[1,1]]}`, BBox{}, true},
+		{"empty feature collection", `{"type":"FeatureCollection","features":[]}`, BBox{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := ParseGeoJSON([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGeoJSON() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && g.BBox() != tt.wantBBox {
+				t.Errorf("ParseGeoJSON().BBox() = %v, want %v", g.BBox(), tt.wantBBox)
+			}
+		})
+	}
+}
+
+func TestGeometryIntersectsBBox(t *testing.T) {
+	square := func(xMin, yMin, xMax, yMax float64) [][2]float64 {
+		return [][2]float64{{xMin, yMin}, {xMax, yMin}, {xMax, yMax}, {xMin, yMax}, {xMin, yMin}}
+	}
+	tile := BBox{XMin: 0, YMin: 0, XMax: 10, YMax: 10}
+	tests := []struct {
+		name string
+		g    Geometry
+		want bool
+	}{
+		{"point inside", Geometry{Points: [][2]float64{{5, 5}}}, true},
+		{"point on the edge", Geometry{Points: [][2]float64{{10, 5}}}, true},
+		{"point outside", Geometry{Points: [][2]float64{{11, 5}}}, false},
+		{"line with a vertex inside", Geometry{Lines: [][][2]float64{{{-5, -5}, {5, 5}}}}, true},
+		{"line crossing without vertex inside", Geometry{Lines: [][][2]float64{{{-5, 5}, {15, 5}}}}, true},
+		{"diagonal line crossing a corner area", Geometry{Lines: [][][2]float64{{{-5, 8}, {8, -5}}}}, true},
+		{"line passing by", Geometry{Lines: [][][2]float64{{{-5, 12}, {15, 20}, {15, -5}}}}, false},
+		{"polygon inside the tile", Geometry{Polygons: [][][][2]float64{{square(2, 2, 4, 4)}}}, true},
+		{"polygon enclosing the whole tile", Geometry{Polygons: [][][][2]float64{{square(-10, -10, 20, 20)}}}, true},
+		{"polygon overlapping a corner", Geometry{Polygons: [][][][2]float64{{square(8, 8, 20, 20)}}}, true},
+		{"polygon apart", Geometry{Polygons: [][][][2]float64{{square(20, 20, 30, 30)}}}, false},
+		{"tile inside the hole of a polygon", Geometry{Polygons: [][][][2]float64{{square(-20, -20, 30, 30), square(-10, -10, 20, 20)}}}, false},
+		{"hole inside the tile", Geometry{Polygons: [][][][2]float64{{square(-20, -20, 30, 30), square(2, 2, 8, 8)}}}, true},
+		{"tile across the edge of a hole", Geometry{Polygons: [][][][2]float64{{square(-20, -20, 30, 30), square(5, -10, 20, 20)}}}, true},
+		{
+			"second polygon of a multi polygon",
+			Geometry{Polygons: [][][][2]float64{{square(20, 20, 30, 30)}, {square(-10, -10, 20, 20), square(-5, -5, 1, 1)}}}, true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.g.IntersectsBBox(tile); got != tt.want {
+				t.Errorf("IntersectsBBox() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
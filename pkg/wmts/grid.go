@@ -1,6 +1,9 @@
 package wmts
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
@@ -10,9 +13,23 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
 	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The errors of the grid methods wrap one of these, test them with errors.Is
+var (
+	// ErrZoomOutOfRange is returned for a zoom level the grid does not have
+	ErrZoomOutOfRange = errors.New("zoom level out of range")
+	// ErrTileOutOfMatrix is returned for a column or a row outside the tile matrix of the zoom level
+	ErrTileOutOfMatrix = errors.New("tile outside the tile matrix")
+	// ErrOutsideGridExtent is returned for coordinates outside the tiles of the grid
+	ErrOutsideGridExtent = errors.New("coordinates outside the grid extent")
 )
 
 // Resolution defines the properties for a WMTS grid zoom level.
@@ -44,20 +61,27 @@ type Grid struct {
 }
 
 // GetTile calculates the tile indices (col, row) for a given coordinate and zoom level.
+// The error wraps ErrOutsideGridExtent when the coordinate is outside the tile matrix of the zoom level.
 func (g *Grid) GetTile(coordX, coordY float64, zoomLevel int) (int, int, error) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	if _, ok := g.resolutions[zoomLevel]; !ok {
-		return 0, 0, fmt.Errorf("unsupported zoom level: %d. Please choose between 0 and %d", zoomLevel, g.MaxZoom())
+	zoomInfo, ok := g.resolutions[zoomLevel]
+	if !ok {
+		return 0, 0, g.zoomError(zoomLevel)
 	}
+	span := g.TileSize * zoomInfo.CellSize
+	col := math.Floor((coordX - g.topLeftX) / span)
+	row := math.Floor((g.topLeftY - coordY) / span)
+	// written to be false for NaN coordinates too
+	if !(col >= 0 && col < float64(g.GetMaxNumCols(zoomLevel)) && row >= 0 && row < float64(g.GetMaxNumRows(zoomLevel))) {
+		return 0, 0, fmt.Errorf("%w: x:%f, y:%f at zoom level %d", ErrOutsideGridExtent, coordX, coordY, zoomLevel)
+	}
+	return int(col), int(row), nil
+}
 
-	zoomInfo := g.resolutions[zoomLevel]
-	resolution := zoomInfo.CellSize
-
-	tileCol := int((coordX - g.topLeftX) / (g.TileSize * resolution))
-	tileRow := int((g.topLeftY - coordY) / (g.TileSize * resolution))
-
-	return tileCol, tileRow, nil
+// zoomError returns the error wrapping ErrZoomOutOfRange for an unknown zoom level
+func (g *Grid) zoomError(zoomLevel int) error {
+	return fmt.Errorf("%w: %d, please choose between %d and %d", ErrZoomOutOfRange, zoomLevel, g.MinZoom(), g.MaxZoom())
 }
 
 // MaxZoom returns the maximum supported zoom level.
@@ -100,40 +124,30 @@ func (g *Grid) MinZoom() int {
 
 // IsValidTile checks if the given tile indices are valid for the specified zoom level.
 func (g *Grid) IsValidTile(zoomLevel, tileCol, tileRow int) bool {
+	return g.CheckTile(zoomLevel, tileCol, tileRow) == nil
+}
+
+// CheckTile returns an error wrapping ErrZoomOutOfRange or ErrTileOutOfMatrix when the tile is not in the grid
+func (g *Grid) CheckTile(zoomLevel, tileCol, tileRow int) error {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 	if _, ok := g.resolutions[zoomLevel]; !ok {
-		return false
-	}
-	if tileCol < 0 || tileCol > g.GetMaxNumCols(zoomLevel) {
-		return false
+		return g.zoomError(zoomLevel)
 	}
-	if tileRow < 0 || tileRow > g.GetMaxNumRows(zoomLevel) {
-		return false
+	maxCols, maxRows := g.GetMaxNumCols(zoomLevel), g.GetMaxNumRows(zoomLevel)
+	if tileCol < 0 || tileCol >= maxCols || tileRow < 0 || tileRow >= maxRows {
+		return fmt.Errorf("%w: zoom:%d, col:%d, row:%d, the matrix has %d columns and %d rows",
+			ErrTileOutOfMatrix, zoomLevel, tileCol, tileRow, maxCols, maxRows)
 	}
-	return true
+	return nil
 }
 
-// GetTileBBox calculates the bounding box for a given tile.
+// GetTileBBox calculates the bounding box for a given tile, the error is the one of CheckTile for a tile not in the grid.
 func (g *Grid) GetTileBBox(zoomLevel, tileCol, tileRow int) (*BBox, error) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	if !g.IsValidTile(zoomLevel, tileCol, tileRow) {
-		maxCols := g.GetMaxNumCols(zoomLevel)
-		maxRows := g.GetMaxNumRows(zoomLevel)
-
-		if _, ok := g.resolutions[zoomLevel]; !ok {
-			return nil, fmt.Errorf("unsupported zoom level. Please choose between 0 and %d", g.MaxZoom())
-		}
-		if tileCol < 0 || tileCol > maxCols {
-			return nil, fmt.Errorf("invalid column index. Please choose between 0 and %d", g.GetMaxNumCols(zoomLevel))
-		}
-		if tileRow < 0 || tileRow > maxRows {
-			return nil, fmt.Errorf("invalid row index. Please choose between 0 and %d", g.GetMaxNumRows(zoomLevel))
-		}
-
-		return nil, fmt.Errorf("invalid tile indices: zoom=%d, col=%d (max=%d), row=%d (max=%d)",
-			zoomLevel, tileCol, maxCols, tileRow, maxRows) // Should not happen based on previous checks, but good practice.
+	if err := g.CheckTile(zoomLevel, tileCol, tileRow); err != nil {
+		return nil, err
 	}
 
 	zoomInfo := g.resolutions[zoomLevel]
@@ -184,44 +198,42 @@ func (g *Grid) GetWidth() float64 {
 	return g.Bbox.XMax - g.Bbox.XMin
 }
 
-// GetMaxNumRows returns the maximum number of rows for a given zoom level.
+// GetMaxNumRows returns the maximum number of rows for a given zoom level, 0 for a zoom level not in the grid.
 func (g *Grid) GetMaxNumRows(zoomLevel int) int {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	if _, ok := g.resolutions[zoomLevel]; !ok {
-		panic(fmt.Sprintf("Unsupported zoom level. Please choose between 0 and %d.", g.MaxZoom()))
+	zoomInfo, ok := g.resolutions[zoomLevel]
+	if !ok {
+		return 0
 	}
-	zoomInfo := g.resolutions[zoomLevel]
 	if zoomInfo.MatrixHeight != 0 {
 		return int(zoomInfo.MatrixHeight)
 	}
-	cellSize := zoomInfo.CellSize
-	if cellSize == 0 {
-		panic(fmt.Sprintf("cellSize was not found for zoom_level %d", zoomLevel))
+	if zoomInfo.CellSize == 0 {
+		return 0
 	}
-	return int(math.Round(g.GetHeight() / (g.TileSize * cellSize)))
+	return int(math.Round(g.GetHeight() / (g.TileSize * zoomInfo.CellSize)))
 }
 
-// GetMaxNumCols returns the maximum number of columns for a given zoom level.
+// GetMaxNumCols returns the maximum number of columns for a given zoom level, 0 for a zoom level not in the grid.
 func (g *Grid) GetMaxNumCols(zoomLevel int) int {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	if _, ok := g.resolutions[zoomLevel]; !ok {
-		panic(fmt.Sprintf("Unsupported zoom level. Please choose between 0 and %d.", g.MaxZoom()))
+	zoomInfo, ok := g.resolutions[zoomLevel]
+	if !ok {
+		return 0
 	}
-	zoomInfo := g.resolutions[zoomLevel]
 	if zoomInfo.MatrixWidth != 0 {
 		return int(zoomInfo.MatrixWidth)
 	}
-	cellSize := zoomInfo.CellSize
-	if cellSize == 0 {
-		panic(fmt.Sprintf("cellSize was not found for zoom_level %d", zoomLevel))
+	if zoomInfo.CellSize == 0 {
+		return 0
 	}
-	return int(math.Round(g.GetWidth() / (g.TileSize * cellSize)))
+	return int(math.Round(g.GetWidth() / (g.TileSize * zoomInfo.CellSize)))
 }
 
 // SaveTileImage get the wms request for a given tile and save the png file in the local cache path
-func (g *Grid) SaveTileImage(zoomLevel, tileCol, tileRow, buffer int, lc LayerConfig, basePath string, client *http.Client) (string, error) {
+func (g *Grid) SaveTileImage(ctx context.Context, zoomLevel, tileCol, tileRow, buffer int, lc LayerConfig, basePath string, client *http.Client) (string, error) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 	bbox, err := g.GetTileBBox(zoomLevel, tileCol, tileRow)
@@ -233,7 +245,7 @@ func (g *Grid) SaveTileImage(zoomLevel, tileCol, tileRow, buffer int, lc LayerCo
 	params := g.GetWMSParams(*bbox, layers, int(g.GetTileWidth()), int(g.GetTileHeight()), buffer, DefaultImageFormat) // Use GetTileWidth
 	wmsURL := fmt.Sprintf("%s?%s%s", g.WmsBackendUrl, g.WmsStartParams, tools.BuildQueryString(params))
 	imgPath := GetWmtsImgPath(basePath, lc.WMTSURLPrefix, lc.Name, lc.WMTSURLStyle, lc.WMTSDimensionYear, lc.WMTSMatrixSet, DefaultImageFormat, zoomLevel, tileRow, tileCol)
-	err = tools.GetPngFromUrl(client, wmsURL, imgPath, buffer, 2, g.l)
+	err = tools.GetPngFromUrl(ctx, client, wmsURL, imgPath, buffer, lc.TileFilter(tileCol, tileRow, int(g.GetTileWidth())), backend.Default.RetryPolicyForURL(g.WmsBackendUrl), g.l)
 	if err != nil {
 		errMsg := fmt.Sprintf("error in GetPngFromUrl tile  zoom:%d, col:%d, row:%d", zoomLevel, tileCol, tileRow)
 		return errMsg, err
@@ -257,20 +269,31 @@ func (g *Grid) GetTileWmsUrl(zoomLevel, tileCol, tileRow, buffer int, layers str
 // SaveTilesFromMetaTile fetches a larger image (a "meta-tile") from the WMS server,
 // splits it into individual tiles, and saves them to the local cache.
 // This approach reduces the number of HTTP requests, improving performance.
-func (g *Grid) SaveTilesFromMetaTile(zoomLevel, startCol, startRow, numCols, numRows, buffer int, lc LayerConfig, basePath string, client *http.Client) error {
+// It returns the number of saved tiles detected as empty, see LayerConfig.IsEmptyTile.
+// The WMS request, decoding, crop and writes are traced as children of the span in ctx.
+func (g *Grid) SaveTilesFromMetaTile(ctx context.Context, zoomLevel, startCol, startRow, numCols, numRows, buffer int, lc LayerConfig, basePath string, client *http.Client) (numEmpty int, err error) {
+	ctx, span := tracing.Start(ctx, "wmts.SaveTilesFromMetaTile", trace.WithAttributes(
+		attribute.String("layer", lc.Name),
+		attribute.Int("zoom", zoomLevel),
+		attribute.Int("start_col", startCol),
+		attribute.Int("start_row", startRow),
+		attribute.Int("num_cols", numCols),
+		attribute.Int("num_rows", numRows),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 	// 1. Calculate the bounding box for the entire meta-tile.
 	// BBox of the top-left tile
 	topLeftBBox, err := g.GetTileBBox(zoomLevel, startCol, startRow)
 	if err != nil {
-		return fmt.Errorf("failed to get bounding box for top-left tile: %w", err)
+		return 0, fmt.Errorf("failed to get bounding box for top-left tile: %w", err)
 	}
 
 	// BBox of the bottom-right tile
 	bottomRightBBox, err := g.GetTileBBox(zoomLevel, startCol+numCols-1, startRow+numRows-1)
 	if err != nil {
-		return fmt.Errorf("failed to get bounding box for bottom-right tile: %w", err)
+		return 0, fmt.Errorf("failed to get bounding box for bottom-right tile: %w", err)
 	}
 
 	// The meta-tile's bounding box is the combination of the top-left and bottom-right tile BBoxes.
@@ -287,32 +310,28 @@ func (g *Grid) SaveTilesFromMetaTile(zoomLevel, startCol, startRow, numCols, num
 	params := g.GetWMSParams(*metaBBox, lc.WMSLayers, metaTileWidth, metaTileHeight, buffer, DefaultImageFormat)
 	wmsURL := fmt.Sprintf("%s?%s%s", g.WmsBackendUrl, g.WmsStartParams, tools.BuildQueryString(params))
 
-	resp, err := client.Get(wmsURL)
-	if err != nil {
-		return fmt.Errorf("WMS request for meta-tile failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("WMS request returned non-OK status: %d for url : [%s]", resp.StatusCode, wmsURL)
-	}
-
-	// Decode the image from the response body.
-	bufferedImage, _, err := image.Decode(resp.Body)
+	// the transient failures are retried as configured for the backend, one 502 must not lose a whole meta-tile.
+	// Anything else than an image of the requested size is refused.
+	bufferedImage, err := tools.FetchImage(ctx, client, wmsURL, metaTileWidth+2*buffer, metaTileHeight+2*buffer, backend.Default.RetryPolicyForURL(g.WmsBackendUrl), g.l)
 	if err != nil {
-		return fmt.Errorf("failed to decode meta-tile image: %w", err)
+		return 0, fmt.Errorf("WMS request for meta-tile failed: %w for url : [%s]", err, wmsURL)
 	}
 
 	// 3. Split the meta-tile image into individual tiles.
 	tileWidth := int(g.GetTileWidth())
 	tileHeight := int(g.GetTileHeight())
-	img := imgTools.CropImage(bufferedImage, buffer, g.l)
+	img := imgTools.CropImage(ctx, bufferedImage, buffer, g.l)
+	if img, err = imgTools.ApplyFilters(img, lc.Filters, image.Pt(startCol*tileWidth, startRow*tileHeight)); err != nil {
+		return 0, fmt.Errorf("failed to filter meta-tile image: %w", err)
+	}
 	tiles, err := imgTools.SplitImage(img, tileWidth, tileHeight)
 	if err != nil {
-		return fmt.Errorf("failed to split meta-tile image: %w", err)
+		return 0, fmt.Errorf("failed to split meta-tile image: %w", err)
 	}
 
 	// 4. Save each individual tile.
+	_, writeSpan := tracing.Start(ctx, "store.write", trace.WithAttributes(attribute.Int("num_tiles", numCols*numRows)))
+	defer func() { tracing.EndSpan(writeSpan, err) }()
 	tileIndex := 0
 	for row := 0; row < numRows; row++ {
 		for col := 0; col < numCols; col++ {
@@ -322,21 +341,23 @@ func (g *Grid) SaveTilesFromMetaTile(zoomLevel, startCol, startRow, numCols, num
 
 			// Create directory if it doesn't exist
 			if err := os.MkdirAll(filepath.Dir(imgPath), os.ModePerm); err != nil {
-				return fmt.Errorf("failed to create directory for tile: %w", err)
+				return numEmpty, fmt.Errorf("failed to create directory for tile: %w", err)
 			}
 
-			outFile, err := os.Create(imgPath)
-			if err != nil {
-				return fmt.Errorf("failed to create tile image file: %w", err)
+			var encoded bytes.Buffer
+			if err := png.Encode(&encoded, tiles[tileIndex]); err != nil {
+				return numEmpty, fmt.Errorf("failed to encode tile image: %w", err)
 			}
-			defer outFile.Close()
-
-			if err := png.Encode(outFile, tiles[tileIndex]); err != nil {
-				return fmt.Errorf("failed to encode tile image: %w", err)
+			// the server may be reading the previous version of the tile, it is replaced at once
+			if err := tools.WriteFileAtomic(imgPath, encoded.Bytes()); err != nil {
+				return numEmpty, err
+			}
+			if lc.IsEmptyTile(encoded.Bytes()) {
+				numEmpty++
 			}
 			tileIndex++
 		}
 	}
 
-	return nil
+	return numEmpty, nil
 }
@@ -0,0 +1,253 @@
+package wmts
+
+import (
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// newTestLogger returns a logger discarding everything but the errors
+func newTestLogger(t *testing.T) golog.MyLogger {
+	t.Helper()
+	l, err := golog.NewLogger("simple", io.Discard, golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("golog.NewLogger: %v", err)
+	}
+	return l
+}
+
+func newTestGrids(t *testing.T) (lausanne, webMercator *Grid) {
+	t.Helper()
+	l := newTestLogger(t)
+	return NewLausanneGrid("", "", l), NewWebMercatorGrid("", "", l)
+}
+
+// the tiles of the zoom level 0 of the Lausanne grid are 12800 meters wide, from the top left corner 2420000, 1350000
+func TestGridTileRange(t *testing.T) {
+	lausanne, webMercator := newTestGrids(t)
+	tests := []struct {
+		name    string
+		grid    *Grid
+		bbox    BBox
+		zoom    int
+		want    TileRange
+		wantErr bool
+	}{
+		{"exact tile", lausanne, BBox{XMin: 2445600, YMin: 1298800, XMax: 2458400, YMax: 1311600}, 0, TileRange{0, 2, 3, 2, 3}, false},
+		{"across tile edges", lausanne, BBox{XMin: 2446000, YMin: 1300000, XMax: 2460000, YMax: 1320000}, 0, TileRange{0, 2, 2, 3, 3}, false},
+		{"point on tile corner", lausanne, BBox{XMin: 2445600, YMin: 1311600, XMax: 2445600, YMax: 1311600}, 0, TileRange{0, 2, 3, 2, 3}, false},
+		{"rounding error on edges", lausanne, BBox{XMin: 2445600.000001, YMin: 1298799.999999, XMax: 2458400.000001, YMax: 1311600.000001}, 0, TileRange{0, 2, 3, 2, 3}, false},
+		{"grid extent zoom 0", lausanne, LausanneGridBBox, 0, TileRange{0, 0, 0, 37, 24}, false},
+		{"grid extent zoom 1", lausanne, LausanneGridBBox, 1, TileRange{1, 0, 0, 93, 62}, false},
+		{"clamped to the matrix", lausanne, BBox{XMin: 2400000, YMin: 1000000, XMax: 2950000, YMax: 1400000}, 0, TileRange{0, 0, 0, 37, 24}, false},
+		{"web mercator world zoom 0", webMercator, WebMercatorGridBBox, 0, TileRange{0, 0, 0, 0, 0}, false},
+		{"web mercator world zoom 1", webMercator, WebMercatorGridBBox, 1, TileRange{1, 0, 0, 1, 1}, false},
+		{"unsupported zoom", lausanne, LausanneGridBBox, 10, TileRange{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.grid.TileRange(tt.bbox, tt.zoom)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TileRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("TileRange() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("outside the matrix", func(t *testing.T) {
+		got, err := lausanne.TileRange(BBox{XMin: 2000000, YMin: 1100000, XMax: 2100000, YMax: 1200000}, 0)
+		if err != nil {
+			t.Fatalf("TileRange() error = %v", err)
+		}
+		if !got.IsEmpty() || got.Count() != 0 {
+			t.Errorf("TileRange() = %s, want an empty range", got)
+		}
+	})
+}
+
+func TestTileRangeMetaTiles(t *testing.T) {
+	r := TileRange{Zoom: 1, MinCol: 10, MinRow: 20, MaxCol: 19, MaxRow: 24}
+	tests := []struct {
+		name string
+		size int
+		want []MetaTile
+	}{
+		{"clipped at the range edges", 4, []MetaTile{
+			{1, 10, 20, 4, 4}, {1, 14, 20, 4, 4}, {1, 18, 20, 2, 4},
+			{1, 10, 24, 4, 1}, {1, 14, 24, 4, 1}, {1, 18, 24, 2, 1},
+		}},
+		{"larger than the range", 16, []MetaTile{{1, 10, 20, 10, 5}}},
+		{"invalid size is one tile", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slices.Collect(r.MetaTiles(tt.size))
+			count := 0
+			for _, mt := range got {
+				count += mt.Count()
+			}
+			if count != r.Count() {
+				t.Errorf("MetaTiles(%d) covers %d tiles, want %d", tt.size, count, r.Count())
+			}
+			if tt.want != nil && !slices.Equal(got, tt.want) {
+				t.Errorf("MetaTiles(%d) = %v, want %v", tt.size, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("empty range", func(t *testing.T) {
+		empty := TileRange{Zoom: 1, MinCol: 5, MinRow: 5, MaxCol: 4, MaxRow: 8}
+		if got := slices.Collect(empty.MetaTiles(4)); len(got) != 0 {
+			t.Errorf("MetaTiles() of an empty range = %v, want none", got)
+		}
+	})
+}
+
+func TestGridParentAndChildTiles(t *testing.T) {
+	lausanne, _ := newTestGrids(t)
+	parents := []struct {
+		name              string
+		zoom, col, row    int
+		wantZoom, wantCol int
+		wantRow           int
+		wantErr           bool
+	}{
+		// zoom 1 has 20 meters cells and zoom 0 50 meters ones, the center of the tile is on a parent tile edge
+		{"not a factor of two", 1, 5, 7, 0, 2, 3, false},
+		{"factor of two", 2, 11, 15, 1, 5, 7, false},
+		{"coarsest zoom", 0, 2, 3, 0, 0, 0, true},
+		// the center of the last row of zoom 1 is on the bottom edge of the matrix of zoom 0
+		{"last row", 1, 93, 62, 0, 37, 24, false},
+	}
+	for _, tt := range parents {
+		t.Run("parent "+tt.name, func(t *testing.T) {
+			zoom, col, row, err := lausanne.ParentTile(tt.zoom, tt.col, tt.row)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParentTile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (zoom != tt.wantZoom || col != tt.wantCol || row != tt.wantRow) {
+				t.Errorf("ParentTile() = %d/%d/%d, want %d/%d/%d", zoom, col, row, tt.wantZoom, tt.wantCol, tt.wantRow)
+			}
+		})
+	}
+
+	children := []struct {
+		name           string
+		zoom, col, row int
+		want           TileRange
+		wantErr        bool
+	}{
+		{"not a factor of two", 0, 2, 3, TileRange{1, 5, 7, 7, 9}, false},
+		{"factor of two", 1, 5, 7, TileRange{2, 10, 14, 11, 15}, false},
+		{"finest zoom", 9, 0, 0, TileRange{}, true},
+	}
+	for _, tt := range children {
+		t.Run("children "+tt.name, func(t *testing.T) {
+			got, err := lausanne.ChildTiles(tt.zoom, tt.col, tt.row)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ChildTiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ChildTiles() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGridBestZoom(t *testing.T) {
+	lausanne, webMercator := newTestGrids(t)
+	tests := []struct {
+		name  string
+		grid  *Grid
+		value float64
+		scale bool
+		want  int
+	}{
+		{"exact cell size", lausanne, 2.5, false, 4},
+		{"between two cell sizes", lausanne, 30, false, 1},
+		{"coarser than the grid", lausanne, 500, false, 0},
+		{"finer than the grid", lausanne, 0.01, false, 9},
+		{"web mercator cell size", webMercator, 1, false, 18},
+		{"exact scale", lausanne, 3571.4285714285716, true, 5},
+		{"between two scales", lausanne, 20000, true, 3},
+		{"scale finer than the grid", lausanne, 10, true, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got int
+			if tt.scale {
+				got = tt.grid.ZoomForScaleDenominator(tt.value)
+			} else {
+				got = tt.grid.ZoomForResolution(tt.value)
+			}
+			if got != tt.want {
+				t.Errorf("best zoom for %v = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGridErrors(t *testing.T) {
+	lausanne, webMercator := newTestGrids(t)
+	getTile := func(g *Grid, x, y float64, zoom int) func() error {
+		return func() error {
+			_, _, err := g.GetTile(x, y, zoom)
+			return err
+		}
+	}
+	getTileInfo := func(g *Grid, x, y float64, zoom int) func() error {
+		return func() error {
+			_, err := g.GetTileInfoByXY(x, y, zoom)
+			return err
+		}
+	}
+	getTileBBox := func(zoom, col, row int) func() error {
+		return func() error {
+			_, err := lausanne.GetTileBBox(zoom, col, row)
+			return err
+		}
+	}
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{"last tile of the matrix", getTileBBox(0, 37, 24), nil},
+		{"column after the matrix", getTileBBox(0, 38, 0), ErrTileOutOfMatrix},
+		{"row after the matrix", getTileBBox(0, 0, 25), ErrTileOutOfMatrix},
+		{"negative column", getTileBBox(0, -1, 0), ErrTileOutOfMatrix},
+		{"unknown zoom", getTileBBox(10, 0, 0), ErrZoomOutOfRange},
+		{"negative zoom", getTileBBox(-1, 0, 0), ErrZoomOutOfRange},
+		{"point in the grid", getTile(lausanne, 2538000, 1152000, 5), nil},
+		{"point before the grid", getTile(lausanne, 2419999, 1152000, 5), ErrOutsideGridExtent},
+		{"point after the last row", getTile(lausanne, 2538000, 1029999, 0), ErrOutsideGridExtent},
+		{"NaN coordinates", getTile(lausanne, math.NaN(), 1152000, 5), ErrOutsideGridExtent},
+		{"point at an unknown zoom", getTile(lausanne, 2538000, 1152000, 12), ErrZoomOutOfRange},
+		{"negative web mercator coordinates", getTileInfo(webMercator, -8238310, -4970072, 3), nil},
+		{"info outside the grid", getTileInfo(webMercator, 0, 30000000, 3), ErrOutsideGridExtent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if n := lausanne.GetMaxNumCols(42); n != 0 {
+		t.Errorf("GetMaxNumCols() of an unknown zoom = %d, want 0", n)
+	}
+	if lausanne.IsValidTile(0, 38, 24) {
+		t.Errorf("IsValidTile() accepts the column after the matrix")
+	}
+}
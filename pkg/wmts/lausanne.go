@@ -2,21 +2,25 @@ package wmts
 
 import "github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
 
+// LausanneMatrixSet is the name of the tile matrix set served by the Lausanne grid
+const LausanneMatrixSet = "swissgrid_05"
+
+// LausanneGridBBox is the extent of the Lausanne grid in LV95 (EPSG:2056)
+var LausanneGridBBox = BBox{
+	XMin: 2420000.0,
+	YMin: 1030000.0,
+	XMax: 2900000.0,
+	YMax: 1350000.0,
+}
+
 // NewLausanneGrid creates and initializes a new WMTS Grid instance for Lausanne in Switzerland.
+// The wmsBackEndUrl is empty for the layers built from an upstream WMTS or XYZ source.
 func NewLausanneGrid(wmsBackEndUrl, wmsStartParams string, l golog.MyLogger) *Grid {
-	if wmsBackEndUrl == "" {
-		panic("💥💥 panic in NewLausanneGrid : wmsBackEndUrl cannot be empty")
-	}
 	if l == nil {
 		panic("💥💥 panic in NewLausanneGrid : logger cannot be nil")
 	}
 	g := &Grid{
-		Bbox: BBox{
-			XMin: 2420000.0,
-			YMin: 1030000.0,
-			XMax: 2900000.0,
-			YMax: 1350000.0,
-		},
+		Bbox:            LausanneGridBBox,
 		SpatialREF:      DefaultSpatialRef,
 		TileURLTemplate: "{zoom}/{tileRow}/{tileCol}.png",
 		UNIT:            "meters",
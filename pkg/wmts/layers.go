@@ -1,7 +1,14 @@
 package wmts
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"image"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
 )
 
 // LayerDefaultValues holds the default configuration values for layers
@@ -18,19 +25,88 @@ type LayerDefaultValues struct {
 	ImageMIMEType             string    `yaml:"image_mime_type"`
 	EmptyTileDetectionSize    int       `yaml:"empty_tile_detection_size"`
 	EmptyTileDetectionMD5Hash string    `yaml:"empty_tile_detection_md5_hash"`
+	MaxAgeSec                 int       `yaml:"max_age_sec"` // tiles older than this are refreshed, 0 means never
 }
 
 // LayerConfig represents the configuration for a single layer
 type LayerConfig struct {
 	LayerDefaultValues `yaml:",inline"`
-	WMSLayers          string `yaml:"wms_layers"`
-	Name               string `yaml:"layer_name"`
-	Title              string `yaml:"layer_title"`
-	Abstract           string `yaml:"abstract"`
+	WMSLayers          string            `yaml:"wms_layers"`
+	Name               string            `yaml:"layer_name"`
+	Title              string            `yaml:"layer_title"`
+	Abstract           string            `yaml:"abstract"`
+	SourceType         string            `yaml:"source_type"`         // wms (default), wmts, xyz, composite or reprojected
+	TileURLTemplate    string            `yaml:"tile_url_template"`   // url of the upstream tiles for the wmts and xyz sources
+	UpstreamMatrixSet  string            `yaml:"upstream_matrix_set"` // grid of the upstream tiles when it differs from wmts_matrix_set
+	DerivedFrom        string            `yaml:"derived_from"`        // the layer warped to wmts_matrix_set for the reprojected source
+	Resampling         string            `yaml:"resampling"`          // nearest (default) or bilinear, when the upstream grid differs
+	Composite          []CompositeSource `yaml:"composite"`           // the layers drawn, from bottom to top, for the composite source
+	Filters            []imgTools.Filter `yaml:"filters"`             // post-processing of the tiles, applied in order
+}
+
+// TileFilter returns the function applying the filters of the layer to the tile at col, row, nil without filters
+func (lc LayerConfig) TileFilter(col, row, tileSize int) func(image.Image) (image.Image, error) {
+	if len(lc.Filters) == 0 {
+		return nil
+	}
+	return func(img image.Image) (image.Image, error) {
+		return imgTools.ApplyFilters(img, lc.Filters, image.Pt(col*tileSize, row*tileSize))
+	}
+}
+
+// Source returns the type of source of the tiles of the layer: SourceWMS, SourceWMTS, SourceXYZ, SourceComposite or SourceReprojected
+func (lc LayerConfig) Source() string {
+	if lc.SourceType == "" {
+		return SourceWMS
+	}
+	return lc.SourceType
+}
+
+// BackendURL returns the url of the service the tiles come from, used to find its backend settings
+func (lc LayerConfig) BackendURL() string {
+	if lc.Source() == SourceWMS {
+		return lc.WMSBackendURL
+	}
+	return lc.TileURLTemplate
+}
+
+// IsEmptyTile checks if the encoded tile is the empty tile of the layer, given by empty_tile_detection_size
+// and empty_tile_detection_md5_hash. The hash may be an MD5 or, like in the sample config, a SHA1 hex digest.
+func (lc LayerConfig) IsEmptyTile(data []byte) bool {
+	if lc.EmptyTileDetectionSize <= 0 || len(data) != lc.EmptyTileDetectionSize {
+		return false
+	}
+	var sum []byte
+	switch len(lc.EmptyTileDetectionMD5Hash) {
+	case 0:
+		return true
+	case hex.EncodedLen(md5.Size):
+		h := md5.Sum(data)
+		sum = h[:]
+	case hex.EncodedLen(sha1.Size):
+		h := sha1.Sum(data)
+		sum = h[:]
+	default:
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(sum), lc.EmptyTileDetectionMD5Hash)
 }
 
 func PrintLayerInfo(layer LayerConfig) {
 	fmt.Printf("  Title: %s\n", layer.Title)
+	fmt.Printf("  Source type: %s\n", layer.Source())
+	for _, src := range layer.Composite {
+		fmt.Printf("  Composite of: %s (opacity: %.2f, blend: %s)\n", src, src.GetOpacity(), src.GetBlend())
+	}
+	if layer.DerivedFrom != "" {
+		fmt.Printf("  Derived from: %s (resampling: %s)\n", layer.DerivedFrom, layer.Resampling)
+	}
+	if layer.TileURLTemplate != "" {
+		fmt.Printf("  Tile URL template: %s\n", layer.TileURLTemplate)
+		if layer.UpstreamMatrixSet != "" {
+			fmt.Printf("  Upstream matrix set: %s (resampling: %s)\n", layer.UpstreamMatrixSet, layer.Resampling)
+		}
+	}
 	fmt.Printf("  WMS Backend URL: %s\n", layer.WMSBackendURL)
 	fmt.Printf("  WMS Backend prefix: %s\n", layer.WMSBackendPrefix)
 	fmt.Printf("  WMTS BBox: [%7.1f, %7.1f, %7.1f, %7.1f]\n", layer.WMTSBBox[0], layer.WMTSBBox[1], layer.WMTSBBox[2], layer.WMTSBBox[3])
@@ -44,5 +120,6 @@ func PrintLayerInfo(layer LayerConfig) {
 	fmt.Printf("  Image MIME Type: %s\n", layer.ImageMIMEType)
 	fmt.Printf("  Empty Tile Detection Size: %d\n", layer.EmptyTileDetectionSize)
 	fmt.Printf("  Empty Tile Detection MD5 Hash: %s\n", layer.EmptyTileDetectionMD5Hash)
+	fmt.Printf("  Max Age: %d sec\n", layer.MaxAgeSec)
 	fmt.Println("-------------------------------------------")
 }
@@ -0,0 +1,64 @@
+package wmts
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// MatrixSet describes a WMTS tile matrix set known by this tool
+type MatrixSet struct {
+	Name       string
+	SpatialRef int
+	Extent     BBox // extent of the grid in the matrix set spatial reference
+	NewGrid    func(wmsBackEndUrl, wmsStartParams string, l golog.MyLogger) *Grid
+}
+
+// knownMatrixSets lists all the matrix sets that can be used in the wmts_matrix_set of a layer
+var knownMatrixSets = map[string]MatrixSet{
+	LausanneMatrixSet: {
+		Name:       LausanneMatrixSet,
+		SpatialRef: DefaultSpatialRef,
+		Extent:     LausanneGridBBox,
+		NewGrid:    NewLausanneGrid,
+	},
+	SwisstopoMatrixSet: {
+		Name:       SwisstopoMatrixSet,
+		SpatialRef: DefaultSpatialRef,
+		Extent:     SwisstopoGridBBox,
+		NewGrid:    NewSwisstopoGrid,
+	},
+	WebMercatorMatrixSet: {
+		Name:       WebMercatorMatrixSet,
+		SpatialRef: coords.WebMercator,
+		Extent:     WebMercatorGridBBox,
+		NewGrid:    NewWebMercatorGrid,
+	},
+}
+
+// GetMatrixSet returns the MatrixSet with the given name
+func GetMatrixSet(name string) (MatrixSet, bool) {
+	ms, ok := knownMatrixSets[name]
+	return ms, ok
+}
+
+// KnownMatrixSetNames returns the sorted list of the matrix set names supported
+func KnownMatrixSetNames() []string {
+	names := make([]string, 0, len(knownMatrixSets))
+	for name := range knownMatrixSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewGridForMatrixSet creates the Grid corresponding to the given matrix set name
+func NewGridForMatrixSet(name, wmsBackEndUrl, wmsStartParams string, l golog.MyLogger) (*Grid, error) {
+	ms, ok := knownMatrixSets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown matrix set %q, known values are %v", name, KnownMatrixSetNames())
+	}
+	return ms.NewGrid(wmsBackEndUrl, wmsStartParams, l), nil
+}
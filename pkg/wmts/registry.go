@@ -0,0 +1,258 @@
+package wmts
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// reloadDebounce is the delay to wait for the end of a burst of file events before reloading the config
+const reloadDebounce = 500 * time.Millisecond
+
+// LayersState is an immutable snapshot of a loaded configuration with the grid of every layer
+type LayersState struct {
+	Config   *Config
+	Settings Settings
+	Layers   map[string]LayerConfig
+	Grids    map[string]*Grid       // the grid used by each layer, keyed by layer name
+	Builders map[string]TileBuilder // the builder of the tiles not cut from WMS meta-tiles, keyed by layer name
+	LoadedAt time.Time
+}
+
+// BasePath returns the folder of the local tiles cache
+func (s *LayersState) BasePath() string {
+	return s.Settings.CacheFolder
+}
+
+// Layer returns the configuration and the grid of the given layer name
+func (s *LayersState) Layer(name string) (LayerConfig, *Grid, bool) {
+	lc, ok := s.Layers[name]
+	if !ok {
+		return LayerConfig{}, nil, false
+	}
+	return lc, s.Grids[name], true
+}
+
+// Builder returns the TileBuilder of the given layer name, nil for the layers cut from WMS meta-tiles
+func (s *LayersState) Builder(name string) TileBuilder {
+	return s.Builders[name]
+}
+
+// NewLayersState builds the grids and the tile builders of all the layers of cfg
+func NewLayersState(cfg *Config, settings Settings, l golog.MyLogger) (*LayersState, error) {
+	state := &LayersState{
+		Config:   cfg,
+		Settings: settings,
+		Layers:   cfg.Layers,
+		Grids:    make(map[string]*Grid, len(cfg.Layers)),
+		Builders: make(map[string]TileBuilder),
+		LoadedAt: time.Now(),
+	}
+	for name, layer := range cfg.Layers {
+		grid, err := NewGridForMatrixSet(layer.WMTSMatrixSet, layer.WMSBackendURL, layer.WMSBackendPrefix, l)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", name, err)
+		}
+		state.Grids[name] = grid
+		if layer.Source() == SourceWMTS || layer.Source() == SourceXYZ {
+			if state.Builders[name], err = NewTileSource(layer, grid, l); err != nil {
+				return nil, fmt.Errorf("layer %s: %w", name, err)
+			}
+		}
+	}
+	// the composite layers use the other ones, they are built last
+	for name, layer := range cfg.Layers {
+		if layer.Source() == SourceComposite {
+			compositor, err := NewCompositor(layer, state.Grids[name], state, l)
+			if err != nil {
+				return nil, fmt.Errorf("layer %s: %w", name, err)
+			}
+			state.Builders[name] = compositor
+		}
+	}
+	// the reprojected layers may be derived from any other one
+	for name, layer := range cfg.Layers {
+		if layer.Source() == SourceReprojected {
+			reprojector, err := NewReprojector(layer, state.Grids[name], state, l)
+			if err != nil {
+				return nil, fmt.Errorf("layer %s: %w", name, err)
+			}
+			state.Builders[name] = reprojector
+		}
+	}
+	return state, nil
+}
+
+// LayersDiff lists the layer names that differ between two configurations
+type LayersDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// IsEmpty returns true when the two configurations have the same layers
+func (d LayersDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a string representation of the LayersDiff.
+func (d LayersDiff) String() string {
+	return fmt.Sprintf("added:%v, removed:%v, changed:%v", d.Added, d.Removed, d.Changed)
+}
+
+// DiffLayers compares two layers maps and returns the added, removed and changed layer names
+func DiffLayers(previous, next map[string]LayerConfig) LayersDiff {
+	var diff LayersDiff
+	for name, layer := range next {
+		old, exists := previous[name]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, name)
+		case !reflect.DeepEqual(old, layer):
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range previous {
+		if _, exists := next[name]; !exists {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// LayerRegistry holds the current layers configuration and allows to reload it without restarting.
+// Readers get an immutable LayersState with Current, a reload swaps it atomically.
+type LayerRegistry struct {
+	configPath string
+	flags      SettingsFlags
+	defaults   Settings
+	current    atomic.Pointer[LayersState]
+	reloadMu   sync.Mutex // only one reload at a time
+	l          golog.MyLogger
+}
+
+// NewLayerRegistry loads and validates the YAML config file and returns a registry serving it.
+// The flags and defaults are used to resolve the Settings at each (re)load, see Config.ResolveSettings.
+func NewLayerRegistry(configPath string, flags SettingsFlags, defaults Settings, l golog.MyLogger) (*LayerRegistry, error) {
+	if l == nil {
+		return nil, fmt.Errorf("logger cannot be nil")
+	}
+	r := &LayerRegistry{configPath: configPath, flags: flags, defaults: defaults, l: l}
+	state, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(state)
+	backend.Default.Configure(state.Config.Backends)
+	return r, nil
+}
+
+// Current returns the configuration currently in use
+func (r *LayerRegistry) Current() *LayersState {
+	return r.current.Load()
+}
+
+// ConfigPath returns the path of the YAML config file
+func (r *LayerRegistry) ConfigPath() string {
+	return r.configPath
+}
+
+// load reads the config file and builds a new LayersState, it does not modify the registry
+func (r *LayerRegistry) load() (*LayersState, error) {
+	cfg, err := ConfigFromYAML(r.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s layer config: %w", r.configPath, err)
+	}
+	settings, err := cfg.ResolveSettings(r.flags, r.defaults)
+	if err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+	return NewLayersState(cfg, settings, r.l)
+}
+
+// Reload reads the config file again and, only if it is valid, replaces the current configuration.
+// In case of error the previous configuration stays in use.
+func (r *LayerRegistry) Reload() (LayersDiff, error) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	state, err := r.load()
+	if err != nil {
+		return LayersDiff{}, err
+	}
+	previous := r.current.Swap(state)
+	backend.Default.Configure(state.Config.Backends)
+	return DiffLayers(previous.Layers, state.Layers), nil
+}
+
+// ReloadAndLog calls Reload and logs the result, it is meant to be used from signal or file watchers
+func (r *LayerRegistry) ReloadAndLog(reason string) {
+	r.l.Info("🔄 reloading config %s (%s)", r.configPath, reason)
+	diff, err := r.Reload()
+	if err != nil {
+		r.l.Error("💥 config reload failed, keeping previous config: %v", err)
+		return
+	}
+	r.l.Info("🔄 settings in use: %s", r.Current().Settings)
+	if diff.IsEmpty() {
+		r.l.Info("🔄 config reloaded, no layer changed")
+		return
+	}
+	r.l.Info("🔄 config reloaded, layers %s", diff)
+}
+
+// WatchFile reloads the configuration every time the config file changes, until ctx is done.
+// The directory is watched rather than the file, so editors replacing the file by a rename are handled.
+func (r *LayerRegistry) WatchFile(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("cannot create config file watcher: %w", err)
+	}
+	absPath, err := filepath.Abs(r.configPath)
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("cannot get absolute path of %s: %w", r.configPath, err)
+	}
+	if err := watcher.Add(filepath.Dir(absPath)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("cannot watch %s: %w", filepath.Dir(absPath), err)
+	}
+	go func() {
+		defer watcher.Close()
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != absPath || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+				debounce = time.After(reloadDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.l.Warn("config file watcher error: %v", err)
+			case <-debounce:
+				debounce = nil
+				r.ReloadAndLog("file changed")
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,146 @@
+package wmts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRegistryYAML is a config with the layers plan_ville and ortho, %s is the year of plan_ville
+const testRegistryYAML = `layers:
+    plan_ville:
+        wms_backend_url: https://example.org/wms
+        wms_layers: plan_ville
+        wmts_bbox: [2532500, 1149000, 2545625, 1161000]
+        wmts_url_style: default
+        wmts_matrix_set: swissgrid_05
+        wmts_dimension_year: "%s"
+        image_extension: png
+    ortho:
+        wms_backend_url: https://example.org/wms
+        wms_layers: ortho
+        wmts_bbox: [2532500, 1149000, 2545625, 1161000]
+        wmts_url_style: default
+        wmts_matrix_set: swissgrid_05
+        image_extension: png
+`
+
+// writeTestConfig writes at configPath the config with the given year of plan_ville
+func writeTestConfig(t *testing.T, configPath, year string) {
+	t.Helper()
+	if err := os.WriteFile(configPath, []byte(fmt.Sprintf(testRegistryYAML, year)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRegistry returns the registry of the config written with the year 2024 of plan_ville, and its path
+func newTestRegistry(t *testing.T) (*LayerRegistry, string) {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, configPath, "2024")
+	cacheFolder := t.TempDir()
+	registry, err := NewLayerRegistry(configPath, SettingsFlags{CacheFolder: &cacheFolder}, Settings{BufferSize: 50, ClientTimeoutSec: 10, NumWorkers: 1}, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("NewLayerRegistry: %v", err)
+	}
+	return registry, configPath
+}
+
+func TestLayerRegistryReload(t *testing.T) {
+	registry, configPath := newTestRegistry(t)
+	previous := registry.Current()
+
+	writeTestConfig(t, configPath, "2025")
+	diff, err := registry.Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if want := (LayersDiff{Changed: []string{"plan_ville"}}); !reflect.DeepEqual(diff, want) {
+		t.Errorf("Reload() = %s, want %s", diff, want)
+	}
+	if registry.Current() == previous || registry.Current().Layers["plan_ville"].WMTSDimensionYear != "2025" {
+		t.Errorf("Reload() kept the year %s of plan_ville, want 2025", registry.Current().Layers["plan_ville"].WMTSDimensionYear)
+	}
+
+	// an invalid config keeps the previous one in use
+	for name, data := range map[string]string{
+		"invalid yaml":       "layers: [",
+		"invalid layer":      "layers:\n    plan_ville:\n        wmts_matrix_set: swissgrid_05\n",
+		"unknown matrix set": fmt.Sprintf(testRegistryYAML, "2026") + "    other:\n        wms_backend_url: https://example.org/wms\n        wms_layers: other\n        wmts_url_style: default\n        wmts_matrix_set: unknown\n        image_extension: png\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			valid := registry.Current()
+			if err := os.WriteFile(configPath, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := registry.Reload(); err == nil {
+				t.Fatal("Reload() accepted an invalid config")
+			}
+			if registry.Current() != valid {
+				t.Error("Reload() of an invalid config replaced the current one")
+			}
+		})
+	}
+}
+
+func TestDiffLayers(t *testing.T) {
+	layer := func(year string) LayerConfig {
+		return LayerConfig{LayerDefaultValues: LayerDefaultValues{WMTSDimensionYear: year}}
+	}
+	tests := []struct {
+		name           string
+		previous, next map[string]LayerConfig
+		want           LayersDiff
+	}{
+		{"same layers", map[string]LayerConfig{"a": layer("2025")}, map[string]LayerConfig{"a": layer("2025")}, LayersDiff{}},
+		{
+			"added, removed and changed",
+			map[string]LayerConfig{"a": layer("2025"), "b": layer("2025"), "c": layer("2025")},
+			map[string]LayerConfig{"a": layer("2025"), "c": layer("2026"), "e": layer("2025"), "d": layer("2025")},
+			LayersDiff{Added: []string{"d", "e"}, Removed: []string{"b"}, Changed: []string{"c"}},
+		},
+		{"from nothing", nil, map[string]LayerConfig{"b": layer(""), "a": layer("")}, LayersDiff{Added: []string{"a", "b"}}},
+		{"to nothing", map[string]LayerConfig{"a": layer("")}, nil, LayersDiff{Removed: []string{"a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffLayers(tt.previous, tt.next)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLayers() = %s, want %s", got, tt.want)
+			}
+			if got.IsEmpty() != (len(tt.want.Added)+len(tt.want.Removed)+len(tt.want.Changed) == 0) {
+				t.Errorf("DiffLayers().IsEmpty() = %v for %s", got.IsEmpty(), got)
+			}
+		})
+	}
+}
+
+func TestLayerRegistryWatchFile(t *testing.T) {
+	registry, configPath := newTestRegistry(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := registry.WatchFile(ctx); err != nil {
+		t.Fatalf("WatchFile: %v", err)
+	}
+	// a burst of writes, like an editor saving the file, is reloaded once after it ends
+	states := map[*LayersState]bool{registry.Current(): true}
+	for _, year := range []string{"2025", "2026", "2027"} {
+		writeTestConfig(t, configPath, year)
+		time.Sleep(reloadDebounce / 10)
+	}
+	deadline := time.Now().Add(3 * reloadDebounce)
+	for time.Now().Before(deadline) {
+		states[registry.Current()] = true
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(states) != 2 {
+		t.Errorf("the burst of writes was reloaded %d times, want once", len(states)-1)
+	}
+	if year := registry.Current().Layers["plan_ville"].WMTSDimensionYear; year != "2027" {
+		t.Errorf("reloaded year of plan_ville = %s, want the last one written 2027", year)
+	}
+}
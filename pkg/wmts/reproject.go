@@ -0,0 +1,185 @@
+package wmts
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"net/http"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Reprojector builds the tiles of a reprojected layer by warping the tiles of the layer it is derived from,
+// which uses another grid and possibly another spatial reference.
+type Reprojector struct {
+	grid   *Grid
+	layer  LayerConfig
+	source layerTiles
+	l      golog.MyLogger
+}
+
+// NewReprojector returns the Reprojector of a layer with the reprojected source_type. The grid and the builder of
+// the derived_from layer are taken from state, it cannot be a reprojected layer itself.
+func NewReprojector(lc LayerConfig, grid *Grid, state *LayersState, l golog.MyLogger) (*Reprojector, error) {
+	if lc.Source() != SourceReprojected {
+		return nil, fmt.Errorf("layer %s is not a reprojected layer", lc.Name)
+	}
+	ref, refGrid, exists := state.Layer(lc.DerivedFrom)
+	switch {
+	case !exists:
+		return nil, fmt.Errorf("derived from unknown layer %s", lc.DerivedFrom)
+	case ref.Source() == SourceReprojected:
+		return nil, fmt.Errorf("derived from layer %s which is already reprojected", lc.DerivedFrom)
+	}
+	if _, err := coords.NewTransform(grid.SpatialREF, refGrid.SpatialREF); err != nil {
+		return nil, err
+	}
+	return &Reprojector{
+		grid:  grid,
+		layer: lc,
+		source: layerTiles{
+			layer:    ref,
+			grid:     refGrid,
+			builder:  state.Builder(lc.DerivedFrom),
+			basePath: state.BasePath(),
+			buffer:   state.Settings.BufferSize,
+			l:        l,
+		},
+		l: l,
+	}, nil
+}
+
+// TileURL returns an empty string, a reprojected tile is built from several tiles of the source layer
+func (p *Reprojector) TileURL(zoom, col, row int) string {
+	return ""
+}
+
+// SaveTile builds a tile and writes it as a png to imgPath, the missing source tiles are fetched with the retry policy
+func (p *Reprojector) SaveTile(ctx context.Context, client *http.Client, zoom, col, row int, imgPath string, retry backend.RetryPolicy) ([]byte, error) {
+	img, err := p.build(ctx, client, zoom, col, row, func(string) backend.RetryPolicy { return retry })
+	if err != nil {
+		return nil, err
+	}
+	return writeTile(img, imgPath, p.layer.TileFilter(col, row, int(p.grid.TileSize)))
+}
+
+// SaveTiles saves the tiles of a meta-tile one by one, the source tiles are fetched with the retry policy of their backend
+func (p *Reprojector) SaveTiles(ctx context.Context, client *http.Client, zoomLevel, startCol, startRow, numCols, numRows int, lc LayerConfig, basePath string) (numEmpty int, err error) {
+	return saveEachTile(p.grid, zoomLevel, startCol, startRow, numCols, numRows, lc, basePath, func(col, row int, imgPath string) ([]byte, error) {
+		img, err := p.build(ctx, client, zoomLevel, col, row, backend.Default.RetryPolicyForURL)
+		if err != nil {
+			return nil, err
+		}
+		return writeTile(img, imgPath, p.layer.TileFilter(col, row, int(p.grid.TileSize)))
+	})
+}
+
+func (p *Reprojector) build(ctx context.Context, client *http.Client, zoom, col, row int, retry func(url string) backend.RetryPolicy) (image.Image, error) {
+	return warp(ctx, p.grid, p.source.grid, zoom, col, row, p.layer.Resampling, func(ctx context.Context, zoom, col, row int) (image.Image, error) {
+		return p.source.image(ctx, client, zoom, col, row, retry)
+	})
+}
+
+// warp builds the tile zoom, col, row of grid from the tiles of src, in the same or another spatial reference.
+// The source zoom level is the coarsest one at least as detailed as the tile around its center, a coarser one is
+// used when more than maxUpstreamTiles source tiles would be needed. fetch returns a tile of src.
+// The parts of the tile outside src are transparent.
+func warp(ctx context.Context, grid, src *Grid, zoom, col, row int, method string, fetch func(ctx context.Context, zoom, col, row int) (image.Image, error)) (img image.Image, err error) {
+	ctx, span := tracing.Start(ctx, "wmts.warp", trace.WithAttributes(
+		attribute.Int("zoom", zoom),
+		attribute.Int("col", col),
+		attribute.Int("row", row),
+		attribute.Int("source_srs", src.SpatialREF),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+	bbox, err := grid.GetTileBBox(zoom, col, row)
+	if err != nil {
+		return nil, err
+	}
+	toSrc, err := coords.NewTransform(grid.SpatialREF, src.SpatialREF)
+	if err != nil {
+		return nil, err
+	}
+	tileSize := int(grid.TileSize)
+	cellSize := bbox.Width() / float64(tileSize)
+
+	// position of the centers of the pixels in src, and the part of the tile covered by src
+	extent := src.GetBBox()
+	points := make([][2]float64, tileSize*tileSize)
+	covered := BBox{XMin: math.Inf(1), YMin: math.Inf(1), XMax: math.Inf(-1), YMax: math.Inf(-1)}
+	var numInside int
+	var sumX, sumY float64
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			wx := bbox.XMin + (float64(x)+0.5)*cellSize
+			wy := bbox.YMax - (float64(y)+0.5)*cellSize
+			sx, sy := toSrc(wx, wy)
+			points[y*tileSize+x] = [2]float64{sx, sy}
+			if sx < extent.XMin || sx > extent.XMax || sy < extent.YMin || sy > extent.YMax {
+				continue
+			}
+			covered = BBox{XMin: min(covered.XMin, sx), YMin: min(covered.YMin, sy), XMax: max(covered.XMax, sx), YMax: max(covered.YMax, sy)}
+			numInside++
+			sumX, sumY = sumX+wx, sumY+wy
+		}
+	}
+	if numInside == 0 {
+		return image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize)), nil
+	}
+
+	// size in src of a pixel at the center of the covered part
+	cx, cy := sumX/float64(numInside), sumY/float64(numInside)
+	x0, y0 := toSrc(cx, cy)
+	x1, y1 := toSrc(cx+cellSize, cy)
+	x2, y2 := toSrc(cx, cy+cellSize)
+	srcZoom := src.ZoomForResolution(math.Sqrt(math.Hypot(x1-x0, y1-y0) * math.Hypot(x2-x0, y2-y0)))
+	var srcRange TileRange
+	for {
+		// half a pixel more, for the bilinear resampling of the pixels on the edges
+		margin := src.resolutions[srcZoom].CellSize / 2
+		srcRange, err = src.TileRange(BBox{XMin: covered.XMin - margin, YMin: covered.YMin - margin, XMax: covered.XMax + margin, YMax: covered.YMax + margin}, srcZoom)
+		if err != nil {
+			return nil, err
+		}
+		if srcRange.Count() <= maxUpstreamTiles {
+			break
+		}
+		coarser, ok := src.coarserZoom(srcZoom)
+		if !ok {
+			return nil, fmt.Errorf("tile zoom:%d, col:%d, row:%d needs more than %d source tiles", zoom, col, row, maxUpstreamTiles)
+		}
+		srcZoom = coarser
+	}
+	span.SetAttributes(attribute.Int("source_zoom", srcZoom))
+	if srcRange.IsEmpty() {
+		return image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize)), nil
+	}
+
+	srcTileSize := int(src.TileSize)
+	mosaic := image.NewNRGBA(image.Rect(0, 0, srcRange.NumCols()*srcTileSize, srcRange.NumRows()*srcTileSize))
+	for r := srcRange.MinRow; r <= srcRange.MaxRow; r++ {
+		for c := srcRange.MinCol; c <= srcRange.MaxCol; c++ {
+			tile, err := fetch(ctx, srcZoom, c, r)
+			if err != nil {
+				return nil, fmt.Errorf("source tile zoom:%d, col:%d, row:%d: %w", srcZoom, c, r, err)
+			}
+			at := image.Pt((c-srcRange.MinCol)*srcTileSize, (r-srcRange.MinRow)*srcTileSize)
+			draw.Draw(mosaic, image.Rectangle{Min: at, Max: at.Add(tile.Bounds().Size())}, tile, tile.Bounds().Min, draw.Src)
+		}
+	}
+	srcCellSize := src.resolutions[srcZoom].CellSize
+	originX := src.topLeftX + float64(srcRange.MinCol)*src.TileSize*srcCellSize
+	originY := src.topLeftY - float64(srcRange.MinRow)*src.TileSize*srcCellSize
+	return imgTools.Resample(mosaic, tileSize, tileSize, func(x, y int) (float64, float64) {
+		p := points[y*tileSize+x]
+		return (p[0] - originX) / srcCellSize, (originY - p[1]) / srcCellSize
+	}, method)
+}
@@ -0,0 +1,58 @@
+package wmts
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestWarp(t *testing.T) {
+	lausanne, webMercator := newTestGrids(t)
+	red := color.NRGBA{R: 255, A: 255}
+	var fetched []TileRange
+	fetch := func(ctx context.Context, zoom, col, row int) (image.Image, error) {
+		fetched = append(fetched, TileRange{Zoom: zoom, MinCol: col, MinRow: row, MaxCol: col, MaxRow: row})
+		img := image.NewNRGBA(image.Rect(0, 0, int(lausanne.TileSize), int(lausanne.TileSize)))
+		draw.Draw(img, img.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+		return img, nil
+	}
+
+	// the WebMercator tile 12/2123/1449 covers the center of Lausanne, inside the LV95 grid
+	img, err := warp(context.Background(), webMercator, lausanne, 12, 2123, 1449, "", fetch)
+	if err != nil {
+		t.Fatalf("warp: %v", err)
+	}
+	if len(fetched) == 0 || len(fetched) > maxUpstreamTiles {
+		t.Fatalf("warp fetched %d source tiles, want 1 to %d", len(fetched), maxUpstreamTiles)
+	}
+	for _, tile := range fetched[1:] {
+		if tile.Zoom != fetched[0].Zoom {
+			t.Errorf("warp fetched tiles of zoom %d and %d, want a single source zoom", fetched[0].Zoom, tile.Zoom)
+		}
+	}
+	for _, p := range []image.Point{{0, 0}, {128, 128}, {255, 255}} {
+		if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)); got != red {
+			t.Errorf("pixel %v = %v, want %v", p, got, red)
+		}
+	}
+
+	// the WebMercator tile 10/526/362 crosses the west edge of the LV95 grid, the part outside it is transparent
+	fetched = nil
+	img, err = warp(context.Background(), webMercator, lausanne, 10, 526, 362, "bilinear", fetch)
+	if err != nil {
+		t.Fatalf("warp: %v", err)
+	}
+	if got := color.NRGBAModel.Convert(img.At(0, 128)).(color.NRGBA); got.A != 0 {
+		t.Errorf("pixel outside the LV95 grid = %v, want transparent", got)
+	}
+	if got := color.NRGBAModel.Convert(img.At(255, 128)); got != red {
+		t.Errorf("pixel inside the LV95 grid = %v, want %v", got, red)
+	}
+
+	// a tile covering the whole LV95 grid would need too many source tiles
+	if _, err := warp(context.Background(), webMercator, lausanne, 3, 4, 2, "", fetch); err == nil {
+		t.Error("warp of the WebMercator tile 3/4/2 must fail")
+	}
+}
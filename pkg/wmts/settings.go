@@ -0,0 +1,96 @@
+package wmts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+)
+
+// Env variables names that can override the YAML settings
+const (
+	EnvBufferSize       = "BUFFER_SIZE"
+	EnvCacheFolder      = "CACHE_FOLDER"
+	EnvClientTimeoutSec = "CLIENT_TIMEOUT_SEC"
+	EnvNumWorkers       = "NUM_WORKERS"
+)
+
+// SettingsConfig holds the optional settings section of the YAML config
+type SettingsConfig struct {
+	BufferSize       *int `yaml:"buffer_size"`
+	ClientTimeoutSec *int `yaml:"client_timeout_sec"`
+	NumWorkers       *int `yaml:"num_workers"`
+}
+
+// SettingsFlags holds the settings given on the command line, a nil field means the flag was not given
+type SettingsFlags struct {
+	BufferSize       *int
+	CacheFolder      *string
+	ClientTimeoutSec *int
+	NumWorkers       *int
+}
+
+// Settings holds the runtime settings shared by the server and the seeder.
+// Each value is resolved with the precedence : command line flag > env variable > YAML > default.
+type Settings struct {
+	BufferSize       int // buffer in pixels around tiles in WMS requests
+	CacheFolder      string
+	ClientTimeoutSec int // timeout of the http client used for the WMS backend
+	NumWorkers       int // number of goroutines used to fetch meta-tiles
+	Sources          map[string]config.Source
+}
+
+// String returns a string representation of the Settings with the source of each value.
+func (s Settings) String() string {
+	values := map[string]string{
+		EnvBufferSize:       fmt.Sprintf("%d", s.BufferSize),
+		EnvCacheFolder:      s.CacheFolder,
+		EnvClientTimeoutSec: fmt.Sprintf("%d", s.ClientTimeoutSec),
+		EnvNumWorkers:       fmt.Sprintf("%d", s.NumWorkers),
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%s (%s)", name, values[name], s.Sources[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ResolveSettings computes the runtime Settings from the command line flags, the env variables,
+// this YAML config and the given defaults.
+func (c *Config) ResolveSettings(flags SettingsFlags, defaults Settings) (Settings, error) {
+	yamlSettings := SettingsConfig{}
+	if c.Settings != nil {
+		yamlSettings = *c.Settings
+	}
+	yamlCacheFolder := ""
+	if c.Caches != nil {
+		yamlCacheFolder = c.Caches.Local.Folder
+	}
+	s := Settings{Sources: make(map[string]config.Source)}
+	var err error
+	var source config.Source
+	if s.BufferSize, source, err = config.ResolveIntSetting(EnvBufferSize, flags.BufferSize, yamlSettings.BufferSize, defaults.BufferSize, 0, 256); err != nil {
+		return s, err
+	}
+	s.Sources[EnvBufferSize] = source
+	if s.ClientTimeoutSec, source, err = config.ResolveIntSetting(EnvClientTimeoutSec, flags.ClientTimeoutSec, yamlSettings.ClientTimeoutSec, defaults.ClientTimeoutSec, 1, 3600); err != nil {
+		return s, err
+	}
+	s.Sources[EnvClientTimeoutSec] = source
+	if s.NumWorkers, source, err = config.ResolveIntSetting(EnvNumWorkers, flags.NumWorkers, yamlSettings.NumWorkers, defaults.NumWorkers, 1, 256); err != nil {
+		return s, err
+	}
+	s.Sources[EnvNumWorkers] = source
+	s.CacheFolder, source = config.ResolveStringSetting(EnvCacheFolder, flags.CacheFolder, yamlCacheFolder, defaults.CacheFolder)
+	s.Sources[EnvCacheFolder] = source
+	if s.CacheFolder == "" {
+		return s, fmt.Errorf("the cache folder must be given in caches.local.folder, env %s or on the command line", EnvCacheFolder)
+	}
+	return s, nil
+}
@@ -0,0 +1,201 @@
+package wmts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Source types of a layer
+const (
+	SourceWMS         = "wms"         // tiles cut from WMS GetMap requests
+	SourceWMTS        = "wmts"        // tiles of an upstream WMTS, url template with {TileMatrix}, {TileCol} and {TileRow}
+	SourceXYZ         = "xyz"         // tiles of an upstream XYZ service, url template with {z}, {x} and {y}
+	SourceComposite   = "composite"   // tiles drawing several layers or WMS requests over each other
+	SourceReprojected = "reprojected" // tiles of another layer warped to the grid of the layer
+)
+
+// SourceTypes lists the supported values of source_type
+var SourceTypes = []string{SourceWMS, SourceWMTS, SourceXYZ, SourceComposite, SourceReprojected}
+
+// TileBuilder builds the tiles of a layer that are not cut from WMS meta-tiles: TileSource, Compositor and Reprojector
+type TileBuilder interface {
+	// TileURL returns the url of the service the tile comes from, used for the logs and the backend settings,
+	// empty when the tile combines several requests
+	TileURL(zoom, col, row int) string
+	// SaveTile builds a tile and writes it as a png to imgPath, it returns the encoded png
+	SaveTile(ctx context.Context, client *http.Client, zoom, col, row int, imgPath string, retry backend.RetryPolicy) ([]byte, error)
+	// SaveTiles saves the tiles of a meta-tile like Grid.SaveTilesFromMetaTile and returns the number of empty ones
+	SaveTiles(ctx context.Context, client *http.Client, zoomLevel, startCol, startRow, numCols, numRows int, lc LayerConfig, basePath string) (numEmpty int, err error)
+}
+
+// templatePlaceholders are the placeholders a tile_url_template must contain for each source type
+var templatePlaceholders = map[string][]string{
+	SourceWMTS: {"{TileMatrix}", "{TileCol}", "{TileRow}"},
+	SourceXYZ:  {"{z}", "{x}", "{y}"},
+}
+
+// maxUpstreamTiles limits the number of upstream tiles fetched to resample one tile
+const maxUpstreamTiles = 64
+
+// TileSource builds the tiles of a layer from an upstream WMTS or XYZ service.
+// When the upstream grid differs from the grid of the layer, each tile is resampled, or reprojected when the
+// spatial references differ, from the upstream tiles covering it.
+type TileSource struct {
+	template   string
+	matrixSet  string // name of the upstream matrix set, replaces {TileMatrixSet}
+	grid       *Grid  // grid of the layer
+	upstream   *Grid  // grid of the upstream tiles, the grid of the layer when they match
+	resampling string
+	layer      LayerConfig
+	l          golog.MyLogger
+}
+
+// NewTileSource returns the TileSource of a layer with a wmts or xyz source_type, grid is the grid of the layer
+func NewTileSource(lc LayerConfig, grid *Grid, l golog.MyLogger) (*TileSource, error) {
+	if lc.Source() == SourceWMS {
+		return nil, fmt.Errorf("layer %s is built from a WMS backend", lc.Name)
+	}
+	s := &TileSource{
+		template:   lc.TileURLTemplate,
+		matrixSet:  lc.WMTSMatrixSet,
+		grid:       grid,
+		upstream:   grid,
+		resampling: lc.Resampling,
+		layer:      lc,
+		l:          l,
+	}
+	if lc.UpstreamMatrixSet != "" && lc.UpstreamMatrixSet != lc.WMTSMatrixSet {
+		upstream, err := NewGridForMatrixSet(lc.UpstreamMatrixSet, "", "", l)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := coords.NewTransform(grid.SpatialREF, upstream.SpatialREF); err != nil {
+			return nil, fmt.Errorf("upstream matrix set %s: %w", lc.UpstreamMatrixSet, err)
+		}
+		s.upstream = upstream
+		s.matrixSet = lc.UpstreamMatrixSet
+	}
+	return s, nil
+}
+
+// Resampled returns true when the tiles are resampled from an upstream grid different from the grid of the layer
+func (s *TileSource) Resampled() bool {
+	return s.upstream != s.grid
+}
+
+// TileURL returns the url of an upstream tile, the XYZ and WMTS placeholders are both replaced
+func (s *TileSource) TileURL(zoom, col, row int) string {
+	z, x, y := strconv.Itoa(zoom), strconv.Itoa(col), strconv.Itoa(row)
+	return strings.NewReplacer(
+		"{z}", z, "{x}", x, "{y}", y,
+		"{TileMatrix}", z, "{TileCol}", x, "{TileRow}", y,
+		"{TileMatrixSet}", s.matrixSet,
+	).Replace(s.template)
+}
+
+// FetchTile returns the image of a tile of the layer grid, a tile missing upstream (404) is transparent
+func (s *TileSource) FetchTile(ctx context.Context, client *http.Client, zoom, col, row int, retry backend.RetryPolicy) (img image.Image, err error) {
+	ctx, span := tracing.Start(ctx, "wmts.TileSource.FetchTile", trace.WithAttributes(
+		attribute.Int("zoom", zoom),
+		attribute.Int("col", col),
+		attribute.Int("row", row),
+		attribute.Bool("resampled", s.Resampled()),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+	if !s.Resampled() {
+		return s.fetchUpstream(ctx, client, zoom, col, row, retry)
+	}
+	return s.resample(ctx, client, zoom, col, row, retry)
+}
+
+func (s *TileSource) fetchUpstream(ctx context.Context, client *http.Client, zoom, col, row int, retry backend.RetryPolicy) (image.Image, error) {
+	size := int(s.upstream.TileSize)
+	img, err := tools.FetchImage(ctx, client, s.TileURL(zoom, col, row), size, size, retry, s.l)
+	var statusErr *backend.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		// sparse sources do not have the empty tiles
+		return image.NewNRGBA(image.Rect(0, 0, size, size)), nil
+	}
+	return img, err
+}
+
+// resample builds the tile from the upstream tiles covering it
+func (s *TileSource) resample(ctx context.Context, client *http.Client, zoom, col, row int, retry backend.RetryPolicy) (image.Image, error) {
+	return warp(ctx, s.grid, s.upstream, zoom, col, row, s.resampling, func(ctx context.Context, zoom, col, row int) (image.Image, error) {
+		return s.fetchUpstream(ctx, client, zoom, col, row, retry)
+	})
+}
+
+// SaveTile fetches a tile of the layer grid and writes it as a png to imgPath, it returns the encoded png
+func (s *TileSource) SaveTile(ctx context.Context, client *http.Client, zoom, col, row int, imgPath string, retry backend.RetryPolicy) ([]byte, error) {
+	img, err := s.FetchTile(ctx, client, zoom, col, row, retry)
+	if err != nil {
+		return nil, err
+	}
+	return writeTile(img, imgPath, s.layer.TileFilter(col, row, int(s.grid.TileSize)))
+}
+
+// SaveTiles saves the numCols x numRows tiles starting at (startCol, startRow) like Grid.SaveTilesFromMetaTile,
+// the tiles outside the grid are skipped. It returns the number of saved tiles detected as empty.
+func (s *TileSource) SaveTiles(ctx context.Context, client *http.Client, zoomLevel, startCol, startRow, numCols, numRows int, lc LayerConfig, basePath string) (numEmpty int, err error) {
+	retry := backend.Default.RetryPolicyForURL(s.template)
+	return saveEachTile(s.grid, zoomLevel, startCol, startRow, numCols, numRows, lc, basePath, func(col, row int, imgPath string) ([]byte, error) {
+		return s.SaveTile(ctx, client, zoomLevel, col, row, imgPath, retry)
+	})
+}
+
+// saveEachTile calls save for each tile of the meta-tile inside the grid and counts the empty ones
+func saveEachTile(grid *Grid, zoomLevel, startCol, startRow, numCols, numRows int, lc LayerConfig, basePath string, save func(col, row int, imgPath string) ([]byte, error)) (numEmpty int, err error) {
+	for row := startRow; row < startRow+numRows; row++ {
+		for col := startCol; col < startCol+numCols; col++ {
+			if !grid.IsValidTile(zoomLevel, col, row) {
+				continue
+			}
+			imgPath := GetWmtsImgPath(basePath, lc.WMTSURLPrefix, lc.Name, lc.WMTSURLStyle, lc.WMTSDimensionYear, lc.WMTSMatrixSet, DefaultImageFormat, zoomLevel, row, col)
+			encoded, err := save(col, row, imgPath)
+			if err != nil {
+				return numEmpty, fmt.Errorf("tile zoom:%d, col:%d, row:%d: %w", zoomLevel, col, row, err)
+			}
+			if lc.IsEmptyTile(encoded) {
+				numEmpty++
+			}
+		}
+	}
+	return numEmpty, nil
+}
+
+// writeTile applies filter, when not nil, to img then encodes it as a png written atomically to imgPath.
+// It returns the encoded png.
+func writeTile(img image.Image, imgPath string, filter func(image.Image) (image.Image, error)) ([]byte, error) {
+	if filter != nil {
+		var err error
+		if img, err = filter(img); err != nil {
+			return nil, err
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return nil, fmt.Errorf("failed to encode tile image: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(imgPath), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory for tile: %w", err)
+	}
+	return encoded.Bytes(), tools.WriteFileAtomic(imgPath, encoded.Bytes())
+}
@@ -0,0 +1,85 @@
+package wmts
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+)
+
+func TestTileSourceTileURL(t *testing.T) {
+	_, webMercator := newTestGrids(t)
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"https://tiles.example.org/{z}/{x}/{y}.png", "https://tiles.example.org/5/17/11.png"},
+		{"https://wmts.example.org/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.png", "https://wmts.example.org/WebMercatorQuad/5/11/17.png"},
+		{"https://tiles.example.org/tile?z={z}&x={x}&y={y}&z2={z}", "https://tiles.example.org/tile?z=5&x=17&y=11&z2=5"},
+	}
+	for _, tt := range tests {
+		lc := LayerConfig{LayerDefaultValues: LayerDefaultValues{WMTSMatrixSet: WebMercatorMatrixSet}, SourceType: SourceXYZ, TileURLTemplate: tt.template}
+		source, err := NewTileSource(lc, webMercator, newTestLogger(t))
+		if err != nil {
+			t.Fatalf("NewTileSource: %v", err)
+		}
+		if got := source.TileURL(5, 17, 11); got != tt.want {
+			t.Errorf("TileURL(%s) = %s, want %s", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestTileSourceFetchTile(t *testing.T) {
+	_, webMercator := newTestGrids(t)
+	tile := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for i := range tile.Pix {
+		tile.Pix[i] = 255
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, tile); err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1/0/0.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(encoded.Bytes())
+		case "/1/1/0.png":
+			http.Error(w, "backend failure", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	lc := LayerConfig{LayerDefaultValues: LayerDefaultValues{WMTSMatrixSet: WebMercatorMatrixSet}, SourceType: SourceXYZ, TileURLTemplate: upstream.URL + "/{z}/{x}/{y}.png"}
+	source, err := NewTileSource(lc, webMercator, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("NewTileSource: %v", err)
+	}
+
+	img, err := source.FetchTile(context.Background(), upstream.Client(), 1, 0, 0, backend.NoRetry)
+	if err != nil {
+		t.Fatalf("FetchTile(1, 0, 0): %v", err)
+	}
+	if got := color.NRGBAModel.Convert(img.At(10, 10)).(color.NRGBA); got.A != 255 {
+		t.Errorf("FetchTile(1, 0, 0) pixel = %v, want the opaque upstream tile", got)
+	}
+	// a tile missing upstream is transparent, like the empty tiles of a sparse source
+	img, err = source.FetchTile(context.Background(), upstream.Client(), 1, 0, 1, backend.NoRetry)
+	if err != nil {
+		t.Fatalf("FetchTile(1, 0, 1): %v", err)
+	}
+	if img.Bounds().Dx() != 256 || img.Bounds().Dy() != 256 || color.NRGBAModel.Convert(img.At(10, 10)).(color.NRGBA).A != 0 {
+		t.Errorf("FetchTile(1, 0, 1) = %v image, want a transparent 256x256 tile", img.Bounds())
+	}
+	// the other errors are returned
+	if _, err := source.FetchTile(context.Background(), upstream.Client(), 1, 1, 0, backend.NoRetry); err == nil {
+		t.Error("FetchTile(1, 1, 0) on a 500 response must fail")
+	}
+}
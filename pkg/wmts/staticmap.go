@@ -0,0 +1,262 @@
+package wmts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// MaxStaticMapSize is the largest width or height in pixels of a static map
+	MaxStaticMapSize = 4096
+	// maxStaticMapTiles limits the tiles of a static map, a coarser zoom level is used when more would be needed
+	maxStaticMapTiles = 400
+	// staticMapFetchers is the number of tiles of a static map read or fetched at the same time
+	staticMapFetchers = 4
+	// staticMapMaxMissingTiles is the number of tiles missing in the cache above which the static map of a layer
+	// with a WMS source is requested from its backend in a single GetMap rather than tile by tile
+	staticMapMaxMissingTiles = 16
+)
+
+// ErrStaticMapTooLarge is returned for a static map larger than MaxStaticMapSize or needing too many tiles
+var ErrStaticMapTooLarge = errors.New("static map too large")
+
+// Marker is a point drawn on a static map, in the spatial reference of its bbox
+type Marker struct {
+	X, Y  float64
+	Color color.NRGBA
+}
+
+// StaticMap describes an image of the bbox of a layer, in the spatial reference of the layer grid
+type StaticMap struct {
+	BBox     BBox
+	Width    int
+	Height   int
+	Markers  []Marker
+	ScaleBar bool
+}
+
+// RenderStaticMap draws the static map m of a layer of state from its tiles at the zoom level the closest to
+// the size of its pixels. The tiles are read from the cache, a missing one is fetched and cached once like for
+// a tile request. When more than staticMapMaxMissingTiles tiles are missing, the map of a layer with a WMS source
+// is requested from its backend in a single GetMap instead, and nothing is cached.
+// When the bbox and the size do not have the same proportions the image is stretched.
+func RenderStaticMap(ctx context.Context, client *http.Client, state *LayersState, layer string, m StaticMap) (img *image.NRGBA, err error) {
+	ctx, span := tracing.Start(ctx, "wmts.RenderStaticMap", trace.WithAttributes(
+		attribute.String("layer", layer),
+		attribute.Int("width", m.Width),
+		attribute.Int("height", m.Height),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+	lc, grid, exists := state.Layer(layer)
+	if !exists {
+		return nil, fmt.Errorf("unknown layer %q", layer)
+	}
+	if m.Width < 1 || m.Height < 1 || m.Width > MaxStaticMapSize || m.Height > MaxStaticMapSize {
+		return nil, fmt.Errorf("%w: %dx%d, width and height must be between 1 and %d", ErrStaticMapTooLarge, m.Width, m.Height, MaxStaticMapSize)
+	}
+	if !(m.BBox.XMax > m.BBox.XMin && m.BBox.YMax > m.BBox.YMin) {
+		return nil, fmt.Errorf("invalid bbox [%s], xmin must be less than xmax and ymin less than ymax", m.BBox.String())
+	}
+	gridBBox := grid.GetBBox()
+	if !gridBBox.Intersects(m.BBox) {
+		return nil, fmt.Errorf("%w: bbox [%s]", ErrOutsideGridExtent, m.BBox.String())
+	}
+	tiles, err := staticMapTiles(grid, m)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("zoom", tiles.Zoom), attribute.Int("tiles", tiles.Count()))
+
+	if lc.Source() == SourceWMS && missingTiles(NewFileStore(state.BasePath()), lc, tiles, staticMapMaxMissingTiles) > staticMapMaxMissingTiles {
+		span.SetAttributes(attribute.Bool("backend_getmap", true))
+		img, err = backendStaticMap(ctx, client, lc, grid, m)
+	} else {
+		img, err = tilesStaticMap(ctx, client, state, layer, tiles, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resX, resY := m.BBox.Width()/float64(m.Width), m.BBox.Height()/float64(m.Height)
+	for _, marker := range m.Markers {
+		x := int(math.Floor((marker.X - m.BBox.XMin) / resX))
+		y := int(math.Floor((m.BBox.YMax - marker.Y) / resY))
+		imgTools.DrawMarker(img, x, y, marker.Color)
+	}
+	if m.ScaleBar {
+		if err := imgTools.DrawScaleBar(img, groundResolution(grid, m.BBox, resX)); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// staticMapTiles returns the tiles of the static map m at the zoom level the closest to the size of its pixels,
+// or at a coarser one when it would need more than maxStaticMapTiles tiles
+func staticMapTiles(grid *Grid, m StaticMap) (TileRange, error) {
+	zoom := grid.ZoomForResolution(min(m.BBox.Width()/float64(m.Width), m.BBox.Height()/float64(m.Height)))
+	for {
+		tiles, err := grid.TileRange(m.BBox, zoom)
+		if err != nil {
+			return TileRange{}, err
+		}
+		if tiles.Count() <= maxStaticMapTiles {
+			return tiles, nil
+		}
+		coarser, ok := grid.coarserZoom(zoom)
+		if !ok {
+			return TileRange{}, fmt.Errorf("%w: it needs more than %d tiles", ErrStaticMapTooLarge, maxStaticMapTiles)
+		}
+		zoom = coarser
+	}
+}
+
+// tilesStaticMap draws the static map m of a layer from the tiles of the range, cropped or resampled
+func tilesStaticMap(ctx context.Context, client *http.Client, state *LayersState, layer string, tiles TileRange, m StaticMap) (*image.NRGBA, error) {
+	lc, grid, _ := state.Layer(layer)
+	source := layerTiles{
+		layer:    lc,
+		grid:     grid,
+		builder:  state.Builder(layer),
+		basePath: state.BasePath(),
+		buffer:   state.Settings.BufferSize,
+		l:        grid.l,
+	}
+	mosaic, err := staticMapMosaic(ctx, client, &source, tiles)
+	if err != nil {
+		return nil, err
+	}
+
+	resX, resY := m.BBox.Width()/float64(m.Width), m.BBox.Height()/float64(m.Height)
+	cellSize := grid.resolutions[tiles.Zoom].CellSize
+	originX := grid.topLeftX + float64(tiles.MinCol)*grid.TileSize*cellSize
+	originY := grid.topLeftY - float64(tiles.MinRow)*grid.TileSize*cellSize
+	offsetX, offsetY := (m.BBox.XMin-originX)/cellSize, (originY-m.BBox.YMax)/cellSize
+	if sameSize(resX, cellSize) && sameSize(resY, cellSize) && isWhole(offsetX) && isWhole(offsetY) {
+		// the pixels of the map are the ones of the tiles
+		at := image.Pt(int(math.Round(offsetX)), int(math.Round(offsetY)))
+		return imgTools.Crop(mosaic, image.Rectangle{Min: at, Max: at.Add(image.Pt(m.Width, m.Height))}), nil
+	}
+	return imgTools.Resample(mosaic, m.Width, m.Height, func(x, y int) (float64, float64) {
+		return offsetX + (float64(x)+0.5)*resX/cellSize, offsetY + (float64(y)+0.5)*resY/cellSize
+	}, imgTools.ResampleBilinear)
+}
+
+// missingTiles counts the tiles of the range missing in the store, up to limit + 1
+func missingTiles(store *FileStore, lc LayerConfig, tiles TileRange, limit int) int {
+	missing := 0
+	for row := tiles.MinRow; row <= tiles.MaxRow; row++ {
+		for col := tiles.MinCol; col <= tiles.MaxCol; col++ {
+			if _, err := store.Stat(lc, tiles.Zoom, row, col); err != nil {
+				if missing++; missing > limit {
+					return missing
+				}
+			}
+		}
+	}
+	return missing
+}
+
+// backendStaticMap requests the static map m of a layer with a WMS source from its backend, its filters applied
+func backendStaticMap(ctx context.Context, client *http.Client, lc LayerConfig, grid *Grid, m StaticMap) (*image.NRGBA, error) {
+	req := WMSMapRequest{CRS: grid.SpatialREF, BBox: m.BBox, Width: m.Width, Height: m.Height}
+	wmsURL := backendMapURL(grid, backendGetMapParams(lc, req))
+	// a request is not retried, sleeping would hold the answer like for a tile request
+	src, err := tools.FetchImage(ctx, client, wmsURL, m.Width, m.Height, backend.NoRetry, grid.l)
+	if err != nil {
+		return nil, err
+	}
+	if src, err = imgTools.ApplyFilters(src, lc.Filters, image.Point{}); err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, m.Width, m.Height))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	return img, nil
+}
+
+// staticMapMosaic draws the tiles of the range side by side, they are read or fetched by staticMapFetchers goroutines
+func staticMapMosaic(ctx context.Context, client *http.Client, source *layerTiles, tiles TileRange) (*image.NRGBA, error) {
+	tileSize := int(source.grid.TileSize)
+	mosaic := image.NewNRGBA(image.Rect(0, 0, tiles.NumCols()*tileSize, tiles.NumRows()*tileSize))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	positions := make(chan image.Point)
+	for range staticMapFetchers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range positions {
+				// a request is not retried, sleeping would hold the answer like for a tile request
+				tile, err := source.image(ctx, client, tiles.Zoom, p.X, p.Y, func(string) backend.RetryPolicy { return backend.NoRetry })
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("tile zoom:%d, col:%d, row:%d: %w", tiles.Zoom, p.X, p.Y, err)
+					cancel()
+				}
+				if err == nil {
+					at := image.Pt((p.X-tiles.MinCol)*tileSize, (p.Y-tiles.MinRow)*tileSize)
+					draw.Draw(mosaic, image.Rectangle{Min: at, Max: at.Add(tile.Bounds().Size())}, tile, tile.Bounds().Min, draw.Src)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+enqueue:
+	for row := tiles.MinRow; row <= tiles.MaxRow; row++ {
+		for col := tiles.MinCol; col <= tiles.MaxCol; col++ {
+			select {
+			case <-ctx.Done():
+				break enqueue
+			case positions <- image.Pt(col, row):
+			}
+		}
+	}
+	close(positions)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return mosaic, ctx.Err()
+}
+
+// groundResolution returns the ground size in meters of a pixel of res units of grid at the center of bbox.
+// The meters of WebMercator are only true at the equator.
+func groundResolution(grid *Grid, bbox BBox, res float64) float64 {
+	res *= float64(grid.MetersPerUnit)
+	if grid.SpatialREF == coords.WebMercator {
+		toWGS84, err := coords.NewTransform(coords.WebMercator, coords.WGS84)
+		if err == nil {
+			_, lat := toWGS84((bbox.XMin+bbox.XMax)/2, (bbox.YMin+bbox.YMax)/2)
+			res *= math.Cos(lat * math.Pi / 180)
+		}
+	}
+	return res
+}
+
+func sameSize(a, b float64) bool {
+	return math.Abs(a-b) <= b*1e-9
+}
+
+func isWhole(v float64) bool {
+	return math.Abs(v-math.Round(v)) < 1e-6
+}
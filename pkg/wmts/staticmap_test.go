@@ -0,0 +1,197 @@
+package wmts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// the tiles of the zoom level 0 of the Lausanne grid are 12800 meters wide, from the top left corner 2420000, 1350000
+func TestStaticMapTiles(t *testing.T) {
+	lausanne, _ := newTestGrids(t)
+	bbox := BBox{XMin: 2530000, YMin: 1150000, XMax: 2535120, YMax: 1155120}
+	tests := []struct {
+		name     string
+		m        StaticMap
+		wantZoom int
+	}{
+		{"pixels of a zoom level", StaticMap{BBox: bbox, Width: 1024, Height: 1024}, 3},
+		{"pixels between two zoom levels", StaticMap{BBox: bbox, Width: 800, Height: 800}, 3},
+		{"pixels larger than the coarsest zoom level", StaticMap{BBox: bbox, Width: 10, Height: 10}, 0},
+		{"smallest side of the pixels", StaticMap{BBox: bbox, Width: 1024, Height: 256}, 3},
+		{"coarser zoom level instead of too many tiles", StaticMap{BBox: BBox{XMin: 2530000, YMin: 1150000, XMax: 2533686.4, YMax: 1153686.4}, Width: 4096, Height: 4096}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiles, err := staticMapTiles(lausanne, tt.m)
+			if err != nil {
+				t.Fatalf("staticMapTiles: %v", err)
+			}
+			if tiles.Zoom != tt.wantZoom || tiles.Count() > maxStaticMapTiles {
+				t.Errorf("staticMapTiles() = %s, want zoom %d and at most %d tiles", tiles, tt.wantZoom, maxStaticMapTiles)
+			}
+		})
+	}
+}
+
+func TestRenderStaticMap(t *testing.T) {
+	red, blue, green := color.NRGBA{R: 0xff, A: 0xff}, color.NRGBA{B: 0xff, A: 0xff}, color.NRGBA{G: 0xff, A: 0xff}
+	uniformPNG := func(width, height int, c color.NRGBA) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// the WMS backend draws every map in green at the size requested
+	var requests atomic.Int32
+	var lastWidth atomic.Value
+	wms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		width, _ := strconv.Atoi(r.URL.Query().Get("WIDTH"))
+		height, _ := strconv.Atoi(r.URL.Query().Get("HEIGHT"))
+		lastWidth.Store(width)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(uniformPNG(width, height, green))
+	}))
+	defer wms.Close()
+	lc := LayerConfig{
+		LayerDefaultValues: LayerDefaultValues{
+			WMTSURLPrefix:     "tiles/1.0.0",
+			WMTSURLStyle:      "default",
+			WMTSDimensionYear: "2025",
+			WMTSMatrixSet:     LausanneMatrixSet,
+			WMSBackendURL:     wms.URL,
+		},
+		Name:      "plan",
+		WMSLayers: "plan",
+	}
+	state, err := NewLayersState(&Config{Layers: map[string]LayerConfig{"plan": lc}}, Settings{CacheFolder: t.TempDir(), BufferSize: 0}, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("NewLayersState: %v", err)
+	}
+	store := NewFileStore(state.BasePath())
+	// the tile at row 3, col 2 of zoom 0 is red, the one on its right is blue
+	if err := store.Write(lc, 0, 3, 2, uniformPNG(256, 256, red)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write(lc, 0, 3, 3, uniformPNG(256, 256, blue)); err != nil {
+		t.Fatal(err)
+	}
+	// 5000 meters across the edge of the two tiles at x 2458400
+	bbox := BBox{XMin: 2455000, YMin: 1300000, XMax: 2460000, YMax: 1305000}
+	at := func(img image.Image, x, y int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+
+	t.Run("pixels of the tiles are cropped", func(t *testing.T) {
+		requests.Store(0)
+		img, err := RenderStaticMap(context.Background(), wms.Client(), state, "plan", StaticMap{BBox: bbox, Width: 100, Height: 100})
+		if err != nil {
+			t.Fatalf("RenderStaticMap: %v", err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 100, 100) || at(img, 67, 50) != red || at(img, 68, 50) != blue {
+			t.Errorf("map %v with pixels %v and %v, want 100x100 red up to x 67 then blue", img.Bounds(), at(img, 67, 50), at(img, 68, 50))
+		}
+		if requests.Load() != 0 {
+			t.Errorf("%d backend requests for cached tiles", requests.Load())
+		}
+	})
+	t.Run("larger pixels are resampled", func(t *testing.T) {
+		img, err := RenderStaticMap(context.Background(), wms.Client(), state, "plan", StaticMap{BBox: bbox, Width: 50, Height: 50})
+		if err != nil {
+			t.Fatalf("RenderStaticMap: %v", err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 50, 50) || at(img, 10, 25) != red || at(img, 45, 25) != blue {
+			t.Errorf("map %v with pixels %v and %v, want 50x50 red on the left and blue on the right", img.Bounds(), at(img, 10, 25), at(img, 45, 25))
+		}
+	})
+	t.Run("marker and scale bar", func(t *testing.T) {
+		marker := color.NRGBA{R: 0xff, G: 0xff, A: 0xff}
+		img, err := RenderStaticMap(context.Background(), wms.Client(), state, "plan", StaticMap{
+			BBox:     bbox,
+			Width:    100,
+			Height:   100,
+			Markers:  []Marker{{X: 2455000 + 25*50 + 25, Y: 1305000 - 25*50 - 25, Color: marker}},
+			ScaleBar: true,
+		})
+		if err != nil {
+			t.Fatalf("RenderStaticMap: %v", err)
+		}
+		if got := at(img, 25, 25); got != marker {
+			t.Errorf("pixel of the marker = %v, want %v", got, marker)
+		}
+		// a quarter of the 5000 meters is rounded to a bar of 1000 meters, 20 pixels from x 10 and 12 pixels above the bottom
+		if got := at(img, 15, 85); got != (color.NRGBA{A: 0xff}) {
+			t.Errorf("pixel of the scale bar = %v, want black", got)
+		}
+		if got := at(img, 50, 50); got != red {
+			t.Errorf("pixel away from the marker and the scale bar = %v, want red", got)
+		}
+	})
+	t.Run("few missing tiles are fetched and cached", func(t *testing.T) {
+		requests.Store(0)
+		// the tile at row 4, col 2 is not cached
+		below := BBox{XMin: 2450000, YMin: 1290000, XMax: 2455000, YMax: 1295000}
+		img, err := RenderStaticMap(context.Background(), wms.Client(), state, "plan", StaticMap{BBox: below, Width: 100, Height: 100})
+		if err != nil {
+			t.Fatalf("RenderStaticMap: %v", err)
+		}
+		if requests.Load() != 1 || at(img, 50, 50) != green {
+			t.Errorf("%d backend requests and pixel %v, want 1 and green", requests.Load(), at(img, 50, 50))
+		}
+		if _, err := store.Stat(lc, 0, 4, 2); err != nil {
+			t.Errorf("the missing tile is not cached: %v", err)
+		}
+	})
+	t.Run("many missing tiles are requested in one GetMap", func(t *testing.T) {
+		requests.Store(0)
+		// 100 kilometers wide, more than 64 tiles of the zoom level 0 and none of them cached
+		far := BBox{XMin: 2548000, YMin: 1119600, XMax: 2650400, YMax: 1222000}
+		img, err := RenderStaticMap(context.Background(), wms.Client(), state, "plan", StaticMap{BBox: far, Width: 2048, Height: 2048})
+		if err != nil {
+			t.Fatalf("RenderStaticMap: %v", err)
+		}
+		if requests.Load() != 1 || lastWidth.Load() != 2048 || img.Bounds() != image.Rect(0, 0, 2048, 2048) || at(img, 1000, 1000) != green {
+			t.Errorf("%d backend requests %v pixels wide for a %v map, want a single GetMap of the 2048x2048 map", requests.Load(), lastWidth.Load(), img.Bounds())
+		}
+		if _, err := store.Stat(lc, 0, 10, 10); err == nil {
+			t.Error("a tile of the map requested in one GetMap is cached")
+		}
+	})
+	t.Run("invalid maps are rejected", func(t *testing.T) {
+		requests.Store(0)
+		for _, tt := range []struct {
+			name    string
+			m       StaticMap
+			wantErr error
+		}{
+			{"outside the grid", StaticMap{BBox: BBox{XMin: 0, YMin: 0, XMax: 10, YMax: 10}, Width: 10, Height: 10}, ErrOutsideGridExtent},
+			{"too wide", StaticMap{BBox: bbox, Width: MaxStaticMapSize + 1, Height: 10}, ErrStaticMapTooLarge},
+			{"empty", StaticMap{BBox: bbox, Width: 0, Height: 10}, ErrStaticMapTooLarge},
+			{"inverted bbox", StaticMap{BBox: BBox{XMin: bbox.XMax, YMin: bbox.YMin, XMax: bbox.XMin, YMax: bbox.YMax}, Width: 10, Height: 10}, nil},
+		} {
+			_, err := RenderStaticMap(context.Background(), wms.Client(), state, "plan", tt.m)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("RenderStaticMap(%s) error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+		}
+		if _, err := RenderStaticMap(context.Background(), wms.Client(), state, "other", StaticMap{BBox: bbox, Width: 10, Height: 10}); err == nil {
+			t.Error("RenderStaticMap() accepted an unknown layer")
+		}
+		if requests.Load() != 0 {
+			t.Errorf("%d backend requests for invalid maps", requests.Load())
+		}
+	})
+}
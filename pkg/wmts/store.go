@@ -0,0 +1,136 @@
+package wmts
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+)
+
+// StaleModTime is the modification time given to a cached tile to mark it as stale.
+// A stale tile can still be served but must be fetched again from the backend.
+var StaleModTime = time.Unix(0, 0)
+
+// IsStaleModTime returns true if the modification time is the one of a tile marked as stale
+func IsStaleModTime(modTime time.Time) bool {
+	return !modTime.After(StaleModTime)
+}
+
+// TileETag returns a strong ETag computed from the tile content, the one of the tiles served
+func TileETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("\"%x\"", sum[:16])
+}
+
+// FileStore is the tiles cache stored in a classical directory tree, see GetWmtsImgPath
+type FileStore struct {
+	BasePath string
+}
+
+// NewFileStore returns a FileStore rooted at basePath
+func NewFileStore(basePath string) *FileStore {
+	return &FileStore{BasePath: basePath}
+}
+
+// TilePath returns the path of the tile image file
+func (s *FileStore) TilePath(lc LayerConfig, zoom, row, col int) string {
+	return GetWmtsImgPath(s.BasePath, lc.WMTSURLPrefix, lc.Name, lc.WMTSURLStyle, lc.WMTSDimensionYear, lc.WMTSMatrixSet, DefaultImageFormat, zoom, row, col)
+}
+
+// Stat returns the file information of the tile, the error satisfies errors.Is(err, fs.ErrNotExist) if not cached
+func (s *FileStore) Stat(lc LayerConfig, zoom, row, col int) (fs.FileInfo, error) {
+	return os.Stat(s.TilePath(lc, zoom, row, col))
+}
+
+// Rows returns the sorted rows between minRow and maxRow having cached tiles at the zoom level
+func (s *FileStore) Rows(lc LayerConfig, zoom, minRow, maxRow int) ([]int, error) {
+	return cachedIndexes(filepath.Dir(filepath.Dir(s.TilePath(lc, zoom, 0, 0))), "", minRow, maxRow)
+}
+
+// Cols returns the sorted columns between minCol and maxCol of the cached tiles of the row
+func (s *FileStore) Cols(lc LayerConfig, zoom, row, minCol, maxCol int) ([]int, error) {
+	return cachedIndexes(filepath.Dir(s.TilePath(lc, zoom, row, 0)), "."+DefaultImageFormat, minCol, maxCol)
+}
+
+// cachedIndexes returns the sorted numbers between first and last of the entries of dir, the folders of the rows
+// when ext is empty, else the files of the tiles, nothing when dir does not exist
+func cachedIndexes(dir, ext string, first, last int) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var indexes []int
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), ext)
+		if entry.IsDir() != (ext == "") || !found {
+			continue
+		}
+		if i, err := strconv.Atoi(name); err == nil && i >= first && i <= last {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// Write stores the encoded tile in the cache, creating its folder. The tile is written in a temporary file
+// renamed once complete, so the server reading the cache never sees a partial tile.
+func (s *FileStore) Write(lc LayerConfig, zoom, row, col int, data []byte) error {
+	return s.WriteModTime(lc, zoom, row, col, data, time.Time{})
+}
+
+// WriteModTime is Write giving the modification time modTime to the tile, the current time when modTime is zero
+func (s *FileStore) WriteModTime(lc LayerConfig, zoom, row, col int, data []byte, modTime time.Time) error {
+	path := s.TilePath(lc, zoom, row, col)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("cannot create the folder of tile zoom:%d, row:%d, col:%d: %w", zoom, row, col, err)
+	}
+	if err := tools.WriteFileAtomicModTime(path, data, modTime); err != nil {
+		return fmt.Errorf("cannot write tile zoom:%d, row:%d, col:%d: %w", zoom, row, col, err)
+	}
+	return nil
+}
+
+// Delete removes the tile from the cache, it returns false if the tile was not cached
+func (s *FileStore) Delete(lc LayerConfig, zoom, row, col int) (bool, error) {
+	err := os.Remove(s.TilePath(lc, zoom, row, col))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot delete tile zoom:%d, row:%d, col:%d: %w", zoom, row, col, err)
+	}
+	return true, nil
+}
+
+// MarkStale keeps the tile in the cache but marks it as stale, it returns false if the tile was not cached
+func (s *FileStore) MarkStale(lc LayerConfig, zoom, row, col int) (bool, error) {
+	err := os.Chtimes(s.TilePath(lc, zoom, row, col), time.Now(), StaleModTime)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot mark tile zoom:%d, row:%d, col:%d as stale: %w", zoom, row, col, err)
+	}
+	return true, nil
+}
+
+// IsTileExpired returns true if a tile cached at modTime must be refreshed from the backend,
+// either because it was marked as stale or because it is older than the layer max_age_sec
+func (lc LayerConfig) IsTileExpired(modTime, now time.Time) bool {
+	if IsStaleModTime(modTime) {
+		return true
+	}
+	return lc.MaxAgeSec > 0 && now.Sub(modTime) > time.Duration(lc.MaxAgeSec)*time.Second
+}
@@ -0,0 +1,55 @@
+package wmts
+
+import (
+	"math"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// SwisstopoMatrixSet is the name of the LV95 tile matrix set of the swisstopo WMTS (wmts.geo.admin.ch)
+const SwisstopoMatrixSet = "2056"
+
+// SwisstopoGridBBox is the extent of the swisstopo LV95 grid (EPSG:2056)
+var SwisstopoGridBBox = BBox{
+	XMin: 2420000.0,
+	YMin: 1030000.0,
+	XMax: 2900000.0,
+	YMax: 1350000.0,
+}
+
+// swisstopoResolutions are the cell sizes in meters of the 29 zoom levels of the swisstopo LV95 grid
+var swisstopoResolutions = []float64{
+	4000, 3750, 3500, 3250, 3000, 2750, 2500, 2250, 2000, 1750, 1500, 1250, 1000, 750, 650,
+	500, 250, 100, 50, 20, 10, 5, 2.5, 2, 1.5, 1, 0.5, 0.25, 0.1,
+}
+
+// NewSwisstopoGrid creates the grid of the swisstopo LV95 tile matrix set, it is used to read tiles from
+// the swisstopo WMTS but may also serve layers built from a WMS backend.
+func NewSwisstopoGrid(wmsBackEndUrl, wmsStartParams string, l golog.MyLogger) *Grid {
+	if l == nil {
+		panic("💥💥 panic in NewSwisstopoGrid : logger cannot be nil")
+	}
+	resolutions := make(map[int]Resolution, len(swisstopoResolutions))
+	for zoom, cellSize := range swisstopoResolutions {
+		resolutions[zoom] = Resolution{
+			ScaleDenominator: cellSize / 0.00028, // OGC standardized rendering pixel size of 0.28 mm
+			CellSize:         cellSize,
+			MatrixWidth:      math.Ceil((SwisstopoGridBBox.XMax - SwisstopoGridBBox.XMin) / (DefaultTileSize * cellSize)),
+			MatrixHeight:     math.Ceil((SwisstopoGridBBox.YMax - SwisstopoGridBBox.YMin) / (DefaultTileSize * cellSize)),
+		}
+	}
+	return &Grid{
+		Bbox:            SwisstopoGridBBox,
+		SpatialREF:      DefaultSpatialRef,
+		TileURLTemplate: "{zoom}/{tileCol}/{tileRow}.png",
+		UNIT:            "meters",
+		MetersPerUnit:   1,
+		TileSize:        DefaultTileSize,
+		topLeftX:        SwisstopoGridBBox.XMin,
+		topLeftY:        SwisstopoGridBBox.YMax,
+		WmsBackendUrl:   wmsBackEndUrl,
+		WmsStartParams:  wmsStartParams,
+		resolutions:     resolutions,
+		l:               l,
+	}
+}
@@ -1,7 +1,5 @@
 package wmts
 
-import "fmt"
-
 type TileInfo struct {
 	ZoomLevel int
 	Col       int
@@ -9,42 +7,22 @@ type TileInfo struct {
 	BBox      BBox
 }
 
-// GetTileInfoByXY returns the tile version for the given x, y and zoom level.
-func (g *Grid) GetTileInfoByXY(x, y, zoomLevel int) (*TileInfo, error) {
-	if x < 0 || y < 0 || zoomLevel < 0 {
-		return nil, fmt.Errorf("invalid x, y or zoom level")
-	}
-
-	if _, ok := g.resolutions[zoomLevel]; !ok {
-		return nil, fmt.Errorf("zoom level %d not supported", zoomLevel)
-	}
-
-	// Get the resolution for the given zoom level.
-	zoomInfo := g.resolutions[zoomLevel]
-	resolution := zoomInfo.CellSize
-
-	// Calculate the tile column and row.
-	tileCol := int((float64(x) - g.topLeftX) / (g.TileSize * resolution))
-	tileRow := int((g.topLeftY - float64(y)) / (g.TileSize * resolution))
-
-	// Check if the tile indices are valid.
-	if !g.IsValidTile(zoomLevel, tileCol, tileRow) {
-		return nil, fmt.Errorf("invalid tile indices")
+// GetTileInfoByXY returns the tile containing the coordinates x, y at the given zoom level.
+// The coordinates are in the spatial reference of the grid, they may be negative like in WebMercator.
+// The error wraps ErrZoomOutOfRange or ErrOutsideGridExtent.
+func (g *Grid) GetTileInfoByXY(x, y float64, zoomLevel int) (*TileInfo, error) {
+	tileCol, tileRow, err := g.GetTile(x, y, zoomLevel)
+	if err != nil {
+		return nil, err
 	}
-
-	// Calculate the bounding box for the tile.
 	tileBBox, err := g.GetTileBBox(zoomLevel, tileCol, tileRow)
 	if err != nil {
-		return nil, fmt.Errorf("error calculating tile bounding box")
+		return nil, err
 	}
-
-	// Create a new TileInfo object.
-	tileInfo := &TileInfo{
+	return &TileInfo{
 		ZoomLevel: zoomLevel,
 		Col:       tileCol,
 		Row:       tileRow,
 		BBox:      *tileBBox,
-	}
-
-	return tileInfo, nil
+	}, nil
 }
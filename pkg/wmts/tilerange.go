@@ -0,0 +1,211 @@
+package wmts
+
+import (
+	"fmt"
+	"iter"
+	"math"
+)
+
+// edgeTolerance is the fraction of a tile under which a coordinate is considered on a tile edge,
+// so the rounding errors of the bbox computations do not add a row or a column of tiles
+const edgeTolerance = 1e-6
+
+// TileRange is the inclusive range of columns and rows of tiles of a zoom level, it is empty when MaxCol < MinCol
+// or MaxRow < MinRow
+type TileRange struct {
+	Zoom   int
+	MinCol int
+	MinRow int
+	MaxCol int
+	MaxRow int
+}
+
+// NumCols returns the number of columns of the range
+func (r TileRange) NumCols() int {
+	return max(r.MaxCol-r.MinCol+1, 0)
+}
+
+// NumRows returns the number of rows of the range
+func (r TileRange) NumRows() int {
+	return max(r.MaxRow-r.MinRow+1, 0)
+}
+
+// Count returns the number of tiles of the range
+func (r TileRange) Count() int {
+	return r.NumCols() * r.NumRows()
+}
+
+// IsEmpty returns true when the range has no tile
+func (r TileRange) IsEmpty() bool {
+	return r.Count() == 0
+}
+
+// Contains returns true when the tile col, row is in the range
+func (r TileRange) Contains(col, row int) bool {
+	return col >= r.MinCol && col <= r.MaxCol && row >= r.MinRow && row <= r.MaxRow
+}
+
+// String returns a string representation of the TileRange.
+func (r TileRange) String() string {
+	return fmt.Sprintf("zoom:%d, cols:[%d, %d], rows:[%d, %d]", r.Zoom, r.MinCol, r.MaxCol, r.MinRow, r.MaxRow)
+}
+
+// MetaTile is a block of tiles of a zoom level fetched with a single request
+type MetaTile struct {
+	Zoom     int
+	StartCol int
+	StartRow int
+	NumCols  int
+	NumRows  int
+}
+
+// Count returns the number of tiles of the meta-tile
+func (m MetaTile) Count() int {
+	return m.NumCols * m.NumRows
+}
+
+// MetaTiles iterates, row by row, over the meta-tiles of at most size x size tiles covering the range.
+// They start at MinCol, MinRow and the last ones of a row or a column are cut at the edge of the range.
+func (r TileRange) MetaTiles(size int) iter.Seq[MetaTile] {
+	size = max(size, 1)
+	return func(yield func(MetaTile) bool) {
+		for row := r.MinRow; row <= r.MaxRow; row += size {
+			for col := r.MinCol; col <= r.MaxCol; col += size {
+				mt := MetaTile{
+					Zoom:     r.Zoom,
+					StartCol: col,
+					StartRow: row,
+					NumCols:  min(size, r.MaxCol-col+1),
+					NumRows:  min(size, r.MaxRow-row+1),
+				}
+				if !yield(mt) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// TileRange returns the range of tiles covering bbox at the zoom level, limited to the tiles of the matrix.
+// A bbox edge lying on a tile edge does not add the tile beyond it, while a bbox without width or height
+// gives the tiles containing it. The range is empty when bbox is outside the matrix.
+func (g *Grid) TileRange(bbox BBox, zoomLevel int) (TileRange, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	res, ok := g.resolutions[zoomLevel]
+	if !ok {
+		return TileRange{}, g.zoomError(zoomLevel)
+	}
+	span := g.TileSize * res.CellSize
+	fromCol, toCol := (bbox.XMin-g.topLeftX)/span, (bbox.XMax-g.topLeftX)/span
+	fromRow, toRow := (g.topLeftY-bbox.YMax)/span, (g.topLeftY-bbox.YMin)/span
+	r := TileRange{
+		Zoom:   zoomLevel,
+		MinCol: int(math.Floor(fromCol + edgeTolerance)),
+		MinRow: int(math.Floor(fromRow + edgeTolerance)),
+		MaxCol: int(math.Ceil(toCol-edgeTolerance)) - 1,
+		MaxRow: int(math.Ceil(toRow-edgeTolerance)) - 1,
+	}
+	// a bbox thinner than the tolerance is a line or a point, it is inside the tiles containing its start
+	r.MaxCol = max(r.MaxCol, r.MinCol)
+	r.MaxRow = max(r.MaxRow, r.MinRow)
+	r.MinCol, r.MinRow = max(r.MinCol, 0), max(r.MinRow, 0)
+	r.MaxCol = min(r.MaxCol, g.GetMaxNumCols(zoomLevel)-1)
+	r.MaxRow = min(r.MaxRow, g.GetMaxNumRows(zoomLevel)-1)
+	return r, nil
+}
+
+// ZoomForResolution returns the coarsest zoom level whose cell size is at most cellSize, so its tiles are at least
+// as detailed, or the most detailed zoom level when cellSize is smaller than all of them
+func (g *Grid) ZoomForResolution(cellSize float64) int {
+	return g.bestZoom(cellSize, func(r Resolution) float64 { return r.CellSize })
+}
+
+// ZoomForScaleDenominator returns the coarsest zoom level whose scale denominator is at most scale,
+// or the most detailed zoom level when scale is smaller than all of them
+func (g *Grid) ZoomForScaleDenominator(scale float64) int {
+	return g.bestZoom(scale, func(r Resolution) float64 { return r.ScaleDenominator })
+}
+
+// bestZoom returns the zoom level with the largest value of at most limit, or the one with the smallest value
+func (g *Grid) bestZoom(limit float64, value func(Resolution) float64) int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	best, bestValue := -1, 0.0
+	finest, finestValue := 0, math.MaxFloat64
+	for zoom, r := range g.resolutions {
+		v := value(r)
+		if v <= limit*(1+1e-9) && v > bestValue {
+			best, bestValue = zoom, v
+		}
+		if v < finestValue {
+			finest, finestValue = zoom, v
+		}
+	}
+	if best < 0 {
+		return finest
+	}
+	return best
+}
+
+// coarserZoom returns the zoom level with the next larger cell size, false when zoom is the coarsest one
+func (g *Grid) coarserZoom(zoom int) (int, bool) {
+	return g.nextZoom(zoom, true)
+}
+
+// finerZoom returns the zoom level with the next smaller cell size, false when zoom is the most detailed one
+func (g *Grid) finerZoom(zoom int) (int, bool) {
+	return g.nextZoom(zoom, false)
+}
+
+// nextZoom returns the zoom level with the closest larger (coarser) or smaller cell size than the one of zoom
+func (g *Grid) nextZoom(zoom int, coarser bool) (int, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	current := g.resolutions[zoom].CellSize
+	next, nextGap := -1, math.MaxFloat64
+	for z, r := range g.resolutions {
+		gap := r.CellSize - current
+		if !coarser {
+			gap = -gap
+		}
+		if gap > 0 && gap < nextGap {
+			next, nextGap = z, gap
+		}
+	}
+	return next, next >= 0
+}
+
+// ParentTile returns the tile of the next coarser zoom level containing the center of the tile zoom, col, row.
+// The zoom levels of a grid are not always twice more detailed than the previous one, a parent tile may cover
+// several tiles partially.
+func (g *Grid) ParentTile(zoom, col, row int) (parentZoom, parentCol, parentRow int, err error) {
+	bbox, err := g.GetTileBBox(zoom, col, row)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	parentZoom, ok := g.coarserZoom(zoom)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("%w: zoom level %d is the coarsest one, it has no parent tile", ErrZoomOutOfRange, zoom)
+	}
+	x, y := (bbox.XMin+bbox.XMax)/2, (bbox.YMin+bbox.YMax)/2
+	r, err := g.TileRange(BBox{XMin: x, YMin: y, XMax: x, YMax: y}, parentZoom)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// the center of the last row or column of tiles may lie on the edge of the parent matrix
+	return parentZoom, min(r.MinCol, g.GetMaxNumCols(parentZoom)-1), min(r.MinRow, g.GetMaxNumRows(parentZoom)-1), nil
+}
+
+// ChildTiles returns the range of tiles of the next finer zoom level covering the tile zoom, col, row
+func (g *Grid) ChildTiles(zoom, col, row int) (TileRange, error) {
+	bbox, err := g.GetTileBBox(zoom, col, row)
+	if err != nil {
+		return TileRange{}, err
+	}
+	childZoom, ok := g.finerZoom(zoom)
+	if !ok {
+		return TileRange{}, fmt.Errorf("%w: zoom level %d is the most detailed one, it has no child tile", ErrZoomOutOfRange, zoom)
+	}
+	return g.TileRange(*bbox, childZoom)
+}
@@ -0,0 +1,368 @@
+package wmts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/config"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/schema"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"gopkg.in/yaml.v3"
+)
+
+// SupportedImageExtensions lists the image_extension values the tiles pipeline is able to produce
+var SupportedImageExtensions = []string{"png"}
+
+// wmsReservedParams are the GetMap parameters a WMTS dimension name must not override
+var wmsReservedParams = []string{
+	"SERVICE", "VERSION", "REQUEST", "LAYERS", "STYLES", "SRS", "CRS", "BBOX", "WIDTH", "HEIGHT",
+	"FORMAT", "BGCOLOR", "TRANSPARENT", "SLD", "EXCEPTIONS", "SALT",
+}
+
+// ConfigError describes one problem found in a YAML configuration
+type ConfigError struct {
+	Line    int    // line number in the YAML file (1 based, 0 if unknown)
+	Column  int    // column number in the YAML file (1 based, 0 if unknown)
+	Path    string // location of the value in the config, like /layers/my_layer/wmts_bbox
+	Message string
+}
+
+// Error returns a string representation of the ConfigError.
+func (e ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d:%d %s: %s", e.Line, e.Column, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors holds all the problems found in a YAML configuration
+type ValidationErrors []ConfigError
+
+// Error returns all the validation errors, one per line.
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("invalid configuration (%d errors):\n%s", len(v), strings.Join(msgs, "\n"))
+}
+
+// ValidateConfigFile reads a YAML file, replaces the env variables references and returns all the problems found in it.
+// The returned error is only set when the file cannot be read.
+func ValidateConfigFile(filePath string) (ValidationErrors, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read YAML file: %v", err)
+	}
+	data, err = config.InterpolateEnv(data)
+	if err != nil {
+		return ValidationErrors{{Path: "/", Message: err.Error()}}, nil
+	}
+	return ValidateConfigData(data), nil
+}
+
+// ValidateConfigData checks the YAML content against schema/schema.json and then runs the semantic
+// checks that a JSON schema cannot express (bbox inside the grid extent, known matrix set, ...)
+func ValidateConfigData(data []byte) ValidationErrors {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return ValidationErrors{yamlSyntaxError(err)}
+	}
+	if len(root.Content) == 0 {
+		return ValidationErrors{{Path: "/", Message: "configuration is empty"}}
+	}
+
+	errs := validateAgainstSchema(data, &root)
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		errs = append(errs, yamlSyntaxError(err))
+		return errs
+	}
+	cfg.Layers = cfg.applyLayerDefaults()
+	// a semantic error is only useful if the schema did not already complain about the same value
+	reported := make(map[string]bool, len(errs))
+	for _, e := range errs {
+		reported[e.Path] = true
+	}
+	for _, e := range validateSemantic(&cfg, &root) {
+		if !reported[e.Path] {
+			errs = append(errs, e)
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return errs
+}
+
+func yamlSyntaxError(err error) ConfigError {
+	return ConfigError{Path: "/", Message: fmt.Sprintf("failed to unmarshal YAML: %v", err)}
+}
+
+func validateAgainstSchema(data []byte, root *yaml.Node) ValidationErrors {
+	sch, err := compileSchema()
+	if err != nil {
+		return ValidationErrors{{Path: "/", Message: fmt.Sprintf("cannot compile schema.json: %v", err)}}
+	}
+	// the yaml is converted to json, so the validator gets the same types as with a json document
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return ValidationErrors{yamlSyntaxError(err)}
+	}
+	applyDocLayerDefaults(doc)
+	jsonDoc, err := json.Marshal(doc)
+	if err != nil {
+		return ValidationErrors{{Path: "/", Message: fmt.Sprintf("cannot convert YAML to JSON: %v", err)}}
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(jsonDoc))
+	if err != nil {
+		return ValidationErrors{{Path: "/", Message: fmt.Sprintf("cannot convert YAML to JSON: %v", err)}}
+	}
+	err = sch.Validate(instance)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return ValidationErrors{{Path: "/", Message: err.Error()}}
+	}
+	printer := message.NewPrinter(language.English)
+	var errs ValidationErrors
+	seen := make(map[string]bool)
+	for _, leaf := range leafErrors(ve) {
+		path := "/" + strings.Join(leaf.InstanceLocation, "/")
+		msg := leaf.ErrorKind.LocalizedString(printer)
+		if seen[path+msg] {
+			continue
+		}
+		seen[path+msg] = true
+		line, col := nodePosition(root, leaf.InstanceLocation)
+		errs = append(errs, ConfigError{Line: line, Column: col, Path: path, Message: msg})
+	}
+	return errs
+}
+
+// applyDocLayerDefaults copies the layer_default_values to the layers without a wms_backend_url,
+// like ConfigFromYAML does, so they are validated with the values they get once loaded
+func applyDocLayerDefaults(doc any) {
+	root, ok := doc.(map[string]any)
+	if !ok {
+		return
+	}
+	defaults, ok := root["layer_default_values"].(map[string]any)
+	if !ok {
+		return
+	}
+	layers, _ := root["layers"].(map[string]any)
+	for _, layer := range layers {
+		values, ok := layer.(map[string]any)
+		if !ok {
+			continue
+		}
+		if url, _ := values["wms_backend_url"].(string); url != "" {
+			continue
+		}
+		// the defaults replace all the values of the layer they hold, even the ones missing in them
+		t := reflect.TypeOf(LayerDefaultValues{})
+		for i := range t.NumField() {
+			delete(values, strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0])
+		}
+		for key, value := range defaults {
+			values[key] = value
+		}
+	}
+}
+
+// leafErrors returns the most precise errors found by the validator
+func leafErrors(ve *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(ve.Causes) == 0 {
+		return []*jsonschema.ValidationError{ve}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range ve.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
+	}
+	return leaves
+}
+
+type regexp2Regexp regexp2.Regexp
+
+func (re *regexp2Regexp) MatchString(s string) bool {
+	matched, err := (*regexp2.Regexp)(re).MatchString(s)
+	return err == nil && matched
+}
+
+func (re *regexp2Regexp) String() string {
+	return (*regexp2.Regexp)(re).String()
+}
+
+// compileRegexp2 is needed because schema.json patterns use look-ahead, unsupported by the regexp package
+func compileRegexp2(s string) (jsonschema.Regexp, error) {
+	re, err := regexp2.Compile(s, regexp2.None)
+	if err != nil {
+		return nil, err
+	}
+	return (*regexp2Regexp)(re), nil
+}
+
+func compileSchema() (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema.JSON))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.UseRegexpEngine(compileRegexp2)
+	if err := c.AddResource(schema.URL, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(schema.URL)
+}
+
+func validateSemantic(cfg *Config, root *yaml.Node) ValidationErrors {
+	var errs ValidationErrors
+	add := func(path []string, format string, v ...any) {
+		line, col := nodePosition(root, path)
+		errs = append(errs, ConfigError{Line: line, Column: col, Path: "/" + strings.Join(path, "/"), Message: fmt.Sprintf(format, v...)})
+	}
+	// the cache folder may come from env CACHE_FOLDER or a flag, it is required by ResolveSettings
+	if len(cfg.Layers) == 0 {
+		add([]string{"layers"}, "at least one layer is required")
+	}
+	for name, layer := range cfg.Layers {
+		path := func(key string) []string { return []string{"layers", name, key} }
+		if layer.Name != "" && layer.Name != name {
+			add(path("layer_name"), "layer_name %q differs from the layer key %q", layer.Name, name)
+		}
+		ms, knownMatrixSet := GetMatrixSet(layer.WMTSMatrixSet)
+		if !knownMatrixSet {
+			add(path("wmts_matrix_set"), "unknown matrix set %q, known values are %v", layer.WMTSMatrixSet, KnownMatrixSetNames())
+		}
+		bbox, err := NewBBoxFromArray(layer.WMTSBBox)
+		if err != nil {
+			add(path("wmts_bbox"), "%v", err)
+		} else if knownMatrixSet && !ms.Extent.Contains(*bbox) {
+			add(path("wmts_bbox"), "bbox [%s] is outside the extent [%s] of matrix set %s", bbox, ms.Extent.String(), ms.Name)
+		}
+		if !slices.Contains(SupportedImageExtensions, layer.ImageExtension) {
+			add(path("image_extension"), "unsupported image extension %q, supported values are %v", layer.ImageExtension, SupportedImageExtensions)
+		}
+		if slices.Contains(wmsReservedParams, strings.ToUpper(layer.WMTSDimensionName)) {
+			add(path("wmts_dimension_name"), "dimension name %q collides with the WMS reserved parameter", layer.WMTSDimensionName)
+		}
+		for _, placeholder := range templatePlaceholders[layer.Source()] {
+			if layer.TileURLTemplate != "" && !strings.Contains(layer.TileURLTemplate, placeholder) {
+				add(path("tile_url_template"), "a %s tile url template must contain %v", layer.Source(), templatePlaceholders[layer.Source()])
+				break
+			}
+		}
+		if layer.UpstreamMatrixSet != "" {
+			upstream, known := GetMatrixSet(layer.UpstreamMatrixSet)
+			switch {
+			case !known:
+				add(path("upstream_matrix_set"), "unknown matrix set %q, known values are %v", layer.UpstreamMatrixSet, KnownMatrixSetNames())
+			case knownMatrixSet:
+				if _, err := coords.NewTransform(ms.SpatialRef, upstream.SpatialRef); err != nil {
+					add(path("upstream_matrix_set"), "%v", err)
+				}
+			}
+		}
+		if layer.DerivedFrom != "" {
+			ref, exists := cfg.Layers[layer.DerivedFrom]
+			switch {
+			case layer.Source() != SourceReprojected:
+				add(path("derived_from"), "derived_from is only used with source_type reprojected")
+			case !exists:
+				add(path("derived_from"), "unknown layer %q", layer.DerivedFrom)
+			case ref.Source() == SourceReprojected:
+				add(path("derived_from"), "layer %q is already reprojected, derive from its source instead", layer.DerivedFrom)
+			}
+		}
+		if layer.Resampling != "" && !slices.Contains(imgTools.ResampleMethods, layer.Resampling) {
+			add(path("resampling"), "unknown resampling method %q, supported values are %v", layer.Resampling, imgTools.ResampleMethods)
+		}
+		for i, src := range layer.Composite {
+			srcPath := []string{"layers", name, "composite", fmt.Sprint(i), "layer"}
+			if src.Layer == "" {
+				continue
+			}
+			ref, exists := cfg.Layers[src.Layer]
+			switch {
+			case !exists:
+				add(srcPath, "unknown layer %q", src.Layer)
+			case ref.Source() == SourceComposite || ref.Source() == SourceReprojected:
+				add(srcPath, "layer %q is a %s layer, it cannot be used as a source", src.Layer, ref.Source())
+			case ref.WMTSMatrixSet != layer.WMTSMatrixSet:
+				add(srcPath, "layer %q uses matrix set %s instead of %s", src.Layer, ref.WMTSMatrixSet, layer.WMTSMatrixSet)
+			}
+		}
+		for i, f := range layer.Filters {
+			if err := f.Validate(); err != nil {
+				add([]string{"layers", name, "filters", fmt.Sprint(i)}, "%v", err)
+			}
+		}
+		if len(layer.Composite) > 0 && layer.Source() != SourceComposite {
+			add(path("composite"), "composite is only used with source_type composite")
+		}
+	}
+	return errs
+}
+
+// nodePosition returns the line and column of the YAML node at the given path.
+// When the exact node cannot be found (e.g. a value coming from a YAML merge key)
+// the position of the deepest existing parent is returned.
+func nodePosition(root *yaml.Node, path []string) (int, int) {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line, col := node.Line, node.Column
+	for _, key := range path {
+		child, keyNode := childNode(node, key)
+		if child == nil {
+			break
+		}
+		line, col = keyNode.Line, keyNode.Column
+		node = child
+	}
+	return line, col
+}
+
+// childNode finds the element key in a mapping or sequence node, following YAML aliases and merge keys.
+// It returns the child value node and the node holding its position (the key node for mappings).
+func childNode(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1], node.Content[i]
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "<<" {
+				if child, _ := childNode(node.Content[i+1], key); child != nil {
+					// the value is inherited, report the position of the merge key in this mapping
+					return child, node.Content[i]
+				}
+			}
+		}
+	case yaml.SequenceNode:
+		var idx int
+		if _, err := fmt.Sscanf(key, "%d", &idx); err == nil && idx >= 0 && idx < len(node.Content) {
+			return node.Content[idx], node.Content[idx]
+		}
+	}
+	return nil, nil
+}
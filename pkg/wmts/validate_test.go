@@ -0,0 +1,89 @@
+package wmts
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const validConfigYAML = `caches:
+    local:
+        cache_type: filesystem
+        folder: /tmp/tiles
+layers:
+    plan_ville:
+        wms_backend_url: https://example.org/wms
+        wms_layers: plan_ville
+        layer_name: plan_ville
+        wmts_bbox: [2532500, 1149000, 2545625, 1161000]
+        wmts_url_style: default
+        wmts_matrix_set: swissgrid_05
+        wmts_dimension_name: DATE
+        image_extension: png
+`
+
+func TestValidateConfigData(t *testing.T) {
+	tests := []struct {
+		name        string
+		replace     [2]string
+		wantPath    string
+		wantLine    int
+		wantMessage string
+	}{
+		{"valid config", [2]string{"", ""}, "", 0, ""},
+		{"cache folder from env or flag", [2]string{"caches:\n    local:\n        cache_type: filesystem\n        folder: /tmp/tiles\n", ""}, "", 0, ""},
+		{"unknown property", [2]string{"layer_name:", "layer_nam:"}, "/layers/plan_ville", 6, "layer_nam"},
+		{"missing wms_layers", [2]string{"        wms_layers: plan_ville\n", ""}, "/layers/plan_ville", 6, "wms_layers"},
+		{"bbox outside grid", [2]string{"2532500, 1149000", "2032500, 1149000"}, "/layers/plan_ville/wmts_bbox", 10, "outside the extent"},
+		{"bbox too short", [2]string{", 1161000]", "]"}, "/layers/plan_ville/wmts_bbox", 10, "minItems"},
+		{"unknown matrix set", [2]string{"swissgrid_05", "swissgrid_5"}, "/layers/plan_ville/wmts_matrix_set", 12, "unknown matrix set"},
+		{"unsupported extension", [2]string{"image_extension: png", "image_extension: jpg"}, "/layers/plan_ville/image_extension", 14, "'png'"},
+		{"layer default values", [2]string{"layers:\n", "layer_default_values:\n    wms_backend_url: https://example.org/wms\n    wmts_bbox: [2532500, 1149000, 2545625, 1161000]\n    wmts_matrix_set: swissgrid_05\n    wmts_url_style: default\n    image_extension: png\nlayers:\n    defaults_only:\n        wms_layers: plan_ville\n"}, "", 0, ""},
+		{"reserved dimension", [2]string{"DATE", "Bbox"}, "/layers/plan_ville/wmts_dimension_name", 13, "does not match pattern"},
+		{"xyz source", [2]string{"wms_backend_url: https://example.org/wms\n        wms_layers: plan_ville", "source_type: xyz\n        tile_url_template: https://example.org/{z}/{x}/{y}.png"}, "", 0, ""},
+		{"xyz template without {y}", [2]string{"wms_backend_url: https://example.org/wms\n        wms_layers: plan_ville", "source_type: xyz\n        tile_url_template: https://example.org/{z}/{x}.png"}, "/layers/plan_ville/tile_url_template", 8, "must contain"},
+		{"wmts source without template", [2]string{"wms_backend_url: https://example.org/wms\n", "source_type: wmts\n"}, "/layers/plan_ville", 6, "tile_url_template"},
+		{"composite source", [2]string{"wms_backend_url: https://example.org/wms\n", "source_type: composite\n        composite: [{wms_backend_url: https://example.org/wms, wms_layers: ortho}, {layer: plan_ville, opacity: 0.5, blend: multiply}]\n"}, "/layers/plan_ville/composite/1/layer", 8, "composite layer"},
+		{"composite source without layers", [2]string{"wms_backend_url: https://example.org/wms\n", "source_type: composite\n        composite: [{wms_backend_url: https://example.org/wms}]\n"}, "/layers/plan_ville/composite/0", 8, "wms_layers"},
+		{"filters", [2]string{"image_extension: png\n", "image_extension: png\n        filters: [{type: grayscale}, {type: gamma, value: 0.8}, {type: watermark, text: © Lausanne}]\n"}, "", 0, ""},
+		{"watermark without text", [2]string{"image_extension: png\n", "image_extension: png\n        filters: [{type: tint, color: '#336699'}, {type: watermark, spacing: 512}]\n"}, "/layers/plan_ville/filters/1", 15, "either a text or an image"},
+		{"xyz source in web mercator", [2]string{"wms_backend_url: https://example.org/wms\n        wms_layers: plan_ville", "source_type: xyz\n        tile_url_template: https://example.org/{z}/{x}/{y}.png\n        upstream_matrix_set: WebMercatorQuad\n        resampling: bilinear"}, "", 0, ""},
+		{"reprojected source", [2]string{"wms_backend_url: https://example.org/wms\n", "source_type: reprojected\n        derived_from: plan_ville\n"}, "/layers/plan_ville/derived_from", 8, "already reprojected"},
+		{"unknown upstream matrix set", [2]string{"wmts_url_style: default\n", "wmts_url_style: default\n        upstream_matrix_set: webmercator\n"}, "/layers/plan_ville/upstream_matrix_set", 12, "unknown matrix set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := validConfigYAML
+			if tt.replace[0] != "" {
+				data = strings.Replace(data, tt.replace[0], tt.replace[1], 1)
+			}
+			errs := ValidateConfigData([]byte(data))
+			if tt.wantPath == "" {
+				if len(errs) != 0 {
+					t.Fatalf("expected no error, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("expected exactly one error, got %v", errs)
+			}
+			e := errs[0]
+			if e.Path != tt.wantPath || e.Line != tt.wantLine || !strings.Contains(e.Message, tt.wantMessage) {
+				t.Errorf("got %+v, want path %s at line %d containing %q", e, tt.wantPath, tt.wantLine, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestValidateConfigFileRepoConfig(t *testing.T) {
+	if _, err := os.Stat("../../config.yaml"); err != nil {
+		t.Skip("config.yaml not found")
+	}
+	errs, err := ValidateConfigFile("../../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("config.yaml should be valid, got %v", errs)
+	}
+}
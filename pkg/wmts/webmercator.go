@@ -0,0 +1,58 @@
+package wmts
+
+import (
+	"math"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/golog"
+)
+
+// WebMercatorMatrixSet is the name of the OGC tile matrix set of the web maps (EPSG:3857), the one of the XYZ services
+const WebMercatorMatrixSet = "WebMercatorQuad"
+
+// webMercatorHalfSize is half the width of the Pseudo-Mercator world in meters
+const webMercatorHalfSize = math.Pi * coords.EarthRadius
+
+// WebMercatorGridBBox is the extent of the WebMercatorQuad grid (EPSG:3857)
+var WebMercatorGridBBox = BBox{
+	XMin: -webMercatorHalfSize,
+	YMin: -webMercatorHalfSize,
+	XMax: webMercatorHalfSize,
+	YMax: webMercatorHalfSize,
+}
+
+// webMercatorMaxZoom is the most detailed zoom level of the grid, about 0.15 meter per pixel at the equator
+const webMercatorMaxZoom = 20
+
+// NewWebMercatorGrid creates the grid of the WebMercatorQuad tile matrix set, zoom level z has 2^z x 2^z tiles.
+// Its tiles are usually reprojected from a layer in LV95, or read from an XYZ service.
+func NewWebMercatorGrid(wmsBackEndUrl, wmsStartParams string, l golog.MyLogger) *Grid {
+	if l == nil {
+		panic("💥💥 panic in NewWebMercatorGrid : logger cannot be nil")
+	}
+	resolutions := make(map[int]Resolution, webMercatorMaxZoom+1)
+	for zoom := 0; zoom <= webMercatorMaxZoom; zoom++ {
+		numTiles := math.Exp2(float64(zoom))
+		cellSize := 2 * webMercatorHalfSize / (DefaultTileSize * numTiles)
+		resolutions[zoom] = Resolution{
+			ScaleDenominator: cellSize / 0.00028, // OGC standardized rendering pixel size of 0.28 mm
+			CellSize:         cellSize,
+			MatrixWidth:      numTiles,
+			MatrixHeight:     numTiles,
+		}
+	}
+	return &Grid{
+		Bbox:            WebMercatorGridBBox,
+		SpatialREF:      coords.WebMercator,
+		TileURLTemplate: "{zoom}/{tileCol}/{tileRow}.png",
+		UNIT:            "meters",
+		MetersPerUnit:   1,
+		TileSize:        DefaultTileSize,
+		topLeftX:        WebMercatorGridBBox.XMin,
+		topLeftY:        WebMercatorGridBBox.YMax,
+		WmsBackendUrl:   wmsBackEndUrl,
+		WmsStartParams:  wmsStartParams,
+		resolutions:     resolutions,
+		l:               l,
+	}
+}
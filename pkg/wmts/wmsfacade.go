@@ -0,0 +1,280 @@
+package wmts
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/backend"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/coords"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/imgTools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tools"
+	"github.com/lao-tseu-is-alive/go-wmts-tool/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The errors of GetMap, they correspond to the exception codes of WMS
+var (
+	// ErrLayerNotDefined is returned for a layer not in the configuration
+	ErrLayerNotDefined = errors.New("layer not defined")
+	// ErrInvalidCRS is returned for a spatial reference neither the one of the layer grid nor served by its WMS backend
+	ErrInvalidCRS = errors.New("invalid crs")
+)
+
+// WMSMapRequest is a WMS GetMap request, the bbox is east, north in the spatial reference crs
+type WMSMapRequest struct {
+	Layers []string
+	CRS    int
+	BBox   BBox
+	Width  int
+	Height int
+}
+
+// GetMap draws the layers of the request from bottom to top. A layer is drawn from its cached tiles like a static map
+// when the request uses the spatial reference of its grid, see RenderStaticMap. Otherwise, or when the map needs
+// too many tiles, a layer with a WMS source is requested from its backend, the others fail with ErrInvalidCRS.
+// The parts of the map outside the grid of a layer are transparent.
+func GetMap(ctx context.Context, client *http.Client, state *LayersState, req WMSMapRequest) (img *image.RGBA, err error) {
+	ctx, span := tracing.Start(ctx, "wmts.GetMap", trace.WithAttributes(
+		attribute.StringSlice("layers", req.Layers),
+		attribute.Int("crs", req.CRS),
+		attribute.Int("width", req.Width),
+		attribute.Int("height", req.Height),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+	for _, name := range req.Layers {
+		if _, _, exists := state.Layer(name); !exists {
+			return nil, fmt.Errorf("%w: %q", ErrLayerNotDefined, name)
+		}
+	}
+	img = image.NewRGBA(image.Rect(0, 0, req.Width, req.Height))
+	for _, name := range req.Layers {
+		layerImg, err := getLayerMap(ctx, client, state, name, req)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", name, err)
+		}
+		if layerImg == nil {
+			continue
+		}
+		if err := imgTools.Composite(img, layerImg, 1, imgTools.BlendNormal); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// getLayerMap returns the image of a layer of the request, nil when it is outside the grid of the layer
+func getLayerMap(ctx context.Context, client *http.Client, state *LayersState, name string, req WMSMapRequest) (image.Image, error) {
+	lc, grid, _ := state.Layer(name)
+	if req.CRS == grid.SpatialREF {
+		img, err := RenderStaticMap(ctx, client, state, name, StaticMap{BBox: req.BBox, Width: req.Width, Height: req.Height})
+		switch {
+		case errors.Is(err, ErrOutsideGridExtent):
+			return nil, nil
+		case err == nil:
+			return img, nil
+		case !errors.Is(err, ErrStaticMapTooLarge) || lc.Source() != SourceWMS:
+			return nil, err
+		}
+	} else if lc.Source() != SourceWMS {
+		return nil, fmt.Errorf("%w: EPSG:%d, the layer is only available in EPSG:%d", ErrInvalidCRS, req.CRS, grid.SpatialREF)
+	}
+	// the WMS backend of the layer draws the map in the crs and at the size requested
+	wmsURL := backendMapURL(grid, backendGetMapParams(lc, req))
+	img, err := tools.FetchImage(ctx, client, wmsURL, req.Width, req.Height, backend.NoRetry, grid.l)
+	if err != nil {
+		return nil, err
+	}
+	if len(lc.Filters) == 0 {
+		return img, nil
+	}
+	return imgTools.ApplyFilters(img, lc.Filters, image.Point{})
+}
+
+// BackendMapURL returns the url of the GetMap of the WMS backend when the map of req comes from it as is : a single
+// layer with a WMS source and without filters, in another spatial reference than the one of its grid. The backend
+// then draws the map in format, on background when it is not nil, and its answer can be sent without decoding it.
+func BackendMapURL(state *LayersState, req WMSMapRequest, format string, background color.Color) (string, bool) {
+	if len(req.Layers) != 1 {
+		return "", false
+	}
+	lc, grid, exists := state.Layer(req.Layers[0])
+	if !exists || lc.Source() != SourceWMS || len(lc.Filters) > 0 || req.CRS == grid.SpatialREF {
+		return "", false
+	}
+	params := backendGetMapParams(lc, req)
+	params["FORMAT"] = format
+	if background != nil {
+		r, g, b, _ := background.RGBA()
+		params["TRANSPARENT"] = "false"
+		params["BGCOLOR"] = fmt.Sprintf("0x%02X%02X%02X", r>>8, g>>8, b>>8)
+	}
+	return backendMapURL(grid, params), true
+}
+
+// backendMapURL returns the url of a request with params to the WMS backend of grid
+func backendMapURL(grid *Grid, params map[string]string) string {
+	return fmt.Sprintf("%s?%s%s", grid.WmsBackendUrl, grid.WmsStartParams, tools.BuildQueryString(params))
+}
+
+// backendGetMapParams returns the WMS 1.3.0 parameters of the request for the backend of the layer
+func backendGetMapParams(lc LayerConfig, req WMSMapRequest) map[string]string {
+	bbox := req.BBox
+	if req.CRS == coords.WGS84 {
+		// WMS 1.3.0 follows the axis order of EPSG:4326, latitude first
+		bbox = BBox{XMin: bbox.YMin, YMin: bbox.XMin, XMax: bbox.YMax, YMax: bbox.XMax}
+	}
+	return map[string]string{
+		"SERVICE":     "WMS",
+		"VERSION":     "1.3.0",
+		"REQUEST":     "GetMap",
+		"FORMAT":      "image/png",
+		"TRANSPARENT": "true",
+		"LAYERS":      lc.WMSLayers,
+		"STYLES":      "",
+		"WIDTH":       strconv.Itoa(req.Width),
+		"HEIGHT":      strconv.Itoa(req.Height),
+		"CRS":         fmt.Sprintf("EPSG:%d", req.CRS),
+		"BBOX":        bbox.String(),
+	}
+}
+
+// wmsMapFormats are the formats of the GetMap answers
+var wmsMapFormats = []string{"image/png", "image/jpeg"}
+
+// WMSCapabilities returns the WMS 1.3.0 capabilities document listing the layers of state, sorted by name.
+// serviceURL is the url of the /wms endpoint given to the clients.
+func WMSCapabilities(state *LayersState, serviceURL string) ([]byte, error) {
+	resource := &wmsOnlineResource{XLinkType: "simple", Href: serviceURL}
+	dcp := []wmsDCPType{{Get: wmsGet{OnlineResource: resource}}}
+	root := wmsLayer{Title: "go-wmts-tool"}
+	allCRS := make(map[int]bool)
+	names := make([]string, 0, len(state.Layers))
+	for name := range state.Layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lc, grid, _ := state.Layer(name)
+		layer := wmsLayer{
+			Queryable: "0",
+			Name:      name,
+			Title:     lc.Title,
+			Abstract:  lc.Abstract,
+			CRS:       []string{fmt.Sprintf("EPSG:%d", grid.SpatialREF)},
+		}
+		if layer.Title == "" {
+			layer.Title = name
+		}
+		if bbox, err := NewBBoxFromArray(lc.WMTSBBox); err == nil {
+			layer.BoundingBox = &wmsBoundingBox{CRS: layer.CRS[0], MinX: bbox.XMin, MinY: bbox.YMin, MaxX: bbox.XMax, MaxY: bbox.YMax}
+			if toWGS84, err := coords.NewTransform(grid.SpatialREF, coords.WGS84); err == nil {
+				west, south, east, north := toWGS84.BBox(bbox.XMin, bbox.YMin, bbox.XMax, bbox.YMax)
+				layer.GeographicBBox = &wmsGeographicBBox{West: west, East: east, South: south, North: north}
+			}
+		}
+		allCRS[grid.SpatialREF] = true
+		root.Layers = append(root.Layers, layer)
+	}
+	for _, code := range coords.Supported() {
+		if allCRS[code] {
+			root.CRS = append(root.CRS, fmt.Sprintf("EPSG:%d", code))
+		}
+	}
+	capabilities := wmsCapabilities{
+		Version:    "1.3.0",
+		Xmlns:      "http://www.opengis.net/wms",
+		XmlnsXLink: "http://www.w3.org/1999/xlink",
+		Service: wmsService{
+			Name:           "WMS",
+			Title:          "go-wmts-tool cached WMS",
+			OnlineResource: resource,
+		},
+		Capability: wmsCapability{
+			Request: wmsRequests{
+				GetCapabilities: wmsOperation{Formats: []string{"text/xml"}, DCPType: dcp},
+				GetMap:          wmsOperation{Formats: wmsMapFormats, DCPType: dcp},
+			},
+			Exception: wmsException{Formats: []string{"XML"}},
+			Layer:     root,
+		},
+	}
+	data, err := xml.MarshalIndent(capabilities, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// The elements of the WMS 1.3.0 capabilities document
+type (
+	wmsCapabilities struct {
+		XMLName    xml.Name      `xml:"WMS_Capabilities"`
+		Version    string        `xml:"version,attr"`
+		Xmlns      string        `xml:"xmlns,attr"`
+		XmlnsXLink string        `xml:"xmlns:xlink,attr"`
+		Service    wmsService    `xml:"Service"`
+		Capability wmsCapability `xml:"Capability"`
+	}
+	wmsService struct {
+		Name           string             `xml:"Name"`
+		Title          string             `xml:"Title"`
+		OnlineResource *wmsOnlineResource `xml:"OnlineResource"`
+	}
+	wmsOnlineResource struct {
+		XLinkType string `xml:"xlink:type,attr"`
+		Href      string `xml:"xlink:href,attr"`
+	}
+	wmsCapability struct {
+		Request   wmsRequests  `xml:"Request"`
+		Exception wmsException `xml:"Exception"`
+		Layer     wmsLayer     `xml:"Layer"`
+	}
+	wmsRequests struct {
+		GetCapabilities wmsOperation `xml:"GetCapabilities"`
+		GetMap          wmsOperation `xml:"GetMap"`
+	}
+	wmsOperation struct {
+		Formats []string     `xml:"Format"`
+		DCPType []wmsDCPType `xml:"DCPType"`
+	}
+	wmsDCPType struct {
+		Get wmsGet `xml:"HTTP>Get"`
+	}
+	wmsGet struct {
+		OnlineResource *wmsOnlineResource `xml:"OnlineResource"`
+	}
+	wmsException struct {
+		Formats []string `xml:"Format"`
+	}
+	wmsLayer struct {
+		Queryable      string             `xml:"queryable,attr,omitempty"`
+		Name           string             `xml:"Name,omitempty"`
+		Title          string             `xml:"Title"`
+		Abstract       string             `xml:"Abstract,omitempty"`
+		CRS            []string           `xml:"CRS"`
+		GeographicBBox *wmsGeographicBBox `xml:"EX_GeographicBoundingBox"`
+		BoundingBox    *wmsBoundingBox    `xml:"BoundingBox"`
+		Layers         []wmsLayer         `xml:"Layer"`
+	}
+	wmsGeographicBBox struct {
+		West  float64 `xml:"westBoundLongitude"`
+		East  float64 `xml:"eastBoundLongitude"`
+		South float64 `xml:"southBoundLatitude"`
+		North float64 `xml:"northBoundLatitude"`
+	}
+	wmsBoundingBox struct {
+		CRS  string  `xml:"CRS,attr"`
+		MinX float64 `xml:"minx,attr"`
+		MinY float64 `xml:"miny,attr"`
+		MaxX float64 `xml:"maxx,attr"`
+		MaxY float64 `xml:"maxy,attr"`
+	}
+)
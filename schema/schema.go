@@ -0,0 +1,12 @@
+// Package schema gives access to the JSON schema describing the goWmtsTool YAML configuration.
+package schema
+
+import _ "embed"
+
+// URL is the identifier ($id) of the configuration schema
+const URL = "https://raw.githubusercontent.com/lao-tseu-is-alive/go-wmts-tool/main/schema/schema.json"
+
+// JSON contains the raw content of schema.json embedded at build time
+//
+//go:embed schema.json
+var JSON []byte